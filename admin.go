@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+// adminPatternsHandler shows (GET) or updates (PUT) the parser's regex set.
+// Updates are validated, applied immediately and persisted to Redis.
+// Admin-only, like every /admin route (see routeAuth).
+func adminPatternsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(services.CurrentPatternConfig())
+
+	case http.MethodPut:
+		var update services.PatternConfig
+		if !decodeJSONBody(w, r, &update) {
+			return
+		}
+		active, err := services.SetPatterns(update)
+		if err != nil {
+			respondAppError(w, err)
+			return
+		}
+		opCtx, cancel := redisContext(r.Context())
+		defer cancel()
+		if err := services.SavePatterns(opCtx, redisClient, active); err != nil {
+			respondError(w, http.StatusInternalServerError, "Patterns applied but could not be persisted")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(active)
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// adminQuotaHandler reports Gmail API call counts for the current window.
+func adminQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	opCtx, cancel := redisContext(r.Context())
+	defer cancel()
+	usage, err := quotaTracker.Usage(opCtx)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to read quota usage")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
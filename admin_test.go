@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+func TestAdminPatternsUpdate(t *testing.T) {
+	const adminToken = "admin-secret"
+	const sample = `{"body":"Rs.10.00 spent at KIOSK dated 01-03-24"}`
+	tests := []struct {
+		name      string
+		update    string
+		status    int
+		errorCode string
+		parses    bool
+	}{
+		{name: "new date pattern", update: `{"date":"(?:on|dated)\\s+(\\d{2}-\\d{2}-\\d{2})"}`,
+			status: http.StatusOK, parses: true},
+		{name: "uncompilable", update: `{"date":"dated\\s+(\\d{2}"}`,
+			status: http.StatusBadRequest, errorCode: "INVALID_PATTERN"},
+		{name: "missing capture group", update: `{"merchant":"at\\s+\\w+"}`,
+			status: http.StatusBadRequest, errorCode: "INVALID_PATTERN"},
+		{name: "unknown field", update: `{"currency":"x"}`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) { c.AdminToken = adminToken })
+			rec := env.doBody("PUT", "/admin/patterns", tt.update, "Authorization", "Bearer "+adminToken)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.errorCode != "" && errorCodeOf(rec) != tt.errorCode {
+				t.Errorf("errorCode %q, want %q", errorCodeOf(rec), tt.errorCode)
+			}
+
+			var details services.ParseDetails
+			json.Unmarshal(env.doBody("POST", "/parse/preview", sample).Body.Bytes(), &details)
+			if parsed := details.Error == ""; parsed != tt.parses {
+				t.Errorf("sample parsed = %v, want %v (%s)", parsed, tt.parses, details.Error)
+			}
+
+			stored, persisted := env.redis.Get("parser:patterns")
+			if persisted != (tt.status == http.StatusOK) {
+				t.Errorf("persisted = %v, want %v", persisted, tt.status == http.StatusOK)
+			}
+			if persisted && !strings.Contains(stored, "dated") {
+				t.Errorf("stored patterns %s lack the update", stored)
+			}
+			var current services.PatternConfig
+			json.Unmarshal(env.do("GET", "/admin/patterns", "Authorization", "Bearer "+adminToken).Body.Bytes(), &current)
+			if current.Amount != services.DefaultPatternConfig().Amount {
+				t.Errorf("untouched amount pattern changed to %q", current.Amount)
+			}
+		})
+	}
+}
+
+func TestAdminPatternsRequiresAdmin(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		status int
+	}{
+		{"admin disabled", "", "Bearer anything", http.StatusForbidden},
+		{"no credential", "admin-secret", "", http.StatusUnauthorized},
+		{"wrong token", "admin-secret", "Bearer nope", http.StatusUnauthorized},
+		{"admin", "admin-secret", "Bearer admin-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) { c.AdminToken = tt.token })
+			var headers []string
+			if tt.header != "" {
+				headers = []string{"Authorization", tt.header}
+			}
+			if rec := env.do("GET", "/admin/patterns", headers...); rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
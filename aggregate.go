@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+// aggregateKeys extracts the grouping key for each supported groupBy value.
+var aggregateKeys = map[string]func(types.Transaction) string{
+	"merchant": func(t types.Transaction) string {
+		if t.MerchantNormalized != "" {
+			return t.MerchantNormalized
+		}
+		return t.Merchant
+	},
+	"category": func(t types.Transaction) string {
+		if t.Category == "" {
+			return services.UncategorizedCategory
+		}
+		return t.Category
+	},
+	"day":     func(t types.Transaction) string { return t.Date },
+	"account": func(t types.Transaction) string { return t.Account },
+}
+
+// aggregateTransactions totals spend per key, sorted by total descending
+// (ties by key). As in the summary's expense, refunds and adjustments count
+// negatively and self-transfers and other credits are left out. Transactions
+// with no value for the key are grouped under "unknown".
+func aggregateTransactions(transactions []types.Transaction, groupBy string) []types.AggregateBucket {
+	keyFn := aggregateKeys[groupBy]
+	buckets := make(map[string]*types.AggregateBucket)
+	for _, txn := range transactions {
+		if txn.IsTransfer || txn.Type == types.TransactionTypeCredit && !txn.IsRefund && !txn.IsAdjustment {
+			continue
+		}
+		key := keyFn(txn)
+		if key == "" {
+			key = "unknown"
+		}
+		b, ok := buckets[key]
+		if !ok {
+			b = &types.AggregateBucket{Key: key}
+			buckets[key] = b
+		}
+		b.Total += spendAmount(txn)
+		b.Count++
+	}
+
+	result := make([]types.AggregateBucket, 0, len(buckets))
+	for _, b := range buckets {
+		b.Total = services.RoundAmount(b.Total, cfg.AmountDecimals)
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Total != result[j].Total {
+			return result[i].Total > result[j].Total
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// loadBaseResponse returns the unfiltered response for a filter, from the
+// cache /refresh populates when possible and otherwise by fetching (which
+// also fills that cache entry), falling back to the stale copy when Gmail is
+// over quota or unavailable.
+func loadBaseResponse(ctx context.Context, gs *services.GmailService, userID, filter string, days int) (*types.TransactionsResponse, error) {
+	key := getCacheKey(userID, filter)
+	if cached, err := getCachedResponse(ctx, key); err == nil {
+		var response types.TransactionsResponse
+		if err := json.Unmarshal(cached, &response); err == nil {
+			return &response, nil
+		}
+	}
+	response, _, err := runRefreshPeriod(ctx, gs, userID, refreshPeriod{Filter: filter, Days: days})
+	return response, err
+}
+
+// aggregateHandler serves GET /transactions/aggregate, grouping a filter's
+// transactions by merchant, category, day or account.
+func aggregateHandler(w http.ResponseWriter, r *http.Request) {
+	groupBy := r.URL.Query().Get("groupBy")
+	if _, ok := aggregateKeys[groupBy]; !ok {
+		respondError(w, http.StatusBadRequest, "Invalid groupBy; expected merchant, category, day or account")
+		return
+	}
+	filter := r.URL.Query().Get("filter")
+	if filter == "" {
+		filter = "all"
+	}
+	days, ok := filterDays(filter)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Invalid filter")
+		return
+	}
+
+	gmailService, userID, ok := gmailServiceFromRequest(w, r)
+	if !ok {
+		return
+	}
+	applyUserRules(r.Context(), gmailService, userID)
+
+	response, err := loadBaseResponse(r.Context(), gmailService, userID, filter, days)
+	if err != nil {
+		respondAppError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(aggregateTransactions(response.Details, groupBy))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+func TestAggregateGroupBy(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-12", 300, "SWIGGY")
+	env.addDebit("m2", "2024-03-12", 150, "ZOMATO")
+	env.addDebit("m3", "2024-03-13", 999, "AMAZON")
+	env.addDebit("m4", "2024-03-14", 200, "SWIGGY")
+	env.addDebit("m5", "2024-03-14", 42, "CORNER STORE")
+
+	tests := []struct {
+		groupBy string
+		status  int
+		want    []types.AggregateBucket
+	}{
+		{"merchant", http.StatusOK, []types.AggregateBucket{
+			{Key: "AMAZON", Total: 999, Count: 1},
+			{Key: "SWIGGY", Total: 500, Count: 2},
+			{Key: "ZOMATO", Total: 150, Count: 1},
+			{Key: "CORNER STORE", Total: 42, Count: 1},
+		}},
+		{"category", http.StatusOK, []types.AggregateBucket{
+			{Key: "shopping", Total: 999, Count: 1},
+			{Key: "food", Total: 650, Count: 3},
+			{Key: "uncategorized", Total: 42, Count: 1},
+		}},
+		{"day", http.StatusOK, []types.AggregateBucket{
+			{Key: "2024-03-13", Total: 999, Count: 1},
+			{Key: "2024-03-12", Total: 450, Count: 2},
+			{Key: "2024-03-14", Total: 242, Count: 2},
+		}},
+		{"", http.StatusBadRequest, nil},
+		{"week", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			rec := env.do("GET", "/transactions/aggregate?filter=all&groupBy="+tt.groupBy+"&access_token="+testToken)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var got []types.AggregateBucket
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("buckets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateMerchantVariants(t *testing.T) {
+	tests := []struct {
+		name    string
+		aliases map[string]string
+		want    []types.AggregateBucket
+	}{
+		{"default aliases", nil, []types.AggregateBucket{
+			{Key: "AMAZON", Total: 1000, Count: 4},
+			{Key: "BUNDL TECHNOLOGIES", Total: 300, Count: 1},
+		}},
+		{"configured alias", map[string]string{"BUNDL": "SWIGGY"}, []types.AggregateBucket{
+			{Key: "AMAZON", Total: 1000, Count: 4},
+			{Key: "SWIGGY", Total: 300, Count: 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) { c.MerchantAliases = tt.aliases })
+			for i, merchant := range []string{"AMAZON", "Amazon.in", "AMAZON PAY INDIA", "Amazon Retail India Ltd"} {
+				env.addDebit(fmt.Sprintf("m%d", i), "2024-03-12", 250, merchant)
+			}
+			env.addDebit("m9", "2024-03-13", 300, "BUNDL TECHNOLOGIES")
+
+			rec := env.do("GET", "/transactions/aggregate?filter=all&groupBy=merchant&access_token="+testToken)
+			var got []types.AggregateBucket
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("buckets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateSpend(t *testing.T) {
+	tests := []struct {
+		name         string
+		transactions []types.Transaction
+		want         []types.AggregateBucket
+	}{
+		{
+			name: "refunds and adjustments reduce spend",
+			transactions: []types.Transaction{
+				{Category: "shopping", Type: "debit", Amount: 500},
+				{Category: "shopping", Type: "credit", Amount: 200, IsRefund: true},
+				{Category: "shopping", Type: "credit", Amount: 50, IsAdjustment: true},
+			},
+			want: []types.AggregateBucket{{Key: "shopping", Total: 250, Count: 3}},
+		},
+		{
+			name: "income and transfers left out",
+			transactions: []types.Transaction{
+				{Category: "food", Type: "debit", Amount: 300},
+				{Category: "income", Type: "credit", Amount: 50000},
+				{Category: "food", Type: "debit", Amount: 900, IsTransfer: true},
+			},
+			want: []types.AggregateBucket{{Key: "food", Total: 300, Count: 1}},
+		},
+		{
+			name:         "only credits",
+			transactions: []types.Transaction{{Category: "income", Type: "credit", Amount: 50000}},
+			want:         []types.AggregateBucket{},
+		},
+	}
+	newTestEnv(t, nil) // aggregateTransactions rounds with cfg.AmountDecimals
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregateTransactions(tt.transactions, "category")
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("buckets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"golang.org/x/oauth2"
+)
+
+// gmailServiceFromRequest builds a Gmail service from the request's session
+// (X-Session-Token) or, failing that, its access_token, and resolves the user
+// it belongs to. On failure it writes the
+// error response and returns ok=false.
+func gmailServiceFromRequest(w http.ResponseWriter, r *http.Request) (gs *services.GmailService, userID string, ok bool) {
+	var err error
+	if sessionToken := r.Header.Get(sessionHeader); sessionToken != "" {
+		gs, userID, err = gmailServiceForSession(r.Context(), sessionToken)
+	} else {
+		gs, userID, err = gmailServiceForToken(r.Context(), r.URL.Query().Get("access_token"))
+	}
+	if err != nil {
+		respondAppError(w, err)
+		return nil, "", false
+	}
+	return gs, userID, true
+}
+
+// gmailServiceForToken builds a Gmail service for an access token and
+// resolves the user it belongs to.
+func gmailServiceForToken(reqCtx context.Context, accessToken string) (*services.GmailService, string, error) {
+	gs, err := newGmailService(reqCtx, accessToken)
+	if err != nil {
+		return nil, "", err
+	}
+	userID, err := gs.GetUserId()
+	if err != nil {
+		if appErr, ok := err.(*services.AppError); ok && appErr.Code == http.StatusUnauthorized {
+			tokenInfoCache.Invalidate(reqCtx, strings.TrimSpace(accessToken))
+		}
+		return nil, "", err
+	}
+	return gs, userID, nil
+}
+
+// newGmailService checks an access token and builds a Gmail service for it
+// without making any Gmail calls.
+func newGmailService(reqCtx context.Context, accessToken string) (*services.GmailService, error) {
+	accessToken = strings.TrimSpace(accessToken)
+	if accessToken == "" {
+		return nil, &services.AppError{Code: http.StatusUnauthorized, Msg: "Missing access token in query string"}
+	}
+	if err := services.ValidateTokenFormat(accessToken); err != nil {
+		return nil, err
+	}
+
+	if cfg.ScopeCheck {
+		if err := tokenInfoCache.CheckGmailScope(reqCtx, accessToken); err != nil {
+			return nil, err
+		}
+	}
+
+	return gmailServiceForOAuthToken(&oauth2.Token{AccessToken: accessToken})
+}
+
+// gmailServiceForOAuthToken builds a Gmail service authorized by token.
+func gmailServiceForOAuthToken(token *oauth2.Token) (*services.GmailService, error) {
+	tokenSource := oauthConfig.TokenSource(ctx, token)
+	client := oauth2.NewClient(ctx, tokenSource)
+	gs, err := services.NewGmailServiceWithClient(cfg, client)
+	if err != nil {
+		return nil, fmt.Errorf("Gmail service error: %v", err)
+	}
+	gs.SetQuotaTracker(quotaTracker)
+	gs.SetCircuitBreaker(gmailBreaker)
+	return gs, nil
+}
+
+// requireAdmin checks the request carries the configured admin token as a
+// bearer token. Admin routes are disabled entirely when no token is set.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if cfg.AdminToken == "" {
+		respondError(w, http.StatusForbidden, "Admin endpoints are disabled")
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) != 1 {
+		respondError(w, http.StatusUnauthorized, "Invalid admin token")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+func TestScopeCheck(t *testing.T) {
+	const (
+		readonly = "https://www.googleapis.com/auth/gmail.readonly"
+		modify   = "https://www.googleapis.com/auth/gmail.modify"
+		profile  = "openid https://www.googleapis.com/auth/userinfo.email"
+	)
+	tests := []struct {
+		name       string
+		scope      string
+		granted    bool
+		scopeCheck bool
+		status     int
+		errorCode  string
+	}{
+		{name: "readonly", scope: profile + " " + readonly, granted: true, scopeCheck: true, status: http.StatusOK},
+		{name: "modify", scope: modify, granted: true, scopeCheck: true, status: http.StatusOK},
+		{name: "no gmail scope", scope: profile, granted: true, scopeCheck: true,
+			status: http.StatusForbidden, errorCode: services.ErrCodeInsufficientScope},
+		{name: "unknown token", granted: false, scopeCheck: true,
+			status: http.StatusUnauthorized, errorCode: services.ErrCodeInvalidToken},
+		{name: "check disabled", scope: profile, granted: true, scopeCheck: false, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) { c.ScopeCheck = tt.scopeCheck })
+			token := "ya29.scope-check-token-000"
+			if tt.granted {
+				env.gmail.Grant(token, tt.scope)
+			}
+			target := "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + token
+			for i := 0; i < 2; i++ {
+				rec := env.do("GET", target)
+				if rec.Code != tt.status {
+					t.Fatalf("request %d: status %d, want %d: %s", i+1, rec.Code, tt.status, rec.Body.String())
+				}
+				if got := errorCodeOf(rec); got != tt.errorCode {
+					t.Errorf("request %d: errorCode %q, want %q", i+1, got, tt.errorCode)
+				}
+			}
+			if tt.status != http.StatusOK && env.gmail.Calls(gmailtest.List) != 0 {
+				t.Error("Gmail was searched with a token that failed the scope check")
+			}
+			// A good token's introspection is cached; a rejected one isn't.
+			wantInfo := map[bool]int{true: 1, false: 2}[tt.granted]
+			if !tt.scopeCheck {
+				wantInfo = 0
+			}
+			if got := env.gmail.Calls(gmailtest.TokenInfo); got != wantInfo {
+				t.Errorf("tokeninfo called %d times, want %d", got, wantInfo)
+			}
+		})
+	}
+}
+
+func TestAccessTokenValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		status    int
+		errorCode string
+		tokenInfo int
+	}{
+		{name: "blank", token: "", status: http.StatusUnauthorized, errorCode: "UNAUTHORIZED"},
+		{name: "whitespace only", token: "   ", status: http.StatusUnauthorized, errorCode: "UNAUTHORIZED"},
+		{name: "too short", token: "ya29.abc", status: http.StatusUnauthorized, errorCode: services.ErrCodeInvalidToken},
+		{name: "too long", token: "ya29." + strings.Repeat("a", 4096), status: http.StatusUnauthorized, errorCode: services.ErrCodeInvalidToken},
+		{name: "inner space", token: "ya29.token with-a-space-in-it", status: http.StatusUnauthorized, errorCode: services.ErrCodeInvalidToken},
+		{name: "quote", token: `ya29."quoted-token-value-0001`, status: http.StatusUnauthorized, errorCode: services.ErrCodeInvalidToken},
+		{name: "padding mid-token", token: "ya29.padding==in-the-middle-01", status: http.StatusUnauthorized, errorCode: services.ErrCodeInvalidToken},
+		{name: "well-formed but unknown", token: "ya29.well-formed-but-unknown-01", status: http.StatusUnauthorized,
+			errorCode: services.ErrCodeInvalidToken, tokenInfo: 1},
+		{name: "valid with surrounding space", token: " " + testToken + "\t", status: http.StatusOK, tokenInfo: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			rec := env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token="+url.QueryEscape(tt.token))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if got := errorCodeOf(rec); got != tt.errorCode {
+				t.Errorf("errorCode %q, want %q", got, tt.errorCode)
+			}
+			if got := env.gmail.Calls(gmailtest.TokenInfo); got != tt.tokenInfo {
+				t.Errorf("tokeninfo called %d times, want %d", got, tt.tokenInfo)
+			}
+		})
+	}
+}
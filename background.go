@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+)
+
+// Background refreshes (warmup and scheduler ticks) run under backgroundCtx
+// and are tracked in backgroundTasks so shutdown can let them finish their
+// Redis writes instead of abandoning them halfway.
+var (
+	backgroundTasks                 sync.WaitGroup
+	backgroundCtx, cancelBackground = context.WithCancel(context.Background())
+)
+
+// goBackground runs fn as a tracked background task.
+func goBackground(fn func(context.Context)) {
+	backgroundTasks.Add(1)
+	go func() {
+		defer backgroundTasks.Done()
+		fn(backgroundCtx)
+	}()
+}
+
+// drainBackground waits up to timeout for running background tasks. Tasks
+// still running after that are cancelled, and given a moment to unwind.
+func drainBackground(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		backgroundTasks.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logger.Infof("Background refreshes drained")
+		return
+	case <-time.After(timeout):
+	}
+
+	logger.Warnf("Background refreshes still running after %s; cancelling", timeout)
+	cancelBackground()
+	select {
+	case <-done:
+	case <-time.After(cfg.RedisTimeout):
+		logger.Warnf("Background refreshes did not stop after cancellation")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDrainBackground(t *testing.T) {
+	tests := []struct {
+		name      string
+		drain     time.Duration
+		redisLag  time.Duration
+		blocking  bool
+		finished  bool
+		maxWait   time.Duration
+		cancelled bool
+	}{
+		{name: "running refresh finishes", drain: 5 * time.Second, redisLag: 20 * time.Millisecond, finished: true, maxWait: 5 * time.Second},
+		{name: "stuck task is cancelled", drain: 30 * time.Millisecond, blocking: true, cancelled: true, maxWait: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			savedCtx, savedCancel := backgroundCtx, cancelBackground
+			backgroundCtx, cancelBackground = context.WithCancel(context.Background())
+			t.Cleanup(func() { backgroundCtx, cancelBackground = savedCtx, savedCancel })
+
+			gs, userID, err := gmailServiceForToken(context.Background(), testToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			env.redis.SetDelay(tt.redisLag)
+			started := make(chan struct{})
+			var taskErr error
+			finished := false
+			goBackground(func(ctx context.Context) {
+				close(started)
+				if tt.blocking {
+					<-ctx.Done()
+					taskErr = ctx.Err()
+					return
+				}
+				_, taskErr = refreshUser(ctx, gs, userID)
+				finished = true
+			})
+			<-started
+
+			start := time.Now()
+			drainBackground(tt.drain)
+			if elapsed := time.Since(start); elapsed > tt.maxWait {
+				t.Errorf("drain took %s", elapsed)
+			}
+			if finished != tt.finished {
+				t.Errorf("finished = %v, want %v", finished, tt.finished)
+			}
+			if cancelled := taskErr == context.Canceled; cancelled != tt.cancelled {
+				t.Errorf("task error %v, want cancelled %v", taskErr, tt.cancelled)
+			}
+			if !tt.finished {
+				return
+			}
+			env.redis.SetDelay(0)
+			for _, filter := range refreshFilters {
+				if _, ok := env.redis.Get(getCacheKey(userID, filter)); !ok {
+					t.Errorf("%s not cached after drain", filter)
+				}
+			}
+		})
+	}
+}
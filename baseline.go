@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+// periodBounds returns the first and last day of the daily, weekly or
+// monthly period ending at (or, for monthly, containing) anchor.
+func periodBounds(period string, anchor time.Time) (start, end time.Time) {
+	switch period {
+	case "weekly":
+		return anchor.AddDate(0, 0, -6), anchor
+	case "monthly":
+		start = time.Date(anchor.Year(), anchor.Month(), 1, 0, 0, 0, 0, anchor.Location())
+		return start, start.AddDate(0, 1, -1)
+	}
+	return anchor, anchor
+}
+
+// periodsBack moves anchor n whole periods earlier.
+func periodsBack(period string, anchor time.Time, n int) time.Time {
+	switch period {
+	case "weekly":
+		return anchor.AddDate(0, 0, -7*n)
+	case "monthly":
+		monthStart := time.Date(anchor.Year(), anchor.Month(), 1, 0, 0, 0, 0, anchor.Location())
+		return monthStart.AddDate(0, -n, 0)
+	}
+	return anchor.AddDate(0, 0, -n)
+}
+
+// baselinePeriods returns the anchors of the periods a baseline compares
+// the current period (anchored at anchor) against.
+func baselinePeriods(period, baseline string, anchor time.Time) []time.Time {
+	switch baseline {
+	case config.BaselineLastYear:
+		return []time.Time{anchor.AddDate(-1, 0, 0)}
+	case config.BaselineRolling3:
+		return []time.Time{periodsBack(period, anchor, 1), periodsBack(period, anchor, 2), periodsBack(period, anchor, 3)}
+	}
+	return []time.Time{periodsBack(period, anchor, 1)}
+}
+
+// baselineDays is how many days back a fetch must reach so every baseline
+// period before asOf is covered. It never returns less than days.
+func baselineDays(period, baseline string, asOf time.Time, days int) int {
+	asOf = time.Date(asOf.Year(), asOf.Month(), asOf.Day(), 0, 0, 0, 0, time.UTC)
+	earliest := asOf
+	for _, anchor := range baselinePeriods(period, baseline, asOf) {
+		if start, _ := periodBounds(period, anchor); start.Before(earliest) {
+			earliest = start
+		}
+	}
+	if needed := int(asOf.Sub(earliest).Hours()/24) + 1; needed > days {
+		return needed
+	}
+	return days
+}
+
+// applyBaseline replaces a summary's comparison with one against baseline:
+// the same period a year earlier, or the average of the three periods
+// before. transactions must reach back over the baseline (see baselineDays).
+// A baseline period that ends before the earliest transaction has no data
+// rather than zero spend, so it is left out of the average; when no baseline
+// period has data, Previously is 0, ChangePercentage is omitted and the
+// returned warning says why.
+func applyBaseline(summary *types.Summary, transactions []types.Transaction, period, baseline string) string {
+	layout := "2006-01-02"
+	summary.Baseline = baseline
+	totals := make(map[string]float64)
+	var first, anchor time.Time
+	for _, txn := range excludeFutureDated(transactions) {
+		t, err := time.Parse(layout, txn.Date)
+		if err != nil {
+			continue
+		}
+		totals[txn.Date] += spendAmount(txn)
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t.After(anchor) {
+			anchor = t
+		}
+	}
+	if anchor.IsZero() {
+		return ""
+	}
+
+	var sum float64
+	covered := 0
+	for _, baselineAnchor := range baselinePeriods(period, baseline, anchor) {
+		start, end := periodBounds(period, baselineAnchor)
+		if end.Before(first) {
+			continue
+		}
+		covered++
+		for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+			sum += totals[d.Format(layout)]
+		}
+	}
+	if covered == 0 {
+		summary.Previously = 0
+		summary.ChangePercentage = nil
+		return fmt.Sprintf("No transactions cover the %s baseline; changePercentage is omitted", baseline)
+	}
+	summary.Previously = sum / float64(covered)
+	summary.ChangePercentage = changePercentage(summary.Total, summary.Previously)
+	roundSummary(summary)
+	return ""
+}
+
+// trimToWindow keeps the transactions dated on or after the start of a
+// days-long window ending at asOf, dropping what a wider baseline fetch
+// pulled in.
+func trimToWindow(transactions []types.Transaction, days int, asOf time.Time) []types.Transaction {
+	windowStart, _ := services.QueryBounds(days, asOf)
+	start := windowStart.Format("2006-01-02")
+	var kept []types.Transaction
+	for _, txn := range transactions {
+		if txn.Date >= start {
+			kept = append(kept, txn)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+func TestTransactionsBaseline(t *testing.T) {
+	tests := []struct {
+		name       string
+		baseline   string
+		lastYear   bool
+		previously float64
+		change     float64
+		warning    string
+	}{
+		{name: "same week last year", baseline: "lastYear", lastYear: true, previously: 150, change: 100},
+		{name: "last year missing", baseline: "lastYear", warning: "No transactions cover the lastYear baseline"},
+		{name: "rolling three weeks", baseline: "rolling3", previously: 200, change: 50},
+	}
+	asOf := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	windowStart, _ := services.QueryBounds(14, asOf)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-15", 300, "SWIGGY")
+			env.addDebit("m2", "2024-03-05", 100, "AMAZON")
+			env.addDebit("m3", "2024-02-28", 200, "ZOMATO")
+			env.addDebit("m4", "2024-02-20", 300, "UBER")
+			if tt.lastYear {
+				env.addDebit("m5", "2023-03-12", 150, "SWIGGY")
+			}
+			target := "/transactions?filter=weekly&endDate=2024-03-15&baseline=" + tt.baseline + "&access_token=" + testToken
+
+			resp := decodeTransactions(t, env.do("GET", target))
+			if resp.Summary.Baseline != tt.baseline {
+				t.Errorf("baseline = %q, want %q", resp.Summary.Baseline, tt.baseline)
+			}
+			if resp.Summary.Previously != tt.previously {
+				t.Errorf("previously = %v, want %v", resp.Summary.Previously, tt.previously)
+			}
+			// A missing baseline omits changePercentage and says why.
+			switch {
+			case tt.warning != "" && resp.Summary.ChangePercentage != nil:
+				t.Errorf("changePercentage = %v, want null", *resp.Summary.ChangePercentage)
+			case tt.warning == "" && (resp.Summary.ChangePercentage == nil || *resp.Summary.ChangePercentage != tt.change):
+				t.Errorf("changePercentage = %v, want %v", resp.Summary.ChangePercentage, tt.change)
+			}
+			if tt.warning != "" && !strings.Contains(strings.Join(resp.Warnings, "\n"), tt.warning) {
+				t.Errorf("warnings %q lack %q", resp.Warnings, tt.warning)
+			}
+
+			// The wider baseline fetch must not leak into details or the
+			// reported window.
+			if len(resp.Details) != 2 {
+				t.Errorf("details %+v, want the two in the weekly window", resp.Details)
+			}
+			if got, want := resp.WindowStart, windowStart.Format(time.RFC3339); got != want {
+				t.Errorf("windowStart = %s, want %s", got, want)
+			}
+			streamed, trailer := readNDJSON(t, env.do("GET", target, "Accept", ndjsonContentType))
+			if len(streamed) != 2 {
+				t.Errorf("streamed %+v, want the two in the weekly window", streamed)
+			}
+			if trailer.Summary.Previously != tt.previously {
+				t.Errorf("trailer previously = %v, want %v", trailer.Summary.Previously, tt.previously)
+			}
+		})
+	}
+}
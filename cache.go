@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/go-redis/redis/v8"
+)
+
+func getETagKey(cacheKey string) string {
+	return cacheKey + ":etag"
+}
+
+// getStaleKey holds a long-lived copy of a cache entry, served when fresh
+// fetches are refused (e.g. the Gmail quota soft limit is reached).
+func getStaleKey(cacheKey string) string {
+	return cacheKey + ":stale"
+}
+
+// computeETag returns a strong ETag for a response body.
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// redisContext bounds a single Redis operation by the configured timeout so a
+// hung Redis can't stall the request that issued it.
+func redisContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, cfg.RedisTimeout)
+}
+
+// getCachedResponse reads a cache entry, from the in-memory tier when it has
+// it and otherwise from Redis, logging anything other than a plain miss (such
+// as a timeout) so callers can fall through to Gmail. Encrypted entries that
+// don't decrypt (wrong key, tampering) are treated as misses.
+func getCachedResponse(parent context.Context, key string) ([]byte, error) {
+	body, _, err := getCachedResponseTTL(parent, key)
+	return body, err
+}
+
+// getCachedResponseTTL is getCachedResponse that also returns how long the
+// Redis entry has left, 0 or less when unknown. The in-memory tier keeps the
+// Redis expiry, so a hit there costs no round trip.
+func getCachedResponseTTL(parent context.Context, key string) ([]byte, time.Duration, error) {
+	if body, _, redisExpires, ok := memCache.get(key); ok {
+		return body, time.Until(redisExpires), nil
+	}
+	body, ttl, err := getRedisResponse(parent, key)
+	if err == nil {
+		memCache.set(key, body, "", time.Now().Add(ttl))
+	}
+	return body, ttl, err
+}
+
+// getRedisResponse reads a cache entry and its remaining TTL (0 when it has
+// none) from Redis in one round trip.
+func getRedisResponse(parent context.Context, key string) ([]byte, time.Duration, error) {
+	opCtx, cancel := redisContext(parent)
+	defer cancel()
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	redisClient.Pipelined(opCtx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(opCtx, key)
+		pttl = pipe.PTTL(opCtx, key)
+		return nil
+	})
+	data, err := get.Bytes()
+	if err != nil && err != redis.Nil {
+		logger.Ctx(parent).Warnf("Error reading Redis cache: %v", err)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl := pttl.Val()
+	if ttl < 0 {
+		ttl = 0
+	}
+	if !cfg.CacheEncryption {
+		return data, ttl, nil
+	}
+	plain, err := decrypt(data)
+	if err != nil {
+		logger.Ctx(parent).Warnf("Error decrypting cache entry %s: %v", key, err)
+		return nil, 0, err
+	}
+	return plain, ttl, nil
+}
+
+// getFetchedAtKey records when the fetch behind a cache entry started, so
+// an older fetch that finishes late can't overwrite a newer entry.
+func getFetchedAtKey(cacheKey string) string {
+	return cacheKey + ":fetchedAt"
+}
+
+// conditionalCacheWrite sets a cache entry, its ETag, fetch time and stale
+// copy in one step, unless the stored fetch time is newer than ours.
+// KEYS: body, etag, fetchedAt, stale. ARGV: body, etag, fetchedAt (unix
+// nanoseconds), ttl ms, stale ttl ms. Returns 1 if written, 0 if skipped.
+var conditionalCacheWrite = redis.NewScript(`
+local current = redis.call('GET', KEYS[3])
+if current and tonumber(current) > tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[4])
+redis.call('SET', KEYS[2], ARGV[2], 'PX', ARGV[4])
+redis.call('SET', KEYS[3], ARGV[3], 'PX', ARGV[5])
+redis.call('SET', KEYS[4], ARGV[1], 'PX', ARGV[5])
+return 1
+`)
+
+// cacheResponse stores a marshalled response and its ETag under the same TTL.
+// fetchedAt is when the Gmail fetch behind it started; if the entry already
+// holds data from a later fetch, the write is skipped so a slow request can't
+// clobber fresher data. With CACHE_ENCRYPTION the body is stored encrypted; if
+// it can't be, nothing is cached rather than falling back to plaintext.
+// Bodies over MAX_CACHE_ENTRY_BYTES aren't cached at all. It reports whether
+// the body was cached.
+func cacheResponse(parent context.Context, key string, body []byte, ttl time.Duration, fetchedAt time.Time) bool {
+	if len(body) > cfg.MaxCacheEntryBytes {
+		logger.Ctx(parent).Warnf("Not caching %s: %d bytes is over the %d byte limit", key, len(body), cfg.MaxCacheEntryBytes)
+		return false
+	}
+	plain, etag := body, computeETag(body)
+	if cfg.CacheEncryption {
+		sealed, err := encrypt(body)
+		if err != nil {
+			logger.Ctx(parent).Errorf("Not caching %s: %v", key, err)
+			return false
+		}
+		body = sealed
+	}
+	opCtx, cancel := redisContext(parent)
+	defer cancel()
+	written, err := conditionalCacheWrite.Run(opCtx, redisClient,
+		[]string{key, getETagKey(key), getFetchedAtKey(key), getStaleKey(key)},
+		body, etag, fetchedAt.UnixNano(), ttl.Milliseconds(), cfg.StaleCacheTTL.Milliseconds()).Int()
+	if err != nil {
+		logger.Ctx(parent).Errorf("Error setting Redis cache: %v", err)
+		return false
+	}
+	if written == 0 {
+		logger.Ctx(parent).Debugf("Skipped caching %s: a newer fetch already cached it", key)
+		return false
+	}
+	memCache.set(key, plain, etag, time.Now().Add(ttl))
+	return true
+}
+
+// setCacheControl lets browsers and CDNs reuse a response for maxAge, the
+// time left on the Redis entry it came from, so a re-fetch lines up with the
+// server's own freshness. Responses that aren't cached server-side
+// (maxAge under a second) must be revalidated, which their ETag makes cheap.
+// Responses vary by credential, so shared caches key on it.
+func setCacheControl(w http.ResponseWriter, maxAge time.Duration) {
+	if cfg.CacheControl == config.CacheControlOff {
+		return
+	}
+	if maxAge < time.Second {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", cfg.CacheControl, int(maxAge/time.Second)))
+	w.Header().Add("Vary", sessionHeader)
+}
+
+// getStaleResponse returns the long-lived copy of a cache entry, if any.
+func getStaleResponse(parent context.Context, key string) ([]byte, error) {
+	return getCachedResponse(parent, getStaleKey(key))
+}
+
+// getCachedETag returns the stored ETag for a cache entry, recomputing it from
+// the body when the ETag key is missing (e.g. entries written before ETags).
+func getCachedETag(parent context.Context, key string, body []byte) string {
+	if _, etag, _, ok := memCache.get(key); ok && etag != "" {
+		return etag
+	}
+	opCtx, cancel := redisContext(parent)
+	defer cancel()
+	etag, err := redisClient.Get(opCtx, getETagKey(key)).Result()
+	if err != nil || etag == "" {
+		return computeETag(body)
+	}
+	return etag
+}
+
+// writeJSONWithETag writes a JSON body with its ETag, answering 304 when the
+// client's If-None-Match already matches.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, body []byte, etag string) {
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
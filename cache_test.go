@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+func TestTransactionsConditionalGet(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+	target := "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+
+	first := env.do("GET", target)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first request: status %d: %s", first.Code, first.Body.String())
+	}
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("first response has no ETag")
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        int
+	}{
+		{"matching", etag, http.StatusNotModified},
+		{"weak form", "W/" + etag, http.StatusNotModified},
+		{"in a list", `"stale", ` + etag, http.StatusNotModified},
+		{"wildcard", "*", http.StatusNotModified},
+		{"different", `"something-else"`, http.StatusOK},
+		{"absent", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.ifNoneMatch != "" {
+				headers = []string{"If-None-Match", tt.ifNoneMatch}
+			}
+			rec := env.do("GET", target, headers...)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag %q, want %q", got, etag)
+			}
+			if tt.want == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("304 has a body: %q", rec.Body.String())
+			}
+		})
+	}
+	// Every request after the first is answered from the cache.
+	if got := env.gmail.Calls(gmailtest.List); got != 1 {
+		t.Errorf("Gmail listed %d times, want 1", got)
+	}
+}
+
+func TestSlowRedisFallsThroughToGmail(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	tests := []struct {
+		name      string
+		delay     time.Duration
+		gmailHits int
+	}{
+		{"responsive Redis serves the second request", 0, 1},
+		{"slow Redis times out to Gmail", 4 * timeout, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) {
+				c.RedisTimeout = timeout
+				c.MemoryCacheSize = 0
+			})
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			target := "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+			decodeTransactions(t, env.do("GET", target))
+
+			env.redis.SetDelay(tt.delay)
+			start := time.Now()
+			getCachedResponse(context.Background(), getCacheKey(testEmail, "weekly"))
+			if elapsed := time.Since(start); tt.delay > 0 && elapsed >= tt.delay {
+				t.Errorf("cache read took %s, want it cut off at %s", elapsed, timeout)
+			}
+
+			resp := decodeTransactions(t, env.do("GET", target))
+			if len(resp.Details) != 1 {
+				t.Errorf("got %d transactions, want 1", len(resp.Details))
+			}
+			if got := env.gmail.Calls(gmailtest.List); got != tt.gmailHits {
+				t.Errorf("Gmail listed %d times, want %d", got, tt.gmailHits)
+			}
+		})
+	}
+}
+
+func TestMessageIDSurvivesCache(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("18e2f0c9a1b2c3d4", "2024-03-13", 120, "AMAZON")
+	env.addDebit("18e2f0c9a1b2c3d5", "2024-03-14", 80, "SWIGGY")
+	target := "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+
+	for _, name := range []string{"fetched from Gmail", "served from cache"} {
+		t.Run(name, func(t *testing.T) {
+			rec := env.do("GET", target)
+			if !strings.Contains(rec.Body.String(), `"messageId":"18e2f0c9a1b2c3d4"`) {
+				t.Errorf("serialized body lacks messageId: %s", rec.Body.String())
+			}
+			ids := map[string]string{}
+			for _, txn := range decodeTransactions(t, rec).Details {
+				ids[txn.Merchant] = txn.MessageID
+			}
+			if ids["AMAZON"] != "18e2f0c9a1b2c3d4" || ids["SWIGGY"] != "18e2f0c9a1b2c3d5" {
+				t.Errorf("message IDs = %v", ids)
+			}
+		})
+	}
+	if got := env.gmail.Calls(gmailtest.List); got != 1 {
+		t.Errorf("Gmail listed %d times, want 1 (second request from cache)", got)
+	}
+}
+
+func TestQuotaSoftLimitServesStaleCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		check  func(t *testing.T, rec *httptest.ResponseRecorder)
+	}{
+		{"transactions", "GET", "/transactions?filter=weekly&endDate=2024-03-15", func(t *testing.T, rec *httptest.ResponseRecorder) {
+			resp := decodeTransactions(t, rec)
+			if len(resp.Details) != 1 || len(resp.Warnings) == 0 {
+				t.Errorf("details/warnings = %d/%q, want the stale transaction and a warning", len(resp.Details), resp.Warnings)
+			}
+		}},
+		{"refresh", "POST", "/refresh", func(t *testing.T, rec *httptest.ResponseRecorder) {
+			var body struct {
+				Success  bool     `json:"success"`
+				Warnings []string `json:"warnings"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if !body.Success || len(body.Warnings) != len(refreshFilters) {
+				t.Errorf("body = %s, want success with a warning per period", rec.Body.String())
+			}
+		}},
+		{"aggregate", "GET", "/transactions/aggregate?filter=weekly&groupBy=merchant", func(t *testing.T, rec *httptest.ResponseRecorder) {
+			var buckets []types.AggregateBucket
+			json.Unmarshal(rec.Body.Bytes(), &buckets)
+			if len(buckets) != 1 || buckets[0].Key != "AMAZON" {
+				t.Errorf("buckets = %s, want the stale AMAZON total", rec.Body.String())
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			// Seed every view's cache, then let the fresh copies expire.
+			decodeTransactions(t, env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token="+testToken))
+			if rec := env.do("POST", "/refresh?access_token="+testToken); rec.Code != http.StatusOK {
+				t.Fatalf("seeding refresh: %d %s", rec.Code, rec.Body.String())
+			}
+			env.redis.FastForward(3 * time.Hour)
+			memCache = newMemoryCache(0, 0)
+			quotaTracker = services.NewQuotaTracker(redisClient, cfg.QuotaWindow, 1, cfg.RedisTimeout)
+			quotaTracker.Record(context.Background(), services.CallList, 1)
+			lists := env.gmail.Calls(gmailtest.List)
+
+			sep := "?"
+			if strings.Contains(tt.query, "?") {
+				sep = "&"
+			}
+			rec := env.do(tt.method, tt.query+sep+"access_token="+testToken)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			tt.check(t, rec)
+			if got := env.gmail.Calls(gmailtest.List); got != lists {
+				t.Errorf("Gmail listed %d more times over the soft limit", got-lists)
+			}
+		})
+	}
+}
+
+func TestCacheKeyVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		version string
+		want    string
+	}{
+		{"defaults", "funmon", "", "funmon:v%d:transactions:" + testEmail + ":weekly"},
+		{"bumped version", "funmon", "2", "funmon:v%d-2:transactions:" + testEmail + ":weekly"},
+		{"shared Redis prefix", "staging", "", "staging:v%d:transactions:" + testEmail + ":weekly"},
+	}
+	keys := map[string]bool{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestEnv(t, func(c *config.Config) {
+				c.CachePrefix = tt.prefix
+				c.CacheVersion = tt.version
+			})
+			key := getCacheKey(testEmail, "weekly")
+			if want := fmt.Sprintf(tt.want, cacheSchemaVersion); key != want {
+				t.Errorf("key %q, want %q", key, want)
+			}
+			if keys[key] {
+				t.Errorf("key %q shared with another configuration", key)
+			}
+			keys[key] = true
+		})
+	}
+}
+
+func TestCacheVersionBumpMissesOldEntries(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+	target := "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+
+	for i, version := range []string{"", "", "2", "2"} {
+		cfg.CacheVersion = version
+		decodeTransactions(t, env.do("GET", target))
+		// Only the first request under each version reaches Gmail.
+		if want := i/2 + 1; env.gmail.Calls(gmailtest.List) != want {
+			t.Fatalf("request %d under version %q: Gmail listed %d times, want %d", i+1, version, env.gmail.Calls(gmailtest.List), want)
+		}
+	}
+}
+
+func TestEncryptedCache(t *testing.T) {
+	key := []byte(strings.Repeat("k", 32))
+	tests := []struct {
+		name      string
+		encrypt   bool
+		readKey   []byte
+		plaintext bool
+		gmailHits int
+	}{
+		{"encryption off", false, key, true, 1},
+		{"round trip", true, key, false, 1},
+		{"rotated key fails closed", true, []byte(strings.Repeat("r", 32)), false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) {
+				c.CacheEncryption = tt.encrypt
+				c.EncryptionKey = key
+				c.MemoryCacheSize = 0
+			})
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			target := "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+			decodeTransactions(t, env.do("GET", target))
+
+			stored, ok := env.redis.Get(getCacheKey(testEmail, "weekly", "endDate=2024-03-15"))
+			if !ok {
+				t.Fatal("nothing cached")
+			}
+			if got := strings.Contains(stored, "AMAZON"); got != tt.plaintext {
+				t.Errorf("cache entry readable = %v, want %v", got, tt.plaintext)
+			}
+
+			cfg.EncryptionKey = tt.readKey
+			resp := decodeTransactions(t, env.do("GET", target))
+			if len(resp.Details) != 1 || resp.Details[0].Merchant != "AMAZON" {
+				t.Errorf("details = %+v, want the AMAZON debit", resp.Details)
+			}
+			if got := env.gmail.Calls(gmailtest.List); got != tt.gmailHits {
+				t.Errorf("Gmail listed %d times, want %d", got, tt.gmailHits)
+			}
+		})
+	}
+}
+
+func TestCacheWriteFresherWins(t *testing.T) {
+	base := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		writes  []int // fetch start offsets in seconds, in write order
+		written []bool
+		want    int
+	}{
+		{"in order", []int{1, 2}, []bool{true, true}, 2},
+		{"slow older fetch lands last", []int{2, 1}, []bool{true, false}, 2},
+		{"same fetch time rewrites", []int{3, 3}, []bool{true, true}, 3},
+		{"interleaved", []int{1, 5, 3, 4}, []bool{true, true, false, false}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestEnv(t, func(c *config.Config) { c.MemoryCacheSize = 0 })
+			key := getCacheKey(testEmail, "weekly")
+			for i, offset := range tt.writes {
+				body := []byte(fmt.Sprintf(`{"fetch":%d}`, offset))
+				if got := cacheResponse(context.Background(), key, body, time.Hour, base.Add(time.Duration(offset)*time.Second)); got != tt.written[i] {
+					t.Errorf("write %d (fetch %d) cached = %v, want %v", i, offset, got, tt.written[i])
+				}
+			}
+			body, err := getCachedResponse(context.Background(), key)
+			if want := fmt.Sprintf(`{"fetch":%d}`, tt.want); err != nil || string(body) != want {
+				t.Errorf("cached %s (err %v), want %s", body, err, want)
+			}
+		})
+	}
+}
+
+func TestConcurrentCacheWrites(t *testing.T) {
+	env := newTestEnv(t, func(c *config.Config) { c.MemoryCacheSize = 0 })
+	key := getCacheKey(testEmail, "weekly")
+	base := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Fetch times are shuffled against the order the writers start in.
+			offset := (i * 7) % writers
+			cacheResponse(context.Background(), key, []byte(fmt.Sprintf(`{"fetch":%d}`, offset)), time.Hour, base.Add(time.Duration(offset)*time.Second))
+		}(i)
+	}
+	wg.Wait()
+	body, err := getCachedResponse(context.Background(), key)
+	if want := fmt.Sprintf(`{"fetch":%d}`, writers-1); err != nil || string(body) != want {
+		t.Errorf("cached %s (err %v), want the freshest fetch %s", body, err, want)
+	}
+	if etag, _ := env.redis.Get(getETagKey(key)); etag != computeETag(body) {
+		t.Errorf("ETag %q doesn't match the cached body", etag)
+	}
+}
+
+func TestCacheEntrySizeLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		cached    bool
+		gmailHits int
+	}{
+		{"under the limit is cached", 8 << 20, true, 1},
+		{"oversized is served but not cached", 64, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) {
+				c.MaxCacheEntryBytes = tt.limit
+				c.MemoryCacheSize = 0
+			})
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			target := "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+
+			for i := 0; i < 2; i++ {
+				if resp := decodeTransactions(t, env.do("GET", target)); len(resp.Details) != 1 {
+					t.Fatalf("request %d: got %d transactions, want 1", i+1, len(resp.Details))
+				}
+			}
+			key := getCacheKey(testEmail, "weekly") + ":endDate=2024-03-15"
+			if _, ok := env.redis.Get(key); ok != tt.cached {
+				t.Errorf("%s cached = %v, want %v", key, ok, tt.cached)
+			}
+			if got := env.gmail.Calls(gmailtest.List); got != tt.gmailHits {
+				t.Errorf("Gmail listed %d times, want %d", got, tt.gmailHits)
+			}
+		})
+	}
+}
+
+func TestTransactionsCacheControl(t *testing.T) {
+	const target = "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+	ttl := int(transactionsCacheTTL / time.Second)
+	tests := []struct {
+		name string
+		// fresh checks the response that fills the cache rather than the
+		// one after it, which is served from the cache.
+		fresh    bool
+		memCache bool
+		marker   time.Time
+		wait     time.Duration
+		query    string
+		// header is the expected Cache-Control, or empty to expect
+		// maxAge seconds.
+		header string
+		maxAge int
+	}{
+		{name: "fresh fetch", fresh: true, maxAge: ttl},
+		{name: "Redis hit", wait: 30 * time.Minute, maxAge: ttl - 30*60},
+		{name: "memory hit", memCache: true, maxAge: ttl},
+		{name: "flagged as new", marker: time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC), header: "no-cache"},
+		{name: "marker with nothing new", marker: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), maxAge: ttl},
+		{name: "error", query: "&baseline=bogus", header: "no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) {
+				if tt.memCache {
+					c.MemoryCacheSize = 10
+				}
+			})
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			if !tt.marker.IsZero() {
+				env.redis.Set(getLastSeenKey(testEmail), strconv.FormatInt(tt.marker.UnixNano(), 10))
+			}
+
+			rec := env.do("GET", target+tt.query)
+			pttlCalls := env.redis.Calls("PTTL")
+			if !tt.fresh {
+				env.redis.FastForward(tt.wait)
+				rec = env.do("GET", target+tt.query)
+			}
+
+			got := rec.Header().Get("Cache-Control")
+			if tt.header != "" {
+				if got != tt.header {
+					t.Errorf("Cache-Control = %q, want %q", got, tt.header)
+				}
+				return
+			}
+			var maxAge int
+			if _, err := fmt.Sscanf(got, "private, max-age=%d", &maxAge); err != nil {
+				t.Fatalf("Cache-Control = %q, want private with a max-age", got)
+			}
+			// A second may pass between the write and the read.
+			if maxAge > tt.maxAge || maxAge < tt.maxAge-1 {
+				t.Errorf("max-age = %d, want %d", maxAge, tt.maxAge)
+			}
+			if tt.memCache && env.redis.Calls("PTTL") != pttlCalls {
+				t.Error("a memory cache hit asked Redis for the TTL")
+			}
+		})
+	}
+}
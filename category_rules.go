@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+// categoryRulesHandler lets a user list (GET), create (POST) and delete
+// (DELETE ?match=...) their merchant-to-category rules. Changing rules drops
+// the user's cached transactions so the next fetch is reclassified.
+func categoryRulesHandler(w http.ResponseWriter, r *http.Request) {
+	_, userID, ok := gmailServiceFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		opCtx, cancel := redisContext(r.Context())
+		defer cancel()
+		rules, err := services.LoadCategoryRules(opCtx, redisClient, cfg.CachePrefix, userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to load category rules")
+			return
+		}
+		list := make([]services.CategoryRule, 0, len(rules))
+		for match, category := range rules {
+			list = append(list, services.CategoryRule{Match: match, Category: category})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Match < list[j].Match })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		var rule services.CategoryRule
+		if !decodeJSONBody(w, r, &rule) {
+			return
+		}
+		rule.Match = strings.TrimSpace(rule.Match)
+		rule.Category = strings.ToLower(strings.TrimSpace(rule.Category))
+		if rule.Match == "" {
+			respondError(w, http.StatusBadRequest, "Missing match")
+			return
+		}
+		if rule.Category == services.UncategorizedCategory || !services.IsKnownCategory(rule.Category) {
+			respondError(w, http.StatusBadRequest, "Unknown category")
+			return
+		}
+		opCtx, cancel := redisContext(r.Context())
+		defer cancel()
+		if err := services.SaveCategoryRule(opCtx, redisClient, cfg.CachePrefix, userID, rule); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to save category rule")
+			return
+		}
+		invalidateUserCache(r, userID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+
+	case http.MethodDelete:
+		match := strings.TrimSpace(r.URL.Query().Get("match"))
+		if match == "" {
+			respondError(w, http.StatusBadRequest, "Missing match")
+			return
+		}
+		opCtx, cancel := redisContext(r.Context())
+		defer cancel()
+		deleted, err := services.DeleteCategoryRule(opCtx, redisClient, cfg.CachePrefix, userID, match)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to delete category rule")
+			return
+		}
+		if !deleted {
+			respondError(w, http.StatusNotFound, "Category rule not found")
+			return
+		}
+		invalidateUserCache(r, userID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// globEscaper escapes the characters SCAN MATCH treats as patterns, so a
+// user ID containing them matches only itself.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// invalidateUserCache removes every cached transactions view for a user.
+// Each SCAN page and DEL gets its own REDIS_TIMEOUT_MS.
+func invalidateUserCache(r *http.Request, userID string) {
+	memCache.deletePrefix(userCachePrefix(userID))
+	pattern := globEscaper.Replace(userCachePrefix(userID)) + "*"
+	var cursor uint64
+	for {
+		opCtx, cancel := redisContext(r.Context())
+		keys, next, err := redisClient.Scan(opCtx, cursor, pattern, 100).Result()
+		cancel()
+		if err != nil {
+			logger.Ctx(r.Context()).Errorf("Error scanning cache keys for %s: %v", userID, err)
+			return
+		}
+		for _, key := range keys {
+			opCtx, cancel := redisContext(r.Context())
+			if err := redisClient.Del(opCtx, key).Err(); err != nil {
+				logger.Ctx(r.Context()).Errorf("Error deleting cache key %s: %v", key, err)
+			}
+			cancel()
+		}
+		if next == 0 {
+			return
+		}
+		cursor = next
+	}
+}
+
+// applyUserRules loads the user's category rules and merchant exclusions
+// onto the Gmail service. A failure only loses the custom rules or
+// exclusions, so it is logged rather than returned.
+func applyUserRules(ctx context.Context, gs *services.GmailService, userID string) {
+	opCtx, cancel := redisContext(ctx)
+	rules, err := services.LoadCategoryRules(opCtx, redisClient, cfg.CachePrefix, userID)
+	cancel()
+	if err != nil {
+		logger.Ctx(ctx).Warnf("Error loading category rules for %s: %v", userID, err)
+	} else {
+		gs.SetCategoryRules(rules)
+	}
+	opCtx, cancel = redisContext(ctx)
+	exclusions, err := services.LoadExclusions(opCtx, redisClient, cfg.CachePrefix, userID)
+	cancel()
+	if err != nil {
+		logger.Ctx(ctx).Warnf("Error loading exclusions for %s: %v", userID, err)
+	} else {
+		gs.SetExclusions(exclusions)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+func TestCategoryRuleChangesClassification(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-13", 42, "CORNER STORE")
+	env.addDebit("m2", "2024-03-14", 300, "SWIGGY")
+	target := "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+
+	categories := func() map[string]string {
+		t.Helper()
+		got := make(map[string]string)
+		for _, txn := range decodeTransactions(t, env.do("GET", target)).Details {
+			got[txn.Merchant] = txn.Category
+		}
+		return got
+	}
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		want   map[string]string
+	}{
+		{name: "defaults", want: map[string]string{"CORNER STORE": "uncategorized", "SWIGGY": "food"}},
+		{name: "add rule", method: "POST", path: "/categories/rules", body: `{"match":"Corner","category":"Groceries"}`,
+			status: http.StatusCreated, want: map[string]string{"CORNER STORE": "groceries", "SWIGGY": "food"}},
+		{name: "rule beats default", method: "POST", path: "/categories/rules", body: `{"match":"swiggy","category":"groceries"}`,
+			status: http.StatusCreated, want: map[string]string{"CORNER STORE": "groceries", "SWIGGY": "groceries"}},
+		{name: "unknown category", method: "POST", path: "/categories/rules", body: `{"match":"x","category":"pets"}`,
+			status: http.StatusBadRequest, want: map[string]string{"CORNER STORE": "groceries", "SWIGGY": "groceries"}},
+		{name: "delete rule", method: "DELETE", path: "/categories/rules?match=CORNER",
+			status: http.StatusNoContent, want: map[string]string{"CORNER STORE": "uncategorized", "SWIGGY": "groceries"}},
+		{name: "delete missing rule", method: "DELETE", path: "/categories/rules?match=nothing",
+			status: http.StatusNotFound, want: map[string]string{"CORNER STORE": "uncategorized", "SWIGGY": "groceries"}},
+	}
+	for _, step := range steps {
+		if step.method != "" {
+			sep := "?"
+			if u, _ := url.Parse(step.path); u.RawQuery != "" {
+				sep = "&"
+			}
+			rec := env.doBody(step.method, step.path+sep+"access_token="+testToken, step.body, "Content-Type", "application/json")
+			if rec.Code != step.status {
+				t.Fatalf("%s: status %d, want %d: %s", step.name, rec.Code, step.status, rec.Body.String())
+			}
+		}
+		got := categories()
+		for merchant, want := range step.want {
+			if got[merchant] != want {
+				t.Errorf("%s: %s is %q, want %q", step.name, merchant, got[merchant], want)
+			}
+		}
+	}
+
+	var rules []map[string]string
+	json.Unmarshal(env.do("GET", "/categories/rules?access_token="+testToken).Body.Bytes(), &rules)
+	if len(rules) != 1 || rules[0]["match"] != "swiggy" || rules[0]["category"] != "groceries" {
+		t.Errorf("rules = %v, want only swiggy -> groceries", rules)
+	}
+}
+
+func TestInvalidateUserCacheEscapesGlob(t *testing.T) {
+	tests := []struct {
+		user  string
+		other string
+	}{
+		{"a*@example.com", "abc@example.com"},
+		{"a?c@example.com", "abc@example.com"},
+		{"[ab]c@example.com", "ac@example.com"},
+		{`a\bc@example.com`, "abc@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.redis.Set(getCacheKey(tt.user, "weekly"), "{}")
+			env.redis.Set(getCacheKey(tt.other, "weekly"), "{}")
+
+			invalidateUserCache(httptest.NewRequest("POST", "/categories/rules", nil), tt.user)
+
+			if _, ok := env.redis.Get(getCacheKey(tt.user, "weekly")); ok {
+				t.Errorf("%s's cache survived", tt.user)
+			}
+			if _, ok := env.redis.Get(getCacheKey(tt.other, "weekly")); !ok {
+				t.Errorf("invalidating %s also dropped %s's cache", tt.user, tt.other)
+			}
+		})
+	}
+}
+
+func TestInvalidateUserCacheTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	env := newTestEnv(t, func(c *config.Config) { c.RedisTimeout = timeout })
+	env.redis.Set(getCacheKey(testEmail, "weekly"), "{}")
+	env.redis.SetDelay(10 * timeout)
+
+	start := time.Now()
+	invalidateUserCache(httptest.NewRequest("POST", "/exclusions", nil), testEmail)
+	if elapsed := time.Since(start); elapsed >= 10*timeout {
+		t.Errorf("invalidation took %s, want Redis cut off at %s", elapsed, timeout)
+	}
+}
+
+func TestCategoryRulesRedis(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		delay        time.Duration
+		status       int
+		stored       string
+	}{
+		{name: "list", method: "GET", path: "/categories/rules", status: http.StatusOK, stored: "map[corner:groceries]"},
+		{name: "add", method: "POST", path: "/categories/rules", body: `{"match":"swiggy","category":"food"}`,
+			status: http.StatusCreated, stored: "map[corner:groceries swiggy:food]"},
+		{name: "delete", method: "DELETE", path: "/categories/rules?match=corner", status: http.StatusNoContent, stored: "map[]"},
+		{name: "slow list", method: "GET", path: "/categories/rules", delay: 10 * timeout, status: http.StatusInternalServerError},
+		{name: "slow add", method: "POST", path: "/categories/rules", body: `{"match":"swiggy","category":"food"}`,
+			delay: 10 * timeout, status: http.StatusInternalServerError},
+		{name: "slow delete", method: "DELETE", path: "/categories/rules?match=corner", delay: 10 * timeout, status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) {
+				c.CachePrefix = "staging"
+				c.RedisTimeout = timeout
+			})
+			rule := services.CategoryRule{Match: "corner", Category: "groceries"}
+			if err := services.SaveCategoryRule(context.Background(), redisClient, cfg.CachePrefix, testEmail, rule); err != nil {
+				t.Fatal(err)
+			}
+			sep := "?"
+			if u, _ := url.Parse(tt.path); u.RawQuery != "" {
+				sep = "&"
+			}
+
+			env.redis.SetDelay(tt.delay)
+			start := time.Now()
+			rec := env.doBody(tt.method, tt.path+sep+"access_token="+testToken, tt.body, "Content-Type", "application/json")
+			elapsed := time.Since(start)
+			env.redis.SetDelay(0)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.delay > 0 {
+				if elapsed >= tt.delay {
+					t.Errorf("request took %s, want Redis cut off at %s", elapsed, timeout)
+				}
+				return
+			}
+			if got := fmt.Sprint(env.redis.HGetAll("staging:category_rules:" + testEmail)); got != tt.stored {
+				t.Errorf("stored rules %s, want %s", got, tt.stored)
+			}
+		})
+	}
+}
+
+func TestApplyUserRulesTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	env := newTestEnv(t, func(c *config.Config) { c.RedisTimeout = timeout })
+	gs, userID, err := gmailServiceForToken(context.Background(), testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.redis.SetDelay(10 * timeout)
+
+	start := time.Now()
+	applyUserRules(context.Background(), gs, userID)
+	if elapsed := time.Since(start); elapsed >= 10*timeout {
+		t.Errorf("loading rules took %s, want Redis cut off at %s", elapsed, timeout)
+	}
+}
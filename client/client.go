@@ -0,0 +1,171 @@
+// Package client is a typed Go client for the funnyMoney API.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+// Client calls the API at BaseURL with a user's Gmail access token.
+type Client struct {
+	BaseURL     string
+	AccessToken string
+	HTTPClient  *http.Client
+}
+
+// New returns a client for the API at baseURL, e.g. "http://localhost:8080".
+func New(baseURL, accessToken string) *Client {
+	return &Client{
+		BaseURL:     strings.TrimRight(baseURL, "/"),
+		AccessToken: accessToken,
+		HTTPClient:  http.DefaultClient,
+	}
+}
+
+// Error is the API's standard error body, returned for any non-2xx response.
+type Error struct {
+	Message   string `json:"error"`
+	Code      int    `json:"code"`
+	ErrorCode string `json:"errorCode"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Code, e.ErrorCode, e.Message)
+}
+
+// TransactionsOptions are the optional /transactions query parameters. Zero
+// values are left out of the request.
+type TransactionsOptions struct {
+	Filter           string
+	Type             string
+	Category         string
+	Locale           string
+	MinAmount        *float64
+	IncludeTransfers bool
+	EndDate          string
+	MonthToDate      bool
+	Senders          []string
+	Fields           []string
+	PageSize         int
+	Cursor           string
+}
+
+func (o TransactionsOptions) values() url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("filter", o.Filter)
+	set("type", o.Type)
+	set("category", o.Category)
+	set("locale", o.Locale)
+	if o.MinAmount != nil {
+		v.Set("minAmount", strconv.FormatFloat(*o.MinAmount, 'f', -1, 64))
+	}
+	if o.IncludeTransfers {
+		v.Set("includeTransfers", "true")
+	}
+	set("endDate", o.EndDate)
+	if o.MonthToDate {
+		v.Set("monthToDate", "true")
+	}
+	set("senders", strings.Join(o.Senders, ","))
+	set("fields", strings.Join(o.Fields, ","))
+	if o.PageSize > 0 {
+		v.Set("pageSize", strconv.Itoa(o.PageSize))
+	}
+	set("cursor", o.Cursor)
+	return v
+}
+
+// Transactions calls GET /transactions. With opts.Fields set, transactions
+// only carry the requested fields.
+func (c *Client) Transactions(ctx context.Context, opts TransactionsOptions) (*types.TransactionsResponse, error) {
+	var resp types.TransactionsResponse
+	if err := c.do(ctx, http.MethodGet, "/transactions", opts.values(), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Aggregate calls GET /transactions/aggregate, grouping by merchant,
+// category, day or account.
+func (c *Client) Aggregate(ctx context.Context, groupBy, filter string) ([]types.AggregateBucket, error) {
+	v := url.Values{"groupBy": {groupBy}}
+	if filter != "" {
+		v.Set("filter", filter)
+	}
+	var buckets []types.AggregateBucket
+	if err := c.do(ctx, http.MethodGet, "/transactions/aggregate", v, &buckets); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// Refresh calls POST /refresh to re-populate the cached views.
+func (c *Client) Refresh(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/refresh", nil, nil)
+}
+
+// Profile is the body of GET /whoami.
+type Profile struct {
+	EmailAddress  string `json:"emailAddress"`
+	MessagesTotal int64  `json:"messagesTotal"`
+}
+
+// WhoAmI calls GET /whoami to find the Gmail account the token belongs to.
+func (c *Client) WhoAmI(ctx context.Context) (*Profile, error) {
+	var profile Profile
+	if err := c.do(ctx, http.MethodGet, "/whoami", nil, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// do sends a request with the access token and decodes a 2xx JSON body into
+// out (when non-nil), or the standard error body into an *Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, out interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("access_token", c.AccessToken)
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{Code: resp.StatusCode}
+		body, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(body, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(body))
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s response: %v", path, err)
+	}
+	return nil
+}
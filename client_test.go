@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/client"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+)
+
+// TestClient drives the client package against the real router.
+func TestClient(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-13", 120, "AMAZON")
+	env.addDebit("m2", "2024-03-14", 80, "SWIGGY")
+	env.addCredit("m3", "2024-03-14", 500, "EMPLOYER")
+	srv := httptest.NewServer(env.handler)
+	t.Cleanup(srv.Close)
+	bg := context.Background()
+
+	tests := []struct {
+		name    string
+		token   string
+		call    func(c *client.Client) error
+		errCode int
+	}{
+		{"transactions", testToken, func(c *client.Client) error {
+			resp, err := c.Transactions(bg, client.TransactionsOptions{Filter: "weekly", Type: "debit", EndDate: "2024-03-15"})
+			if err == nil && (len(resp.Details) != 2 || resp.Summary.Expense != 200) {
+				t.Errorf("details %d, spent %v; want 2 debits totalling 200", len(resp.Details), resp.Summary.Expense)
+			}
+			return err
+		}, 0},
+		{"transactions page", testToken, func(c *client.Client) error {
+			resp, err := c.Transactions(bg, client.TransactionsOptions{Filter: "weekly", EndDate: "2024-03-15", PageSize: 1})
+			if err == nil && (len(resp.Details) != 1 || resp.NextCursor == "") {
+				t.Errorf("details %d, cursor %q; want one transaction and a cursor", len(resp.Details), resp.NextCursor)
+			}
+			return err
+		}, 0},
+		{"aggregate", testToken, func(c *client.Client) error {
+			buckets, err := c.Aggregate(bg, "merchant", "weekly")
+			if err == nil && len(buckets) == 0 {
+				t.Error("no buckets")
+			}
+			return err
+		}, 0},
+		{"refresh", testToken, func(c *client.Client) error { return c.Refresh(bg) }, 0},
+		{"whoami", testToken, func(c *client.Client) error {
+			profile, err := c.WhoAmI(bg)
+			if err == nil && profile.EmailAddress != testEmail {
+				t.Errorf("email %q, want %q", profile.EmailAddress, testEmail)
+			}
+			return err
+		}, 0},
+		{"bad parameter", testToken, func(c *client.Client) error {
+			_, err := c.Aggregate(bg, "weekday", "")
+			return err
+		}, http.StatusBadRequest},
+		{"unknown token", "not-granted", func(c *client.Client) error {
+			_, err := c.Transactions(bg, client.TransactionsOptions{})
+			return err
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call(client.New(srv.URL+"/", tt.token))
+			var apiErr *client.Error
+			switch {
+			case tt.errCode == 0 && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.errCode != 0 && !errors.As(err, &apiErr):
+				t.Fatalf("err = %v, want a *client.Error", err)
+			case tt.errCode != 0 && (apiErr.Code != tt.errCode || apiErr.Message == ""):
+				t.Errorf("error = %+v, want code %d with a message", apiErr, tt.errCode)
+			}
+		})
+	}
+	if env.gmail.Calls(gmailtest.Profile) == 0 {
+		t.Error("whoami never reached Gmail")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+// maxCompareSpanDays bounds how far apart the two compared ranges may reach,
+// since both are served from a single Gmail fetch.
+const maxCompareSpanDays = 366
+
+type dateRange struct {
+	From, To time.Time
+}
+
+func (dr dateRange) contains(date string) bool {
+	t, err := time.ParseInLocation("2006-01-02", date, dr.From.Location())
+	return err == nil && !t.Before(dr.From) && !t.After(dr.To)
+}
+
+// parseDateRange reads an inclusive YYYY-MM-DD range from two parameters.
+func parseDateRange(values url.Values, fromName, toName string, loc *time.Location) (dateRange, error) {
+	var dr dateRange
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{fromName, &dr.From}, {toName, &dr.To}} {
+		v := values.Get(p.name)
+		if v == "" {
+			return dr, fmt.Errorf("Missing %s", p.name)
+		}
+		t, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			return dr, fmt.Errorf("Invalid %s; expected YYYY-MM-DD", p.name)
+		}
+		*p.dst = t
+	}
+	if dr.To.Before(dr.From) {
+		return dr, fmt.Errorf("Invalid range; %s is after %s", fromName, toName)
+	}
+	return dr, nil
+}
+
+// summarizeRange summarizes the transactions dated within dr using the
+// "all" aggregation, so a range reports its total, count and daily average.
+func summarizeRange(transactions []types.Transaction, dr dateRange) (types.CompareRange, error) {
+	var inRange []types.Transaction
+	for _, txn := range transactions {
+		if dr.contains(txn.Date) {
+			inRange = append(inRange, txn)
+		}
+	}
+	summary, err := calculateSummary(inRange, "all")
+	return types.CompareRange{
+		From:    dr.From.Format("2006-01-02"),
+		To:      dr.To.Format("2006-01-02"),
+		Summary: summary,
+	}, err
+}
+
+// compareHandler serves GET /transactions/compare, summarizing two arbitrary
+// date ranges side by side with the percentage change from the first to the
+// second. Both ranges come from one Gmail fetch spanning them.
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	base, err := parseDateRange(values, "from", "to", cfg.Location)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	other, err := parseDateRange(values, "compareFrom", "compareTo", cfg.Location)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	start, end := base.From, base.To
+	if other.From.Before(start) {
+		start = other.From
+	}
+	if other.To.After(end) {
+		end = other.To
+	}
+	days := int(end.Sub(start).Hours()/24) + 1
+	if days > maxCompareSpanDays {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Ranges span %d days; at most %d are supported", days, maxCompareSpanDays))
+		return
+	}
+
+	gmailService, userID, ok := gmailServiceFromRequest(w, r)
+	if !ok {
+		return
+	}
+	applyUserRules(r.Context(), gmailService, userID)
+	gmailService.SetLocation(cfg.Location)
+	gmailService.SetEndDate(end)
+	result, err := gmailService.FetchTransactions(r.Context(), days)
+	if err != nil {
+		respondAppError(w, err)
+		return
+	}
+	transactions := excludeTransfers(result.Transactions)
+
+	response := types.CompareResponse{Warnings: result.Warnings}
+	if response.Base, err = summarizeRange(transactions, base); err == nil {
+		response.Compare, err = summarizeRange(transactions, other)
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if change := changePercentage(response.Compare.Summary.Total, response.Base.Summary.Total); change != nil {
+		rounded := services.RoundAmount(*change, cfg.AmountDecimals)
+		response.ChangePercentage = &rounded
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
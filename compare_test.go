@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		status        int
+		base, compare float64
+		change        string
+	}{
+		{"january vs february", "from=2024-01-01&to=2024-01-31&compareFrom=2024-02-01&compareTo=2024-02-29",
+			http.StatusOK, 400, 500, "25"},
+		{"february vs march so far", "from=2024-02-01&to=2024-02-29&compareFrom=2024-03-01&compareTo=2024-03-15",
+			http.StatusOK, 500, 125, "-75"},
+		{"empty base has no change", "from=2023-12-01&to=2023-12-31&compareFrom=2024-01-01&compareTo=2024-01-31",
+			http.StatusOK, 0, 400, "null"},
+		{"overlapping ranges", "from=2024-01-15&to=2024-02-15&compareFrom=2024-01-01&compareTo=2024-02-29",
+			http.StatusOK, 500, 900, "80"},
+		{"missing compare range", "from=2024-01-01&to=2024-01-31", http.StatusBadRequest, 0, 0, ""},
+		{"reversed range", "from=2024-01-31&to=2024-01-01&compareFrom=2024-02-01&compareTo=2024-02-29", http.StatusBadRequest, 0, 0, ""},
+		{"bad date", "from=2024-01-01&to=2024-01-31&compareFrom=Feb&compareTo=2024-02-29", http.StatusBadRequest, 0, 0, ""},
+		{"span too long", "from=2022-01-01&to=2022-01-31&compareFrom=2024-02-01&compareTo=2024-02-29", http.StatusBadRequest, 0, 0, ""},
+	}
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-01-05", 100, "CAFE")
+	env.addDebit("m2", "2024-01-20", 300, "AMAZON")
+	env.addDebit("m3", "2024-02-10", 200, "UBER")
+	env.addDebit("m4", "2024-02-28", 300, "RENT")
+	env.addDebit("m5", "2024-03-02", 125, "SWIGGY")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.do("GET", "/transactions/compare?"+tt.query+"&access_token="+testToken)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp types.CompareResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Base.Summary.Total != tt.base || resp.Compare.Summary.Total != tt.compare {
+				t.Errorf("totals %v vs %v, want %v vs %v", resp.Base.Summary.Total, resp.Compare.Summary.Total, tt.base, tt.compare)
+			}
+			if got := fmtPercent(resp.ChangePercentage); got != tt.change {
+				t.Errorf("change %s, want %s", got, tt.change)
+			}
+		})
+	}
+}
@@ -1,19 +1,584 @@
 package config
 
 import (
+	"encoding/base64"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
 )
 
 type Config struct {
 	GmailClientID     string
 	GmailClientSecret string
 	GmailTokenFile    string
+	MaxMessages       int
+	GzipMinSize       int
+	RedisTimeout      time.Duration
+	ParseDebug        bool
+	LogLevel          string
+	LogFormat         string
+	ScopeCheck        bool
+	// TokenInfoTTL is how long a token's tokeninfo result is reused.
+	TokenInfoTTL      time.Duration
+	IdempotencyTTL    time.Duration
+	AdminToken        string
+	TransferKeywords  []string
+	MinAmount         float64
+	WarmupAccessToken string
+	QuotaWindow       time.Duration
+	QuotaSoftLimit    int64
+	StaleCacheTTL     time.Duration
+	// CacheControl is the Cache-Control scope for cached /transactions
+	// responses (CacheControlPrivate or CacheControlPublic), whose max-age is
+	// what is left of the Redis entry; CacheControlOff sends no header.
+	CacheControl  string
+	SenderDomains []string
+	// CurrencySymbols maps a currency token as written in emails (upper-cased,
+	// e.g. "$", "RS") to an ISO code; IssuerCurrencySymbols does the same per
+	// sender domain and wins over it.
+	CurrencySymbols       map[string]string
+	IssuerCurrencySymbols map[string]map[string]string
+	// PartPreference is the order MIME types are tried when extracting an
+	// email body; IssuerPartPreference overrides it per sender domain.
+	PartPreference       []string
+	IssuerPartPreference map[string][]string
+	// IssuerPolarity marks sender domains as PolarityCard or PolarityBank.
+	// On a card a credit is a bill payment, not income.
+	IssuerPolarity map[string]string
+	// Days fetched from Gmail for each filter. They overshoot the period
+	// itself so the summary has a previous period to compare against.
+	DailyWindowDays   int
+	WeeklyWindowDays  int
+	MonthlyWindowDays int
+	AllWindowDays     int
+	// SchedulerInterval enables the background cache refresh when positive.
+	SchedulerInterval    time.Duration
+	SchedulerConcurrency int
+	MaxBodyBytes         int64
+	// Location is the default timezone whose calendar days filter windows
+	// cover; requests can override it with ?tz=.
+	Location *time.Location
+	// CachePrefix namespaces cache keys in a shared Redis; changing
+	// CacheVersion orphans every cached response without a deploy.
+	CachePrefix  string
+	CacheVersion string
+	// AmountDecimals is how many decimals amounts and totals are rounded to.
+	AmountDecimals int
+	// EncryptionKey is the AES key (16, 24 or 32 bytes, base64 in the
+	// environment) that sessions are stored under.
+	EncryptionKey []byte
+	SessionTTL    time.Duration
+	// CacheEncryption stores cached responses encrypted with EncryptionKey.
+	// It defaults to on whenever a key is configured.
+	CacheEncryption bool
+	// ParseTimeout bounds how long a single email may take to parse.
+	ParseTimeout time.Duration
+	// ShutdownDrain bounds how long shutdown waits for in-flight requests
+	// and background refreshes before cancelling them.
+	ShutdownDrain time.Duration
+	// MemoryCacheSize is how many responses the in-process LRU in front of
+	// Redis holds; 0 disables it. Entries expire after MemoryCacheTTL.
+	MemoryCacheSize int
+	MemoryCacheTTL  time.Duration
+	// MaxCacheEntryBytes is the largest marshalled response written to the
+	// cache; bigger ones are still served but not cached.
+	MaxCacheEntryBytes int
+	// StoreRawEmails keeps a compact, stripped copy of each parsed email for
+	// RawEmailTTL so /reparse can re-derive transactions without Gmail.
+	StoreRawEmails bool
+	// IncludeSpamTrash searches Spam and Trash as well by default; requests
+	// can override it with includeSpamTrash.
+	IncludeSpamTrash bool
+	// DedupWindow is how far apart two otherwise identical transactions from
+	// different accounts, or from one Gmail thread, may be and still count
+	// as one.
+	DedupWindow time.Duration
+	// ThreadDedup collapses transactions repeated by several messages in one
+	// Gmail thread into the most complete parse.
+	ThreadDedup bool
+	RawEmailTTL time.Duration
+	// PDFStatements turns on parsing line items from PDF statements attached
+	// to emails, for attachments up to PDFMaxBytes.
+	PDFStatements bool
+	PDFMaxBytes   int
+	// DigestEmails turns on parsing emails that list several transactions,
+	// each with its own amount and date. DigestMismatch says what to do when
+	// a digest's amounts and dates don't pair up one to one.
+	DigestEmails   bool
+	DigestMismatch string
+	// SkipBalanceEmails drops balance notifications (a balance but no
+	// debit or credit) before parsing, so the balance isn't taken as spend.
+	SkipBalanceEmails bool
+	// FetchBudget is a soft deadline for fetching email bodies, after which
+	// a fetch returns partial results; 0 means no deadline.
+	FetchBudget time.Duration
+	// BreakerThreshold is how many consecutive Gmail failures open the
+	// circuit breaker (0 disables it); it stays open for BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// MerchantAliases maps normalized merchant names (or their leading
+	// words) to the name they are grouped under.
+	MerchantAliases map[string]string
+	// Transactions dated more than FutureDateTolerance after now are dropped
+	// (FutureDateReject) or kept but flagged and left out of summaries
+	// (FutureDateFlag).
+	FutureDateTolerance time.Duration
+	FutureDatePolicy    string
+	// Transactions dated more than DatePlausibilityYears before now are
+	// re-dated from the email's Date header (OldDateHeader) or dropped
+	// (OldDateDrop).
+	DatePlausibilityYears int
+	OldDatePolicy         string
+	// SummaryBaseline is what a summary's changePercentage compares against
+	// when the request has no baseline parameter.
+	SummaryBaseline string
+	// AmountKeywords must appear within AmountKeywordWindow characters of an
+	// amount for it to count; a window of 0 disables the check.
+	AmountKeywords      []string
+	AmountKeywordWindow int
+	// FeeKeywords label an amount as a fee, charge or tax, reported apart
+	// from the transaction amount. An empty list turns fee detection off.
+	FeeKeywords []string
 }
 
 func LoadConfig() *Config {
+	encryptionKey := getEnvKey("ENCRYPTION_KEY")
 	return &Config{
 		GmailClientID:     os.Getenv("GMAIL_CLIENT_ID"),
 		GmailClientSecret: os.Getenv("GMAIL_CLIENT_SECRET"),
 		GmailTokenFile:    "token.json",
+		MaxMessages:       getEnvInt("MAX_MESSAGES", 500),
+		GzipMinSize:       getEnvInt("GZIP_MIN_SIZE", 1024),
+		RedisTimeout:      time.Duration(getEnvInt("REDIS_TIMEOUT_MS", 500)) * time.Millisecond,
+		ParseDebug:        getEnvBool("PARSE_DEBUG", false),
+		LogLevel:          os.Getenv("LOG_LEVEL"),
+		LogFormat:         os.Getenv("LOG_FORMAT"),
+		ScopeCheck:        getEnvBool("SCOPE_CHECK", true),
+		TokenInfoTTL:      time.Duration(getEnvInt("TOKENINFO_CACHE_SECONDS", 60)) * time.Second,
+		IdempotencyTTL:    time.Duration(getEnvInt("IDEMPOTENCY_TTL_SECONDS", 600)) * time.Second,
+		AdminToken:        os.Getenv("ADMIN_TOKEN"),
+		MinAmount:         getEnvFloat("MIN_TRANSACTION_AMOUNT", 0),
+		WarmupAccessToken: os.Getenv("WARMUP_ACCESS_TOKEN"),
+		QuotaWindow:       time.Duration(getEnvInt("GMAIL_QUOTA_WINDOW_SECONDS", 3600)) * time.Second,
+		QuotaSoftLimit:    int64(getEnvNonNegative("GMAIL_QUOTA_SOFT_LIMIT", 0)),
+		StaleCacheTTL:     time.Duration(getEnvInt("STALE_CACHE_TTL_SECONDS", 86400)) * time.Second,
+		CacheControl:      getEnvCacheControl("CACHE_CONTROL"),
+		TransferKeywords: getEnvList("SELF_TRANSFER_KEYWORDS",
+			[]string{"self transfer", "own account", "transfer to self", "between your accounts"}),
+		SenderDomains:         getEnvList("SENDER_DOMAINS", nil),
+		CurrencySymbols:       getEnvCurrencyMap("CURRENCY_SYMBOLS"),
+		IssuerCurrencySymbols: getEnvIssuerCurrencyMap("ISSUER_CURRENCY_SYMBOLS"),
+		PartPreference:        getEnvList("MIME_PART_PREFERENCE", []string{"text/plain", "text/html"}),
+		IssuerPartPreference:  getEnvIssuerPartPreference("ISSUER_MIME_PART_PREFERENCE"),
+		IssuerPolarity:        getEnvIssuerPolarity("ISSUER_POLARITY"),
+		DailyWindowDays:       getEnvInt("DAILY_WINDOW_DAYS", 2),
+		WeeklyWindowDays:      getEnvInt("WEEKLY_WINDOW_DAYS", 14),
+		MonthlyWindowDays:     getEnvInt("MONTHLY_WINDOW_DAYS", 60),
+		AllWindowDays:         getEnvInt("ALL_WINDOW_DAYS", 90),
+		SchedulerInterval:     time.Duration(getEnvInt("SCHEDULER_INTERVAL_SECONDS", 0)) * time.Second,
+		SchedulerConcurrency:  getEnvInt("SCHEDULER_CONCURRENCY", 2),
+		MaxBodyBytes:          int64(getEnvInt("MAX_BODY_BYTES", 1<<20)),
+		Location:              getEnvLocation("TIMEZONE", time.Local),
+		CachePrefix:           getEnvString("CACHE_PREFIX", "funmon"),
+		CacheVersion:          os.Getenv("CACHE_VERSION"),
+		AmountDecimals:        getEnvDecimals("AMOUNT_DECIMALS", 2),
+		EncryptionKey:         encryptionKey,
+		CacheEncryption:       getEnvBool("CACHE_ENCRYPTION", len(encryptionKey) > 0),
+		SessionTTL:            time.Duration(getEnvInt("SESSION_TTL_HOURS", 720)) * time.Hour,
+		ParseTimeout:          time.Duration(getEnvInt("PARSE_TIMEOUT_MS", 2000)) * time.Millisecond,
+		ShutdownDrain:         time.Duration(getEnvInt("SHUTDOWN_DRAIN_SECONDS", 30)) * time.Second,
+		MemoryCacheSize:       getEnvNonNegative("MEMORY_CACHE_SIZE", 0),
+		MemoryCacheTTL:        time.Duration(getEnvInt("MEMORY_CACHE_TTL_SECONDS", 30)) * time.Second,
+		MaxCacheEntryBytes:    getEnvInt("MAX_CACHE_ENTRY_BYTES", 8<<20),
+		StoreRawEmails:        getEnvBool("STORE_RAW_EMAILS", false),
+		IncludeSpamTrash:      getEnvBool("INCLUDE_SPAM_TRASH", false),
+		DedupWindow:           time.Duration(getEnvInt("DEDUP_WINDOW_MINUTES", 10)) * time.Minute,
+		ThreadDedup:           getEnvBool("THREAD_DEDUP", true),
+		RawEmailTTL:           time.Duration(getEnvInt("RAW_EMAIL_TTL_HOURS", 168)) * time.Hour,
+		PDFStatements:         getEnvBool("PDF_STATEMENTS", false),
+		PDFMaxBytes:           getEnvInt("PDF_MAX_BYTES", 2<<20),
+		DigestEmails:          getEnvBool("DIGEST_EMAILS", false),
+		DigestMismatch:        getEnvDigestMismatch("DIGEST_MISMATCH_STRATEGY"),
+		SkipBalanceEmails:     getEnvBool("SKIP_BALANCE_EMAILS", true),
+		FetchBudget:           time.Duration(getEnvNonNegative("FETCH_BUDGET_MS", 0)) * time.Millisecond,
+		BreakerThreshold:      getEnvNonNegative("GMAIL_BREAKER_THRESHOLD", 0),
+		BreakerCooldown:       time.Duration(getEnvInt("GMAIL_BREAKER_COOLDOWN_SECONDS", 30)) * time.Second,
+		MerchantAliases:       getEnvMerchantAliases("MERCHANT_ALIASES"),
+		FutureDateTolerance:   time.Duration(getEnvInt("FUTURE_DATE_TOLERANCE_HOURS", 24)) * time.Hour,
+		FutureDatePolicy:      getEnvFutureDatePolicy("FUTURE_DATE_POLICY"),
+		DatePlausibilityYears: getEnvNonNegative("DATE_PLAUSIBILITY_YEARS", 5),
+		OldDatePolicy:         getEnvOldDatePolicy("OLD_DATE_POLICY"),
+		SummaryBaseline:       getEnvSummaryBaseline("SUMMARY_BASELINE"),
+		AmountKeywords: getEnvList("AMOUNT_KEYWORDS",
+			[]string{"debited", "credited", "spent", "paid", "received", "withdrawn", "purchase", "txn", "transaction", "refund"}),
+		AmountKeywordWindow: getEnvNonNegative("AMOUNT_KEYWORD_WINDOW", 0),
+		FeeKeywords: getEnvList("FEE_KEYWORDS",
+			[]string{"fee", "fees", "charge", "charges", "surcharge", "gst", "tax"}),
+	}
+}
+
+// getEnvInt reads a positive integer from the environment, falling back to
+// def when the variable is unset or invalid.
+func getEnvInt(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v <= 0 {
+		logger.Warnf("Invalid %s=%q, using default %d", key, raw, def)
+		return def
+	}
+	return v
+}
+
+// getEnvNonNegative reads an integer that may be 0, for settings where 0
+// turns a feature off, falling back to def when the variable is unset or
+// invalid.
+func getEnvNonNegative(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		logger.Warnf("Invalid %s=%q, using default %d", key, raw, def)
+		return def
+	}
+	return v
+}
+
+// getEnvFloat reads a non-negative number from the environment, falling back
+// to def when the variable is unset or invalid.
+func getEnvFloat(key string, def float64) float64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v < 0 {
+		logger.Warnf("Invalid %s=%q, using default %g", key, raw, def)
+		return def
+	}
+	return v
+}
+
+// getEnvDecimals reads a number of decimal places, 0 to 6, falling back to
+// def when the variable is unset or invalid. 0 rounds to whole units.
+func getEnvDecimals(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 || v > 6 {
+		logger.Warnf("Invalid %s=%q, using default %d", key, raw, def)
+		return def
+	}
+	return v
+}
+
+func getEnvBool(key string, def bool) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		logger.Warnf("Invalid %s=%q, using default %t", key, raw, def)
+		return def
+	}
+	return v
+}
+
+// getEnvList reads a comma-separated list, trimming blanks and lowercasing
+// entries so they can be matched case-insensitively.
+func getEnvList(key string, def []string) []string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvCurrencyMap reads "symbol=CODE" pairs separated by commas, e.g.
+// "$=USD,RS=INR". Symbols are upper-cased to match the parser's tokens.
+func getEnvCurrencyMap(key string) map[string]string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	symbols := make(map[string]string)
+	for _, item := range strings.Split(raw, ",") {
+		symbol, code, ok := parseCurrencyPair(item)
+		if !ok {
+			logger.Warnf("Ignoring invalid %s entry %q", key, item)
+			continue
+		}
+		symbols[symbol] = code
+	}
+	return symbols
+}
+
+// getEnvMerchantAliases reads "name=ALIAS" pairs separated by commas, e.g.
+// "AMZN MKTP=AMAZON,BUNDL=SWIGGY". Both sides are upper-cased.
+func getEnvMerchantAliases(key string) map[string]string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	aliases := make(map[string]string)
+	for _, item := range strings.Split(raw, ",") {
+		name, alias, found := strings.Cut(item, "=")
+		name = strings.Join(strings.Fields(strings.ToUpper(name)), " ")
+		alias = strings.Join(strings.Fields(strings.ToUpper(alias)), " ")
+		if !found || name == "" || alias == "" {
+			logger.Warnf("Ignoring invalid %s entry %q", key, item)
+			continue
+		}
+		aliases[name] = alias
+	}
+	return aliases
+}
+
+// getEnvIssuerCurrencyMap reads "domain:symbol=CODE" entries separated by
+// commas, e.g. "commbank.com.au:$=AUD,anz.com:$=AUD".
+func getEnvIssuerCurrencyMap(key string) map[string]map[string]string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	issuers := make(map[string]map[string]string)
+	for _, item := range strings.Split(raw, ",") {
+		domain, pair, found := strings.Cut(item, ":")
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		symbol, code, ok := parseCurrencyPair(pair)
+		if !found || domain == "" || !ok {
+			logger.Warnf("Ignoring invalid %s entry %q", key, item)
+			continue
+		}
+		if issuers[domain] == nil {
+			issuers[domain] = make(map[string]string)
+		}
+		issuers[domain][symbol] = code
+	}
+	return issuers
+}
+
+func parseCurrencyPair(item string) (symbol, code string, ok bool) {
+	symbol, code, found := strings.Cut(item, "=")
+	symbol = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(symbol), "."))
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !found || symbol == "" || len(code) != 3 {
+		return "", "", false
+	}
+	return symbol, code, true
+}
+
+// getEnvIssuerPartPreference reads "domain=type|type" entries separated by
+// commas, e.g. "hdfcbank.net=text/html|text/plain".
+func getEnvIssuerPartPreference(key string) map[string][]string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	issuers := make(map[string][]string)
+	for _, item := range strings.Split(raw, ",") {
+		domain, types, found := strings.Cut(item, "=")
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		var preference []string
+		for _, t := range strings.Split(types, "|") {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				preference = append(preference, t)
+			}
+		}
+		if !found || domain == "" || len(preference) == 0 {
+			logger.Warnf("Ignoring invalid %s entry %q", key, item)
+			continue
+		}
+		issuers[domain] = preference
+	}
+	return issuers
+}
+
+// Account polarities for IssuerPolarity.
+const (
+	PolarityBank = "bank"
+	PolarityCard = "card"
+)
+
+// getEnvIssuerPolarity reads "domain=card" or "domain=bank" entries separated
+// by commas, e.g. "sbicard.com=card,hdfcbank.net=bank".
+func getEnvIssuerPolarity(key string) map[string]string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	issuers := make(map[string]string)
+	for _, item := range strings.Split(raw, ",") {
+		domain, polarity, found := strings.Cut(item, "=")
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		polarity = strings.ToLower(strings.TrimSpace(polarity))
+		if !found || domain == "" || (polarity != PolarityBank && polarity != PolarityCard) {
+			logger.Warnf("Ignoring invalid %s entry %q", key, item)
+			continue
+		}
+		issuers[domain] = polarity
+	}
+	return issuers
+}
+
+// Values for FutureDatePolicy.
+const (
+	FutureDateReject = "reject"
+	FutureDateFlag   = "flag"
+)
+
+func getEnvFutureDatePolicy(key string) string {
+	switch raw := strings.ToLower(os.Getenv(key)); raw {
+	case "":
+		return FutureDateReject
+	case FutureDateReject, FutureDateFlag:
+		return raw
+	default:
+		logger.Warnf("Invalid %s=%q, using default %s", key, raw, FutureDateReject)
+		return FutureDateReject
+	}
+}
+
+// Values for OldDatePolicy.
+const (
+	OldDateHeader = "header"
+	OldDateDrop   = "drop"
+)
+
+func getEnvOldDatePolicy(key string) string {
+	switch raw := strings.ToLower(os.Getenv(key)); raw {
+	case "":
+		return OldDateHeader
+	case OldDateHeader, OldDateDrop:
+		return raw
+	default:
+		logger.Warnf("Invalid %s=%q, using default %s", key, raw, OldDateHeader)
+		return OldDateHeader
+	}
+}
+
+// Values for DigestMismatch: skip the digest, pair each date with the
+// closest unpaired amount, or give every amount the date nearest to it.
+const (
+	DigestSkip        = "skip"
+	DigestProximity   = "proximity"
+	DigestNearestDate = "nearest-date"
+)
+
+func getEnvDigestMismatch(key string) string {
+	switch raw := strings.ToLower(os.Getenv(key)); raw {
+	case "":
+		return DigestSkip
+	case DigestSkip, DigestProximity, DigestNearestDate:
+		return raw
+	default:
+		logger.Warnf("Invalid %s=%q, using default %s", key, raw, DigestSkip)
+		return DigestSkip
+	}
+}
+
+// Values for CacheControl.
+const (
+	CacheControlPrivate = "private"
+	CacheControlPublic  = "public"
+	CacheControlOff     = "off"
+)
+
+func getEnvCacheControl(key string) string {
+	switch raw := strings.ToLower(os.Getenv(key)); raw {
+	case "":
+		return CacheControlPrivate
+	case CacheControlPrivate, CacheControlPublic, CacheControlOff:
+		return raw
+	default:
+		logger.Warnf("Invalid %s=%q, using default %s", key, raw, CacheControlPrivate)
+		return CacheControlPrivate
+	}
+}
+
+// Values for SummaryBaseline: the period before the current one, the same
+// period a year earlier, or the average of the three periods before.
+const (
+	BaselinePrevious = "previous"
+	BaselineLastYear = "lastYear"
+	BaselineRolling3 = "rolling3"
+)
+
+// ParseBaseline returns the canonical spelling of a baseline name, matched
+// case-insensitively.
+func ParseBaseline(raw string) (string, bool) {
+	for _, b := range []string{BaselinePrevious, BaselineLastYear, BaselineRolling3} {
+		if strings.EqualFold(raw, b) {
+			return b, true
+		}
+	}
+	return "", false
+}
+
+func getEnvSummaryBaseline(key string) string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return BaselinePrevious
+	}
+	baseline, ok := ParseBaseline(raw)
+	if !ok {
+		logger.Warnf("Invalid %s=%q, using default %s", key, raw, BaselinePrevious)
+		return BaselinePrevious
+	}
+	return baseline
+}
+
+// getEnvLocation reads an IANA timezone name such as "Asia/Kolkata", falling
+// back to def when the variable is unset or unknown.
+func getEnvLocation(key string, def *time.Location) *time.Location {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	loc, err := time.LoadLocation(raw)
+	if err != nil {
+		logger.Warnf("Invalid %s=%q, using default %s", key, raw, def)
+		return def
+	}
+	return loc
+}
+
+// getEnvString reads a string from the environment, falling back to def when
+// the variable is unset.
+func getEnvString(key, def string) string {
+	if raw := os.Getenv(key); raw != "" {
+		return raw
+	}
+	return def
+}
+
+// getEnvKey reads a base64 AES key, ignoring it (with a warning) unless it
+// decodes to 16, 24 or 32 bytes.
+func getEnvKey(key string) []byte {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	k, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || (len(k) != 16 && len(k) != 24 && len(k) != 32) {
+		logger.Warnf("Invalid %s: expected a base64 16, 24 or 32 byte key", key)
+		return nil
 	}
+	return k
 }
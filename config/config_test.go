@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCurrencySymbolsFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		symbols string
+		issuers string
+		want    string
+		issuer  string
+	}{
+		{"unset", "", "", "map[]", "map[]"},
+		{"deployment map", "$=cad, rs.=inr", "", "map[$:CAD RS:INR]", "map[]"},
+		{"issuer map", "", "CommBank.com.au:$=AUD,anz.com:$=aud", "map[]", "map[anz.com:map[$:AUD] commbank.com.au:map[$:AUD]]"},
+		{"invalid entries are skipped", "$=,=USD,$=USD", "nodomain,bank.example:$=NZD", "map[$:USD]", "map[bank.example:map[$:NZD]]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CURRENCY_SYMBOLS", tt.symbols)
+			t.Setenv("ISSUER_CURRENCY_SYMBOLS", tt.issuers)
+			cfg := LoadConfig()
+			if got := fmt.Sprint(cfg.CurrencySymbols); got != tt.want {
+				t.Errorf("CurrencySymbols = %s, want %s", got, tt.want)
+			}
+			if got := fmt.Sprint(cfg.IssuerCurrencySymbols); got != tt.issuer {
+				t.Errorf("IssuerCurrencySymbols = %s, want %s", got, tt.issuer)
+			}
+		})
+	}
+}
+
+func TestFilterWindowsFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  int
+	}{
+		{"unset", "", 60},
+		{"configured", "31", 31},
+		{"zero", "0", 60},
+		{"negative", "-7", 60},
+		{"not a number", "month", 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MONTHLY_WINDOW_DAYS", tt.value)
+			if got := LoadConfig().MonthlyWindowDays; got != tt.want {
+				t.Errorf("MonthlyWindowDays = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmountDecimalsFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", 2},
+		{"0", 0},
+		{"3", 3},
+		{"6", 6},
+		{"7", 2},
+		{"-1", 2},
+		{"two", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("AMOUNT_DECIMALS", tt.value)
+			if got := LoadConfig().AmountDecimals; got != tt.want {
+				t.Errorf("AmountDecimals = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZeroDisablesFromEnv(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		get   func(*Config) int64
+		want  int64
+	}{
+		{"FETCH_BUDGET_MS", "0", func(c *Config) int64 { return int64(c.FetchBudget) }, 0},
+		{"FETCH_BUDGET_MS", "250", func(c *Config) int64 { return int64(c.FetchBudget) }, int64(250 * time.Millisecond)},
+		{"FETCH_BUDGET_MS", "-1", func(c *Config) int64 { return int64(c.FetchBudget) }, 0},
+		{"MEMORY_CACHE_SIZE", "0", func(c *Config) int64 { return int64(c.MemoryCacheSize) }, 0},
+		{"MEMORY_CACHE_SIZE", "100", func(c *Config) int64 { return int64(c.MemoryCacheSize) }, 100},
+		{"AMOUNT_KEYWORD_WINDOW", "0", func(c *Config) int64 { return int64(c.AmountKeywordWindow) }, 0},
+		{"AMOUNT_KEYWORD_WINDOW", "40", func(c *Config) int64 { return int64(c.AmountKeywordWindow) }, 40},
+		{"GMAIL_QUOTA_SOFT_LIMIT", "0", func(c *Config) int64 { return c.QuotaSoftLimit }, 0},
+		{"GMAIL_BREAKER_THRESHOLD", "0", func(c *Config) int64 { return int64(c.BreakerThreshold) }, 0},
+		{"DATE_PLAUSIBILITY_YEARS", "0", func(c *Config) int64 { return int64(c.DatePlausibilityYears) }, 0},
+		{"DATE_PLAUSIBILITY_YEARS", "-2", func(c *Config) int64 { return int64(c.DatePlausibilityYears) }, 5},
+		{"DATE_PLAUSIBILITY_YEARS", "x", func(c *Config) int64 { return int64(c.DatePlausibilityYears) }, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+			if got := tt.get(LoadConfig()); got != tt.want {
+				t.Errorf("%s=%s gives %d, want %d", tt.key, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerchantAliasesFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]string
+	}{
+		{"unset", "", nil},
+		{"pairs", "amzn mktp=Amazon,BUNDL=swiggy", map[string]string{"AMZN MKTP": "AMAZON", "BUNDL": "SWIGGY"}},
+		{"spacing collapsed", "  amzn   mktp =  amazon ", map[string]string{"AMZN MKTP": "AMAZON"}},
+		{"invalid entries skipped", "AMZN,=AMAZON,BUNDL=,ZMT=ZOMATO", map[string]string{"ZMT": "ZOMATO"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MERCHANT_ALIASES", tt.value)
+			if got := LoadConfig().MerchantAliases; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MerchantAliases = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmountKeywordsFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		keywords string
+		window   string
+		want     []string
+		wantWin  int
+	}{
+		{"defaults", "", "", []string{"debited", "credited", "spent", "paid", "received", "withdrawn", "purchase", "txn", "transaction", "refund"}, 0},
+		{"custom list", " Debited, ,SPENT ", "40", []string{"debited", "spent"}, 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AMOUNT_KEYWORDS", tt.keywords)
+			t.Setenv("AMOUNT_KEYWORD_WINDOW", tt.window)
+			cfg := LoadConfig()
+			if !reflect.DeepEqual(cfg.AmountKeywords, tt.want) {
+				t.Errorf("AmountKeywords = %v, want %v", cfg.AmountKeywords, tt.want)
+			}
+			if cfg.AmountKeywordWindow != tt.wantWin {
+				t.Errorf("AmountKeywordWindow = %d, want %d", cfg.AmountKeywordWindow, tt.wantWin)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+var errEncryptionDisabled = errors.New("encryption key is not configured")
+
+// newAEAD returns an AES-GCM cipher for the configured ENCRYPTION_KEY.
+func newAEAD() (cipher.AEAD, error) {
+	if len(cfg.EncryptionKey) == 0 {
+		return nil, errEncryptionDisabled
+	}
+	block, err := aes.NewCipher(cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt seals plaintext with AES-GCM, prefixing the random nonce.
+func encrypt(plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// decrypt opens data produced by encrypt. It fails if the data was tampered
+// with or sealed under a different key.
+func decrypt(data []byte) ([]byte, error) {
+	aead, err := newAEAD()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	keyA := []byte(strings.Repeat("a", 32))
+	keyB := []byte(strings.Repeat("b", 32))
+	tests := []struct {
+		name       string
+		sealKey    []byte
+		openKey    []byte
+		tamper     bool
+		wantOpened bool
+	}{
+		{"same key", keyA, keyA, false, true},
+		{"128-bit key", keyA[:16], keyA[:16], false, true},
+		{"different key", keyA, keyB, false, false},
+		{"tampered ciphertext", keyA, keyA, true, false},
+		{"key removed", keyA, nil, false, false},
+	}
+	plaintext := []byte(`{"details":[{"amount":250,"merchant":"AMAZON"}]}`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestEnv(t, func(c *config.Config) { c.EncryptionKey = tt.sealKey })
+			sealed, err := encrypt(plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(sealed, []byte("AMAZON")) {
+				t.Fatal("ciphertext contains the plaintext")
+			}
+			if tt.tamper {
+				sealed[len(sealed)-1] ^= 1
+			}
+			cfg.EncryptionKey = tt.openKey
+			opened, err := decrypt(sealed)
+			if got := err == nil && bytes.Equal(opened, plaintext); got != tt.wantOpened {
+				t.Errorf("opened = %v (err %v), want %v", got, err, tt.wantOpened)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+// exclusionsHandler lets a user list (GET), add (POST) and remove (DELETE
+// ?match=...) merchant exclusions. Changing them drops the user's cached
+// transactions so the next fetch leaves the excluded merchants out.
+func exclusionsHandler(w http.ResponseWriter, r *http.Request) {
+	_, userID, ok := gmailServiceFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		opCtx, cancel := redisContext(r.Context())
+		defer cancel()
+		matches, err := services.LoadExclusions(opCtx, redisClient, cfg.CachePrefix, userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to load exclusions")
+			return
+		}
+		sort.Strings(matches)
+		list := make([]services.Exclusion, 0, len(matches))
+		for _, match := range matches {
+			list = append(list, services.Exclusion{Match: match})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		var exclusion services.Exclusion
+		if !decodeJSONBody(w, r, &exclusion) {
+			return
+		}
+		exclusion.Match = strings.ToLower(strings.TrimSpace(exclusion.Match))
+		if exclusion.Match == "" {
+			respondError(w, http.StatusBadRequest, "Missing match")
+			return
+		}
+		opCtx, cancel := redisContext(r.Context())
+		defer cancel()
+		if err := services.SaveExclusion(opCtx, redisClient, cfg.CachePrefix, userID, exclusion.Match); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to save exclusion")
+			return
+		}
+		invalidateUserCache(r, userID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(exclusion)
+
+	case http.MethodDelete:
+		match := strings.TrimSpace(r.URL.Query().Get("match"))
+		if match == "" {
+			respondError(w, http.StatusBadRequest, "Missing match")
+			return
+		}
+		opCtx, cancel := redisContext(r.Context())
+		defer cancel()
+		deleted, err := services.DeleteExclusion(opCtx, redisClient, cfg.CachePrefix, userID, match)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to delete exclusion")
+			return
+		}
+		if !deleted {
+			respondError(w, http.StatusNotFound, "Exclusion not found")
+			return
+		}
+		invalidateUserCache(r, userID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+func TestExclusionsHideTransactions(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-12", 300, "SWIGGY")
+	env.addDebit("m2", "2024-03-13", 5000, "ACME CONSULTING")
+	env.addDebit("m3", "2024-03-14", 1000, "AMAZON")
+	target := "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+
+	steps := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		status    int
+		merchants []string
+		total     float64
+	}{
+		{name: "no exclusions", merchants: []string{"AMAZON", "ACME CONSULTING", "SWIGGY"}, total: 6300},
+		{name: "exclude by substring", method: "POST", path: "/exclusions", body: `{"match":" Acme "}`,
+			status: http.StatusCreated, merchants: []string{"AMAZON", "SWIGGY"}, total: 1300},
+		{name: "second exclusion", method: "POST", path: "/exclusions", body: `{"match":"swig"}`,
+			status: http.StatusCreated, merchants: []string{"AMAZON"}, total: 1000},
+		{name: "blank match", method: "POST", path: "/exclusions", body: `{"match":"  "}`,
+			status: http.StatusBadRequest, merchants: []string{"AMAZON"}, total: 1000},
+		{name: "remove exclusion", method: "DELETE", path: "/exclusions?match=ACME",
+			status: http.StatusNoContent, merchants: []string{"AMAZON", "ACME CONSULTING"}, total: 6000},
+		{name: "remove missing exclusion", method: "DELETE", path: "/exclusions?match=nothing",
+			status: http.StatusNotFound, merchants: []string{"AMAZON", "ACME CONSULTING"}, total: 6000},
+	}
+	for _, step := range steps {
+		if step.method != "" {
+			sep := "?"
+			if u, _ := url.Parse(step.path); u.RawQuery != "" {
+				sep = "&"
+			}
+			rec := env.doBody(step.method, step.path+sep+"access_token="+testToken, step.body, "Content-Type", "application/json")
+			if rec.Code != step.status {
+				t.Fatalf("%s: status %d, want %d: %s", step.name, rec.Code, step.status, rec.Body.String())
+			}
+		}
+		resp := decodeTransactions(t, env.do("GET", target))
+		var got []string
+		for _, txn := range resp.Details {
+			got = append(got, txn.Merchant)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(step.merchants) {
+			t.Errorf("%s: merchants = %v, want %v", step.name, got, step.merchants)
+		}
+		if resp.Summary.Total != step.total {
+			t.Errorf("%s: summary total = %v, want %v", step.name, resp.Summary.Total, step.total)
+		}
+	}
+
+	var list []services.Exclusion
+	if err := json.Unmarshal(env.do("GET", "/exclusions?access_token="+testToken).Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(list) != fmt.Sprint([]services.Exclusion{{Match: "swig"}}) {
+		t.Errorf("exclusions = %v, want [{swig}]", list)
+	}
+}
+
+func TestExclusionsRedis(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		delay        time.Duration
+		status       int
+		stored       string
+	}{
+		{name: "list", method: "GET", path: "/exclusions", status: http.StatusOK, stored: "[acme]"},
+		{name: "add", method: "POST", path: "/exclusions", body: `{"match":"swig"}`, status: http.StatusCreated, stored: "[acme swig]"},
+		{name: "delete", method: "DELETE", path: "/exclusions?match=acme", status: http.StatusNoContent, stored: "[]"},
+		{name: "slow list", method: "GET", path: "/exclusions", delay: 10 * timeout, status: http.StatusInternalServerError},
+		{name: "slow add", method: "POST", path: "/exclusions", body: `{"match":"swig"}`, delay: 10 * timeout, status: http.StatusInternalServerError},
+		{name: "slow delete", method: "DELETE", path: "/exclusions?match=acme", delay: 10 * timeout, status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) {
+				c.CachePrefix = "staging"
+				c.RedisTimeout = timeout
+			})
+			if err := services.SaveExclusion(context.Background(), redisClient, cfg.CachePrefix, testEmail, "acme"); err != nil {
+				t.Fatal(err)
+			}
+			sep := "?"
+			if u, _ := url.Parse(tt.path); u.RawQuery != "" {
+				sep = "&"
+			}
+
+			env.redis.SetDelay(tt.delay)
+			start := time.Now()
+			rec := env.doBody(tt.method, tt.path+sep+"access_token="+testToken, tt.body, "Content-Type", "application/json")
+			elapsed := time.Since(start)
+			env.redis.SetDelay(0)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.delay > 0 {
+				if elapsed >= tt.delay {
+					t.Errorf("request took %s, want Redis cut off at %s", elapsed, timeout)
+				}
+				return
+			}
+			if got := fmt.Sprint(env.redis.Members("staging:exclusions:" + testEmail)); got != tt.stored {
+				t.Errorf("stored exclusions %s, want %s", got, tt.stored)
+			}
+		})
+	}
+}
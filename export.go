@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+var exportColumns = []string{"Date", "Merchant", "Category", "Type", "Amount", "Currency", "Account", "Description"}
+
+func exportRow(txn types.Transaction) []string {
+	return []string{csvText(txn.Date), csvText(txn.Merchant), csvText(txn.Category), csvText(txn.Type),
+		strconv.FormatFloat(txn.Amount, 'f', services.CurrencyDecimals(txn.Currency, cfg.AmountDecimals), 64),
+		csvText(txn.Currency), csvText(txn.Account), csvText(txn.Description)}
+}
+
+// csvText guards a text cell against formula injection: merchant names and
+// descriptions come from email bodies, and a spreadsheet opening the CSV
+// would evaluate a cell starting with =, +, -, @, tab or carriage return,
+// so such cells are prefixed with a quote to keep them literal text.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// exportHandler serves GET /transactions/export, a filter's transactions as
+// a CSV file or, with format=xlsx, a workbook with Summary, Transactions and
+// Categories sheets.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "xlsx" {
+		respondError(w, http.StatusBadRequest, "Invalid format; expected csv or xlsx")
+		return
+	}
+	filter := r.URL.Query().Get("filter")
+	if filter == "" {
+		filter = "all"
+	}
+	days, ok := filterDays(filter)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Invalid filter")
+		return
+	}
+
+	gmailService, userID, ok := gmailServiceFromRequest(w, r)
+	if !ok {
+		return
+	}
+	applyUserRules(r.Context(), gmailService, userID)
+
+	response, err := loadBaseResponse(r.Context(), gmailService, userID, filter, days)
+	if err != nil {
+		respondAppError(w, err)
+		return
+	}
+
+	filename := fmt.Sprintf("transactions-%s.%s", filter, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write(exportColumns)
+		for _, txn := range response.Details {
+			cw.Write(exportRow(txn))
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			logger.Ctx(r.Context()).Warnf("Error writing CSV export: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", xlsxContentType)
+	if err := writeXLSX(w, exportSheets(response, filter)); err != nil {
+		logger.Ctx(r.Context()).Warnf("Error writing XLSX export: %v", err)
+	}
+}
+
+// exportSheets lays out a response as workbook sheets: the summary, every
+// transaction, and totals per category.
+func exportSheets(response *types.TransactionsResponse, filter string) []xlsxSheet {
+	header := func(names ...string) []xlsxCell {
+		row := make([]xlsxCell, len(names))
+		for i, name := range names {
+			row[i] = xlsxCell{Value: name, Style: xlsxStyleHeader}
+		}
+		return row
+	}
+	// Totals mix currencies and are rounded to AMOUNT_DECIMALS; a
+	// transaction's amount shows its own currency's decimals.
+	money := func(v float64) xlsxCell { return xlsxCell{Value: v, Style: xlsxMoneyStyle(cfg.AmountDecimals)} }
+	text := func(v string) xlsxCell { return xlsxCell{Value: v} }
+
+	s := response.Summary
+	summary := xlsxSheet{Name: "Summary", Rows: [][]xlsxCell{
+		header("Filter", filter),
+		{text("Total"), money(s.Total)},
+		{text("Previously"), money(s.Previously)},
+		{text("Income"), money(s.Income)},
+		{text("Expense"), money(s.Expense)},
+		{text("Net"), money(s.Net)},
+	}}
+	if s.ChangePercentage != nil {
+		summary.Rows = append(summary.Rows, []xlsxCell{text("Change %"), {Value: *s.ChangePercentage / 100, Style: xlsxStylePercent}})
+	}
+
+	transactions := xlsxSheet{Name: "Transactions", Rows: [][]xlsxCell{header(exportColumns...)}}
+	for _, txn := range response.Details {
+		transactions.Rows = append(transactions.Rows, []xlsxCell{
+			text(txn.Date), text(txn.Merchant), text(txn.Category), text(txn.Type),
+			{Value: txn.Amount, Style: xlsxMoneyStyle(services.CurrencyDecimals(txn.Currency, cfg.AmountDecimals))}, text(txn.Currency), text(txn.Account), text(txn.Description),
+		})
+	}
+
+	categories := xlsxSheet{Name: "Categories", Rows: [][]xlsxCell{header("Category", "Total", "Count")}}
+	for _, bucket := range aggregateTransactions(response.Details, "category") {
+		categories.Rows = append(categories.Rows, []xlsxCell{
+			text(bucket.Key), money(bucket.Total), {Value: float64(bucket.Count)},
+		})
+	}
+	return []xlsxSheet{summary, transactions, categories}
+}
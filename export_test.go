@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+func TestCSVText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"SWIGGY", "SWIGGY"},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+91 98765", "'+91 98765"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{"\rcmd", "'\rcmd"},
+		{"A=B", "A=B"},
+	}
+	for _, tt := range tests {
+		if got := csvText(tt.in); got != tt.want {
+			t.Errorf("csvText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-14", 300, "SWIGGY")
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		rows   [][]string
+	}{
+		{"default format", "filter=all", http.StatusOK, [][]string{
+			exportColumns,
+			{"2024-03-14", "SWIGGY", "food", "debit", "300.00", "INR", "", "Transaction from HTML email"},
+		}},
+		{"invalid format", "format=pdf", http.StatusBadRequest, nil},
+		{"invalid filter", "filter=yearly", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.do("GET", "/transactions/export?"+tt.query+"&access_token="+testToken)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			rows, err := csv.NewReader(rec.Body).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rows, tt.rows) {
+				t.Errorf("rows = %q, want %q", rows, tt.rows)
+			}
+		})
+	}
+}
+
+// readXLSX returns the contents of every part of a workbook by name, and
+// the part names in archive order.
+func readXLSX(t *testing.T, data []byte) (map[string]string, []string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("not a zip archive: %v", err)
+	}
+	parts := make(map[string]string)
+	var names []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = string(content)
+		names = append(names, f.Name)
+	}
+	return parts, names
+}
+
+func TestExportXLSX(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-14", 300, "SWIGGY")
+	env.addDebit("m2", "2024-03-13", 999, "AMAZON")
+
+	rec := env.do("GET", "/transactions/export?filter=all&format=xlsx&access_token="+testToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != xlsxContentType {
+		t.Errorf("Content-Type = %q, want %q", got, xlsxContentType)
+	}
+	parts, names := readXLSX(t, rec.Body.Bytes())
+	wantNames := []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml",
+		"xl/worksheets/sheet1.xml", "xl/worksheets/sheet2.xml", "xl/worksheets/sheet3.xml"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("parts in order %q, want %q", names, wantNames)
+	}
+
+	tests := []struct {
+		part, want string
+	}{
+		{"xl/workbook.xml", `<sheet name="Summary" sheetId="1" r:id="rId1"/>`},
+		{"xl/workbook.xml", `<sheet name="Transactions" sheetId="2" r:id="rId2"/>`},
+		{"xl/workbook.xml", `<sheet name="Categories" sheetId="3" r:id="rId3"/>`},
+		{"xl/styles.xml", `<numFmt numFmtId="164" formatCode="#,##0"/>`},
+		{"xl/styles.xml", `<numFmt numFmtId="166" formatCode="#,##0.00"/>`},
+		{"xl/worksheets/sheet1.xml", `<c r="B2" s="5"><v>1299</v></c>`},
+		{"xl/worksheets/sheet2.xml", `<c r="B3" s="0" t="inlineStr"><is><t>SWIGGY</t></is></c>`},
+		{"xl/worksheets/sheet2.xml", `<c r="E3" s="5"><v>300</v></c>`},
+		{"xl/worksheets/sheet3.xml", `<c r="A2" s="0" t="inlineStr"><is><t>shopping</t></is></c>`},
+	}
+	for _, tt := range tests {
+		if !strings.Contains(parts[tt.part], tt.want) {
+			t.Errorf("%s missing %s:\n%s", tt.part, tt.want, parts[tt.part])
+		}
+	}
+}
+
+func TestExportSheetStyles(t *testing.T) {
+	newTestEnv(t, nil)
+	change := 12.5
+	response := &types.TransactionsResponse{
+		Summary: types.Summary{Total: 1299, ChangePercentage: &change},
+		Details: []types.Transaction{
+			{Date: "2024-03-14", Merchant: "SWIGGY", Category: "food", Type: "debit", Amount: 300},
+			{Date: "2024-03-14", Merchant: "SWIGGY", Category: "food", Type: "credit", Amount: 100, IsRefund: true},
+			{Date: "2024-03-13", Merchant: "ACME CORP", Category: "income", Type: "credit", Amount: 50000},
+			{Date: "2024-03-12", Merchant: "TOKYO CAFE", Category: "shopping", Type: "debit", Amount: 1250, Currency: "JPY"},
+			{Date: "2024-03-12", Merchant: "KUWAIT AIR", Category: "travel", Type: "debit", Amount: 12.345, Currency: "KWD"},
+		},
+	}
+	sheets := exportSheets(response, "all")
+
+	tests := []struct {
+		name          string
+		sheet, row, c int
+		want          xlsxCell
+	}{
+		{"total as money", 0, 1, 1, xlsxCell{Value: 1299.0, Style: xlsxMoneyStyle(2)}},
+		{"change as percent fraction", 0, 6, 1, xlsxCell{Value: 0.125, Style: xlsxStylePercent}},
+		{"header bold", 1, 0, 0, xlsxCell{Value: "Date", Style: xlsxStyleHeader}},
+		{"amount as money", 1, 1, 4, xlsxCell{Value: 300.0, Style: xlsxMoneyStyle(2)}},
+		{"JPY amount without decimals", 1, 4, 4, xlsxCell{Value: 1250.0, Style: xlsxMoneyStyle(0)}},
+		{"KWD amount with three decimals", 1, 5, 4, xlsxCell{Value: 12.345, Style: xlsxMoneyStyle(3)}},
+		{"category spend net of refunds", 2, 2, 1, xlsxCell{Value: 200.0, Style: xlsxMoneyStyle(2)}},
+		{"category count plain", 2, 2, 2, xlsxCell{Value: 2.0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sheets[tt.sheet].Rows[tt.row][tt.c]; got != tt.want {
+				t.Errorf("cell = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+type groupedResponse struct {
+	Summary         types.Summary     `json:"summary"`
+	Days            []types.DayGroup  `json:"days"`
+	Series          []types.DayTotal  `json:"series"`
+	Warnings        []string          `json:"warnings,omitempty"`
+	NextCursor      string            `json:"nextCursor,omitempty"`
+	Matched         *types.MatchCount `json:"matched,omitempty"`
+	WindowStart     string            `json:"windowStart,omitempty"`
+	WindowEnd       string            `json:"windowEnd,omitempty"`
+	Truncated       bool              `json:"truncated,omitempty"`
+	SearchedFolders []string          `json:"searchedFolders,omitempty"`
+}
+
+// groupResponse reshapes a response for group=day: details become one group
+// per date, newest first, each with its spend subtotal counted the way the
+// summary counts it.
+func groupResponse(response types.TransactionsResponse, includeTransfers bool) groupedResponse {
+	return groupedResponse{
+		Summary:         response.Summary,
+		Days:            groupByDay(response.Details, includeTransfers),
+		Series:          response.Series,
+		Warnings:        response.Warnings,
+		NextCursor:      response.NextCursor,
+		Matched:         response.Matched,
+		WindowStart:     response.WindowStart,
+		WindowEnd:       response.WindowEnd,
+		Truncated:       response.Truncated,
+		SearchedFolders: response.SearchedFolders,
+	}
+}
+
+// groupByDay buckets transactions by date, keeping their order within a
+// day. Self-transfers and future-dated transactions are listed but left out
+// of the subtotal, as they are in the summary.
+func groupByDay(transactions []types.Transaction, includeTransfers bool) []types.DayGroup {
+	index := make(map[string]int)
+	groups := make([]types.DayGroup, 0)
+	for _, txn := range transactions {
+		i, ok := index[txn.Date]
+		if !ok {
+			i = len(groups)
+			index[txn.Date] = i
+			groups = append(groups, types.DayGroup{Date: txn.Date})
+		}
+		groups[i].Transactions = append(groups[i].Transactions, txn)
+		if (includeTransfers || !txn.IsTransfer) && !txn.FutureDated {
+			groups[i].Total += spendAmount(txn)
+		}
+	}
+	for i := range groups {
+		groups[i].Total = services.RoundAmount(groups[i].Total, cfg.AmountDecimals)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Date > groups[j].Date })
+	return groups
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+func TestGroupByDay(t *testing.T) {
+	type group struct {
+		Date      string
+		Total     float64
+		Merchants []string
+	}
+	tests := []struct {
+		name             string
+		transactions     []types.Transaction
+		includeTransfers bool
+		want             []group
+	}{
+		{
+			name: "grouped newest first, order kept within a day",
+			transactions: []types.Transaction{
+				{Date: "2024-03-14", Amount: 250, Merchant: "AMAZON"},
+				{Date: "2024-03-15", Amount: 80, Merchant: "ZOMATO"},
+				{Date: "2024-03-10", Amount: 120, Merchant: "SWIGGY"},
+				{Date: "2024-03-15", Amount: 40, Merchant: "UBER"},
+			},
+			want: []group{
+				{"2024-03-15", 120, []string{"ZOMATO", "UBER"}},
+				{"2024-03-14", 250, []string{"AMAZON"}},
+				{"2024-03-10", 120, []string{"SWIGGY"}},
+			},
+		},
+		{
+			name: "refunds reduce the subtotal",
+			transactions: []types.Transaction{
+				{Date: "2024-03-15", Amount: 500, Merchant: "AMAZON"},
+				{Date: "2024-03-15", Amount: 200, Merchant: "AMAZON", IsRefund: true},
+			},
+			want: []group{{"2024-03-15", 300, []string{"AMAZON", "AMAZON"}}},
+		},
+		{
+			name: "transfers and future-dated listed but not totalled",
+			transactions: []types.Transaction{
+				{Date: "2024-03-15", Amount: 100, Merchant: "SWIGGY"},
+				{Date: "2024-03-15", Amount: 900, Merchant: "SELF", IsTransfer: true},
+				{Date: "2024-03-20", Amount: 60, Merchant: "NETFLIX", FutureDated: true},
+			},
+			want: []group{
+				{"2024-03-20", 0, []string{"NETFLIX"}},
+				{"2024-03-15", 100, []string{"SWIGGY", "SELF"}},
+			},
+		},
+		{
+			name: "transfers totalled when included",
+			transactions: []types.Transaction{
+				{Date: "2024-03-15", Amount: 100, Merchant: "SWIGGY"},
+				{Date: "2024-03-15", Amount: 900, Merchant: "SELF", IsTransfer: true},
+			},
+			includeTransfers: true,
+			want:             []group{{"2024-03-15", 1000, []string{"SWIGGY", "SELF"}}},
+		},
+		{name: "no transactions", want: []group{}},
+	}
+	newTestEnv(t, nil) // groupByDay rounds with cfg.AmountDecimals
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []group{}
+			for _, g := range groupByDay(tt.transactions, tt.includeTransfers) {
+				var merchants []string
+				for _, txn := range g.Transactions {
+					merchants = append(merchants, txn.Merchant)
+				}
+				got = append(got, group{g.Date, g.Total, merchants})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groups = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactionsGroupByDay(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		dates  []string
+	}{
+		{name: "grouped", query: "group=day", status: http.StatusOK, dates: []string{"2024-03-15", "2024-03-14", "2024-03-10"}},
+		{name: "unknown group", query: "group=week", status: http.StatusBadRequest},
+		{name: "with fields", query: "group=day&fields=date,amount", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-10", 120, "SWIGGY")
+			env.addDebit("m2", "2024-03-14", 250, "AMAZON")
+			env.addDebit("m3", "2024-03-15", 80, "ZOMATO")
+			env.addDebit("m4", "2024-03-15", 40, "UBER")
+			target := "/transactions?filter=weekly&endDate=2024-03-15&" + tt.query + "&access_token=" + testToken
+
+			rec := env.do("GET", target)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var grouped struct {
+				Summary types.Summary       `json:"summary"`
+				Days    []types.DayGroup    `json:"days"`
+				Details []types.Transaction `json:"details"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &grouped); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if grouped.Details != nil {
+				t.Errorf("details present alongside days: %+v", grouped.Details)
+			}
+			var dates []string
+			var sum float64
+			for _, g := range grouped.Days {
+				dates = append(dates, g.Date)
+				sum += g.Total
+			}
+			if !reflect.DeepEqual(dates, tt.dates) {
+				t.Errorf("dates = %v, want %v", dates, tt.dates)
+			}
+
+			flat := decodeTransactions(t, env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token="+testToken))
+			if !reflect.DeepEqual(grouped.Summary, flat.Summary) {
+				t.Errorf("summary = %+v, want the ungrouped %+v", grouped.Summary, flat.Summary)
+			}
+			if sum != flat.Summary.Total {
+				t.Errorf("day subtotals add up to %v, want the summary total %v", sum, flat.Summary.Total)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// healthHandler serves GET /health for load balancers and uptime checks. It
+// needs no credentials and touches neither Redis nor Gmail.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+const (
+	idempotencyInProgress = "in_progress"
+	maxIdempotencyKeyLen  = 255
+)
+
+// idempotencyLease is how long a claimed key stays in progress unless the
+// request holding it renews it, so a crashed request frees its key within
+// the lease rather than blocking retries for IDEMPOTENCY_TTL_SECONDS.
+var idempotencyLease = 30 * time.Second
+
+// idempotentResult is what gets replayed for a repeated Idempotency-Key.
+type idempotentResult struct {
+	Status    int    `json:"status"`
+	ErrorCode string `json:"errorCode,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Warnings lists the periods served from their stale copy.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func getIdempotencyKey(userID, key string) string {
+	return "idempotency:refresh:" + userID + ":" + key
+}
+
+// beginIdempotent claims an idempotency key. It returns claimed=true when the
+// caller should do the work; otherwise the previous result (nil while the
+// original request is still running).
+func beginIdempotent(ctx context.Context, userID, key string) (claimed bool, previous *idempotentResult, err error) {
+	opCtx, cancel := redisContext(ctx)
+	defer cancel()
+
+	redisKey := getIdempotencyKey(userID, key)
+	claimed, err = redisClient.SetNX(opCtx, redisKey, idempotencyInProgress, idempotencyLease).Result()
+	if err != nil || claimed {
+		return claimed, nil, err
+	}
+
+	stored, err := redisClient.Get(opCtx, redisKey).Result()
+	if err != nil {
+		return false, nil, err
+	}
+	if stored == idempotencyInProgress {
+		return false, nil, nil
+	}
+	var result idempotentResult
+	if err := json.Unmarshal([]byte(stored), &result); err != nil {
+		return false, nil, err
+	}
+	return false, &result, nil
+}
+
+// holdIdempotent keeps a claimed key in progress while its request runs,
+// renewing the lease until the returned stop is called. stop waits for the
+// renewals to end, so none can shorten the TTL of the result stored after.
+func holdIdempotent(ctx context.Context, userID, key string) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(idempotencyLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				opCtx, cancel := redisContext(ctx)
+				err := redisClient.Expire(opCtx, getIdempotencyKey(userID, key), idempotencyLease).Err()
+				cancel()
+				if err != nil {
+					logger.Ctx(ctx).Warnf("Error renewing idempotency key: %v", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// finishIdempotent records the outcome of a claimed request for replay.
+func finishIdempotent(ctx context.Context, userID, key string, result idempotentResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		logger.Ctx(ctx).Errorf("Error marshalling idempotent result: %v", err)
+		return
+	}
+	opCtx, cancel := redisContext(ctx)
+	defer cancel()
+	if err := redisClient.Set(opCtx, getIdempotencyKey(userID, key), data, cfg.IdempotencyTTL).Err(); err != nil {
+		logger.Ctx(ctx).Errorf("Error storing idempotent result: %v", err)
+	}
+}
+
+func idempotentResultFromError(err error) idempotentResult {
+	if appErr, ok := err.(*services.AppError); ok {
+		return idempotentResult{Status: appErr.Code, ErrorCode: appErr.ErrorCode, Error: appErr.Msg}
+	}
+	return idempotentResult{Status: http.StatusInternalServerError, Error: err.Error()}
+}
+
+// writeRefreshResult writes a refresh outcome in the same shape /refresh
+// normally returns.
+func writeRefreshResult(w http.ResponseWriter, result idempotentResult) {
+	if result.Status != http.StatusOK {
+		respondErrorCode(w, result.Status, result.ErrorCode, result.Error)
+		return
+	}
+	body := map[string]interface{}{"success": true}
+	if len(result.Warnings) > 0 {
+		body["warnings"] = result.Warnings
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+)
+
+func TestRefreshIdempotencyKeys(t *testing.T) {
+	// Each refresh searches Gmail once per refreshed period.
+	periods := len(refreshFilters)
+	tests := []struct {
+		name     string
+		keys     []string
+		failList int
+		statuses []int
+		lists    int
+	}{
+		{"repeated key replays", []string{"k1", "k1", "k1"}, 0,
+			[]int{http.StatusOK, http.StatusOK, http.StatusOK}, periods},
+		{"distinct keys refresh independently", []string{"k1", "k2"}, 0,
+			[]int{http.StatusOK, http.StatusOK}, 2 * periods},
+		{"no key always refreshes", []string{"", ""}, 0,
+			[]int{http.StatusOK, http.StatusOK}, 2 * periods},
+		{"failure replays too", []string{"k1", "k1"}, http.StatusInternalServerError,
+			[]int{http.StatusInternalServerError, http.StatusInternalServerError}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-14", 100, "AMAZON")
+			env.gmail.Fail(gmailtest.List, tt.failList)
+			for i, key := range tt.keys {
+				var headers []string
+				if key != "" {
+					headers = []string{"Idempotency-Key", key}
+				}
+				rec := env.do("POST", "/refresh?access_token="+testToken, headers...)
+				if rec.Code != tt.statuses[i] {
+					t.Fatalf("request %d: status %d, want %d: %s", i+1, rec.Code, tt.statuses[i], rec.Body.String())
+				}
+			}
+			if got := env.gmail.Calls(gmailtest.List); got != tt.lists {
+				t.Errorf("Gmail listed %d times, want %d", got, tt.lists)
+			}
+		})
+	}
+}
+
+func TestIdempotencyLease(t *testing.T) {
+	saved := idempotencyLease
+	idempotencyLease = 60 * time.Millisecond
+	t.Cleanup(func() { idempotencyLease = saved })
+
+	tests := []struct {
+		name    string
+		hold    bool
+		wait    time.Duration
+		claimed bool
+	}{
+		{"running request keeps its key", false, 0, false},
+		{"crashed request frees its key", false, 2 * idempotencyLease, true},
+		{"renewed past the lease", true, 3 * idempotencyLease, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestEnv(t, nil)
+			ctx := context.Background()
+			if claimed, _, err := beginIdempotent(ctx, testEmail, "k"); err != nil || !claimed {
+				t.Fatalf("first claim = %v, %v", claimed, err)
+			}
+			if tt.hold {
+				stop := holdIdempotent(ctx, testEmail, "k")
+				defer stop()
+			}
+			time.Sleep(tt.wait)
+
+			claimed, previous, err := beginIdempotent(ctx, testEmail, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claimed != tt.claimed || previous != nil {
+				t.Errorf("second claim = %v (previous %v), want %v", claimed, previous, tt.claimed)
+			}
+		})
+	}
+
+	t.Run("result outlives the lease", func(t *testing.T) {
+		newTestEnv(t, nil)
+		ctx := context.Background()
+		beginIdempotent(ctx, testEmail, "k")
+		stop := holdIdempotent(ctx, testEmail, "k")
+		stop()
+		finishIdempotent(ctx, testEmail, "k", idempotentResult{Status: http.StatusOK})
+		time.Sleep(2 * idempotencyLease)
+		_, previous, err := beginIdempotent(ctx, testEmail, "k")
+		if err != nil || previous == nil || previous.Status != http.StatusOK {
+			t.Errorf("stored result = %v, %v; want the 200 replayed", previous, err)
+		}
+	})
+}
@@ -0,0 +1,400 @@
+// Package gmailtest runs a fake Gmail API, with Google's tokeninfo endpoint
+// and Gmail's batch endpoint, for tests that fetch mail end to end.
+//
+// The client returned by Client sends every request to the fake whatever
+// host it names, so a gmail.Service built on it needs no endpoint override.
+package gmailtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Call kinds, for Fail and Calls.
+const (
+	Profile    = "profile"
+	List       = "list"
+	Get        = "get"
+	Attachment = "attachment"
+	Batch      = "batch"
+	TokenInfo  = "tokeninfo"
+)
+
+// ReadScope is the scope a token needs to read mail.
+const ReadScope = "https://www.googleapis.com/auth/gmail.readonly"
+
+// Server is a fake Gmail account.
+type Server struct {
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	email       string
+	messages    []*gmail.Message
+	attachments map[string]string
+	scopes      map[string]string
+	pageSize    int
+	failures    map[string]failure
+	delays      map[string]time.Duration
+	calls       map[string]int
+	queries     []string
+}
+
+// Run starts a fake for the account email, shut down when the test ends.
+func Run(t testing.TB, email string) *Server {
+	t.Helper()
+	s := &Server{
+		email:       email,
+		attachments: make(map[string]string),
+		scopes:      make(map[string]string),
+		pageSize:    100,
+		failures:    make(map[string]failure),
+		delays:      make(map[string]time.Duration),
+		calls:       make(map[string]int),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// URL is the fake's base URL.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// TokenInfoURL is the fake's tokeninfo endpoint.
+func (s *Server) TokenInfoURL() string {
+	return s.srv.URL + "/tokeninfo"
+}
+
+// Client returns an HTTP client whose requests all reach the fake.
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.srv.URL)
+	return &http.Client{Transport: rewriteTransport{target: target}}
+}
+
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (rt rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	req.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// Route returns an HTTP client that sends each request to whichever of the
+// fakes granted its access token, taken from the bearer token or the
+// access_token parameter, so one process can talk to several accounts.
+// Requests with a token none of them granted go to the first.
+func Route(servers ...*Server) *http.Client {
+	return &http.Client{Transport: routeTransport(servers)}
+}
+
+type routeTransport []*Server
+
+func (rt routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = req.URL.Query().Get("access_token")
+	}
+	target := rt[0]
+	for _, s := range rt {
+		s.mu.Lock()
+		_, ok := s.scopes[token]
+		s.mu.Unlock()
+		if ok {
+			target = s
+			break
+		}
+	}
+	u, _ := url.Parse(target.srv.URL)
+	return rewriteTransport{target: u}.RoundTrip(req)
+}
+
+// Add puts messages in the mailbox. List returns them newest first, in the
+// reverse of the order they were added.
+func (s *Server) Add(messages ...*gmail.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, messages...)
+}
+
+// AddAttachment stores data as attachment id of a message.
+func (s *Server) AddAttachment(id string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attachments[id] = base64.URLEncoding.EncodeToString(data)
+}
+
+// Grant makes tokeninfo report scope for token. Tokens never granted are
+// rejected as invalid.
+func (s *Server) Grant(token, scope string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scopes[token] = scope
+}
+
+// SetPageSize sets how many messages a list page holds when the request
+// doesn't say.
+func (s *Server) SetPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageSize = n
+}
+
+type failure struct {
+	status  int
+	message string
+}
+
+// Fail makes calls of the given kind answer with status; 0 restores them.
+func (s *Server) Fail(kind string, status int) {
+	s.FailWith(kind, status, fmt.Sprintf("injected %s failure", kind))
+}
+
+// FailWith is Fail with the error message Gmail would send, for errors
+// recognized by their message (e.g. "Mail service not enabled").
+func (s *Server) FailWith(kind string, status int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status == 0 {
+		delete(s.failures, kind)
+		return
+	}
+	s.failures[kind] = failure{status, message}
+}
+
+// SetDelay holds calls of the given kind back by d before answering, to
+// simulate a slow backend; 0 restores them.
+func (s *Server) SetDelay(kind string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delays[kind] = d
+}
+
+// Calls returns how many calls of the given kind the fake has served. Each
+// message in a batch counts as one Get as well as the Batch itself.
+func (s *Server) Calls(kind string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[kind]
+}
+
+// Queries returns the q parameter of every list call so far.
+func (s *Server) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queries...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me")
+	switch {
+	case r.URL.Path == "/tokeninfo":
+		s.tokenInfo(w, r)
+	case r.URL.Path == "/batch/gmail/v1":
+		s.batch(w, r)
+	case path == "/profile":
+		if s.count(w, Profile) {
+			writeJSON(w, &gmail.Profile{EmailAddress: s.email, MessagesTotal: int64(len(s.messages))})
+		}
+	case path == "/messages":
+		s.list(w, r)
+	case strings.Contains(path, "/attachments/"):
+		if s.count(w, Attachment) {
+			s.attachment(w, path[strings.LastIndex(path, "/")+1:])
+		}
+	case strings.HasPrefix(path, "/messages/"):
+		if s.count(w, Get) {
+			s.get(w, strings.TrimPrefix(path, "/messages/"))
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// count records a call, waits out any delay set for its kind and, when the
+// kind is set to fail, writes the failure. It reports whether the call should be served.
+func (s *Server) count(w http.ResponseWriter, kind string) bool {
+	s.mu.Lock()
+	s.calls[kind]++
+	f, failing := s.failures[kind]
+	delay := s.delays[kind]
+	s.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if failing {
+		writeError(w, f.status, f.message)
+		return false
+	}
+	return true
+}
+
+func (s *Server) tokenInfo(w http.ResponseWriter, r *http.Request) {
+	if !s.count(w, TokenInfo) {
+		return
+	}
+	s.mu.Lock()
+	scope, ok := s.scopes[r.URL.Query().Get("access_token")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_token")
+		return
+	}
+	writeJSON(w, map[string]string{"scope": scope, "expires_in": "3599", "email": s.email})
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	if !s.count(w, List) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, r.URL.Query().Get("q"))
+	size := s.pageSize
+	if n, err := strconv.Atoi(r.URL.Query().Get("maxResults")); err == nil && n > 0 {
+		size = n
+	}
+	start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+	resp := &gmail.ListMessagesResponse{ResultSizeEstimate: int64(len(s.messages))}
+	for i := start; i < len(s.messages) && i < start+size; i++ {
+		msg := s.messages[len(s.messages)-1-i]
+		resp.Messages = append(resp.Messages, &gmail.Message{Id: msg.Id, ThreadId: msg.ThreadId})
+	}
+	if start+size < len(s.messages) {
+		resp.NextPageToken = strconv.Itoa(start + size)
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) message(id string) *gmail.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, msg := range s.messages {
+		if msg.Id == id {
+			return msg
+		}
+	}
+	return nil
+}
+
+func (s *Server) get(w http.ResponseWriter, id string) {
+	msg := s.message(id)
+	if msg == nil {
+		writeError(w, http.StatusNotFound, "Requested entity was not found.")
+		return
+	}
+	writeJSON(w, msg)
+}
+
+func (s *Server) attachment(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	data, ok := s.attachments[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "Requested entity was not found.")
+		return
+	}
+	writeJSON(w, &gmail.MessagePartBody{AttachmentId: id, Data: data, Size: int64(len(data))})
+}
+
+// batch serves a multipart/mixed batch of message gets.
+func (s *Server) batch(w http.ResponseWriter, r *http.Request) {
+	if !s.count(w, Batch) {
+		return
+	}
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var out bytes.Buffer
+	mw := multipart.NewWriter(&out)
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		line, _ := io.ReadAll(part)
+		fields := strings.Fields(string(line))
+		if len(fields) < 2 {
+			continue
+		}
+		target, _ := url.Parse(fields[1])
+		id := strings.TrimPrefix(target.Path, "/gmail/v1/users/me/messages/")
+
+		rec := httptest.NewRecorder()
+		if s.count(rec, Get) {
+			s.get(rec, id)
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", "<response-"+strings.Trim(part.Header.Get("Content-ID"), "<>")+">")
+		pw, _ := mw.CreatePart(header)
+		fmt.Fprintf(pw, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\n\r\n%s",
+			rec.Code, http.StatusText(rec.Code), rec.Body.String())
+	}
+	mw.Close()
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.Write(out.Bytes())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"message": msg,
+			"errors":  []map[string]string{{"domain": "global", "message": msg}},
+		},
+	})
+}
+
+// Email builds a single-part text/plain message. Date sets both the Date
+// header and internalDate.
+func Email(id, from, subject, body string, date time.Time) *gmail.Message {
+	return &gmail.Message{
+		Id:           id,
+		ThreadId:     id,
+		InternalDate: date.UnixMilli(),
+		Payload: &gmail.MessagePart{
+			MimeType: "text/plain",
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "From", Value: from},
+				{Name: "Subject", Value: subject},
+				{Name: "Date", Value: date.Format(time.RFC1123Z)},
+			},
+			Body: &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(body))},
+		},
+	}
+}
@@ -0,0 +1,644 @@
+// Package redistest runs an in-process stand-in for Redis that speaks enough
+// of the wire protocol for go-redis and the commands this service uses, so
+// tests can exercise caching, sessions and quotas without a real server.
+//
+// Lua scripts can't run here; a test registers a Go function for each script
+// it needs with HandleScript. EVALSHA always answers NOSCRIPT, which makes
+// go-redis fall back to EVAL with the source.
+package redistest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ScriptFunc emulates a Lua script. It runs with the server locked and
+// returns the script's reply: nil, an int64, a string, an error or a slice
+// of those.
+type ScriptFunc func(s *Server, keys, args []string) interface{}
+
+// Server is a fake Redis. Its methods (Get, Set, ...) act on the data
+// directly, for arranging and inspecting state in tests.
+type Server struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]string
+	sets    map[string]map[string]bool
+	expires map[string]time.Time
+	scripts map[string]ScriptFunc
+	offset  time.Duration
+	delay   time.Duration
+	fail    string
+	calls   map[string]int
+}
+
+// Run starts a server that is shut down when the test ends.
+func Run(t testing.TB) *Server {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("redistest: %v", err)
+	}
+	s := &Server{
+		listener: l,
+		strings:  make(map[string]string),
+		hashes:   make(map[string]map[string]string),
+		sets:     make(map[string]map[string]bool),
+		expires:  make(map[string]time.Time),
+		scripts:  make(map[string]ScriptFunc),
+		calls:    make(map[string]int),
+	}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+// Addr is the host:port the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Client returns a go-redis client for the server, closed when the test ends.
+func (s *Server) Client(t testing.TB) *redis.Client {
+	client := redis.NewClient(&redis.Options{Addr: s.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// HandleScript registers fn as the implementation of script.
+func (s *Server) HandleScript(script *redis.Script, fn ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[script.Hash()] = fn
+}
+
+// SetDelay holds every reply back by d, to simulate a slow server.
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// SetError makes every command fail with "ERR msg"; "" restores normal
+// replies.
+func (s *Server) SetError(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = msg
+}
+
+// FastForward moves the server's clock on by d, expiring keys whose TTL
+// runs out.
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// Calls returns how many times a command (upper case, e.g. "PTTL") was run.
+func (s *Server) Calls(command string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[command]
+}
+
+// Get returns a string key's value.
+func (s *Server) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+	v, ok := s.strings[key]
+	return v, ok
+}
+
+// Set stores a string key without a TTL.
+func (s *Server) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.del(key)
+	s.strings[key] = value
+}
+
+// TTL returns a key's remaining time to live, 0 when it has none or doesn't
+// exist.
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.exists(key) {
+		return 0
+	}
+	if at, ok := s.expires[key]; ok {
+		return at.Sub(s.now())
+	}
+	return 0
+}
+
+// Keys returns every live key, sorted.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys("*")
+}
+
+// HGetAll returns a copy of a hash.
+func (s *Server) HGetAll(key string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+	out := make(map[string]string)
+	for f, v := range s.hashes[key] {
+		out[f] = v
+	}
+	return out
+}
+
+// Members returns a set's members, sorted.
+func (s *Server) Members(key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+	var out []string
+	for m := range s.sets[key] {
+		out = append(out, m)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// The helpers below expect s.mu to be held. ScriptFuncs may call them.
+
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func (s *Server) expire(key string) {
+	if at, ok := s.expires[key]; ok && !s.now().Before(at) {
+		s.remove(key)
+	}
+}
+
+func (s *Server) exists(key string) bool {
+	s.expire(key)
+	_, str := s.strings[key]
+	_, hash := s.hashes[key]
+	_, set := s.sets[key]
+	return str || hash || set
+}
+
+func (s *Server) del(key string) bool {
+	existed := s.exists(key)
+	s.remove(key)
+	return existed
+}
+
+func (s *Server) remove(key string) {
+	delete(s.strings, key)
+	delete(s.hashes, key)
+	delete(s.sets, key)
+	delete(s.expires, key)
+}
+
+// StringValue returns a string key's value, for ScriptFuncs.
+func (s *Server) StringValue(key string) (string, bool) {
+	s.expire(key)
+	v, ok := s.strings[key]
+	return v, ok
+}
+
+// SetString stores a string key with a TTL (0 for none), for ScriptFuncs.
+func (s *Server) SetString(key, value string, ttl time.Duration) {
+	s.del(key)
+	s.strings[key] = value
+	if ttl > 0 {
+		s.expires[key] = s.now().Add(ttl)
+	}
+}
+
+func (s *Server) keys(pattern string) []string {
+	seen := make(map[string]bool)
+	for k := range s.strings {
+		seen[k] = true
+	}
+	for k := range s.hashes {
+		seen[k] = true
+	}
+	for k := range s.sets {
+		seen[k] = true
+	}
+	var out []string
+	for k := range seen {
+		if s.exists(k) && globMatch(pattern, k) {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// globMatch implements Redis's MATCH patterns: * ? [...] and \ escapes.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 || s == "" {
+				return false
+			}
+			class := pattern[1 : end+1]
+			negate := strings.HasPrefix(class, "^")
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					if class[i] <= s[0] && s[0] <= class[i+2] {
+						matched = true
+					}
+					i += 2
+				} else if class[i] == s[0] {
+					matched = true
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			pattern, s = pattern[end+2:], s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return s == ""
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var queued [][]string
+	inMulti := false
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		delay := s.delay
+		s.mu.Unlock()
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		name := strings.ToUpper(args[0])
+		switch {
+		case name == "MULTI":
+			inMulti, queued = true, nil
+			writeReply(w, simple("OK"))
+		case name == "EXEC":
+			replies := make([]interface{}, len(queued))
+			for i, cmd := range queued {
+				replies[i] = s.run(cmd)
+			}
+			inMulti, queued = false, nil
+			writeReply(w, replies)
+		case name == "DISCARD":
+			inMulti, queued = false, nil
+			writeReply(w, simple("OK"))
+		case inMulti:
+			queued = append(queued, args)
+			writeReply(w, simple("QUEUED"))
+		default:
+			writeReply(w, s.run(args))
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+type simple string
+
+func (s *Server) run(args []string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := strings.ToUpper(args[0])
+	s.calls[name]++
+	if s.fail != "" {
+		return fmt.Errorf("ERR %s", s.fail)
+	}
+	args = args[1:]
+	switch name {
+	case "PING":
+		return simple("PONG")
+	case "GET":
+		if v, ok := s.StringValue(args[0]); ok {
+			return v
+		}
+		return nil
+	case "SET":
+		return s.set(args)
+	case "DEL":
+		var n int64
+		for _, k := range args {
+			if s.del(k) {
+				n++
+			}
+		}
+		return n
+	case "EXISTS":
+		var n int64
+		for _, k := range args {
+			if s.exists(k) {
+				n++
+			}
+		}
+		return n
+	case "EXPIRE", "PEXPIRE":
+		if !s.exists(args[0]) {
+			return int64(0)
+		}
+		n, _ := strconv.ParseInt(args[1], 10, 64)
+		unit := time.Second
+		if name == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		s.expires[args[0]] = s.now().Add(time.Duration(n) * unit)
+		return int64(1)
+	case "TTL", "PTTL":
+		if !s.exists(args[0]) {
+			return int64(-2)
+		}
+		at, ok := s.expires[args[0]]
+		if !ok {
+			return int64(-1)
+		}
+		if name == "TTL" {
+			return int64(at.Sub(s.now()) / time.Second)
+		}
+		return int64(at.Sub(s.now()) / time.Millisecond)
+	case "HGET":
+		s.expire(args[0])
+		if v, ok := s.hashes[args[0]][args[1]]; ok {
+			return v
+		}
+		return nil
+	case "HGETALL":
+		s.expire(args[0])
+		fields := make([]string, 0, len(s.hashes[args[0]]))
+		for f := range s.hashes[args[0]] {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		out := make([]interface{}, 0, 2*len(fields))
+		for _, f := range fields {
+			out = append(out, f, s.hashes[args[0]][f])
+		}
+		return out
+	case "HSET":
+		h := s.hash(args[0])
+		var n int64
+		for i := 1; i+1 < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		return n
+	case "HDEL":
+		s.expire(args[0])
+		var n int64
+		for _, f := range args[1:] {
+			if _, ok := s.hashes[args[0]][f]; ok {
+				delete(s.hashes[args[0]], f)
+				n++
+			}
+		}
+		if len(s.hashes[args[0]]) == 0 {
+			s.del(args[0])
+		}
+		return n
+	case "HINCRBY":
+		h := s.hash(args[0])
+		cur, _ := strconv.ParseInt(h[args[1]], 10, 64)
+		by, _ := strconv.ParseInt(args[2], 10, 64)
+		h[args[1]] = strconv.FormatInt(cur+by, 10)
+		return cur + by
+	case "SADD":
+		set := s.memberSet(args[0])
+		var n int64
+		for _, m := range args[1:] {
+			if !set[m] {
+				set[m] = true
+				n++
+			}
+		}
+		return n
+	case "SREM":
+		s.expire(args[0])
+		var n int64
+		for _, m := range args[1:] {
+			if s.sets[args[0]][m] {
+				delete(s.sets[args[0]], m)
+				n++
+			}
+		}
+		if len(s.sets[args[0]]) == 0 {
+			s.del(args[0])
+		}
+		return n
+	case "SMEMBERS":
+		s.expire(args[0])
+		out := make([]interface{}, 0, len(s.sets[args[0]]))
+		var members []string
+		for m := range s.sets[args[0]] {
+			members = append(members, m)
+		}
+		sort.Strings(members)
+		for _, m := range members {
+			out = append(out, m)
+		}
+		return out
+	case "SCAN":
+		pattern := "*"
+		for i := 1; i+1 < len(args); i += 2 {
+			if strings.EqualFold(args[i], "MATCH") {
+				pattern = args[i+1]
+			}
+		}
+		keys := make([]interface{}, 0)
+		for _, k := range s.keys(pattern) {
+			keys = append(keys, k)
+		}
+		return []interface{}{"0", keys}
+	case "EVALSHA":
+		return fmt.Errorf("NOSCRIPT No matching script. Please use EVAL.")
+	case "EVAL":
+		sum := sha1.Sum([]byte(args[0]))
+		fn, ok := s.scripts[hex.EncodeToString(sum[:])]
+		if !ok {
+			return fmt.Errorf("ERR redistest: no handler registered for script")
+		}
+		n, _ := strconv.Atoi(args[1])
+		return fn(s, args[2:2+n], args[2+n:])
+	}
+	return fmt.Errorf("ERR redistest: unsupported command %s", name)
+}
+
+func (s *Server) hash(key string) map[string]string {
+	s.expire(key)
+	if s.hashes[key] == nil {
+		s.hashes[key] = make(map[string]string)
+	}
+	return s.hashes[key]
+}
+
+func (s *Server) memberSet(key string) map[string]bool {
+	s.expire(key)
+	if s.sets[key] == nil {
+		s.sets[key] = make(map[string]bool)
+	}
+	return s.sets[key]
+}
+
+// set runs SET key value [EX s|PX ms] [NX|XX] [KEEPTTL].
+func (s *Server) set(args []string) interface{} {
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	nx, xx, keepTTL := false, false, false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "EX", "PX":
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("ERR value is not an integer or out of range")
+			}
+			ttl = time.Duration(n) * time.Second
+			if strings.EqualFold(args[i], "PX") {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+			i++
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		}
+	}
+	exists := s.exists(key)
+	if (nx && exists) || (xx && !exists) {
+		return nil
+	}
+	expires, hadTTL := s.expires[key]
+	s.SetString(key, value, ttl)
+	if keepTTL && hadTTL {
+		s.expires[key] = expires
+	}
+	return simple("OK")
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(header, "$"))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case simple:
+		fmt.Fprintf(w, "+%s\r\n", string(v))
+	case error:
+		fmt.Fprintf(w, "-%s\r\n", v.Error())
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR redistest: unexpected reply %T\r\n", reply)
+	}
+}
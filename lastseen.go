@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/types"
+	"github.com/go-redis/redis/v8"
+)
+
+// getLastSeenKey holds when a user last marked their transactions as seen.
+// It lives outside the response cache prefix so cache invalidation and
+// schema bumps don't reset it.
+func getLastSeenKey(userID string) string {
+	return cfg.CachePrefix + ":lastseen:" + userID
+}
+
+// loadLastSeen returns the user's last-seen time, or the zero time when they
+// have never marked transactions as seen (or Redis can't be read).
+func loadLastSeen(parent context.Context, userID string) time.Time {
+	opCtx, cancel := redisContext(parent)
+	defer cancel()
+	raw, err := redisClient.Get(opCtx, getLastSeenKey(userID)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			logger.Ctx(parent).Warnf("Error reading last-seen marker: %v", err)
+		}
+		return time.Time{}
+	}
+	nanos, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// saveLastSeen moves the user's last-seen marker to t.
+func saveLastSeen(parent context.Context, userID string, t time.Time) {
+	opCtx, cancel := redisContext(parent)
+	defer cancel()
+	if err := redisClient.Set(opCtx, getLastSeenKey(userID), t.UnixNano(), 0).Err(); err != nil {
+		logger.Ctx(parent).Warnf("Error saving last-seen marker: %v", err)
+	}
+}
+
+// markNew sets IsNew on transactions that happened after lastSeen, using the
+// timestamp when there is one and otherwise the start of the transaction's
+// day. It reports whether any flag was set.
+func markNew(transactions []types.Transaction, lastSeen time.Time) bool {
+	marked := false
+	for i := range transactions {
+		when, err := time.Parse(time.RFC3339, transactions[i].Timestamp)
+		if err != nil {
+			if when, err = time.Parse("2006-01-02", transactions[i].Date); err != nil {
+				continue
+			}
+		}
+		if when.After(lastSeen) {
+			transactions[i].IsNew = true
+			marked = true
+		}
+	}
+	return marked
+}
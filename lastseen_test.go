@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTransactionsIsNew(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-12", 300, "SWIGGY")
+	env.addDebit("m2", "2024-03-14", 150, "ZOMATO")
+	target := "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+
+	// Each step runs in order against the same user: marker, when set, is
+	// written straight to Redis before the request.
+	steps := []struct {
+		name    string
+		marker  time.Time
+		query   string
+		wantNew []string
+	}{
+		{name: "never marked", wantNew: nil},
+		{name: "marker between transactions", marker: time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC), wantNew: []string{"ZOMATO"}},
+		{name: "markSeen still flags against the old marker", query: "&markSeen=true", wantNew: []string{"ZOMATO"}},
+		{name: "after markSeen nothing is new", wantNew: nil},
+		{name: "marker before everything", marker: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), wantNew: []string{"ZOMATO", "SWIGGY"}},
+	}
+	for _, step := range steps {
+		if !step.marker.IsZero() {
+			env.redis.Set(getLastSeenKey(testEmail), strconv.FormatInt(step.marker.UnixNano(), 10))
+		}
+		resp := decodeTransactions(t, env.do("GET", target+step.query))
+		var gotNew []string
+		for _, txn := range resp.Details {
+			if txn.IsNew {
+				gotNew = append(gotNew, txn.Merchant)
+			}
+		}
+		if !reflect.DeepEqual(gotNew, step.wantNew) {
+			t.Errorf("%s: new = %v, want %v", step.name, gotNew, step.wantNew)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	level = new(slog.LevelVar)
+	base  = slog.New(requestIDHandler{slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})})
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying a request's correlation ID, which
+// lines logged through Ctx with that context include as requestId.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the correlation ID stored by WithRequestID, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDHandler adds the context's request ID to every record.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("requestId", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// Init configures the global logger. levelName is one of debug, info, warn or
+// error (default info) and format is json or text (default text).
+func Init(levelName, format string) {
+	InitWithWriter(os.Stderr, levelName, format)
+}
+
+// InitWithWriter is Init with an explicit destination.
+func InitWithWriter(w io.Writer, levelName, format string) {
+	parsed, ok := parseLevel(levelName)
+	level.Set(parsed)
+
+	opts := &slog.HandlerOptions{Level: level}
+	if strings.EqualFold(format, "json") {
+		base = slog.New(requestIDHandler{slog.NewJSONHandler(w, opts)})
+	} else {
+		base = slog.New(requestIDHandler{slog.NewTextHandler(w, opts)})
+	}
+
+	if !ok {
+		Warnf("Invalid LOG_LEVEL=%q, using info", levelName)
+	}
+}
+
+func parseLevel(name string) (slog.Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "", "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return slog.LevelInfo, false
+}
+
+func logf(ctx context.Context, l slog.Level, format string, args ...interface{}) {
+	if !base.Enabled(ctx, l) {
+		return
+	}
+	base.Log(ctx, l, fmt.Sprintf(format, args...))
+}
+
+func Debugf(format string, args ...interface{}) {
+	logf(context.Background(), slog.LevelDebug, format, args...)
+}
+func Infof(format string, args ...interface{}) {
+	logf(context.Background(), slog.LevelInfo, format, args...)
+}
+func Warnf(format string, args ...interface{}) {
+	logf(context.Background(), slog.LevelWarn, format, args...)
+}
+func Errorf(format string, args ...interface{}) {
+	logf(context.Background(), slog.LevelError, format, args...)
+}
+
+// ContextLogger logs with a context, so lines carry its request ID.
+type ContextLogger struct {
+	ctx context.Context
+}
+
+// Ctx returns a logger for ctx: logger.Ctx(r.Context()).Warnf(...).
+func Ctx(ctx context.Context) ContextLogger {
+	return ContextLogger{ctx: ctx}
+}
+
+func (c ContextLogger) Debugf(format string, args ...interface{}) {
+	logf(c.ctx, slog.LevelDebug, format, args...)
+}
+func (c ContextLogger) Infof(format string, args ...interface{}) {
+	logf(c.ctx, slog.LevelInfo, format, args...)
+}
+func (c ContextLogger) Warnf(format string, args ...interface{}) {
+	logf(c.ctx, slog.LevelWarn, format, args...)
+}
+func (c ContextLogger) Errorf(format string, args ...interface{}) {
+	logf(c.ctx, slog.LevelError, format, args...)
+}
+
+// Fatalf logs at error level and exits, mirroring log.Fatalf.
+func Fatalf(format string, args ...interface{}) {
+	logf(context.Background(), slog.LevelError, format, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLevelFiltering(t *testing.T) {
+	tests := []struct {
+		level string
+		want  []string
+	}{
+		{"debug", []string{"debug line", "info line", "warn line", "error line"}},
+		{"info", []string{"info line", "warn line", "error line"}},
+		{"", []string{"info line", "warn line", "error line"}},
+		{"WARNING", []string{"warn line", "error line"}},
+		{"error", []string{"error line"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			var out bytes.Buffer
+			InitWithWriter(&out, tt.level, "text")
+			t.Cleanup(func() { Init("info", "text") })
+
+			Debugf("debug line")
+			Infof("info line")
+			Warnf("warn line")
+			Errorf("error line")
+
+			for _, line := range []string{"debug line", "info line", "warn line", "error line"} {
+				logged := strings.Contains(out.String(), line)
+				want := false
+				for _, w := range tt.want {
+					want = want || w == line
+				}
+				if logged != want {
+					t.Errorf("%q logged = %v, want %v", line, logged, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		json   bool
+	}{
+		{"json", true},
+		{"JSON", true},
+		{"text", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			InitWithWriter(&out, "info", tt.format)
+			t.Cleanup(func() { Init("info", "text") })
+
+			Ctx(WithRequestID(context.Background(), "req-1")).Infof("hello %d", 42)
+
+			var record map[string]interface{}
+			isJSON := json.Unmarshal(out.Bytes(), &record) == nil
+			if isJSON != tt.json {
+				t.Fatalf("JSON output = %v, want %v: %s", isJSON, tt.json, out.String())
+			}
+			if isJSON && (record["msg"] != "hello 42" || record["requestId"] != "req-1") {
+				t.Errorf("record = %v", record)
+			}
+			if !isJSON && !strings.Contains(out.String(), "requestId=req-1") {
+				t.Errorf("text line lacks the request ID: %s", out.String())
+			}
+		})
+	}
+}
+
+func TestInvalidLevelFallsBackToInfo(t *testing.T) {
+	var out bytes.Buffer
+	InitWithWriter(&out, "verbose", "text")
+	t.Cleanup(func() { Init("info", "text") })
+	Debugf("hidden")
+	if !strings.Contains(out.String(), `Invalid LOG_LEVEL=\"verbose\"`) || strings.Contains(out.String(), "hidden") {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+}
@@ -3,15 +3,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"sort"
-	"strings"
+	"syscall"
 	"time"
 
 	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/logger"
 	"github.com/abhayyadav/funnyMoney/be/services"
 	"github.com/abhayyadav/funnyMoney/be/types"
 	"github.com/go-redis/redis/v8"
@@ -29,47 +31,277 @@ type CustomClaims struct {
 	jwt.StandardClaims
 }
 
-type Summary struct {
-	Total            float64 `json:"total"`
-	Previously       float64 `json:"previously"`
-	ChangePercentage float64 `json:"changePercentage"`
+// spendAmount is a transaction's contribution to period totals: refunds
+// reverse an earlier debit and adjustments (cashback, waivers) give part of
+// one back, so both count negatively.
+func spendAmount(txn types.Transaction) float64 {
+	if txn.IsRefund || txn.IsAdjustment {
+		return -txn.Amount
+	}
+	return txn.Amount
 }
 
-type TransactionsResponse struct {
-	Summary Summary             `json:"summary"`
-	Details []types.Transaction `json:"details"`
+// applyCashflow fills Income, Expense and Net from the transactions that fall
+// in the summary's current period. Refunds and adjustments reduce Expense
+// rather than counting as Income.
+func applyCashflow(summary *types.Summary, transactions []types.Transaction, inPeriod func(t time.Time) bool) {
+	for _, txn := range transactions {
+		t, err := time.Parse("2006-01-02", txn.Date)
+		if err != nil || !inPeriod(t) {
+			continue
+		}
+		switch {
+		case txn.IsRefund, txn.IsAdjustment:
+			summary.Expense -= txn.Amount
+		case txn.Type == types.TransactionTypeCredit:
+			summary.Income += txn.Amount
+		default:
+			summary.Expense += txn.Amount
+		}
+	}
+	summary.Net = summary.Income - summary.Expense
+}
+
+// changePercentage is the change from previous to current in percent. It is
+// nil when there was nothing previously, which the client shows as "new"
+// rather than "0%".
+func changePercentage(current, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := ((current - previous) / previous) * 100
+	return &change
+}
+
+// staleWarnings lists the fetch errors that fall back to the stale copy of a
+// cache entry, with the warning added to the stale response.
+var staleWarnings = map[string]string{
+	services.ErrCodeQuotaExceeded:      "Gmail quota limit reached; showing previously cached data",
+	services.ErrCodeServiceUnavailable: "Gmail is unavailable; showing previously cached data",
+}
+
+// staleResponse returns the stale copy of a cache entry, with the matching
+// staleWarnings entry appended, when err is one that falls back to it. The
+// warning is returned too; ok is false when there is no fallback.
+func staleResponse(ctx context.Context, key string, err error) (*types.TransactionsResponse, string, bool) {
+	warning, ok := staleWarnings[errorCode(err)]
+	if !ok {
+		return nil, "", false
+	}
+	stale, staleErr := getStaleResponse(ctx, key)
+	if staleErr != nil {
+		return nil, "", false
+	}
+	var response types.TransactionsResponse
+	if json.Unmarshal(stale, &response) != nil {
+		return nil, "", false
+	}
+	logger.Ctx(ctx).Warnf("Serving stale cache for %s: %v", key, err)
+	response.Warnings = append(response.Warnings, warning)
+	return &response, warning, true
+}
+
+// errorCode returns an AppError's ErrorCode, or "" for other errors.
+func errorCode(err error) string {
+	if appErr, ok := err.(*services.AppError); ok {
+		return appErr.ErrorCode
+	}
+	return ""
+}
+
+// setWindow records the bounds of a days-long fetch ending on asOf.
+func setWindow(response *types.TransactionsResponse, days int, asOf time.Time) {
+	start, end := services.QueryBounds(days, asOf)
+	response.WindowStart = start.Format(time.RFC3339)
+	response.WindowEnd = end.Format(time.RFC3339)
+}
+
+func matchCount(result *services.FetchResult) *types.MatchCount {
+	return &types.MatchCount{Messages: result.MatchedMessages, Exact: result.MatchedExact}
 }
 
 var (
-	gmailService *services.GmailService
-	oauthConfig  *oauth2.Config
-	redisClient  *redis.Client
-	cfg          *config.Config
-	ctx          = context.Background()
+	oauthConfig    *oauth2.Config
+	redisClient    *redis.Client
+	quotaTracker   *services.QuotaTracker
+	gmailBreaker   *services.CircuitBreaker
+	tokenInfoCache *services.TokenInfoCache
+	memCache       *memoryCache
+	cfg            *config.Config
+	ctx            = context.Background()
 )
 
-func getCacheKey(userID, filter string) string {
-	return fmt.Sprintf("transactions:%s:%s", userID, filter)
+// cacheSchemaVersion is part of every cache key. Bump it whenever
+// TransactionsResponse changes shape so entries in the old shape are never
+// read back; they simply expire.
+const cacheSchemaVersion = 10
+
+// transactionsCacheTTL is how long a /transactions response fetched on
+// demand stays cached.
+const transactionsCacheTTL = 120 * time.Minute
+
+// userCachePrefix is the key prefix shared by all of a user's cached views:
+// the app prefix (for shared Redis instances), the schema version plus any
+// CACHE_VERSION suffix, and the user.
+func userCachePrefix(userID string) string {
+	version := fmt.Sprintf("v%d", cacheSchemaVersion)
+	if cfg.CacheVersion != "" {
+		version += "-" + cfg.CacheVersion
+	}
+	return fmt.Sprintf("%s:%s:transactions:%s:", cfg.CachePrefix, version, userID)
+}
+
+// getCacheKey builds the Redis key for a user's filtered transactions. Any
+// non-empty variants (e.g. a type filter) are appended so that each view of
+// the data is cached separately.
+func getCacheKey(userID, filter string, variants ...string) string {
+	key := userCachePrefix(userID) + filter
+	for _, v := range variants {
+		if v != "" {
+			key += ":" + v
+		}
+	}
+	return key
+}
+
+// filterByType returns the transactions of the given type, or all of them when
+// txnType is empty.
+func filterByType(transactions []types.Transaction, txnType string) []types.Transaction {
+	if txnType == "" {
+		return transactions
+	}
+	var filtered []types.Transaction
+	for _, txn := range transactions {
+		if txn.Type == txnType {
+			filtered = append(filtered, txn)
+		}
+	}
+	return filtered
 }
 
 // profile, err := srv.Users.GetProfile("me").Do()
 // userID := profile.EmailAddress
 
 func respondError(w http.ResponseWriter, statusCode int, message string) {
+	respondErrorCode(w, statusCode, "", message)
+}
+
+// respondErrorCode writes the standard error body. Every error carries a
+// machine-readable errorCode; when none is given it is derived from the status.
+// The request's correlation ID is included as requestId.
+func respondErrorCode(w http.ResponseWriter, statusCode int, errorCode, message string) {
+	body := errorBody(statusCode, errorCode, message)
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		body["requestId"] = id
+	}
 	w.Header().Set("Content-Type", "application/json")
+	// Errors are never cached, whatever a handler set before failing.
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(statusCode)
-	resp := map[string]interface{}{
-		"error": message,
-		"code":  statusCode,
+	json.NewEncoder(w).Encode(body)
+}
+
+func errorBody(statusCode int, errorCode, message string) map[string]interface{} {
+	if errorCode == "" {
+		errorCode = defaultErrorCode(statusCode)
+	}
+	return map[string]interface{}{
+		"error":     message,
+		"code":      statusCode,
+		"errorCode": errorCode,
+	}
+}
+
+func defaultErrorCode(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "BAD_REQUEST"
+	case http.StatusUnauthorized:
+		return "UNAUTHORIZED"
+	case http.StatusForbidden:
+		return "FORBIDDEN"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusMethodNotAllowed:
+		return "METHOD_NOT_ALLOWED"
+	case http.StatusConflict:
+		return "CONFLICT"
+	case http.StatusRequestEntityTooLarge:
+		return "PAYLOAD_TOO_LARGE"
+	case http.StatusTooManyRequests:
+		return "RATE_LIMITED"
+	case http.StatusBadGateway:
+		return "UPSTREAM_ERROR"
+	case http.StatusServiceUnavailable:
+		return "SERVICE_UNAVAILABLE"
+	}
+	if statusCode >= 500 {
+		return "INTERNAL_ERROR"
+	}
+	return "ERROR"
+}
+
+// respondAppError writes err using its AppError status and code when it has
+// one, falling back to a 500.
+func respondAppError(w http.ResponseWriter, err error) {
+	if appErr, ok := err.(*services.AppError); ok {
+		respondErrorCode(w, appErr.Code, appErr.ErrorCode, appErr.Msg)
+		return
 	}
-	json.NewEncoder(w).Encode(resp)
+	respondError(w, http.StatusInternalServerError, err.Error())
 }
 
 type TokenRequest struct {
 	AccessToken string `json:"access_token"`
 }
 
-func calculateSummary(transactions []types.Transaction, period string) (Summary, error) {
+// decodeJSONBody decodes a request body of at most MAX_BODY_BYTES into dst,
+// rejecting unknown fields and trailing data so typos don't pass silently.
+// On failure it writes the error response and returns false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, cfg.MaxBodyBytes))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err == nil && dec.More() {
+		err = errors.New("unexpected data after JSON body")
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", cfg.MaxBodyBytes))
+			return false
+		}
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
+
+// calculateSummary summarizes the transactions for the period, rounded to
+// AMOUNT_DECIMALS.
+func calculateSummary(transactions []types.Transaction, period string) (types.Summary, error) {
+	summary, err := summarizePeriod(excludeFutureDated(transactions), period)
+	roundSummary(&summary)
+	return summary, err
+}
+
+// roundSummary rounds every amount in a summary, and the percentage, so
+// arithmetic drift never reaches clients.
+func roundSummary(summary *types.Summary) {
+	round := func(v float64) float64 { return services.RoundAmount(v, cfg.AmountDecimals) }
+	summary.Total = round(summary.Total)
+	summary.Previously = round(summary.Previously)
+	summary.Income = round(summary.Income)
+	summary.Expense = round(summary.Expense)
+	summary.Net = round(summary.Net)
+	summary.DailyAverage = round(summary.DailyAverage)
+	if summary.ChangePercentage != nil {
+		change := round(*summary.ChangePercentage)
+		summary.ChangePercentage = &change
+	}
+}
+
+func summarizePeriod(transactions []types.Transaction, period string) (types.Summary, error) {
 	switch period {
 	case "daily":
 
@@ -82,30 +314,28 @@ func calculateSummary(transactions []types.Transaction, period string) (Summary,
 				continue
 			}
 
-			dateTotals[txn.Date] += txn.Amount
+			dateTotals[txn.Date] += spendAmount(txn)
 			if t.After(maxDate) {
 				maxDate = t
 			}
 		}
 		if maxDate.IsZero() {
-			return Summary{}, nil
+			return types.Summary{}, nil
 		}
 
 		currentDay := maxDate.Format(layout)
 		previousDay := maxDate.AddDate(0, 0, -1).Format(layout)
 		currentTotal := dateTotals[currentDay]
 		previousTotal := dateTotals[previousDay]
-		var change float64
-		if previousTotal != 0 {
-			change = ((currentTotal - previousTotal) / previousTotal) * 100
-		} else {
-			change = 0
-		}
-		return Summary{
+		summary := types.Summary{
 			Total:            currentTotal,
 			Previously:       previousTotal,
-			ChangePercentage: change,
-		}, nil
+			ChangePercentage: changePercentage(currentTotal, previousTotal),
+		}
+		applyCashflow(&summary, transactions, func(t time.Time) bool {
+			return t.Format(layout) == currentDay
+		})
+		return summary, nil
 
 	case "weekly":
 
@@ -118,13 +348,13 @@ func calculateSummary(transactions []types.Transaction, period string) (Summary,
 				continue
 			}
 			dateKey := t.Format(layout)
-			dateTotals[dateKey] += txn.Amount
+			dateTotals[dateKey] += spendAmount(txn)
 			if t.After(maxDate) {
 				maxDate = t
 			}
 		}
 		if maxDate.IsZero() {
-			return Summary{}, nil
+			return types.Summary{}, nil
 		}
 
 		currentWeekTotal := 0.0
@@ -140,17 +370,16 @@ func calculateSummary(transactions []types.Transaction, period string) (Summary,
 				previousWeekTotal += amount
 			}
 		}
-		var change float64
-		if previousWeekTotal != 0 {
-			change = ((currentWeekTotal - previousWeekTotal) / previousWeekTotal) * 100
-		} else {
-			change = 0
-		}
-		return Summary{
+		summary := types.Summary{
 			Total:            currentWeekTotal,
 			Previously:       previousWeekTotal,
-			ChangePercentage: change,
-		}, nil
+			ChangePercentage: changePercentage(currentWeekTotal, previousWeekTotal),
+		}
+		weekStart := maxDate.AddDate(0, 0, -6)
+		applyCashflow(&summary, transactions, func(t time.Time) bool {
+			return !t.Before(weekStart) && !t.After(maxDate)
+		})
+		return summary, nil
 
 	case "monthly":
 
@@ -162,7 +391,7 @@ func calculateSummary(transactions []types.Transaction, period string) (Summary,
 				continue
 			}
 			monthKey := t.Format("2006-01")
-			monthTotals[monthKey] += txn.Amount
+			monthTotals[monthKey] += spendAmount(txn)
 		}
 
 		var months []string
@@ -170,7 +399,7 @@ func calculateSummary(transactions []types.Transaction, period string) (Summary,
 			months = append(months, m)
 		}
 		if len(months) == 0 {
-			return Summary{}, nil
+			return types.Summary{}, nil
 		}
 		sort.Strings(months)
 
@@ -187,254 +416,461 @@ func calculateSummary(transactions []types.Transaction, period string) (Summary,
 		if previousMonth != "" {
 			previousTotal = monthTotals[previousMonth]
 		}
-		var change float64
-		if previousTotal != 0 {
-			change = ((currentTotal - previousTotal) / previousTotal) * 100
-		} else {
-			change = 0
-		}
-		return Summary{
+		summary := types.Summary{
 			Total:            currentTotal,
 			Previously:       previousTotal,
-			ChangePercentage: change,
-		}, nil
+			ChangePercentage: changePercentage(currentTotal, previousTotal),
+		}
+		applyCashflow(&summary, transactions, func(t time.Time) bool {
+			return t.Format("2006-01") == currentMonth
+		})
+		return summary, nil
 
 	default:
 
 		var total float64
+		var first, last time.Time
 		for _, t := range transactions {
-			total += t.Amount
+			total += spendAmount(t)
+			d, err := time.Parse("2006-01-02", t.Date)
+			if err != nil {
+				continue
+			}
+			if first.IsZero() || d.Before(first) {
+				first = d
+			}
+			if d.After(last) {
+				last = d
+			}
+		}
+		summary := types.Summary{
+			Total: total,
+			Count: len(transactions),
 		}
-		return Summary{
-			Total:            total,
-			ChangePercentage: 0,
-		}, nil
+		if !first.IsZero() {
+			summary.FirstDate = first.Format("2006-01-02")
+			summary.LastDate = last.Format("2006-01-02")
+			days := int(last.Sub(first).Hours()/24) + 1
+			summary.DailyAverage = total / float64(days)
+		}
+		applyCashflow(&summary, transactions, func(t time.Time) bool {
+			return true
+		})
+		return summary, nil
 	}
 }
 
-func transactionsHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received request for transactions with method: %s", r.Method)
-	frontendURL := os.Getenv("FRONTEND_URL")
+// calculateMonthToDateSummary compares spend from the 1st of asOf's month up
+// to asOf with the same stretch of the previous month. When the previous
+// month is shorter (e.g. asOf is the 31st and last month had 30 days), its
+// whole month is the baseline.
+func calculateMonthToDateSummary(transactions []types.Transaction, asOf time.Time) types.Summary {
+	layout := "2006-01-02"
+	asOf = time.Date(asOf.Year(), asOf.Month(), asOf.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := asOf.AddDate(0, 0, 1-asOf.Day())
+	prevStart := monthStart.AddDate(0, -1, 0)
+	prevEnd := prevStart.AddDate(0, 0, asOf.Day()-1)
+	if lastOfPrev := monthStart.AddDate(0, 0, -1); prevEnd.After(lastOfPrev) {
+		prevEnd = lastOfPrev
+	}
+
+	inCurrent := func(t time.Time) bool {
+		return !t.Before(monthStart) && !t.After(asOf)
+	}
+	var currentTotal, previousTotal float64
+	for _, txn := range transactions {
+		t, err := time.Parse(layout, txn.Date)
+		if err != nil {
+			continue
+		}
+		switch {
+		case inCurrent(t):
+			currentTotal += spendAmount(txn)
+		case !t.Before(prevStart) && !t.After(prevEnd):
+			previousTotal += spendAmount(txn)
+		}
+	}
+	summary := types.Summary{
+		Total:            currentTotal,
+		Previously:       previousTotal,
+		ChangePercentage: changePercentage(currentTotal, previousTotal),
+	}
+	applyCashflow(&summary, transactions, inCurrent)
+	roundSummary(&summary)
+	return summary
+}
 
-	w.Header().Set("Access-Control-Allow-Origin", frontendURL)
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
+// monthToDateDays is how many days back a fetch must reach for a
+// month-to-date comparison as of asOf: to the 1st of the previous month.
+func monthToDateDays(asOf time.Time) int {
+	prevStart := time.Date(asOf.Year(), asOf.Month()-1, 1, 0, 0, 0, 0, asOf.Location())
+	return int(asOf.Sub(prevStart).Hours()/24) + 1
+}
 
-	if r.Method == "OPTIONS" {
-		w.Header().Set("Access-Control-Allow-Methods", "GET")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.WriteHeader(http.StatusOK)
-		return
+// trimToLatestDays keeps only the transactions dated within `days` calendar
+// days ending on asOf, in asOf's timezone. Anchoring on the period's end
+// rather than the newest transaction keeps a quiet day from pulling older
+// ones into the window. Unparseable dates are dropped.
+func trimToLatestDays(transactions []types.Transaction, days int, asOf time.Time) []types.Transaction {
+	layout := "2006-01-02"
+	cutoff := time.Date(asOf.Year(), asOf.Month(), asOf.Day()-(days-1), 0, 0, 0, 0, time.UTC)
+	var trimmed []types.Transaction
+	for _, txn := range transactions {
+		t, err := time.Parse(layout, txn.Date)
+		if err != nil || t.Before(cutoff) {
+			continue
+		}
+		trimmed = append(trimmed, txn)
 	}
+	return trimmed
+}
 
-	if r.Method != "GET" {
-		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
-		return
+// filterByCategory returns the transactions in the given category, or all of
+// them when category is empty. Transactions with no category count as
+// uncategorized.
+func filterByCategory(transactions []types.Transaction, category string) []types.Transaction {
+	if category == "" {
+		return transactions
+	}
+	var filtered []types.Transaction
+	for _, txn := range transactions {
+		c := txn.Category
+		if c == "" {
+			c = services.UncategorizedCategory
+		}
+		if c == category {
+			filtered = append(filtered, txn)
+		}
 	}
+	return filtered
+}
 
-	filter := r.URL.Query().Get("filter")
-	if filter == "" {
-		filter = "all"
-	}
-	accessToken := r.URL.Query().Get("access_token")
-	if strings.TrimSpace(accessToken) == "" {
-		respondError(w, http.StatusUnauthorized, "Missing access token in query string")
-		return
+// applyAmountDisplay fills AmountDisplay on each transaction for the locale.
+// Transactions without a parsed currency are assumed to be in INR.
+func applyAmountDisplay(transactions []types.Transaction, locale string) {
+	for i := range transactions {
+		currency := transactions[i].Currency
+		if currency == "" {
+			currency = "INR"
+		}
+		display, err := services.FormatAmount(transactions[i].Amount, currency, locale)
+		if err != nil {
+			continue
+		}
+		transactions[i].AmountDisplay = display
 	}
+}
 
-	oauthToken := &oauth2.Token{
-		AccessToken: accessToken,
+// excludeFutureDated drops flagged future-dated transactions, which would
+// otherwise move the latest day the summaries are anchored on.
+func excludeFutureDated(transactions []types.Transaction) []types.Transaction {
+	var current []types.Transaction
+	for _, txn := range transactions {
+		if !txn.FutureDated {
+			current = append(current, txn)
+		}
 	}
+	return current
+}
 
-	tokenSource := oauthConfig.TokenSource(ctx, oauthToken)
-	client := oauth2.NewClient(ctx, tokenSource)
-	gmailService, err := services.NewGmailServiceWithClient(cfg, client)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Gmail service error: %v", err))
-		return
-	}
-	userID, err := gmailService.GetUserId()
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Gmail service error: %v", err))
-		return
+// excludeTransfers drops self-transfers, which move money between the user's
+// own accounts and shouldn't count as spend.
+func excludeTransfers(transactions []types.Transaction) []types.Transaction {
+	var filtered []types.Transaction
+	for _, txn := range transactions {
+		if !txn.IsTransfer {
+			filtered = append(filtered, txn)
+		}
 	}
-	key := getCacheKey(userID, filter)
-	var response TransactionsResponse
+	return filtered
+}
 
-	cached, err := redisClient.Get(ctx, userID+key).Result()
-	if err == nil {
-		err = json.Unmarshal([]byte(cached), &response)
-		if err == nil {
-			log.Printf("Cache hit for filter: %s", filter)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-			return
-		}
+// cacheVariant formats an optional query parameter for getCacheKey, returning
+// "" when the parameter is unset.
+func cacheVariant(name, value string) string {
+	if value == "" {
+		return ""
 	}
-	log.Printf("Cache miss for filter: %s; calling Gmail API", filter)
+	return name + "=" + value
+}
 
-	var days int
+// filterDays maps a filter name to the number of days fetched from Gmail, as
+// configured for the deployment.
+func filterDays(filter string) (int, bool) {
 	switch filter {
 	case "daily":
-		days = 2
+		return cfg.DailyWindowDays, true
 	case "weekly":
-		days = 14
+		return cfg.WeeklyWindowDays, true
 	case "monthly":
-		days = 60
+		return cfg.MonthlyWindowDays, true
 	case "all":
-		days = 90
-	default:
-		respondError(w, http.StatusBadRequest, "Invalid filter")
+		return cfg.AllWindowDays, true
+	}
+	return 0, false
+}
+
+func transactionsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Ctx(r.Context()).Debugf("Received request for transactions with method: %s", r.Method)
+
+	if r.Method != "GET" {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
-	log.Printf("Fetching transactions for filter: %s, days: %d", filter, days)
-	transactions, err := gmailService.FetchTransactions(days)
+
+	q, err := TransactionsQuery{}.Parse(r)
 	if err != nil {
-		if appErr, ok := err.(*services.AppError); ok {
-			respondError(w, appErr.Code, appErr.Msg)
-		} else {
-			respondError(w, http.StatusInternalServerError, err.Error())
-		}
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	log.Printf("Fetched transactions for filter")
-	summary, err := calculateSummary(transactions, filter)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	if q.DebugRaw && !requireAdmin(w, r) {
 		return
 	}
-	response = TransactionsResponse{
-		Summary: summary,
-		Details: transactions,
+	// lastSeen is the user's last-seen marker, loaded once the user is known;
+	// it stays zero for multi-account requests.
+	var lastSeen, requestedAt time.Time
+	var seenUserID string
+	// write sends a response, flagging transactions newer than lastSeen and
+	// reducing it to the version 1 shape when the client negotiated that, or
+	// else to the requested fields when ?fields= is set. The cache always
+	// holds the full, unflagged response.
+	write := func(response types.TransactionsResponse, body []byte, etag string) {
+		switch {
+		case q.DebugRaw:
+			w.Header().Set("Cache-Control", "no-store")
+		case w.Header().Get("Cache-Control") == "":
+			setCacheControl(w, 0)
+		}
+		if !lastSeen.IsZero() {
+			response.Details = append([]types.Transaction(nil), response.Details...)
+			if markNew(response.Details, lastSeen) {
+				// The flags change once the marker moves, so a flagged
+				// response must not be reused without revalidating.
+				if !q.DebugRaw {
+					setCacheControl(w, 0)
+				}
+				flagged, err := json.Marshal(response)
+				if err != nil {
+					respondError(w, http.StatusInternalServerError, "Failed to encode response")
+					return
+				}
+				body, etag = flagged, computeETag(flagged)
+			}
+		}
+		if q.MarkSeen && seenUserID != "" {
+			saveLastSeen(r.Context(), seenUserID, requestedAt)
+		}
+		if apiVersion(r) == apiVersionLegacy {
+			legacy, err := legacyBody(response)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to encode response")
+				return
+			}
+			body, etag = legacy, computeETag(legacy)
+		} else if q.Fields != nil {
+			projected, err := projectResponse(response, q.Fields)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to encode response")
+				return
+			}
+			body, etag = projected, computeETag(projected)
+		} else if q.Group == "day" {
+			grouped, err := json.Marshal(groupResponse(response, q.IncludeTransfers))
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to encode response")
+				return
+			}
+			body, etag = grouped, computeETag(grouped)
+		}
+		writeJSONWithETag(w, r, body, etag)
 	}
-	log.Printf("Calculated summary for filter: %s", filter)
-	respJSON, err := json.Marshal(response)
-	if err != nil {
-		log.Printf("Error marshalling response: %v", err)
-	} else {
-		err = redisClient.Set(ctx, userID+key, respJSON, 120*time.Minute).Err()
+
+	prepare := func(gs *services.GmailService, userID string) {
+		applyUserRules(r.Context(), gs, userID)
+		if q.HasMinAmount {
+			gs.SetMinAmount(q.MinAmount)
+		}
+		if !q.EndDate.IsZero() {
+			gs.SetEndDate(q.EndDate)
+		}
+		if len(q.Senders) > 0 {
+			gs.SetSenderDomains(q.Senders)
+		}
+		gs.SetLocation(q.Location)
+		gs.SetIncludeRawBody(q.DebugRaw)
+		gs.SetIncludeSpamTrash(q.IncludeSpamTrash)
+		applyRawEmailStore(r.Context(), gs, userID)
+	}
+	finalize := func(transactions []types.Transaction, warnings []string) (types.TransactionsResponse, error) {
+		// A non-default baseline widens the fetch to reach the baseline periods;
+		// summarize over all of it but return only the filter's own window.
+		baselineTxns := transactions
+		if q.Baseline != config.BaselinePrevious {
+			transactions = trimToWindow(transactions, q.PeriodDays, q.AsOf)
+		}
+		// The daily window is widened to cover timezone and query-boundary slop, so
+		// trim it back to the day the period ends on and the one before it.
+		if q.Filter == "daily" {
+			transactions = trimToLatestDays(transactions, 2, q.AsOf)
+		}
+		transactions = q.narrow(transactions)
+		if q.Locale != "" {
+			applyAmountDisplay(transactions, q.Locale)
+		}
+		summaryTxns := transactions
+		if !q.IncludeTransfers {
+			summaryTxns = excludeTransfers(transactions)
+		}
+		summary, err := calculateSummary(summaryTxns, q.Filter)
 		if err != nil {
-			log.Printf("Error setting Redis cache: %v", err)
+			return types.TransactionsResponse{}, err
+		}
+		if q.MonthToDate {
+			summary = calculateMonthToDateSummary(summaryTxns, q.AsOf)
+		}
+		if q.Baseline != config.BaselinePrevious {
+			baselineTxns = q.narrow(baselineTxns)
+			if !q.IncludeTransfers {
+				baselineTxns = excludeTransfers(baselineTxns)
+			}
+			if warning := applyBaseline(&summary, baselineTxns, q.Filter, q.Baseline); warning != "" {
+				warnings = append(warnings, warning)
+			}
 		}
+		response := types.TransactionsResponse{
+			Summary:  summary,
+			Details:  q.sorted(q.sinceStart(transactions)),
+			Series:   buildSeries(summaryTxns, q.AsOf, q.PeriodDays),
+			Warnings: warnings,
+		}
+		setWindow(&response, q.PeriodDays, q.AsOf)
+		response.SearchedFolders = services.SearchedFolders(q.IncludeSpamTrash)
+		return response, nil
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
-func refreshHandler(w http.ResponseWriter, r *http.Request) {
-	frontendURL := os.Getenv("FRONTEND_URL")
-	w.Header().Set("Access-Control-Allow-Origin", frontendURL)
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-	if r.Method == "OPTIONS" {
-		w.Header().Set("Access-Control-Allow-Methods", "GET,POST")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.WriteHeader(http.StatusOK)
+	ndjson := wantsNDJSON(r)
+	if ndjson && (q.Paginated || len(r.URL.Query()["access_token"]) > 1) {
+		respondError(w, http.StatusBadRequest, "NDJSON is not supported with pagination or multiple access tokens")
 		return
 	}
-
-	if r.Method != "GET" && r.Method != "POST" {
-		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	if ndjson && q.Sort != "" {
+		respondError(w, http.StatusBadRequest, "NDJSON is not supported with sort")
 		return
 	}
-
-	accessToken := r.URL.Query().Get("access_token")
-	if strings.TrimSpace(accessToken) == "" {
-		respondError(w, http.StatusUnauthorized, "Missing access token in query string")
+	if tokens := r.URL.Query()["access_token"]; len(tokens) > 1 {
+		serveMultiAccount(w, r, tokens, q.Days, prepare, finalize, write)
 		return
 	}
 
-	oauthToken := &oauth2.Token{
-		AccessToken: accessToken,
-	}
-
-	tokenSource := oauthConfig.TokenSource(ctx, oauthToken)
-	client := oauth2.NewClient(ctx, tokenSource)
-
-	var err error
-	gmailService, err = services.NewGmailServiceWithClient(cfg, client)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Gmail service error: %v", err))
+	gmailService, userID, ok := gmailServiceFromRequest(w, r)
+	if !ok {
 		return
 	}
-
-	dailyTxns, err := gmailService.FetchTransactions(2)
-	if err != nil {
-		if appErr, ok := err.(*services.AppError); ok {
-			respondError(w, appErr.Code, appErr.Msg)
-		} else {
-			respondError(w, http.StatusInternalServerError, err.Error())
-		}
+	lastSeen, requestedAt, seenUserID = loadLastSeen(r.Context(), userID), time.Now(), userID
+	if ndjson {
+		prepare(gmailService, userID)
+		serveNDJSON(w, r, gmailService, q, finalize)
 		return
 	}
-	weeklyTxns, err := gmailService.FetchTransactions(14)
-	if err != nil {
-		if appErr, ok := err.(*services.AppError); ok {
-			respondError(w, appErr.Code, appErr.Msg)
-		} else {
-			respondError(w, http.StatusInternalServerError, err.Error())
-		}
+	if q.Paginated {
+		prepare(gmailService, userID)
+		servePage(r.Context(), w, gmailService, q.Days, q.Cursor, q.PageSize, finalize, write)
 		return
 	}
-	monthlyTxns, err := gmailService.FetchTransactions(60)
-	if err != nil {
-		if appErr, ok := err.(*services.AppError); ok {
-			respondError(w, appErr.Code, appErr.Msg)
-		} else {
-			respondError(w, http.StatusInternalServerError, err.Error())
+	key := q.cacheKey(userID)
+	var response types.TransactionsResponse
+
+	// Debug responses carry email bodies, so they are never cached and never
+	// served from the cache.
+	if !q.DebugRaw {
+		if cached, ttl, err := getCachedResponseTTL(r.Context(), key); err == nil {
+			if err := json.Unmarshal(cached, &response); err == nil {
+				logger.Ctx(r.Context()).Debugf("Cache hit for filter: %s", q.Filter)
+				setCacheControl(w, ttl)
+				write(response, cached, getCachedETag(r.Context(), key, cached))
+				return
+			}
 		}
-		return
 	}
+	logger.Ctx(r.Context()).Debugf("Cache miss for filter: %s; calling Gmail API", q.Filter)
 
-	dailySummary, err := calculateSummary(dailyTxns, "daily")
+	logger.Ctx(r.Context()).Infof("Fetching transactions for filter: %s, days: %d", q.Filter, q.Days)
+	prepare(gmailService, userID)
+	fetchedAt := time.Now()
+	result, err := gmailService.FetchTransactions(r.Context(), q.Days)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		if stale, _, ok := staleResponse(r.Context(), key, err); ok {
+			if body, err := json.Marshal(stale); err == nil {
+				write(*stale, body, computeETag(body))
+				return
+			}
+		}
+		respondAppError(w, err)
 		return
 	}
-	weeklySummary, err := calculateSummary(weeklyTxns, "weekly")
+	logger.Ctx(r.Context()).Debugf("Fetched transactions for filter")
+	response, err = finalize(result.Transactions, result.Warnings)
+	response.Matched = matchCount(result)
+	response.Truncated = result.Truncated
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	monthlySummary, err := calculateSummary(monthlyTxns, "monthly")
+	logger.Ctx(r.Context()).Debugf("Calculated summary for filter: %s", q.Filter)
+	respJSON, err := json.Marshal(response)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		logger.Ctx(r.Context()).Errorf("Error marshalling response: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to encode response")
 		return
 	}
-
-	dailyResponse := TransactionsResponse{
-		Summary: dailySummary,
-		Details: dailyTxns,
-	}
-	weeklyResponse := TransactionsResponse{
-		Summary: weeklySummary,
-		Details: weeklyTxns,
-	}
-	monthlyResponse := TransactionsResponse{
-		Summary: monthlySummary,
-		Details: monthlyTxns,
-	}
-	userID, err := gmailService.GetUserId()
-	if data, err := json.Marshal(dailyResponse); err == nil {
-		redisClient.Set(ctx, getCacheKey(userID, "daily"), data, 20*time.Minute)
-	} else {
-		log.Printf("Error marshalling daily response: %v", err)
-	}
-	if data, err := json.Marshal(weeklyResponse); err == nil {
-		redisClient.Set(ctx, getCacheKey(userID, "weekly"), data, 20*time.Minute)
-	} else {
-		log.Printf("Error marshalling weekly response: %v", err)
-	}
-	if data, err := json.Marshal(monthlyResponse); err == nil {
-		redisClient.Set(ctx, getCacheKey(userID, "monthly"), data, 20*time.Minute)
-	} else {
-		log.Printf("Error marshalling monthly response: %v", err)
+	// A partial response is served but not cached, so the next request
+	// tries for the full one.
+	if !response.Truncated && !q.DebugRaw {
+		if cacheResponse(r.Context(), key, respJSON, transactionsCacheTTL, fetchedAt) {
+			setCacheControl(w, transactionsCacheTTL)
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
+	write(response, respJSON, computeETag(respJSON))
+}
+
+// newRouter registers every endpoint and wraps the router in the
+// middleware all requests go through.
+func newRouter() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/health", healthHandler).Methods("GET")
+	r.HandleFunc("/transactions", transactionsHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/transactions/aggregate", aggregateHandler).Methods("GET")
+	r.HandleFunc("/transactions/compare", compareHandler).Methods("GET")
+	r.HandleFunc("/transactions/export", exportHandler).Methods("GET")
+	r.HandleFunc("/reparse", reparseHandler).Methods("POST")
+	r.HandleFunc("/metrics/summary", metricsSummaryHandler).Methods("GET")
+	r.HandleFunc("/refresh", refreshHandler).Methods("POST", "OPTIONS")
+	r.HandleFunc("/refresh/stream", refreshStreamHandler).Methods("GET")
+	r.HandleFunc("/parse/preview", parsePreviewHandler).Methods("POST")
+	r.HandleFunc("/parse/eml", parseEMLHandler).Methods("POST")
+	r.HandleFunc("/categories/rules", categoryRulesHandler).Methods("GET", "POST", "DELETE")
+	r.HandleFunc("/exclusions", exclusionsHandler).Methods("GET", "POST", "DELETE")
+	r.HandleFunc("/whoami", whoamiHandler).Methods("GET")
+	r.HandleFunc("/connect", connectHandler).Methods("POST")
+	r.HandleFunc("/disconnect", disconnectHandler).Methods("POST")
+	r.HandleFunc("/supported-banks", supportedBanksHandler).Methods("GET")
+	r.HandleFunc("/admin/patterns", adminPatternsHandler).Methods("GET", "PUT")
+	r.HandleFunc("/admin/quota", adminQuotaHandler).Methods("GET")
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondError(w, http.StatusNotFound, "Not found")
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	})
+	r.Use(gzipMiddleware)
+	r.Use(corsMiddleware)
+	r.Use(authMiddleware)
+	checkRouteAuth(r)
+
+	// The request ID and version negotiation wrap the whole router so 404s
+	// and 405s get them too.
+	return requestIDMiddleware(apiVersionMiddleware(r))
 }
 
 func main() {
@@ -443,7 +879,18 @@ func main() {
 		port = "8080" // Default port
 	}
 	cfg = config.LoadConfig()
+	logger.Init(cfg.LogLevel, cfg.LogFormat)
 	redisClient = services.InitRedis()
+	if cfg.CacheEncryption && len(cfg.EncryptionKey) == 0 {
+		logger.Warnf("CACHE_ENCRYPTION is on but ENCRYPTION_KEY is not set; responses will not be cached")
+	}
+	quotaTracker = services.NewQuotaTracker(redisClient, cfg.QuotaWindow, cfg.QuotaSoftLimit, cfg.RedisTimeout)
+	gmailBreaker = services.NewCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	tokenInfoCache = services.NewTokenInfoCache(redisClient, cfg.TokenInfoTTL, cfg.RedisTimeout)
+	memCache = newMemoryCache(cfg.MemoryCacheSize, cfg.MemoryCacheTTL)
+	if err := services.LoadPatterns(ctx, redisClient); err != nil {
+		logger.Errorf("Error loading stored parser patterns, using defaults: %v", err)
+	}
 
 	oauthConfig = &oauth2.Config{
 		ClientID:     os.Getenv("GMAIL_CLIENT_ID"),
@@ -451,10 +898,33 @@ func main() {
 		Scopes:       []string{gmail.GmailReadonlyScope},
 	}
 
-	r := mux.NewRouter()
-	r.HandleFunc("/transactions", transactionsHandler).Methods("GET", "OPTIONS")
-	r.HandleFunc("/refresh", refreshHandler).Methods("POST", "OPTIONS")
+	stopCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	fmt.Println("Server starting on port" + port + "...")
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	if cfg.WarmupAccessToken != "" {
+		goBackground(func(warmCtx context.Context) {
+			warmupCache(warmCtx, cfg.WarmupAccessToken)
+		})
+	}
+	if cfg.SchedulerInterval > 0 {
+		go runScheduler(stopCtx)
+	}
+
+	srv := &http.Server{Addr: ":" + port, Handler: newRouter()}
+	go func() {
+		logger.Infof("Server starting on port %s...", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("Server stopped: %v", err)
+		}
+	}()
+
+	<-stopCtx.Done()
+	logger.Infof("Shutting down, draining for up to %s", cfg.ShutdownDrain)
+	deadline := time.Now().Add(cfg.ShutdownDrain)
+	shutdownCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Warnf("Error shutting down HTTP server: %v", err)
+	}
+	drainBackground(time.Until(deadline))
 }
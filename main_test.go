@@ -0,0 +1,1021 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"github.com/abhayyadav/funnyMoney/be/internal/redistest"
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testEmail = "user@example.com"
+	testToken = "ya29.test-access-token-0001"
+)
+
+// testEnv is the server wired to a fake Redis and a fake Gmail account that
+// has granted testToken read access.
+type testEnv struct {
+	redis   *redistest.Server
+	gmail   *gmailtest.Server
+	handler http.Handler
+}
+
+// newTestEnv points the package globals at fresh fakes for the length of the
+// test, with the default configuration in UTC as adjusted by configure.
+func newTestEnv(t *testing.T, configure func(*config.Config)) *testEnv {
+	t.Helper()
+	saved := struct {
+		cfg            *config.Config
+		ctx            context.Context
+		tokenInfoURL   string
+		oauthConfig    *oauth2.Config
+		redisClient    *redis.Client
+		quotaTracker   *services.QuotaTracker
+		gmailBreaker   *services.CircuitBreaker
+		tokenInfoCache *services.TokenInfoCache
+		memCache       *memoryCache
+	}{cfg, ctx, services.TokenInfoURL, oauthConfig, redisClient, quotaTracker, gmailBreaker, tokenInfoCache, memCache}
+	t.Cleanup(func() {
+		cfg, ctx, services.TokenInfoURL, oauthConfig = saved.cfg, saved.ctx, saved.tokenInfoURL, saved.oauthConfig
+		redisClient, quotaTracker, gmailBreaker, tokenInfoCache, memCache = saved.redisClient, saved.quotaTracker, saved.gmailBreaker, saved.tokenInfoCache, saved.memCache
+		services.SetPatterns(services.DefaultPatternConfig())
+	})
+
+	env := &testEnv{redis: redistest.Run(t), gmail: gmailtest.Run(t, testEmail)}
+	env.redis.HandleScript(conditionalCacheWrite, conditionalCacheWriteFake)
+	env.gmail.Grant(testToken, gmailtest.ReadScope)
+
+	cfg = config.LoadConfig()
+	cfg.Location = time.UTC
+	if configure != nil {
+		configure(cfg)
+	}
+	redisClient = env.redis.Client(t)
+	quotaTracker = services.NewQuotaTracker(redisClient, cfg.QuotaWindow, cfg.QuotaSoftLimit, cfg.RedisTimeout)
+	gmailBreaker = services.NewCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	tokenInfoCache = services.NewTokenInfoCache(redisClient, cfg.TokenInfoTTL, cfg.RedisTimeout)
+	memCache = newMemoryCache(cfg.MemoryCacheSize, cfg.MemoryCacheTTL)
+	services.TokenInfoURL = env.gmail.TokenInfoURL()
+	oauthConfig = &oauth2.Config{}
+	ctx = context.WithValue(context.Background(), oauth2.HTTPClient, env.gmail.Client())
+	env.handler = newRouter()
+	return env
+}
+
+// conditionalCacheWriteFake is conditionalCacheWrite for the fake Redis.
+func conditionalCacheWriteFake(s *redistest.Server, keys, args []string) interface{} {
+	if current, ok := s.StringValue(keys[2]); ok {
+		stored, _ := strconv.ParseInt(current, 10, 64)
+		fetchedAt, _ := strconv.ParseInt(args[2], 10, 64)
+		if stored > fetchedAt {
+			return int64(0)
+		}
+	}
+	ttl, _ := strconv.ParseInt(args[3], 10, 64)
+	staleTTL, _ := strconv.ParseInt(args[4], 10, 64)
+	s.SetString(keys[0], args[0], time.Duration(ttl)*time.Millisecond)
+	s.SetString(keys[1], args[1], time.Duration(ttl)*time.Millisecond)
+	s.SetString(keys[2], args[2], time.Duration(staleTTL)*time.Millisecond)
+	s.SetString(keys[3], args[0], time.Duration(staleTTL)*time.Millisecond)
+	return int64(1)
+}
+
+// do serves one request, with the given headers as name/value pairs.
+func (env *testEnv) do(method, target string, headers ...string) *httptest.ResponseRecorder {
+	return env.doBody(method, target, "", headers...)
+}
+
+// doBody is do with a request body.
+func (env *testEnv) doBody(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	env.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// addDebit puts a debit alert for amount at merchant, dated date
+// (YYYY-MM-DD), in the mailbox.
+func (env *testEnv) addDebit(id, date string, amount float64, merchant string) {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		panic(err)
+	}
+	body := fmt.Sprintf("Rs.%.2f debited from your account at %s on %s.", amount, merchant, day.Format("02-01-06"))
+	env.gmail.Add(gmailtest.Email(id, "alerts@hdfcbank.net", "Transaction alert", body, day.Add(10*time.Hour)))
+}
+
+// addCredit puts a credit alert for amount from payer, dated date
+// (YYYY-MM-DD), in the mailbox.
+func (env *testEnv) addCredit(id, date string, amount float64, payer string) {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		panic(err)
+	}
+	body := fmt.Sprintf("Rs.%.2f received from %s on %s.", amount, payer, day.Format("02-01-06"))
+	env.gmail.Add(gmailtest.Email(id, "alerts@hdfcbank.net", "Payment received", body, day.Add(10*time.Hour)))
+}
+
+// decodeTransactions decodes a /transactions response, failing the test
+// unless it is a 200.
+func decodeTransactions(t *testing.T, rec *httptest.ResponseRecorder) types.TransactionsResponse {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp types.TransactionsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	return resp
+}
+
+// errorCodeOf returns the errorCode of an error response.
+func errorCodeOf(rec *httptest.ResponseRecorder) string {
+	var body struct {
+		ErrorCode string `json:"errorCode"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return body.ErrorCode
+}
+
+func transactionDates(txns []types.Transaction) []string {
+	dates := make([]string, len(txns))
+	for i, txn := range txns {
+		dates[i] = txn.Date
+	}
+	return dates
+}
+
+func TestDailyKeepsEndDateAndDayBefore(t *testing.T) {
+	tests := []struct {
+		name  string
+		dates []string
+		want  []string
+	}{
+		{
+			name:  "three days of mail",
+			dates: []string{"2024-03-13", "2024-03-14", "2024-03-15"},
+			want:  []string{"2024-03-15", "2024-03-14"},
+		},
+		{
+			// Nothing today must not pull the day before yesterday in.
+			name:  "quiet end date",
+			dates: []string{"2024-03-13", "2024-03-14"},
+			want:  []string{"2024-03-14"},
+		},
+		{
+			name:  "nothing recent",
+			dates: []string{"2024-03-10", "2024-03-12"},
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			for i, date := range tt.dates {
+				env.addDebit(fmt.Sprintf("m%d", i), date, 100, "AMAZON")
+			}
+			resp := decodeTransactions(t, env.do("GET", "/transactions?filter=daily&endDate=2024-03-15&access_token="+testToken))
+			got := transactionDates(resp.Details)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("dates = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactionsTypeFilter(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("d1", "2024-03-12", 300, "AMAZON")
+	env.addDebit("d2", "2024-03-13", 200, "SWIGGY")
+	env.addCredit("c1", "2024-03-14", 1000, "ACME PAYROLL")
+
+	tests := []struct {
+		query  string
+		status int
+		types  []string
+		total  float64
+	}{
+		{"type=debit", http.StatusOK, []string{"debit", "debit"}, 500},
+		{"type=credit", http.StatusOK, []string{"credit"}, 1000},
+		{"", http.StatusOK, []string{"credit", "debit", "debit"}, 1500},
+		{"type=refund", http.StatusBadRequest, nil, 0},
+		{"type=DEBIT", http.StatusBadRequest, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token="+testToken+"&"+tt.query)
+			if tt.status != http.StatusOK {
+				if rec.Code != tt.status {
+					t.Fatalf("status %d, want %d", rec.Code, tt.status)
+				}
+				return
+			}
+			resp := decodeTransactions(t, rec)
+			var got []string
+			for _, txn := range resp.Details {
+				got = append(got, txn.Type)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.types) {
+				t.Errorf("types = %v, want %v", got, tt.types)
+			}
+			if resp.Summary.Total != tt.total {
+				t.Errorf("summary total = %v, want %v", resp.Summary.Total, tt.total)
+			}
+		})
+	}
+}
+
+func TestCalculateSummaryCashflow(t *testing.T) {
+	newTestEnv(t, nil)
+	debit := func(date string, amount float64) types.Transaction {
+		return types.Transaction{Date: date, Amount: amount, Type: types.TransactionTypeDebit}
+	}
+	credit := func(date string, amount float64) types.Transaction {
+		return types.Transaction{Date: date, Amount: amount, Type: types.TransactionTypeCredit}
+	}
+	refund := credit("2024-03-09", 40)
+	refund.IsRefund = true
+
+	tests := []struct {
+		name                 string
+		period               string
+		transactions         []types.Transaction
+		income, expense, net float64
+	}{
+		{"mixed month", "monthly",
+			[]types.Transaction{debit("2024-03-02", 120.5), credit("2024-03-05", 5000), debit("2024-03-10", 79.5)},
+			5000, 200, 4800},
+		{"debits only", "monthly",
+			[]types.Transaction{debit("2024-03-02", 300), debit("2024-03-03", 200)},
+			0, 500, -500},
+		{"credits only", "monthly",
+			[]types.Transaction{credit("2024-03-02", 300), credit("2024-03-03", 200)},
+			500, 0, 500},
+		{"refund reduces expense", "monthly",
+			[]types.Transaction{debit("2024-03-02", 100), refund},
+			0, 60, -60},
+		{"previous month left out", "monthly",
+			[]types.Transaction{credit("2024-02-20", 900), debit("2024-03-02", 100), credit("2024-03-03", 250)},
+			250, 100, 150},
+		{"mixed day", "daily",
+			[]types.Transaction{debit("2024-03-14", 10), debit("2024-03-15", 30), credit("2024-03-15", 45)},
+			45, 30, 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, err := calculateSummary(tt.transactions, tt.period)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.Income != tt.income || summary.Expense != tt.expense || summary.Net != tt.net {
+				t.Errorf("income/expense/net = %v/%v/%v, want %v/%v/%v",
+					summary.Income, summary.Expense, summary.Net, tt.income, tt.expense, tt.net)
+			}
+		})
+	}
+}
+
+func TestTransactionsCategoryFilter(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-11", 300, "SWIGGY")
+	env.addDebit("m2", "2024-03-12", 150, "ZOMATO")
+	env.addDebit("m3", "2024-03-13", 999, "AMAZON")
+	env.addDebit("m4", "2024-03-14", 42, "CORNER STORE")
+
+	tests := []struct {
+		category  string
+		status    int
+		merchants []string
+		total     float64
+	}{
+		{"food", http.StatusOK, []string{"ZOMATO", "SWIGGY"}, 450},
+		{"FOOD", http.StatusOK, []string{"ZOMATO", "SWIGGY"}, 450},
+		{"shopping", http.StatusOK, []string{"AMAZON"}, 999},
+		{"uncategorized", http.StatusOK, []string{"CORNER STORE"}, 42},
+		{"health", http.StatusOK, nil, 0},
+		{"pets", http.StatusBadRequest, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.category, func(t *testing.T) {
+			rec := env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&category="+tt.category+"&access_token="+testToken)
+			if tt.status != http.StatusOK {
+				if rec.Code != tt.status {
+					t.Fatalf("status %d, want %d", rec.Code, tt.status)
+				}
+				return
+			}
+			resp := decodeTransactions(t, rec)
+			var got []string
+			for _, txn := range resp.Details {
+				got = append(got, txn.Merchant)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.merchants) {
+				t.Errorf("merchants = %v, want %v", got, tt.merchants)
+			}
+			if resp.Summary.Total != tt.total {
+				t.Errorf("summary total = %v, want %v", resp.Summary.Total, tt.total)
+			}
+		})
+	}
+}
+
+func TestTransactionsAmountDisplay(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-14", 1234567.5, "AMAZON")
+
+	tests := []struct {
+		locale string
+		status int
+		want   string
+	}{
+		{"", http.StatusOK, ""},
+		{"en-IN", http.StatusOK, "₹12,34,567.50"},
+		{"en-US", http.StatusOK, "₹1,234,567.50"},
+		{"xx-XX", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			rec := env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&locale="+tt.locale+"&access_token="+testToken)
+			if tt.status != http.StatusOK {
+				if rec.Code != tt.status {
+					t.Fatalf("status %d, want %d", rec.Code, tt.status)
+				}
+				return
+			}
+			resp := decodeTransactions(t, rec)
+			if len(resp.Details) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(resp.Details))
+			}
+			if got := resp.Details[0].AmountDisplay; got != tt.want {
+				t.Errorf("amountDisplay = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactionsExcludeTransfers(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-13", 300, "SWIGGY")
+	env.gmail.Add(gmailtest.Email("m2", "alerts@hdfcbank.net", "Transaction alert",
+		"Rs.5000.00 debited from a/c XX1234 on 14-03-24 for self transfer to a/c XX9876.",
+		time.Date(2024, 3, 14, 10, 0, 0, 0, time.UTC)))
+
+	tests := []struct {
+		query   string
+		expense float64
+	}{
+		{"", 300},
+		{"&includeTransfers=false", 300},
+		{"&includeTransfers=true", 5300},
+	}
+	for _, tt := range tests {
+		t.Run("includeTransfers"+tt.query, func(t *testing.T) {
+			resp := decodeTransactions(t, env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15"+tt.query+"&access_token="+testToken))
+			if len(resp.Details) != 2 {
+				t.Fatalf("got %d transactions, want both listed", len(resp.Details))
+			}
+			flagged := map[float64]bool{}
+			for _, txn := range resp.Details {
+				flagged[txn.Amount] = txn.IsTransfer
+			}
+			if !flagged[5000] || flagged[300] {
+				t.Errorf("isTransfer by amount = %v, want only the 5000 transfer flagged", flagged)
+			}
+			if resp.Summary.Expense != tt.expense || resp.Summary.Total != tt.expense {
+				t.Errorf("expense/total = %v/%v, want %v", resp.Summary.Expense, resp.Summary.Total, tt.expense)
+			}
+		})
+	}
+}
+
+func TestTransactionsMinAmount(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured float64
+		query      string
+		merchants  []string
+		total      float64
+	}{
+		{"no threshold", 0, "", []string{"AMAZON", "SWIGGY", "PAYTM", "VERIFY"}, 1300.5},
+		{"configured threshold", 10, "", []string{"AMAZON", "SWIGGY", "PAYTM"}, 1299.5},
+		{"amount at the threshold is kept", 99.5, "", []string{"AMAZON", "SWIGGY", "PAYTM"}, 1299.5},
+		{"request override raises it", 10, "&minAmount=100", []string{"AMAZON", "SWIGGY"}, 1200},
+		{"request override lowers it", 10, "&minAmount=0", []string{"AMAZON", "SWIGGY", "PAYTM", "VERIFY"}, 1300.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) { c.MinAmount = tt.configured })
+			env.addDebit("m1", "2024-03-11", 1, "VERIFY")
+			env.addDebit("m2", "2024-03-12", 99.5, "PAYTM")
+			env.addDebit("m3", "2024-03-13", 100, "SWIGGY")
+			env.addDebit("m4", "2024-03-14", 1100, "AMAZON")
+
+			resp := decodeTransactions(t, env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15"+tt.query+"&access_token="+testToken))
+			var got []string
+			for _, txn := range resp.Details {
+				got = append(got, txn.Merchant)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.merchants) {
+				t.Errorf("merchants = %v, want %v", got, tt.merchants)
+			}
+			if resp.Summary.Total != tt.total {
+				t.Errorf("summary total = %v, want %v", resp.Summary.Total, tt.total)
+			}
+		})
+	}
+}
+
+func TestErrorBodyShape(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		headers   []string
+		gmailFail string
+		status    int
+	}{
+		{name: "transactions without token", method: "GET", target: "/transactions?filter=weekly", status: http.StatusUnauthorized},
+		{name: "transactions with unknown token", method: "GET", target: "/transactions?filter=weekly&access_token=ya29.unknown", status: http.StatusUnauthorized},
+		{name: "transactions bad filter", method: "GET", target: "/transactions?filter=hourly&access_token=" + testToken, status: http.StatusBadRequest},
+		{name: "transactions wrong method", method: "DELETE", target: "/transactions?filter=weekly&access_token=" + testToken, status: http.StatusMethodNotAllowed},
+		{name: "transactions Gmail list fails", method: "GET", target: "/transactions?filter=weekly&access_token=" + testToken, gmailFail: gmailtest.List, status: http.StatusInternalServerError},
+		{name: "refresh without token", method: "POST", target: "/refresh", status: http.StatusUnauthorized},
+		{name: "refresh wrong method", method: "PUT", target: "/refresh?access_token=" + testToken, status: http.StatusMethodNotAllowed},
+		{name: "refresh long idempotency key", method: "POST", target: "/refresh?access_token=" + testToken,
+			headers: []string{"Idempotency-Key", strings.Repeat("k", maxIdempotencyKeyLen+1)}, status: http.StatusBadRequest},
+		{name: "refresh Gmail list fails", method: "POST", target: "/refresh?access_token=" + testToken, gmailFail: gmailtest.List, status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			if tt.gmailFail != "" {
+				env.gmail.Fail(tt.gmailFail, http.StatusInternalServerError)
+			}
+			rec := env.do(tt.method, tt.target, tt.headers...)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var body struct {
+				Error     string `json:"error"`
+				Code      int    `json:"code"`
+				ErrorCode string `json:"errorCode"`
+				RequestID string `json:"requestId"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body is not JSON: %q", rec.Body.String())
+			}
+			if body.Error == "" || body.Code != tt.status || body.ErrorCode == "" || body.RequestID == "" {
+				t.Errorf("error body = %+v, want error, code %d, errorCode and requestId", body, tt.status)
+			}
+		})
+	}
+}
+
+func TestTransactionsRefundSummary(t *testing.T) {
+	tests := []struct {
+		name             string
+		refunds          []string
+		total, expense   float64
+		income, netSpend float64
+	}{
+		{"no refund", nil, 1300, 1300, 0, -1300},
+		{"partial refund", []string{"Refund of Rs.400.00 credited to your account from AMAZON on 14-03-24."}, 900, 900, 0, -900},
+		{"reversal and refund", []string{
+			"Your transaction of Rs.300.00 at SWIGGY on 13-03-24 has been reversed.",
+			"Refund of Rs.400.00 credited to your account from AMAZON on 14-03-24.",
+		}, 600, 600, 0, -600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-12", 300, "SWIGGY")
+			env.addDebit("m2", "2024-03-12", 1000, "AMAZON")
+			for i, body := range tt.refunds {
+				env.gmail.Add(gmailtest.Email(fmt.Sprintf("r%d", i), "alerts@hdfcbank.net", "Refund processed", body,
+					time.Date(2024, 3, 14, 10, 0, 0, 0, time.UTC)))
+			}
+			resp := decodeTransactions(t, env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token="+testToken))
+			refunds := 0
+			for _, txn := range resp.Details {
+				if txn.IsRefund {
+					refunds++
+				}
+			}
+			if refunds != len(tt.refunds) {
+				t.Errorf("%d transactions flagged as refunds, want %d", refunds, len(tt.refunds))
+			}
+			s := resp.Summary
+			if s.Total != tt.total || s.Expense != tt.expense || s.Income != tt.income || s.Net != tt.netSpend {
+				t.Errorf("total/expense/income/net = %v/%v/%v/%v, want %v/%v/%v/%v",
+					s.Total, s.Expense, s.Income, s.Net, tt.total, tt.expense, tt.income, tt.netSpend)
+			}
+		})
+	}
+}
+
+func TestTransactionsAdjustmentSummary(t *testing.T) {
+	tests := []struct {
+		name           string
+		bodies         []string
+		adjustments    int
+		total, expense float64
+	}{
+		{"cashback credited", []string{"Cashback of Rs.50.00 has been credited to your account on 14-03-24."}, 1, 1250, 1250},
+		{"cashback and fee waiver", []string{
+			"Cashback of Rs.50.00 has been credited to your account on 14-03-24.",
+			"Annual fee of Rs.250.00 waived and credited to your card on 14-03-24.",
+		}, 2, 1000, 1000},
+		{"purchase advertising cashback", []string{"Rs.200.00 spent at ZOMATO on 14-03-24. Cashback will be credited in 7 days."}, 0, 1500, 1500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-12", 300, "SWIGGY")
+			env.addDebit("m2", "2024-03-12", 1000, "AMAZON")
+			for i, body := range tt.bodies {
+				env.gmail.Add(gmailtest.Email(fmt.Sprintf("a%d", i), "alerts@hdfcbank.net", "Transaction alert", body,
+					time.Date(2024, 3, 14, 10, 0, 0, 0, time.UTC)))
+			}
+			resp := decodeTransactions(t, env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token="+testToken))
+			adjustments := 0
+			for _, txn := range resp.Details {
+				if txn.IsAdjustment {
+					adjustments++
+				}
+			}
+			if adjustments != tt.adjustments {
+				t.Errorf("%d transactions flagged as adjustments, want %d", adjustments, tt.adjustments)
+			}
+			if s := resp.Summary; s.Total != tt.total || s.Expense != tt.expense || s.Income != 0 {
+				t.Errorf("total/expense/income = %v/%v/%v, want %v/%v/0", s.Total, s.Expense, s.Income, tt.total, tt.expense)
+			}
+		})
+	}
+}
+
+func TestTransactionsEndDateQuery(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        string
+		status        int
+		after, before string
+	}{
+		{"weekly to mid-March", "filter=weekly&endDate=2024-03-15", http.StatusOK, "2024-03-01", "2024-03-16"},
+		{"daily on a leap day", "filter=daily&endDate=2024-02-29", http.StatusOK, "2024-02-27", "2024-03-01"},
+		{"historical year end", "filter=weekly&endDate=2022-12-31", http.StatusOK, "2022-12-17", "2023-01-01"},
+		{"not a date", "filter=weekly&endDate=31-12-2022", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			rec := env.do("GET", "/transactions?"+tt.params+"&access_token="+testToken)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			queries := env.gmail.Queries()
+			if tt.status != http.StatusOK {
+				if len(queries) != 0 {
+					t.Errorf("Gmail searched %q for a rejected request", queries)
+				}
+				return
+			}
+			after, _ := time.Parse("2006-01-02", tt.after)
+			before, _ := time.Parse("2006-01-02", tt.before)
+			want := fmt.Sprintf("after:%d before:%d ", after.Unix(), before.Unix())
+			if len(queries) != 1 || !strings.HasPrefix(queries[0], want) {
+				t.Errorf("queries = %q, want one starting %q", queries, want)
+			}
+		})
+	}
+}
+
+func TestCalculateSummaryChangePercentage(t *testing.T) {
+	newTestEnv(t, nil)
+	debit := func(date string, amount float64) types.Transaction {
+		return types.Transaction{Date: date, Amount: amount, Type: types.TransactionTypeDebit}
+	}
+	refund := debit("2024-03-07", 100)
+	refund.IsRefund, refund.Type = true, types.TransactionTypeCredit
+	pct := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name         string
+		period       string
+		transactions []types.Transaction
+		want         *float64
+	}{
+		{"daily, nothing the day before", "daily", []types.Transaction{debit("2024-03-15", 80)}, nil},
+		{"daily, up", "daily", []types.Transaction{debit("2024-03-14", 100), debit("2024-03-15", 150)}, pct(50)},
+		{"weekly, nothing the week before", "weekly", []types.Transaction{debit("2024-03-10", 30), debit("2024-03-15", 70)}, nil},
+		{"weekly, down", "weekly", []types.Transaction{debit("2024-03-05", 400), debit("2024-03-15", 300)}, pct(-25)},
+		{"weekly, previous week refunded in full", "weekly", []types.Transaction{debit("2024-03-06", 100), refund, debit("2024-03-15", 50)}, nil},
+		{"monthly, first month", "monthly", []types.Transaction{debit("2024-03-02", 500)}, nil},
+		{"monthly, unchanged", "monthly", []types.Transaction{debit("2024-02-10", 500), debit("2024-03-02", 500)}, pct(0)},
+		{"all has nothing to compare", "all", []types.Transaction{debit("2024-02-10", 500), debit("2024-03-02", 500)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, err := calculateSummary(tt.transactions, tt.period)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := summary.ChangePercentage
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Fatalf("changePercentage = %v, want %v", fmtPercent(got), fmtPercent(tt.want))
+			}
+			data, _ := json.Marshal(summary)
+			if tt.want == nil && !strings.Contains(string(data), `"changePercentage":null`) {
+				t.Errorf("summary JSON %s does not carry changePercentage: null", data)
+			}
+		})
+	}
+}
+
+func fmtPercent(p *float64) string {
+	if p == nil {
+		return "null"
+	}
+	return strconv.FormatFloat(*p, 'f', -1, 64)
+}
+
+func TestCalculateMonthToDateSummary(t *testing.T) {
+	newTestEnv(t, nil)
+	debit := func(date string, amount float64) types.Transaction {
+		return types.Transaction{Date: date, Amount: amount, Type: types.TransactionTypeDebit}
+	}
+	tests := []struct {
+		name              string
+		asOf              string
+		transactions      []types.Transaction
+		total, previously float64
+	}{
+		{"mid-month counts the same days last month", "2024-03-15",
+			[]types.Transaction{debit("2024-02-10", 100), debit("2024-02-15", 50), debit("2024-02-16", 999), debit("2024-03-01", 70), debit("2024-03-15", 30)},
+			100, 150},
+		{"first of the month", "2024-03-01",
+			[]types.Transaction{debit("2024-02-01", 40), debit("2024-02-02", 60), debit("2024-03-01", 25)},
+			25, 40},
+		{"31st against a 30-day month uses all of it", "2024-05-31",
+			[]types.Transaction{debit("2024-04-30", 80), debit("2024-05-31", 10)},
+			10, 80},
+		{"30th against leap February", "2024-03-30",
+			[]types.Transaction{debit("2024-02-29", 45), debit("2024-03-30", 5)},
+			5, 45},
+		{"29th against a 28-day February", "2023-03-29",
+			[]types.Transaction{debit("2023-02-28", 12), debit("2023-03-29", 8)},
+			8, 12},
+		{"January against December", "2024-01-10",
+			[]types.Transaction{debit("2023-12-10", 33), debit("2023-12-11", 66), debit("2024-01-10", 11)},
+			11, 33},
+		{"future days of this month left out", "2024-03-15",
+			[]types.Transaction{debit("2024-03-16", 500), debit("2024-03-14", 20)},
+			20, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asOf, _ := time.Parse("2006-01-02", tt.asOf)
+			summary := calculateMonthToDateSummary(tt.transactions, asOf)
+			if summary.Total != tt.total || summary.Previously != tt.previously {
+				t.Errorf("total/previously = %v/%v, want %v/%v", summary.Total, summary.Previously, tt.total, tt.previously)
+			}
+		})
+	}
+}
+
+func TestTransactionsMonthToDate(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-02-14", 200, "AMAZON")
+	env.addDebit("m2", "2024-02-20", 999, "AMAZON")
+	env.addDebit("m3", "2024-03-05", 300, "SWIGGY")
+
+	tests := []struct {
+		params string
+		status int
+		total  float64
+		prev   float64
+	}{
+		{"filter=monthly&monthToDate=true", http.StatusOK, 300, 200},
+		{"filter=weekly&monthToDate=true", http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.params, func(t *testing.T) {
+			rec := env.do("GET", "/transactions?"+tt.params+"&endDate=2024-03-15&access_token="+testToken)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			s := decodeTransactions(t, rec).Summary
+			if s.Total != tt.total || s.Previously != tt.prev {
+				t.Errorf("total/previously = %v/%v, want %v/%v", s.Total, s.Previously, tt.total, tt.prev)
+			}
+		})
+	}
+}
+
+func TestTransactionsMatchedCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  types.MatchCount
+	}{
+		{"full listing", "", types.MatchCount{Messages: 5, Exact: true}},
+		{"first page", "&pageSize=2", types.MatchCount{Messages: 5, Exact: false}},
+		{"only page", "&pageSize=10", types.MatchCount{Messages: 5, Exact: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			for i := 0; i < 5; i++ {
+				env.addDebit(fmt.Sprintf("m%d", i), fmt.Sprintf("2024-03-%02d", 10+i), float64(100+i), "AMAZON")
+			}
+			resp := decodeTransactions(t, env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15"+tt.query+"&access_token="+testToken))
+			if resp.Matched == nil || *resp.Matched != tt.want {
+				t.Errorf("matched = %+v, want %+v", resp.Matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactionsConfiguredWindows(t *testing.T) {
+	tests := []struct {
+		filter      string
+		configure   func(*config.Config)
+		windowStart string
+	}{
+		{"daily", func(c *config.Config) { c.DailyWindowDays = 1 }, "2024-03-14T00:00:00Z"},
+		{"weekly", func(c *config.Config) { c.WeeklyWindowDays = 7 }, "2024-03-08T00:00:00Z"},
+		{"monthly", func(c *config.Config) { c.MonthlyWindowDays = 31 }, "2024-02-13T00:00:00Z"},
+		{"all", func(c *config.Config) { c.AllWindowDays = 365 }, "2023-03-16T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			env := newTestEnv(t, tt.configure)
+			resp := decodeTransactions(t, env.do("GET", "/transactions?filter="+tt.filter+"&endDate=2024-03-15&access_token="+testToken))
+			if resp.WindowStart != tt.windowStart {
+				t.Errorf("windowStart = %q, want %q", resp.WindowStart, tt.windowStart)
+			}
+		})
+	}
+}
+
+func TestCalculateSummaryRounding(t *testing.T) {
+	debits := func(amounts ...float64) []types.Transaction {
+		var txns []types.Transaction
+		for _, a := range amounts {
+			txns = append(txns, types.Transaction{Date: "2024-03-10", Amount: a, Type: types.TransactionTypeDebit})
+		}
+		return txns
+	}
+	tests := []struct {
+		name     string
+		decimals int
+		txns     []types.Transaction
+		expense  float64
+	}{
+		{"float drift removed", 2, debits(0.1, 0.2), 0.3},
+		{"many small amounts", 2, debits(19.99, 19.99, 19.99, 0.01), 59.98},
+		{"whole units", 0, debits(10.4, 10.4), 21},
+		{"three decimals", 3, debits(1.0005, 1.0001), 2.001},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestEnv(t, func(c *config.Config) { c.AmountDecimals = tt.decimals })
+			summary, err := calculateSummary(tt.txns, "monthly")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.Expense != tt.expense || summary.Net != -tt.expense {
+				t.Errorf("expense/net = %v/%v, want %v/%v", summary.Expense, summary.Net, tt.expense, -tt.expense)
+			}
+		})
+	}
+}
+
+func TestCalculateSummaryAll(t *testing.T) {
+	txn := func(date string, amount float64, typ string) types.Transaction {
+		return types.Transaction{Date: date, Amount: amount, Type: typ}
+	}
+	debit, credit := types.TransactionTypeDebit, types.TransactionTypeCredit
+	tests := []struct {
+		name         string
+		transactions []types.Transaction
+		want         types.Summary
+	}{
+		{"empty", nil, types.Summary{}},
+		{"single day", []types.Transaction{txn("2024-03-10", 90, debit), txn("2024-03-10", 30, debit)},
+			types.Summary{Total: 120, Count: 2, DailyAverage: 120, FirstDate: "2024-03-10", LastDate: "2024-03-10", Expense: 120, Net: -120}},
+		{"span across months", []types.Transaction{txn("2024-03-10", 100, debit), txn("2024-01-01", 200, debit), txn("2024-02-15", 1000, credit)},
+			types.Summary{Total: 1300, Count: 3, DailyAverage: 18.57, FirstDate: "2024-01-01", LastDate: "2024-03-10", Income: 1000, Expense: 300, Net: 700}},
+		// The undated debit counts toward the total but not the span or cashflow.
+		{"unparseable dates", []types.Transaction{txn("2024-03-01", 50, debit), txn("soon", 50, debit), txn("2024-03-05", 50, debit)},
+			types.Summary{Total: 150, Count: 3, DailyAverage: 30, FirstDate: "2024-03-01", LastDate: "2024-03-05", Expense: 100, Net: -100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestEnv(t, nil)
+			got, err := calculateSummary(tt.transactions, "all")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ChangePercentage != nil {
+				t.Errorf("changePercentage = %v, want null", *got.ChangePercentage)
+			}
+			got.ChangePercentage = nil
+			if got != tt.want {
+				t.Errorf("summary = %+v\nwant      %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGmailDisabled(t *testing.T) {
+	const disabled = "Mail service not enabled"
+	tests := []struct {
+		name    string
+		path    string
+		kind    string
+		status  int
+		message string
+		want    int
+		code    string
+	}{
+		{"transactions list", "/transactions?filter=weekly", gmailtest.List, http.StatusBadRequest, disabled, http.StatusForbidden, services.ErrCodeGmailDisabled},
+		{"transactions profile", "/transactions?filter=weekly", gmailtest.Profile, http.StatusBadRequest, disabled, http.StatusForbidden, services.ErrCodeGmailDisabled},
+		{"whoami", "/whoami?", gmailtest.Profile, http.StatusBadRequest, disabled, http.StatusForbidden, services.ErrCodeGmailDisabled},
+		{"rejected token is not disabled", "/whoami?", gmailtest.Profile, http.StatusUnauthorized, "Invalid Credentials", http.StatusUnauthorized, services.ErrCodeInvalidToken},
+		{"other precondition failure", "/transactions?filter=weekly", gmailtest.List, http.StatusBadRequest, "Precondition check failed.", http.StatusInternalServerError, "INTERNAL_ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.gmail.FailWith(tt.kind, tt.status, tt.message)
+			rec := env.do("GET", tt.path+"&access_token="+testToken)
+			if rec.Code != tt.want || errorCodeOf(rec) != tt.code {
+				t.Errorf("status %d errorCode %q, want %d %q: %s", rec.Code, errorCodeOf(rec), tt.want, tt.code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestTransactionsWindowBounds(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800)
+	tests := []struct {
+		name     string
+		filter   string
+		location *time.Location
+		start    string
+		end      string
+	}{
+		{"daily", "daily", time.UTC, "2024-03-13T00:00:00Z", "2024-03-16T00:00:00Z"},
+		{"weekly", "weekly", time.UTC, "2024-03-01T00:00:00Z", "2024-03-16T00:00:00Z"},
+		{"monthly", "monthly", time.UTC, "2024-01-15T00:00:00Z", "2024-03-16T00:00:00Z"},
+		{"all", "all", time.UTC, "2023-12-16T00:00:00Z", "2024-03-16T00:00:00Z"},
+		{"daily in IST", "daily", ist, "2024-03-13T00:00:00+05:30", "2024-03-16T00:00:00+05:30"},
+		{"monthly in IST", "monthly", ist, "2024-01-15T00:00:00+05:30", "2024-03-16T00:00:00+05:30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) { c.Location = tt.location })
+			resp := decodeTransactions(t, env.do("GET", "/transactions?filter="+tt.filter+"&endDate=2024-03-15&access_token="+testToken))
+			if resp.WindowStart != tt.start || resp.WindowEnd != tt.end {
+				t.Errorf("window = [%s, %s), want [%s, %s)", resp.WindowStart, resp.WindowEnd, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestGmailCircuitBreaker(t *testing.T) {
+	const target = "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+	tests := []struct {
+		name string
+		// seeded caches a good response first, then lets it go stale.
+		seeded bool
+		// failing is the status while Gmail is down, open the status once
+		// the breaker has opened.
+		failing int
+		open    int
+	}{
+		{"no cache", false, http.StatusInternalServerError, http.StatusServiceUnavailable},
+		{"stale cache", true, http.StatusInternalServerError, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) {
+				c.BreakerThreshold = 2
+				c.BreakerCooldown = 50 * time.Millisecond
+			})
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			if tt.seeded {
+				decodeTransactions(t, env.do("GET", target))
+				env.redis.FastForward(3 * time.Hour)
+				memCache = newMemoryCache(0, 0)
+			}
+
+			env.gmail.Fail(gmailtest.List, http.StatusInternalServerError)
+			for i := 0; i < 2; i++ {
+				if rec := env.do("GET", target); rec.Code != tt.failing {
+					t.Fatalf("failure %d: status %d, want %d", i+1, rec.Code, tt.failing)
+				}
+			}
+			lists := env.gmail.Calls(gmailtest.List)
+			rec := env.do("GET", target)
+			if rec.Code != tt.open {
+				t.Fatalf("open: status %d, want %d: %s", rec.Code, tt.open, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK && errorCodeOf(rec) != services.ErrCodeServiceUnavailable {
+				t.Errorf("open: errorCode %q, want %s", errorCodeOf(rec), services.ErrCodeServiceUnavailable)
+			}
+			if rec.Code == http.StatusOK && len(decodeTransactions(t, rec).Details) != 1 {
+				t.Errorf("open: want the stale debit, got %s", rec.Body.String())
+			}
+			if got := env.gmail.Calls(gmailtest.List); got != lists {
+				t.Errorf("open breaker still called Gmail %d times", got-lists)
+			}
+
+			env.gmail.Fail(gmailtest.List, 0)
+			time.Sleep(60 * time.Millisecond)
+			if resp := decodeTransactions(t, env.do("GET", target)); len(resp.Warnings) != 0 {
+				t.Errorf("after recovery: warnings %q, want a fresh response", resp.Warnings)
+			}
+			if got := env.gmail.Calls(gmailtest.List); got != lists+1 {
+				t.Errorf("after recovery: %d list calls, want the one probe", got-lists)
+			}
+		})
+	}
+}
+
+func TestDebugRawGated(t *testing.T) {
+	const adminToken = "admin-secret"
+	tests := []struct {
+		name    string
+		query   string
+		admin   string
+		headers []string
+		status  int
+		raw     bool
+	}{
+		{name: "off by default", admin: adminToken, status: http.StatusOK},
+		{name: "admin disabled", query: "&debug=raw", status: http.StatusForbidden},
+		{name: "no admin token", query: "&debug=raw", admin: adminToken, status: http.StatusUnauthorized},
+		{name: "wrong admin token", query: "&debug=raw", admin: adminToken, headers: []string{"Authorization", "Bearer nope"}, status: http.StatusUnauthorized},
+		{name: "admin", query: "&debug=raw", admin: adminToken, headers: []string{"Authorization", "Bearer " + adminToken}, status: http.StatusOK, raw: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) { c.AdminToken = tt.admin })
+			env.gmail.Add(gmailtest.Email("m1", "alerts@hdfcbank.net", "Transaction alert",
+				"Rs.250.00 debited from A/c XX1234 at AMAZON on 14-03-24. Queries: help@hdfcbank.net", time.Date(2024, 3, 14, 10, 0, 0, 0, time.UTC)))
+
+			rec := env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token="+testToken+tt.query, tt.headers...)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			resp := decodeTransactions(t, rec)
+			if len(resp.Details) != 1 {
+				t.Fatalf("details = %+v, want one", resp.Details)
+			}
+			raw := resp.Details[0].RawBody
+			if (raw != "") != tt.raw {
+				t.Fatalf("rawBody = %q, want present %v", raw, tt.raw)
+			}
+			if _, cached := env.redis.Get(getCacheKey(testEmail, "weekly") + ":endDate=2024-03-15"); cached == tt.raw {
+				t.Errorf("cached = %v, want %v", cached, !tt.raw)
+			}
+			if !tt.raw {
+				return
+			}
+			if strings.Contains(raw, "XX1234") || strings.Contains(raw, "help@hdfcbank.net") || !strings.Contains(raw, "Rs.250.00") {
+				t.Errorf("rawBody = %q, want account and address masked", raw)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+		})
+	}
+}
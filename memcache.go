@@ -0,0 +1,95 @@
+package main
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryCache is a small in-process LRU in front of Redis, so hot cache keys
+// skip the network round trip. Entries live for a short TTL; Redis stays the
+// shared tier, so other instances' writes show up once an entry expires.
+type memoryCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	body    []byte
+	etag    string
+	expires time.Time
+	// redisExpires is when the Redis entry this copies expires, so a hit
+	// can report the time left without asking Redis. Zero when unknown.
+	redisExpires time.Time
+}
+
+// newMemoryCache returns an LRU holding up to size entries, or nil (a cache
+// that never hits) when size is zero.
+func newMemoryCache(size int, ttl time.Duration) *memoryCache {
+	if size <= 0 {
+		return nil
+	}
+	return &memoryCache{size: size, ttl: ttl, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *memoryCache) get(key string) (body []byte, etag string, redisExpires time.Time, ok bool) {
+	if c == nil {
+		return nil, "", time.Time{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, "", time.Time{}, false
+	}
+	entry := el.Value.(*memoryEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(el)
+		return nil, "", time.Time{}, false
+	}
+	c.order.MoveToFront(el)
+	return entry.body, entry.etag, entry.redisExpires, true
+}
+
+// set stores a copy of the Redis entry key, which expires at redisExpires.
+func (c *memoryCache) set(key string, body []byte, etag string, redisExpires time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &memoryEntry{key: key, body: body, etag: etag, expires: time.Now().Add(c.ttl), redisExpires: redisExpires}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// deletePrefix drops every entry whose key starts with prefix.
+func (c *memoryCache) deletePrefix(prefix string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, el := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(el)
+		}
+	}
+}
+
+func (c *memoryCache) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*memoryEntry).key)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+)
+
+func TestMemoryCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		ttl    time.Duration
+		run    func(c *memoryCache)
+		hits   []string
+		misses []string
+	}{
+		{"disabled cache never hits", 0, time.Minute, func(c *memoryCache) {
+			c.set("a", []byte("1"), "", time.Time{})
+		}, nil, []string{"a"}},
+		{"hit within ttl", 2, time.Minute, func(c *memoryCache) {
+			c.set("a", []byte("1"), "", time.Time{})
+		}, []string{"a"}, []string{"b"}},
+		{"expired entry misses", 2, 10 * time.Millisecond, func(c *memoryCache) {
+			c.set("a", []byte("1"), "", time.Time{})
+			time.Sleep(20 * time.Millisecond)
+		}, nil, []string{"a"}},
+		{"least recently used is evicted", 2, time.Minute, func(c *memoryCache) {
+			c.set("a", []byte("1"), "", time.Time{})
+			c.set("b", []byte("2"), "", time.Time{})
+			c.get("a")
+			c.set("c", []byte("3"), "", time.Time{})
+		}, []string{"a", "c"}, []string{"b"}},
+		{"overwrite keeps one entry", 2, time.Minute, func(c *memoryCache) {
+			c.set("a", []byte("1"), "", time.Time{})
+			c.set("a", []byte("2"), "", time.Time{})
+			c.set("b", []byte("3"), "", time.Time{})
+		}, []string{"a", "b"}, nil},
+		{"deletePrefix drops one user", 4, time.Minute, func(c *memoryCache) {
+			c.set("u1:weekly", []byte("1"), "", time.Time{})
+			c.set("u1:monthly", []byte("2"), "", time.Time{})
+			c.set("u2:weekly", []byte("3"), "", time.Time{})
+			c.deletePrefix("u1:")
+		}, []string{"u2:weekly"}, []string{"u1:weekly", "u1:monthly"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newMemoryCache(tt.size, tt.ttl)
+			tt.run(c)
+			for _, key := range tt.hits {
+				if _, _, _, ok := c.get(key); !ok {
+					t.Errorf("get(%q) missed, want a hit", key)
+				}
+			}
+			for _, key := range tt.misses {
+				if _, _, _, ok := c.get(key); ok {
+					t.Errorf("get(%q) hit, want a miss", key)
+				}
+			}
+		})
+	}
+}
+
+func TestTwoTierCache(t *testing.T) {
+	const target = "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		between   func(t *testing.T, env *testEnv)
+		wantLists int
+	}{
+		{"memory hit skips Redis", time.Minute, func(t *testing.T, env *testEnv) {
+			wipeRedisCache(t, env)
+		}, 0},
+		{"memory miss falls back to Redis", time.Minute, func(t *testing.T, env *testEnv) {
+			memCache = newMemoryCache(cfg.MemoryCacheSize, cfg.MemoryCacheTTL)
+		}, 0},
+		{"expired memory entry and empty Redis fetch", 10 * time.Millisecond, func(t *testing.T, env *testEnv) {
+			wipeRedisCache(t, env)
+			time.Sleep(20 * time.Millisecond)
+		}, 1},
+		{"invalidation clears both tiers", time.Minute, func(t *testing.T, env *testEnv) {
+			rec := env.doBody("POST", "/categories/rules?access_token="+testToken, `{"match":"AMAZON","category":"shopping"}`)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("saving rule: %d %s", rec.Code, rec.Body.String())
+			}
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) {
+				c.MemoryCacheSize = 8
+				c.MemoryCacheTTL = tt.ttl
+			})
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			decodeTransactions(t, env.do("GET", target))
+			tt.between(t, env)
+			lists := env.gmail.Calls(gmailtest.List)
+
+			got := decodeTransactions(t, env.do("GET", target))
+			if len(got.Details) != 1 {
+				t.Errorf("details = %+v, want the one debit", got.Details)
+			}
+			if n := env.gmail.Calls(gmailtest.List) - lists; n != tt.wantLists {
+				t.Errorf("Gmail listed %d times, want %d", n, tt.wantLists)
+			}
+		})
+	}
+}
+
+// wipeRedisCache deletes every Redis key, leaving only the in-memory tier.
+func wipeRedisCache(t *testing.T, env *testEnv) {
+	t.Helper()
+	for _, key := range env.redis.Keys() {
+		if err := redisClient.Del(context.Background(), key).Err(); err != nil {
+			t.Fatalf("deleting %s: %v", key, err)
+		}
+	}
+}
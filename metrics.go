@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// summaryGauges are the summary values exposed by /metrics/summary.
+var summaryGauges = []struct {
+	name, help string
+	value      func(types.Summary) float64
+}{
+	{"funmon_spend", "Spend in the current period.", func(s types.Summary) float64 { return s.Total }},
+	{"funmon_spend_previous", "Spend in the period before the current one.", func(s types.Summary) float64 { return s.Previously }},
+	{"funmon_income", "Income in the current period.", func(s types.Summary) float64 { return s.Income }},
+	{"funmon_expense", "Expenses in the current period.", func(s types.Summary) float64 { return s.Expense }},
+	{"funmon_net", "Income minus expenses in the current period.", func(s types.Summary) float64 { return s.Net }},
+}
+
+// metricsSummaryHandler serves GET /metrics/summary, the user's daily, weekly
+// and monthly summaries as OpenMetrics gauges for Prometheus to scrape. It
+// reads the views /refresh caches, fetching only the ones that are missing.
+func metricsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	gmailService, userID, ok := gmailServiceFromRequest(w, r)
+	if !ok {
+		return
+	}
+	applyUserRules(r.Context(), gmailService, userID)
+
+	summaries := make(map[string]types.Summary, len(refreshFilters))
+	for _, period := range refreshPeriods() {
+		response, err := loadBaseResponse(r.Context(), gmailService, userID, period.Filter, period.Days)
+		if err != nil {
+			respondAppError(w, err)
+			return
+		}
+		summaries[period.Filter] = response.Summary
+	}
+
+	var b strings.Builder
+	for _, gauge := range summaryGauges {
+		fmt.Fprintf(&b, "# TYPE %s gauge\n# HELP %s %s\n", gauge.name, gauge.name, gauge.help)
+		for _, filter := range refreshFilters {
+			fmt.Fprintf(&b, "%s{period=%q} %s\n", gauge.name, filter,
+				strconv.FormatFloat(gauge.value(summaries[filter]), 'f', -1, 64))
+		}
+	}
+	b.WriteString("# EOF\n")
+
+	w.Header().Set("Content-Type", openMetricsContentType)
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+)
+
+func TestMetricsSummary(t *testing.T) {
+	env := newTestEnv(t, nil)
+	// The gauges cover the current periods, so the emails must be recent.
+	daysAgo := func(n int) string { return time.Now().AddDate(0, 0, -n).Format("2006-01-02") }
+	env.addDebit("m1", daysAgo(1), 300, "SWIGGY")
+	env.addDebit("m2", daysAgo(4), 100, "AMAZON")
+
+	rec := env.do("GET", "/metrics/summary?access_token="+testToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != openMetricsContentType {
+		t.Errorf("Content-Type = %q, want %q", got, openMetricsContentType)
+	}
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		lines[line] = true
+	}
+	for _, want := range []string{
+		"# TYPE funmon_spend gauge",
+		`funmon_spend{period="daily"} 300`,
+		`funmon_spend{period="weekly"} 400`,
+		`funmon_spend_previous{period="daily"} 0`,
+		`funmon_expense{period="weekly"} 400`,
+		`funmon_income{period="weekly"} 0`,
+		`funmon_net{period="weekly"} -400`,
+		"# EOF",
+	} {
+		if !lines[want] {
+			t.Errorf("missing line %q in:\n%s", want, rec.Body.String())
+		}
+	}
+
+	for _, gauge := range summaryGauges {
+		if got := strings.Count(rec.Body.String(), "\n"+gauge.name+"{period="); got != len(refreshFilters) {
+			t.Errorf("%s has %d samples, want one per period (%d)", gauge.name, got, len(refreshFilters))
+		}
+	}
+
+	// A second scrape is served from the cached summaries.
+	listed := env.gmail.Calls(gmailtest.List)
+	if rec := env.do("GET", "/metrics/summary?access_token="+testToken); rec.Code != http.StatusOK {
+		t.Fatalf("second scrape: status %d", rec.Code)
+	}
+	if got := env.gmail.Calls(gmailtest.List); got != listed {
+		t.Errorf("second scrape listed Gmail %d more times, want 0", got-listed)
+	}
+
+	if rec := env.do("GET", "/metrics/summary"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/gorilla/mux"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// validRequestID bounds the IDs accepted from clients or proxies, so they
+// can't inject anything odd into logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,64}$`)
+
+// requestIDMiddleware gives every request a correlation ID, reusing a sane
+// incoming X-Request-Id (e.g. from a load balancer) or generating one. It is
+// echoed as X-Request-Id, stored in the request context for logger.Ctx and
+// added to error bodies as requestId.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logger.WithRequestID(r.Context(), id)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// gzipResponseWriter buffers the start of a response so that bodies smaller
+// than minSize are sent uncompressed; once the threshold is crossed it
+// switches to streaming through a gzip writer.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize     int
+	status      int
+	buf         bytes.Buffer
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	if g.wroteHeader {
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf.Write(p)
+	if g.buf.Len() < g.minSize {
+		return len(p), nil
+	}
+
+	h := g.Header()
+	if h.Get("Content-Encoding") != "" {
+		// The handler already encoded the body; pass it through untouched.
+		g.flushHeader()
+		_, err := g.ResponseWriter.Write(g.buf.Bytes())
+		g.buf.Reset()
+		return len(p), err
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	g.flushHeader()
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf.Bytes())
+	g.buf.Reset()
+	return len(p), err
+}
+
+func (g *gzipResponseWriter) flushHeader() {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+}
+
+// Flush lets streaming handlers push data through the compressor.
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	} else if !g.wroteHeader {
+		g.flushHeader()
+		g.ResponseWriter.Write(g.buf.Bytes())
+		g.buf.Reset()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close finishes the response, writing any small buffered body uncompressed.
+func (g *gzipResponseWriter) close() error {
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	g.flushHeader()
+	if g.buf.Len() > 0 {
+		_, err := g.ResponseWriter.Write(g.buf.Bytes())
+		return err
+	}
+	return nil
+}
+
+// gzipMiddleware compresses responses for clients that accept gzip once the
+// body reaches the configured minimum size.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: cfg.GzipMinSize}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, either
+// by name or through "*". A q-value of zero (q=0, q=0.0, ...) refuses the
+// coding, and an explicit gzip entry overrides the wildcard.
+func acceptsGzip(acceptEncoding string) bool {
+	wildcard := false
+	for _, enc := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		accepted := codingQuality(params) > 0
+		if name == "gzip" {
+			return accepted
+		}
+		wildcard = accepted
+	}
+	return wildcard
+}
+
+// codingQuality returns the q-value among an Accept-Encoding entry's
+// parameters, 1 when there is none and 0 when it is malformed.
+func codingQuality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
+
+// corsAllowHeaders are the request headers browsers may send cross-origin.
+const corsAllowHeaders = "Content-Type, Authorization, " + sessionHeader + ", " + acceptVersionHeader
+
+// corsExposeHeaders are the response headers cross-origin scripts may read.
+const corsExposeHeaders = apiVersionHeader
+
+// corsMiddleware sets the CORS headers for the frontend on every routed
+// response, and answers preflights itself. It runs before authMiddleware so a
+// 401 or 403 still reaches the page as a readable error instead of an opaque
+// CORS failure.
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", os.Getenv("FRONTEND_URL"))
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", allowedMethods(r))
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedMethods lists the methods the matched route is registered for, for
+// CORS preflight responses, so what is advertised always matches the router.
+func allowedMethods(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	registered, err := route.GetMethods()
+	if err != nil {
+		return ""
+	}
+	var methods []string
+	for _, m := range registered {
+		if m != http.MethodOptions {
+			methods = append(methods, m)
+		}
+	}
+	return strings.Join(methods, ",")
+}
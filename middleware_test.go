@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"github.com/abhayyadav/funnyMoney/be/logger"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.5", true},
+		{"br, deflate", false},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0", false},
+		{"gzip;q=0.000", false},
+		{"gzip;Q=0", false},
+		{"gzip;q=0.001", true},
+		{"gzip;q=bogus", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*, gzip;q=0", false},
+		{"*;q=0, gzip", true},
+		{"identity, *;q=0.1", true},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestGzipMiddlewareThreshold(t *testing.T) {
+	newTestEnv(t, nil)
+	tests := []struct {
+		name           string
+		size           int
+		acceptEncoding string
+		gzipped        bool
+	}{
+		{"large body", 10 * cfg.GzipMinSize, "gzip", true},
+		{"at the threshold", cfg.GzipMinSize, "gzip", true},
+		{"tiny body", 20, "gzip", false},
+		{"large body, gzip refused", 10 * cfg.GzipMinSize, "gzip;q=0", false},
+		{"large body, no Accept-Encoding", 10 * cfg.GzipMinSize, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat("x", tt.size)
+			handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, body)
+			}))
+			req := httptest.NewRequest("GET", "/transactions", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			gotGzip := rec.Header().Get("Content-Encoding") == "gzip"
+			if gotGzip != tt.gzipped {
+				t.Fatalf("gzipped = %v, want %v", gotGzip, tt.gzipped)
+			}
+			var reader io.Reader = rec.Body
+			if gotGzip {
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reader = zr
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Errorf("body round-trips to %d bytes, want %d", len(got), len(body))
+			}
+		})
+	}
+}
+
+func TestPreflightAllowMethods(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/transactions", "GET"},
+		{"/refresh", "POST"},
+	}
+	env := newTestEnv(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := env.do("OPTIONS", tt.path+"?access_token="+testToken)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != tt.want {
+				t.Errorf("Allow-Methods = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreflightAllowHeaders(t *testing.T) {
+	env := newTestEnv(t, nil)
+	for _, path := range []string{"/transactions", "/refresh"} {
+		t.Run(path, func(t *testing.T) {
+			rec := env.do("OPTIONS", path+"?access_token="+testToken)
+			allowed := strings.Split(rec.Header().Get("Access-Control-Allow-Headers"), ", ")
+			for _, want := range []string{"Content-Type", "Authorization", sessionHeader} {
+				found := false
+				for _, h := range allowed {
+					found = found || h == want
+				}
+				if !found {
+					t.Errorf("Allow-Headers %q lacks %s", allowed, want)
+				}
+			}
+		})
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to log into from several goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records decodes the JSON log lines written so far.
+func (b *lockedBuffer) records(t *testing.T) []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRequestIDInLogsAndErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		incoming  string
+		wantID    string
+		gmailFail string
+		logged    string
+	}{
+		{name: "generated", logged: "Parse failure for message junk"},
+		{name: "from load balancer", incoming: "lb-1234.abc", wantID: "lb-1234.abc", logged: "Parse failure for message junk"},
+		{name: "invalid replaced", incoming: "bad id!", logged: "Parse failure for message junk"},
+		{name: "on an error response", incoming: "lb-err", wantID: "lb-err", gmailFail: gmailtest.List, logged: "Fetching transactions for filter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) { c.ParseDebug = true })
+			var logs lockedBuffer
+			logger.InitWithWriter(&logs, "debug", "json")
+			t.Cleanup(func() { logger.Init(cfg.LogLevel, cfg.LogFormat) })
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			env.gmail.Add(gmailtest.Email("junk", "alerts@hdfcbank.net", "Alert", "Your statement is ready.", time.Date(2024, 3, 14, 10, 0, 0, 0, time.UTC)))
+			if tt.gmailFail != "" {
+				env.gmail.Fail(tt.gmailFail, http.StatusInternalServerError)
+			}
+
+			var headers []string
+			if tt.incoming != "" {
+				headers = []string{"X-Request-Id", tt.incoming}
+			}
+			rec := env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token="+testToken, headers...)
+			id := rec.Header().Get("X-Request-Id")
+			if id == "" || (tt.wantID != "" && id != tt.wantID) || id == tt.incoming && tt.wantID == "" {
+				t.Fatalf("X-Request-Id = %q, want %q (incoming %q)", id, tt.wantID, tt.incoming)
+			}
+			if rec.Code != http.StatusOK {
+				var body struct {
+					RequestID string `json:"requestId"`
+				}
+				json.Unmarshal(rec.Body.Bytes(), &body)
+				if body.RequestID != id {
+					t.Errorf("error requestId = %q, want %q", body.RequestID, id)
+				}
+			}
+
+			found := false
+			for _, record := range logs.records(t) {
+				msg, _ := record["msg"].(string)
+				if !strings.HasPrefix(msg, tt.logged) {
+					continue
+				}
+				found = true
+				if record["requestId"] != id {
+					t.Errorf("log %q has requestId %v, want %q", msg, record["requestId"], id)
+				}
+			}
+			if !found {
+				t.Errorf("no log line starting %q", tt.logged)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+type accountFetch struct {
+	result *services.FetchResult
+	err    error
+}
+
+// serveMultiAccount fetches from every access token in parallel, merges and
+// dedupes the transactions, and responds with one combined view. Accounts
+// that fail are reported as warnings; the request only fails if all do.
+// Combined views aren't cached since they span several users.
+func serveMultiAccount(w http.ResponseWriter, r *http.Request, tokens []string, days int,
+	prepare func(*services.GmailService, string),
+	finalize func([]types.Transaction, []string) (types.TransactionsResponse, error),
+	write func(types.TransactionsResponse, []byte, string)) {
+
+	fetches := make([]accountFetch, len(tokens))
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		wg.Add(1)
+		go func(i int, token string) {
+			defer wg.Done()
+			gs, userID, err := gmailServiceForToken(r.Context(), token)
+			if err != nil {
+				fetches[i].err = err
+				return
+			}
+			prepare(gs, userID)
+			fetches[i].result, fetches[i].err = gs.FetchTransactions(r.Context(), days)
+		}(i, token)
+	}
+	wg.Wait()
+
+	var merged []types.Transaction
+	var warnings []string
+	var firstErr error
+	failed := 0
+	matched := &types.MatchCount{Exact: true}
+	truncated := false
+	for i, f := range fetches {
+		if f.err != nil {
+			logger.Ctx(r.Context()).Warnf("Fetch failed for account %d: %v", i+1, f.err)
+			warnings = append(warnings, fmt.Sprintf("account %d: %v", i+1, f.err))
+			if firstErr == nil {
+				firstErr = f.err
+			}
+			failed++
+			continue
+		}
+		merged = append(merged, f.result.Transactions...)
+		matched.Messages += f.result.MatchedMessages
+		matched.Exact = matched.Exact && f.result.MatchedExact
+		truncated = truncated || f.result.Truncated
+		for _, warning := range f.result.Warnings {
+			warnings = append(warnings, fmt.Sprintf("account %d: %s", i+1, warning))
+		}
+	}
+	if failed == len(tokens) {
+		respondAppError(w, firstErr)
+		return
+	}
+
+	response, err := finalize(dedupeTransactions(merged), warnings)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.Matched = matched
+	response.Truncated = truncated
+	body, err := json.Marshal(response)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+	write(response, body, computeETag(body))
+}
+
+// dedupeTransactions drops transactions that appear in more than one account
+// (e.g. an alert forwarded between mailboxes) and orders the rest newest
+// first. Two otherwise identical transactions are only the same one when
+// their timestamps are within DEDUP_WINDOW_MINUTES, so separate same-amount
+// payments hours apart are both kept; without timestamps, the same date
+// decides.
+func dedupeTransactions(transactions []types.Transaction) []types.Transaction {
+	seen := make(map[string][]types.Transaction, len(transactions))
+	var unique []types.Transaction
+	for _, txn := range transactions {
+		key := fmt.Sprintf("%.2f|%s|%s|%s", txn.Amount, txn.Merchant, txn.Type, txn.Account)
+		duplicate := false
+		for _, kept := range seen[key] {
+			if services.SameMoment(kept, txn, cfg.DedupWindow) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		seen[key] = append(seen[key], txn)
+		unique = append(unique, txn)
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		return unique[i].Date > unique[j].Date
+	})
+	return unique
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"github.com/abhayyadav/funnyMoney/be/types"
+	"golang.org/x/oauth2"
+)
+
+const secondToken = "ya29.test-access-token-0002"
+
+func TestMultiAccountMerge(t *testing.T) {
+	tests := []struct {
+		name      string
+		failList  bool
+		status    int
+		merchants []string
+		total     float64
+		warning   string
+	}{
+		{name: "both accounts", status: http.StatusOK,
+			merchants: []string{"AMAZON", "UBER", "SWIGGY"}, total: 1450},
+		{name: "second account fails", failList: true, status: http.StatusOK,
+			merchants: []string{"AMAZON", "SWIGGY"}, total: 1300, warning: "account 2: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// tokeninfo lives on one host; the scope check has its own tests.
+			env := newTestEnv(t, func(c *config.Config) { c.ScopeCheck = false })
+			second := gmailtest.Run(t, "other@example.com")
+			second.Grant(secondToken, gmailtest.ReadScope)
+			ctx = context.WithValue(context.Background(), oauth2.HTTPClient, gmailtest.Route(env.gmail, second))
+
+			env.addDebit("m1", "2024-03-12", 300, "SWIGGY")
+			env.addDebit("m2", "2024-03-14", 1000, "AMAZON")
+			// The SWIGGY alert was forwarded to the second mailbox too.
+			day := time.Date(2024, 3, 12, 10, 0, 0, 0, time.UTC)
+			second.Add(gmailtest.Email("f1", "alerts@hdfcbank.net", "Transaction alert",
+				"Rs.300.00 debited from your account at SWIGGY on 12-03-24.", day))
+			second.Add(gmailtest.Email("f2", "alerts@hdfcbank.net", "Transaction alert",
+				"Rs.150.00 debited from your account at UBER on 13-03-24.", day.AddDate(0, 0, 1)))
+			if tt.failList {
+				second.Fail(gmailtest.List, http.StatusInternalServerError)
+			}
+
+			rec := env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token="+testToken+"&access_token="+secondToken)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			resp := decodeTransactions(t, rec)
+			var got []string
+			for _, txn := range resp.Details {
+				got = append(got, txn.Merchant)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.merchants) {
+				t.Errorf("merchants = %v, want %v", got, tt.merchants)
+			}
+			if resp.Summary.Total != tt.total {
+				t.Errorf("summary total = %v, want %v", resp.Summary.Total, tt.total)
+			}
+			warned := false
+			for _, w := range resp.Warnings {
+				warned = warned || (tt.warning != "" && strings.HasPrefix(w, tt.warning))
+			}
+			if warned != (tt.warning != "") {
+				t.Errorf("warnings = %q, want one starting %q", resp.Warnings, tt.warning)
+			}
+			if env.gmail.Calls(gmailtest.List) == 0 || second.Calls(gmailtest.List) == 0 {
+				t.Errorf("lists = %d and %d, want both accounts searched", env.gmail.Calls(gmailtest.List), second.Calls(gmailtest.List))
+			}
+		})
+	}
+}
+
+func TestDedupeTransactions(t *testing.T) {
+	at := func(timestamp string) types.Transaction {
+		return types.Transaction{Amount: 300, Merchant: "SWIGGY", Type: "debit", Date: timestamp[:10], Timestamp: timestamp}
+	}
+	onDay := func(date string) types.Transaction {
+		return types.Transaction{Amount: 300, Merchant: "SWIGGY", Type: "debit", Date: date}
+	}
+	tests := []struct {
+		name   string
+		window time.Duration
+		txns   []types.Transaction
+		want   int
+	}{
+		{"true duplicate minutes apart", 10 * time.Minute,
+			[]types.Transaction{at("2024-03-12T10:00:00Z"), at("2024-03-12T10:04:00Z")}, 1},
+		{"same amount hours apart", 10 * time.Minute,
+			[]types.Transaction{at("2024-03-12T10:00:00Z"), at("2024-03-12T14:00:00Z")}, 2},
+		{"wider window merges them", 5 * time.Hour,
+			[]types.Transaction{at("2024-03-12T10:00:00Z"), at("2024-03-12T14:00:00Z")}, 1},
+		{"zone offsets compared as instants", 10 * time.Minute,
+			[]types.Transaction{at("2024-03-12T10:00:00Z"), at("2024-03-12T15:32:00+05:30")}, 1},
+		{"no timestamps, same day", 10 * time.Minute,
+			[]types.Transaction{onDay("2024-03-12"), onDay("2024-03-12")}, 1},
+		{"no timestamps, different days", 10 * time.Minute,
+			[]types.Transaction{onDay("2024-03-12"), onDay("2024-03-13")}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestEnv(t, func(c *config.Config) { c.DedupWindow = tt.window })
+			if got := dedupeTransactions(tt.txns); len(got) != tt.want {
+				t.Errorf("kept %d transactions, want %d: %+v", len(got), tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+// serveNDJSON streams a /transactions response as NDJSON: one transaction
+// per line, written and flushed as each email is parsed, then a trailer line
+// with the summary. The daily filter needs the whole fetch to know which
+// days to keep, so its lines follow the fetch instead. Nothing is cached.
+func serveNDJSON(w http.ResponseWriter, r *http.Request, gs *services.GmailService, q TransactionsQuery,
+	finalize func([]types.Transaction, []string) (types.TransactionsResponse, error)) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", ndjsonContentType)
+			setCacheControl(w, 0)
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	writeLine := func(v interface{}) {
+		start()
+		if err := enc.Encode(v); err != nil {
+			logger.Ctx(r.Context()).Debugf("Error writing NDJSON line: %v", err)
+		}
+		flusher.Flush()
+	}
+
+	streamed := q.Filter != "daily"
+	if streamed {
+		gs.SetOnTransaction(func(txn types.Transaction) {
+			matches := []types.Transaction{txn}
+			// A baseline widens the fetch; only the filter's own window is
+			// streamed, as finalize does for details.
+			if q.Baseline != config.BaselinePrevious {
+				matches = trimToWindow(matches, q.PeriodDays, q.AsOf)
+			}
+			matches = q.sinceStart(q.narrow(matches))
+			if q.Locale != "" {
+				applyAmountDisplay(matches, q.Locale)
+			}
+			for _, t := range matches {
+				writeLine(t)
+			}
+		})
+	}
+	result, err := gs.FetchTransactions(r.Context(), q.Days)
+	if err != nil {
+		if !started {
+			respondAppError(w, err)
+			return
+		}
+		logger.Ctx(r.Context()).Warnf("NDJSON stream failed after it started: %v", err)
+		return
+	}
+
+	response, err := finalize(result.Transactions, result.Warnings)
+	if err != nil {
+		if !started {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if !streamed {
+		for _, txn := range response.Details {
+			writeLine(txn)
+		}
+	}
+	writeLine(types.NDJSONTrailer{
+		Trailer:         true,
+		Summary:         response.Summary,
+		Series:          response.Series,
+		Warnings:        response.Warnings,
+		Matched:         matchCount(result),
+		WindowStart:     response.WindowStart,
+		WindowEnd:       response.WindowEnd,
+		Truncated:       result.Truncated,
+		SearchedFolders: response.SearchedFolders,
+	})
+}
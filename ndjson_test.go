@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+// readNDJSON splits an NDJSON body into its transactions and trailer,
+// failing the test unless the trailer is the last line and the only one.
+func readNDJSON(t *testing.T, rec *httptest.ResponseRecorder) ([]types.Transaction, types.NDJSONTrailer) {
+	t.Helper()
+	var txns []types.Transaction
+	var trailer types.NDJSONTrailer
+	seenTrailer := false
+	scanner := bufio.NewScanner(strings.NewReader(rec.Body.String()))
+	for scanner.Scan() {
+		if seenTrailer {
+			t.Fatalf("line after the trailer: %s", scanner.Text())
+		}
+		var probe struct {
+			Trailer bool `json:"trailer"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &probe); err != nil {
+			t.Fatalf("bad line %q: %v", scanner.Text(), err)
+		}
+		if probe.Trailer {
+			json.Unmarshal(scanner.Bytes(), &trailer)
+			seenTrailer = true
+			continue
+		}
+		var txn types.Transaction
+		json.Unmarshal(scanner.Bytes(), &txn)
+		txns = append(txns, txn)
+	}
+	if !seenTrailer {
+		t.Fatalf("no trailer in %s", rec.Body.String())
+	}
+	return txns, trailer
+}
+
+func TestTransactionsNDJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"streamed as parsed", "filter=weekly", 4},
+		{"daily buffered", "filter=daily", 3},
+		{"narrowed by type", "filter=weekly&type=credit", 1},
+		{"narrowed by minAmount", "filter=weekly&minAmount=200", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-10", 120, "SWIGGY")
+			env.addDebit("m2", "2024-03-14", 250, "AMAZON")
+			env.addCredit("m3", "2024-03-15", 1000, "ACME")
+			env.addDebit("m4", "2024-03-15", 80, "ZOMATO")
+			target := "/transactions?" + tt.query + "&endDate=2024-03-15&access_token=" + testToken
+
+			rec := env.do("GET", target, "Accept", ndjsonContentType)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Type"); got != ndjsonContentType {
+				t.Errorf("Content-Type = %q", got)
+			}
+			streamed, trailer := readNDJSON(t, rec)
+			if len(streamed) != tt.want {
+				t.Fatalf("streamed %d transactions, want %d: %s", len(streamed), tt.want, rec.Body.String())
+			}
+
+			// Reassembled, the stream matches the buffered response.
+			buffered := decodeTransactions(t, env.do("GET", target))
+			byID := func(txns []types.Transaction) string {
+				ids := make([]string, len(txns))
+				for i, txn := range txns {
+					ids[i] = fmt.Sprintf("%s:%v", txn.MessageID, txn.Amount)
+				}
+				sort.Strings(ids)
+				return strings.Join(ids, " ")
+			}
+			if byID(streamed) != byID(buffered.Details) {
+				t.Errorf("stream %s, buffered %s", byID(streamed), byID(buffered.Details))
+			}
+			if !reflect.DeepEqual(trailer.Summary, buffered.Summary) {
+				t.Errorf("trailer summary %+v, buffered %+v", trailer.Summary, buffered.Summary)
+			}
+			if trailer.WindowStart != buffered.WindowStart || trailer.WindowEnd != buffered.WindowEnd {
+				t.Errorf("trailer window [%s, %s), buffered [%s, %s)", trailer.WindowStart, trailer.WindowEnd, buffered.WindowStart, buffered.WindowEnd)
+			}
+		})
+	}
+}
+
+func TestTransactionsNDJSONErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		fail   string
+		status int
+	}{
+		{"Gmail failing before the stream starts", "filter=weekly", gmailtest.List, http.StatusInternalServerError},
+		{"sort rejected", "filter=weekly&sort=amount", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			if tt.fail != "" {
+				env.gmail.Fail(tt.fail, http.StatusInternalServerError)
+			}
+			rec := env.do("GET", "/transactions?"+tt.query+"&access_token="+testToken, "Accept", ndjsonContentType)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Type"); got == ndjsonContentType {
+				t.Errorf("error served as %s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+const defaultPageSize = 100
+
+// servePage responds with a single page of transactions and the cursor for
+// the next one. Only that page's messages are fetched and held, so the
+// summary covers the page rather than the whole window. Pages aren't cached:
+// the cursor already makes each request cheap.
+func servePage(ctx context.Context, w http.ResponseWriter, gs *services.GmailService, days int, cursor string, pageSize int64,
+	finalize func([]types.Transaction, []string) (types.TransactionsResponse, error),
+	write func(types.TransactionsResponse, []byte, string)) {
+
+	result, err := gs.FetchTransactionsPage(ctx, days, cursor, pageSize)
+	if err != nil {
+		respondAppError(w, err)
+		return
+	}
+	response, err := finalize(result.Transactions, result.Warnings)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.NextCursor = result.NextCursor
+	response.Matched = matchCount(result)
+	response.Truncated = result.Truncated
+	body, err := json.Marshal(response)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+	write(response, body, computeETag(body))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+)
+
+func TestTransactionsCursorPagination(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages int
+		pageSize int
+		pages    []string
+	}{
+		{"uneven last page", 5, 2, []string{"[m5 m4]", "[m3 m2]", "[m1]"}},
+		{"even pages", 4, 2, []string{"[m4 m3]", "[m2 m1]"}},
+		{"one page", 3, 10, []string{"[m3 m2 m1]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			for i := 1; i <= tt.messages; i++ {
+				env.addDebit(fmt.Sprintf("m%d", i), fmt.Sprintf("2024-03-%02d", 8+i), float64(100*i), "AMAZON")
+			}
+
+			cursor := ""
+			var pages []string
+			for len(pages) <= len(tt.pages) {
+				target := fmt.Sprintf("/transactions?filter=weekly&pageSize=%d&access_token=%s", tt.pageSize, testToken)
+				if cursor != "" {
+					target += "&cursor=" + url.QueryEscape(cursor)
+				}
+				resp := decodeTransactions(t, env.do("GET", target))
+				var ids []string
+				for _, txn := range resp.Details {
+					ids = append(ids, txn.MessageID)
+				}
+				pages = append(pages, fmt.Sprint(ids))
+				cursor = resp.NextCursor
+				if cursor == "" {
+					break
+				}
+			}
+			if fmt.Sprint(pages) != fmt.Sprint(tt.pages) {
+				t.Errorf("pages = %v, want %v", pages, tt.pages)
+			}
+			if got := env.gmail.Calls(gmailtest.List); got != len(tt.pages) {
+				t.Errorf("Gmail listed %d times, want one per page (%d)", got, len(tt.pages))
+			}
+		})
+	}
+}
+
+func TestTransactionsCursorValidation(t *testing.T) {
+	env := newTestEnv(t, nil)
+	tests := []struct {
+		name   string
+		params string
+	}{
+		{"garbage cursor", "cursor=not-a-cursor"},
+		{"zero page size", "pageSize=0"},
+		{"page size over the limit", fmt.Sprintf("pageSize=%d", cfg.MaxMessages+1)},
+		{"cursor with multiple accounts", "pageSize=2&access_token=ya29.second-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.do("GET", "/transactions?filter=weekly&"+tt.params+"&access_token="+testToken)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+type ParsePreviewRequest struct {
+	Body          string `json:"body"`
+	PayloadBase64 string `json:"payloadBase64"`
+}
+
+// parsePreviewHandler runs the parser over a submitted email body and returns
+// what it extracted. It never touches Gmail or the cache, so it is safe to use
+// when debugging why a bank's emails aren't being picked up.
+func parsePreviewHandler(w http.ResponseWriter, r *http.Request) {
+	var req ParsePreviewRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	body := req.Body
+	if body == "" && req.PayloadBase64 != "" {
+		data, err := base64.URLEncoding.DecodeString(req.PayloadBase64)
+		if err != nil {
+			data, err = base64.StdEncoding.DecodeString(req.PayloadBase64)
+		}
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "payloadBase64 is not valid base64")
+			return
+		}
+		body = string(data)
+	}
+	if strings.TrimSpace(body) == "" {
+		respondError(w, http.StatusBadRequest, "Missing body or payloadBase64")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services.PreviewParse(cfg, body, time.Now().In(cfg.Location)))
+}
+
+// parseEMLHandler parses a raw .eml message posted as the request body into
+// a transaction, so exported emails can be checked without Gmail access.
+// Bodies over MAX_BODY_BYTES are rejected with a 413.
+func parseEMLHandler(w http.ResponseWriter, r *http.Request) {
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cfg.MaxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", cfg.MaxBodyBytes))
+			return
+		}
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Unable to read request body: %v", err))
+		return
+	}
+	txn, err := services.ParseEML(r.Context(), cfg, bytes.NewReader(raw))
+	if err != nil {
+		if _, ok := err.(*services.AppError); ok {
+			respondAppError(w, err)
+			return
+		}
+		respondErrorCode(w, http.StatusUnprocessableEntity, "PARSE_FAILED", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(txn)
+}
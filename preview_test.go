@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+func TestParsePreview(t *testing.T) {
+	const alert = "Rs.1,499.00 debited from A/c XX1234 at FLIPKART on 05-03-24 14:22."
+	tests := []struct {
+		name     string
+		request  interface{}
+		status   int
+		amount   float64
+		merchant string
+		currency string
+		date     string
+		errText  string
+	}{
+		{name: "plain body", request: ParsePreviewRequest{Body: alert},
+			status: http.StatusOK, amount: 1499, merchant: "FLIPKART", currency: "INR", date: "2024-03-05"},
+		{name: "html body", request: ParsePreviewRequest{Body: "<div><b>USD 42.10</b> spent at <i>STEAM</i> on 01-02-24</div>"},
+			status: http.StatusOK, amount: 42.1, merchant: "STEAM", currency: "USD", date: "2024-02-01"},
+		{name: "url-safe base64 payload", request: ParsePreviewRequest{PayloadBase64: base64.URLEncoding.EncodeToString([]byte(alert))},
+			status: http.StatusOK, amount: 1499, merchant: "FLIPKART", currency: "INR", date: "2024-03-05"},
+		{name: "standard base64 payload", request: ParsePreviewRequest{PayloadBase64: base64.StdEncoding.EncodeToString([]byte(alert))},
+			status: http.StatusOK, amount: 1499, merchant: "FLIPKART", currency: "INR", date: "2024-03-05"},
+		{name: "no amount", request: ParsePreviewRequest{Body: "Your statement is ready on 05-03-24."},
+			status: http.StatusOK, errText: "no amount found"},
+		{name: "no date", request: ParsePreviewRequest{Body: "Rs.20 spent at CAFE"},
+			status: http.StatusOK, errText: "no date found"},
+		{name: "empty", request: ParsePreviewRequest{}, status: http.StatusBadRequest},
+		{name: "bad base64", request: ParsePreviewRequest{PayloadBase64: "%%%"}, status: http.StatusBadRequest},
+	}
+	env := newTestEnv(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(tt.request)
+			rec := env.doBody("POST", "/parse/preview", string(payload), "Content-Type", "application/json")
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var got services.ParseDetails
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if tt.errText != "" {
+				if !strings.Contains(got.Error, tt.errText) {
+					t.Errorf("error = %q, want it to mention %q", got.Error, tt.errText)
+				}
+				return
+			}
+			if got.Error != "" {
+				t.Fatalf("unexpected error %q", got.Error)
+			}
+			if got.Amount != tt.amount || got.Merchant != tt.merchant || got.Currency != tt.currency || got.Date != tt.date {
+				t.Errorf("got %v %q %s %s, want %v %q %s %s",
+					got.Amount, got.Merchant, got.Currency, got.Date, tt.amount, tt.merchant, tt.currency, tt.date)
+			}
+			if got.AmountPattern == "" || got.DatePattern == "" || got.MerchantPattern == "" || got.Profile == "" {
+				t.Errorf("matched patterns not reported: %+v", got)
+			}
+			if got.Confidence <= 0 {
+				t.Errorf("confidence = %v, want > 0", got.Confidence)
+			}
+		})
+	}
+	if keys := env.redis.Keys(); len(keys) != 0 {
+		t.Errorf("preview wrote to Redis: %v", keys)
+	}
+}
+
+func TestRequestBodyLimits(t *testing.T) {
+	const limit = 256
+	oversize := `{"body":"` + strings.Repeat("x", limit) + `"}`
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"json within limit", "/parse/preview", `{"body":"Rs.20 spent at CAFE on 01-03-24"}`, http.StatusOK},
+		{"json over limit", "/parse/preview", oversize, http.StatusRequestEntityTooLarge},
+		{"unknown field", "/parse/preview", `{"bdy":"Rs.20 spent at CAFE"}`, http.StatusBadRequest},
+		{"trailing data", "/parse/preview", `{"body":"Rs.20"} {"body":"Rs.30"}`, http.StatusBadRequest},
+		{"malformed json", "/parse/preview", `{"body":`, http.StatusBadRequest},
+		{"connect unknown field", "/connect", `{"token":"x"}`, http.StatusBadRequest},
+		{"eml over limit", "/parse/eml", "Subject: alert\r\n\r\n" + strings.Repeat("x", limit), http.StatusRequestEntityTooLarge},
+		{"eml within limit", "/parse/eml", "Subject: alert\r\n\r\nRs.20 spent at CAFE on 01-03-24", http.StatusOK},
+	}
+	env := newTestEnv(t, func(c *config.Config) {
+		c.MaxBodyBytes = limit
+		c.EncryptionKey = []byte(strings.Repeat("k", 32))
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.doBody("POST", tt.path+"?access_token="+testToken, tt.body, "Content-Type", "application/json")
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+// transactionFields is the set of JSON field names a client may project.
+var transactionFields = jsonFieldNames(reflect.TypeOf(types.Transaction{}))
+
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// parseFields validates a comma-separated ?fields= value against the
+// transaction's JSON field names.
+func parseFields(raw string) ([]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !transactionFields[f] {
+			return nil, fmt.Errorf("unknown field %q", f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+type projectedResponse struct {
+	Summary         types.Summary            `json:"summary"`
+	Details         []map[string]interface{} `json:"details"`
+	Series          []types.DayTotal         `json:"series"`
+	Warnings        []string                 `json:"warnings,omitempty"`
+	NextCursor      string                   `json:"nextCursor,omitempty"`
+	Matched         *types.MatchCount        `json:"matched,omitempty"`
+	WindowStart     string                   `json:"windowStart,omitempty"`
+	WindowEnd       string                   `json:"windowEnd,omitempty"`
+	Truncated       bool                     `json:"truncated,omitempty"`
+	SearchedFolders []string                 `json:"searchedFolders,omitempty"`
+}
+
+// projectResponse marshals a response keeping only the requested fields on
+// each transaction. Fields that are empty and omitted normally stay omitted.
+func projectResponse(response types.TransactionsResponse, fields []string) ([]byte, error) {
+	projected := projectedResponse{
+		Summary:         response.Summary,
+		Details:         make([]map[string]interface{}, 0, len(response.Details)),
+		Series:          response.Series,
+		Warnings:        response.Warnings,
+		NextCursor:      response.NextCursor,
+		Matched:         response.Matched,
+		WindowStart:     response.WindowStart,
+		WindowEnd:       response.WindowEnd,
+		Truncated:       response.Truncated,
+		SearchedFolders: response.SearchedFolders,
+	}
+	for _, txn := range response.Details {
+		data, err := json.Marshal(txn)
+		if err != nil {
+			return nil, err
+		}
+		var full map[string]interface{}
+		if err := json.Unmarshal(data, &full); err != nil {
+			return nil, err
+		}
+		out := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			if v, ok := full[f]; ok {
+				out[f] = v
+			}
+		}
+		projected.Details = append(projected.Details, out)
+	}
+	return json.Marshal(projected)
+}
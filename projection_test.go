@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTransactionsFieldsProjection(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+
+	tests := []struct {
+		fields string
+		status int
+		keys   []string
+	}{
+		{"date,amount,merchant", http.StatusOK, []string{"amount", "date", "merchant"}},
+		{" amount , messageId ", http.StatusOK, []string{"amount", "messageId"}},
+		{"amount,,date", http.StatusOK, []string{"amount", "date"}},
+		{"amount,bogus", http.StatusBadRequest, nil},
+		{"Amount", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fields, func(t *testing.T) {
+			rec := env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&fields="+strings.ReplaceAll(tt.fields, " ", "%20")+"&access_token="+testToken)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				if code := errorCodeOf(rec); code == "" {
+					t.Errorf("400 has no errorCode: %s", rec.Body.String())
+				}
+				return
+			}
+			var body struct {
+				Summary struct {
+					Total float64 `json:"total"`
+				} `json:"summary"`
+				Details []map[string]interface{} `json:"details"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Details) != 1 {
+				t.Fatalf("got %d details, want 1", len(body.Details))
+			}
+			var keys []string
+			for k := range body.Details[0] {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if strings.Join(keys, ",") != strings.Join(tt.keys, ",") {
+				t.Errorf("detail keys = %v, want %v", keys, tt.keys)
+			}
+			if body.Summary.Total != 250 {
+				t.Errorf("summary total = %v, want it untouched by projection", body.Summary.Total)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+// TransactionsQuery is the validated form of the /transactions query
+// parameters. Zero values mean the parameter was not given.
+type TransactionsQuery struct {
+	Filter string
+	// Days is how far back to fetch. PeriodDays is the window the response
+	// covers; Days only exceeds it when a baseline needs older data.
+	Days             int
+	PeriodDays       int
+	Type             string
+	Category         string
+	IncludeTransfers bool
+	IncludeSpamTrash bool
+	MinAmount        float64
+	HasMinAmount     bool
+	MaxAmount        float64
+	HasMaxAmount     bool
+	// Search (q) keeps transactions whose merchant or description contains
+	// it, ignoring case.
+	Search string
+	// StartDate narrows details to that day onwards; the summary and series
+	// still cover the filter's window.
+	StartDate time.Time
+	// Sort orders details: "date", "amount", or either with a leading "-"
+	// for descending. Empty keeps Gmail's order, newest first.
+	Sort        string
+	Locale      string
+	Senders     []string
+	Location    *time.Location
+	EndDate     time.Time
+	MonthToDate bool
+	// Baseline is what the summary compares against: one of the
+	// config.Baseline* values.
+	Baseline string
+	// AsOf is the day the period ends on: EndDate when given, otherwise now
+	// in Location.
+	AsOf      time.Time
+	Cursor    string
+	PageSize  int64
+	Paginated bool
+	Fields    []string
+	// Group is "day" to return details as per-day groups instead of a flat
+	// list, or empty.
+	Group string
+	// DebugRaw (debug=raw) attaches each email's masked body. It needs the
+	// admin token and bypasses the cache.
+	DebugRaw bool
+	// MarkSeen (markSeen=true) moves the user's last-seen marker to now once
+	// the response is built; IsNew flags are relative to the old marker.
+	MarkSeen bool
+
+	raw url.Values
+}
+
+// parseBool accepts an optional "true"/"false" parameter.
+func parseBool(values url.Values, name string) (bool, error) {
+	switch values.Get(name) {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	}
+	return false, fmt.Errorf("Invalid %s; expected true or false", name)
+}
+
+// maxSearchLen bounds q, which is matched against every transaction.
+const maxSearchLen = 100
+
+// sortOrders are the accepted sort values.
+var sortOrders = map[string]bool{"date": true, "-date": true, "amount": true, "-amount": true}
+
+// Parse validates every /transactions query parameter, including the rules
+// that span several of them, so handlers work with a TransactionsQuery
+// instead of raw strings. Errors are client errors and carry the message to
+// return with a 400.
+func (TransactionsQuery) Parse(r *http.Request) (TransactionsQuery, error) {
+	values := r.URL.Query()
+	q := TransactionsQuery{raw: values, Location: cfg.Location, PageSize: defaultPageSize}
+	var err error
+
+	q.Filter = values.Get("filter")
+	if q.Filter == "" {
+		q.Filter = "all"
+	}
+	days, ok := filterDays(q.Filter)
+	if !ok {
+		return q, errors.New("Invalid filter")
+	}
+	q.Days = days
+
+	q.Type = values.Get("type")
+	if q.Type != "" && q.Type != types.TransactionTypeDebit && q.Type != types.TransactionTypeCredit {
+		return q, errors.New("Invalid type; expected debit or credit")
+	}
+	q.Category = strings.ToLower(values.Get("category"))
+	if q.Category != "" && !services.IsKnownCategory(q.Category) {
+		return q, errors.New("Unknown category")
+	}
+	if q.IncludeTransfers, err = parseBool(values, "includeTransfers"); err != nil {
+		return q, err
+	}
+	q.IncludeSpamTrash = cfg.IncludeSpamTrash
+	if values.Get("includeSpamTrash") != "" {
+		if q.IncludeSpamTrash, err = parseBool(values, "includeSpamTrash"); err != nil {
+			return q, err
+		}
+	}
+	if v := values.Get("minAmount"); v != "" {
+		amount, err := strconv.ParseFloat(v, 64)
+		if err != nil || amount < 0 {
+			return q, errors.New("Invalid minAmount; expected a non-negative number")
+		}
+		q.MinAmount, q.HasMinAmount = amount, true
+	}
+	if v := values.Get("maxAmount"); v != "" {
+		amount, err := strconv.ParseFloat(v, 64)
+		if err != nil || amount < 0 {
+			return q, errors.New("Invalid maxAmount; expected a non-negative number")
+		}
+		q.MaxAmount, q.HasMaxAmount = amount, true
+	}
+	if q.HasMinAmount && q.HasMaxAmount && q.MinAmount > q.MaxAmount {
+		return q, errors.New("minAmount must not exceed maxAmount")
+	}
+	q.Search = strings.TrimSpace(values.Get("q"))
+	if len([]rune(q.Search)) > maxSearchLen {
+		return q, fmt.Errorf("Invalid q; expected at most %d characters", maxSearchLen)
+	}
+	q.Sort = values.Get("sort")
+	if q.Sort != "" && !sortOrders[q.Sort] {
+		return q, errors.New("Invalid sort; expected date, -date, amount or -amount")
+	}
+	q.Locale = values.Get("locale")
+	if q.Locale != "" && !services.IsSupportedLocale(q.Locale) {
+		return q, errors.New("Unsupported locale")
+	}
+	for _, domain := range strings.Split(strings.ToLower(values.Get("senders")), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			if strings.ContainsAny(domain, " @()") {
+				return q, errors.New("Invalid senders; expected comma-separated domains")
+			}
+			q.Senders = append(q.Senders, domain)
+		}
+	}
+
+	if tz := values.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return q, errors.New("Invalid tz; expected an IANA timezone such as Asia/Kolkata")
+		}
+		q.Location = loc
+	}
+	q.AsOf = time.Now().In(q.Location)
+	if v := values.Get("endDate"); v != "" {
+		endDate, err := time.ParseInLocation("2006-01-02", v, q.Location)
+		if err != nil {
+			return q, errors.New("Invalid endDate; expected YYYY-MM-DD")
+		}
+		q.EndDate, q.AsOf = endDate, endDate
+	}
+	if v := values.Get("startDate"); v != "" {
+		startDate, err := time.ParseInLocation("2006-01-02", v, q.Location)
+		if err != nil {
+			return q, errors.New("Invalid startDate; expected YYYY-MM-DD")
+		}
+		if startDate.After(q.AsOf) {
+			return q, errors.New("startDate must not be after endDate")
+		}
+		q.StartDate = startDate
+	}
+	if q.MonthToDate, err = parseBool(values, "monthToDate"); err != nil {
+		return q, err
+	}
+	if q.MonthToDate {
+		if q.Filter != "monthly" {
+			return q, errors.New("monthToDate is only supported with filter=monthly")
+		}
+		if mtdDays := monthToDateDays(q.AsOf); mtdDays > q.Days {
+			q.Days = mtdDays
+		}
+	}
+
+	q.Cursor = values.Get("cursor")
+	pageSize := values.Get("pageSize")
+	q.Paginated = q.Cursor != "" || pageSize != ""
+
+	// The configured baseline quietly falls back to previous where it has no
+	// meaning; an explicit baseline parameter is rejected instead.
+	q.Baseline = cfg.SummaryBaseline
+	if v := values.Get("baseline"); v != "" {
+		baseline, ok := config.ParseBaseline(v)
+		if !ok {
+			return q, errors.New("Invalid baseline; expected previous, lastYear or rolling3")
+		}
+		if baseline != config.BaselinePrevious {
+			switch {
+			case q.Filter == "all":
+				return q, errors.New("baseline is not supported with filter=all")
+			case q.MonthToDate:
+				return q, errors.New("baseline is not supported with monthToDate")
+			case q.Paginated:
+				return q, errors.New("baseline is not supported with pagination")
+			}
+		}
+		q.Baseline = baseline
+	}
+	if q.Filter == "all" || q.MonthToDate || q.Paginated {
+		q.Baseline = config.BaselinePrevious
+	}
+	q.PeriodDays = q.Days
+	if q.Baseline != config.BaselinePrevious {
+		q.Days = baselineDays(q.Filter, q.Baseline, q.AsOf, q.Days)
+	}
+	if pageSize != "" {
+		v, err := strconv.ParseInt(pageSize, 10, 64)
+		if err != nil || v <= 0 || v > int64(cfg.MaxMessages) {
+			return q, fmt.Errorf("Invalid pageSize; expected 1-%d", cfg.MaxMessages)
+		}
+		q.PageSize = v
+	}
+	if q.Paginated && len(values["access_token"]) > 1 {
+		return q, errors.New("Pagination is not supported with multiple access tokens")
+	}
+
+	if q.Fields, err = parseFields(values.Get("fields")); err != nil {
+		return q, fmt.Errorf("Invalid fields: %v", err)
+	}
+	switch q.Group = values.Get("group"); q.Group {
+	case "", "day":
+	default:
+		return q, errors.New("Invalid group; expected day")
+	}
+	if q.Group != "" && q.Fields != nil {
+		return q, errors.New("group is not supported with fields")
+	}
+	if q.Group != "" && q.Sort != "" {
+		return q, errors.New("sort is not supported with group")
+	}
+	if q.MarkSeen, err = parseBool(values, "markSeen"); err != nil {
+		return q, err
+	}
+	switch values.Get("debug") {
+	case "":
+	case "raw":
+		q.DebugRaw = true
+	default:
+		return q, errors.New("Invalid debug; expected raw")
+	}
+	return q, nil
+}
+
+// narrow applies the filters that decide which transactions the response
+// (summary included) is about: type, category, maxAmount and q.
+func (q TransactionsQuery) narrow(transactions []types.Transaction) []types.Transaction {
+	transactions = filterByCategory(filterByType(transactions, q.Type), q.Category)
+	if !q.HasMaxAmount && q.Search == "" {
+		return transactions
+	}
+	search := strings.ToLower(q.Search)
+	var filtered []types.Transaction
+	for _, txn := range transactions {
+		if q.HasMaxAmount && txn.Amount > q.MaxAmount {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(txn.Merchant), search) &&
+			!strings.Contains(strings.ToLower(txn.Description), search) {
+			continue
+		}
+		filtered = append(filtered, txn)
+	}
+	return filtered
+}
+
+// sinceStart drops details dated before StartDate.
+func (q TransactionsQuery) sinceStart(transactions []types.Transaction) []types.Transaction {
+	if q.StartDate.IsZero() {
+		return transactions
+	}
+	start := q.StartDate.Format("2006-01-02")
+	var filtered []types.Transaction
+	for _, txn := range transactions {
+		if txn.Date >= start {
+			filtered = append(filtered, txn)
+		}
+	}
+	return filtered
+}
+
+// sorted returns details in the requested order. Ties keep their existing
+// (newest first) order.
+func (q TransactionsQuery) sorted(transactions []types.Transaction) []types.Transaction {
+	if q.Sort == "" {
+		return transactions
+	}
+	out := append([]types.Transaction(nil), transactions...)
+	desc := strings.HasPrefix(q.Sort, "-")
+	less := func(a, b types.Transaction) bool { return a.Date+a.Timestamp < b.Date+b.Timestamp }
+	if strings.TrimPrefix(q.Sort, "-") == "amount" {
+		less = func(a, b types.Transaction) bool { return a.Amount < b.Amount }
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if desc {
+			return less(out[j], out[i])
+		}
+		return less(out[i], out[j])
+	})
+	return out
+}
+
+// maxAmountVariant keys responses by the parsed maxAmount, like
+// minAmountVariant.
+func (q TransactionsQuery) maxAmountVariant() string {
+	if !q.HasMaxAmount {
+		return ""
+	}
+	return strconv.FormatFloat(q.MaxAmount, 'f', -1, 64)
+}
+
+// spamTrashVariant keys responses by includeSpamTrash only when it differs
+// from the deployment default, so the plain key matches what /refresh caches.
+func (q TransactionsQuery) spamTrashVariant() string {
+	if q.IncludeSpamTrash == cfg.IncludeSpamTrash {
+		return ""
+	}
+	return strconv.FormatBool(q.IncludeSpamTrash)
+}
+
+// minAmountVariant keys responses by the parsed minAmount, so "1", "1.0"
+// and "01" share one cache entry.
+func (q TransactionsQuery) minAmountVariant() string {
+	if !q.HasMinAmount {
+		return ""
+	}
+	return strconv.FormatFloat(q.MinAmount, 'f', -1, 64)
+}
+
+// baselineVariant keys responses by baseline unless it is previous, which is
+// what /refresh caches under the plain key.
+func (q TransactionsQuery) baselineVariant() string {
+	if q.Baseline == config.BaselinePrevious {
+		return ""
+	}
+	return q.Baseline
+}
+
+// cacheKey is the cache key for this query's full (unprojected) response.
+// Most variants use the raw parameter values so keys stay stable across
+// releases.
+func (q TransactionsQuery) cacheKey(userID string) string {
+	return getCacheKey(userID, q.Filter, cacheVariant("type", q.Type), cacheVariant("category", q.Category),
+		cacheVariant("locale", q.Locale), cacheVariant("includeTransfers", q.raw.Get("includeTransfers")),
+		cacheVariant("minAmount", q.minAmountVariant()), cacheVariant("endDate", q.raw.Get("endDate")), cacheVariant("tz", q.raw.Get("tz")), cacheVariant("monthToDate", q.raw.Get("monthToDate")),
+		cacheVariant("senders", strings.Join(q.Senders, ",")), cacheVariant("spamTrash", q.spamTrashVariant()),
+		cacheVariant("baseline", q.baselineVariant()), cacheVariant("maxAmount", q.maxAmountVariant()),
+		cacheVariant("q", strings.ToLower(q.Search)), cacheVariant("startDate", q.raw.Get("startDate")), cacheVariant("sort", q.Sort))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+)
+
+func TestMinAmountCacheKey(t *testing.T) {
+	newTestEnv(t, nil)
+	key := func(query string) string {
+		t.Helper()
+		q, err := TransactionsQuery{}.Parse(httptest.NewRequest("GET", "/transactions?filter=weekly"+query, nil))
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return q.cacheKey(testEmail)
+	}
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"&minAmount=1", "&minAmount=1.0", true},
+		{"&minAmount=1", "&minAmount=01", true},
+		{"&minAmount=0.50", "&minAmount=.5", true},
+		{"&minAmount=1", "&minAmount=2", false},
+		{"&minAmount=0", "", false},
+	}
+	for _, tt := range tests {
+		if got := key(tt.a) == key(tt.b); got != tt.same {
+			t.Errorf("keys for %q and %q shared = %v, want %v (%s, %s)", tt.a, tt.b, got, tt.same, key(tt.a), key(tt.b))
+		}
+	}
+}
+
+func TestTransactionsQueryParse(t *testing.T) {
+	newTestEnv(t, nil)
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{"filter=weekly", ""},
+		{"", ""},
+		{"filter=yearly", "Invalid filter"},
+		{"type=debit&category=food", ""},
+		{"type=transfer", "Invalid type"},
+		{"minAmount=10&maxAmount=100", ""},
+		{"minAmount=10&maxAmount=10", ""},
+		{"minAmount=100&maxAmount=10", "minAmount must not exceed maxAmount"},
+		{"maxAmount=-1", "Invalid maxAmount"},
+		{"maxAmount=lots", "Invalid maxAmount"},
+		{"minAmount=-5", "Invalid minAmount"},
+		{"q=amazon", ""},
+		{"q=" + strings.Repeat("x", 100), ""},
+		{"q=" + strings.Repeat("x", 101), "Invalid q"},
+		{"sort=amount", ""},
+		{"sort=-date", ""},
+		{"sort=merchant", "Invalid sort"},
+		{"sort=amount&group=day", "sort is not supported with group"},
+		{"startDate=2024-03-10&endDate=2024-03-15", ""},
+		{"startDate=2024-03-15&endDate=2024-03-15", ""},
+		{"startDate=2024-03-16&endDate=2024-03-15", "startDate must not be after endDate"},
+		{"startDate=2099-01-01", "startDate must not be after endDate"},
+		{"startDate=10-03-2024", "Invalid startDate"},
+		{"endDate=2024-13-01", "Invalid endDate"},
+		{"tz=Mars/Olympus", "Invalid tz"},
+		{"filter=weekly&monthToDate=true", "monthToDate is only supported with filter=monthly"},
+		{"filter=monthly&monthToDate=true", ""},
+		{"pageSize=0", "Invalid pageSize"},
+		{"pageSize=10&cursor=abc", ""},
+		{"pageSize=10&access_token=a&access_token=b", "Pagination is not supported with multiple access tokens"},
+		{"baseline=lastYear&filter=all", "baseline is not supported with filter=all"},
+		{"baseline=lastYear&filter=weekly&pageSize=5", "baseline is not supported with pagination"},
+		{"fields=date,amount&group=day", "group is not supported with fields"},
+		{"includeTransfers=yes", "Invalid includeTransfers"},
+		{"debug=verbose", "Invalid debug"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := TransactionsQuery{}.Parse(httptest.NewRequest("GET", "/transactions?"+tt.query, nil))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)):
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransactionsNarrowAndSort(t *testing.T) {
+	tests := []struct {
+		query     string
+		merchants string
+		expense   float64
+	}{
+		{"", "SWIGGY UBER AMAZON CAFE", 1380},
+		{"&maxAmount=200", "SWIGGY UBER CAFE", 380},
+		{"&minAmount=100&maxAmount=1000", "SWIGGY UBER AMAZON", 1350},
+		{"&q=amaz", "AMAZON", 1000},
+		{"&q=Swiggy", "SWIGGY", 150},
+		{"&sort=amount", "CAFE SWIGGY UBER AMAZON", 1380},
+		{"&sort=-amount", "AMAZON UBER SWIGGY CAFE", 1380},
+		{"&sort=date", "CAFE AMAZON UBER SWIGGY", 1380},
+		{"&sort=-date", "SWIGGY UBER AMAZON CAFE", 1380},
+		// startDate narrows details but not the summary.
+		{"&startDate=2024-03-13", "SWIGGY UBER AMAZON", 1380},
+		{"&startDate=2024-03-13&sort=amount&maxAmount=500", "SWIGGY UBER", 380},
+	}
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-11", 30, "CAFE")
+	env.addDebit("m2", "2024-03-13", 1000, "AMAZON")
+	env.addDebit("m3", "2024-03-14", 200, "UBER")
+	env.addDebit("m4", "2024-03-15", 150, "SWIGGY")
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			resp := decodeTransactions(t, env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15"+tt.query+"&access_token="+testToken))
+			var merchants []string
+			for _, txn := range resp.Details {
+				merchants = append(merchants, txn.Merchant)
+			}
+			if got := strings.Join(merchants, " "); got != tt.merchants {
+				t.Errorf("details %q, want %q", got, tt.merchants)
+			}
+			if resp.Summary.Expense != tt.expense {
+				t.Errorf("expense %v, want %v", resp.Summary.Expense, tt.expense)
+			}
+		})
+	}
+}
+
+func TestIncludeSpamTrash(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		query    string
+		anywhere bool
+		folders  []string
+	}{
+		{"off by default", false, "", false, []string{"all mail"}},
+		{"enabled by config", true, "", true, []string{"all mail", "spam", "trash"}},
+		{"enabled by request", false, "&includeSpamTrash=true", true, []string{"all mail", "spam", "trash"}},
+		{"disabled by request", true, "&includeSpamTrash=false", false, []string{"all mail"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) { c.IncludeSpamTrash = tt.enabled })
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			resp := decodeTransactions(t, env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token="+testToken+tt.query))
+			if !reflect.DeepEqual(resp.SearchedFolders, tt.folders) {
+				t.Errorf("searchedFolders = %v, want %v", resp.SearchedFolders, tt.folders)
+			}
+			queries := env.gmail.Queries()
+			if len(queries) != 1 {
+				t.Fatalf("Gmail searched %d times, want 1", len(queries))
+			}
+			if got := strings.Contains(queries[0], "in:anywhere"); got != tt.anywhere {
+				t.Errorf("query %q has in:anywhere = %v, want %v", queries[0], got, tt.anywhere)
+			}
+		})
+	}
+
+	env := newTestEnv(t, nil)
+	if rec := env.do("GET", "/transactions?filter=weekly&includeSpamTrash=maybe&access_token="+testToken); rec.Code != http.StatusBadRequest {
+		t.Errorf("includeSpamTrash=maybe: status %d, want 400", rec.Code)
+	}
+}
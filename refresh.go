@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+type refreshPeriod struct {
+	Filter string
+	Days   int
+}
+
+// refreshFilters are the views that /refresh pre-populates, in order.
+var refreshFilters = []string{"daily", "weekly", "monthly"}
+
+// refreshPeriods pairs each refreshed view with its configured window.
+func refreshPeriods() []refreshPeriod {
+	periods := make([]refreshPeriod, 0, len(refreshFilters))
+	for _, filter := range refreshFilters {
+		days, _ := filterDays(filter)
+		periods = append(periods, refreshPeriod{Filter: filter, Days: days})
+	}
+	return periods
+}
+
+const refreshCacheTTL = 20 * time.Minute
+
+// runRefreshPeriod fetches, summarizes and caches one period for a user.
+// When Gmail is over quota or unavailable it falls back to the period's
+// stale copy, returning the warning that says so.
+func runRefreshPeriod(ctx context.Context, gs *services.GmailService, userID string, period refreshPeriod) (*types.TransactionsResponse, string, error) {
+	fetchedAt := time.Now()
+	result, err := gs.FetchTransactions(ctx, period.Days)
+	if err != nil {
+		if stale, warning, ok := staleResponse(ctx, getCacheKey(userID, period.Filter), err); ok {
+			return stale, warning, nil
+		}
+		return nil, "", err
+	}
+	response, err := cachePeriod(ctx, userID, period, result.Transactions, result.Warnings, matchCount(result), result.Truncated, fetchedAt)
+	return response, "", err
+}
+
+// cachePeriod summarizes one period's transactions and caches the response
+// as the period's plain view. A truncated response is returned but not
+// cached, so the partial view doesn't stand in for the full one.
+func cachePeriod(ctx context.Context, userID string, period refreshPeriod, transactions []types.Transaction,
+	warnings []string, matched *types.MatchCount, truncated bool, fetchedAt time.Time) (*types.TransactionsResponse, error) {
+	now := time.Now().In(cfg.Location)
+	if period.Filter == "daily" {
+		transactions = trimToLatestDays(transactions, 2, now)
+	}
+
+	summary, err := calculateSummary(excludeTransfers(transactions), period.Filter)
+	if err != nil {
+		return nil, err
+	}
+	response := &types.TransactionsResponse{
+		Summary:   summary,
+		Details:   transactions,
+		Series:    buildSeries(excludeTransfers(transactions), now, period.Days),
+		Warnings:  warnings,
+		Matched:   matched,
+		Truncated: truncated,
+	}
+	setWindow(response, period.Days, now)
+	response.SearchedFolders = services.SearchedFolders(cfg.IncludeSpamTrash)
+	if truncated {
+		return response, nil
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling %s response: %v", period.Filter, err)
+	}
+	cacheResponse(ctx, getCacheKey(userID, period.Filter), data, refreshCacheTTL, fetchedAt)
+	return response, nil
+}
+
+// refreshUser re-populates every refreshed view for a user, stopping at the
+// first period that fails. It returns a warning per period that kept its
+// stale copy instead.
+func refreshUser(ctx context.Context, gs *services.GmailService, userID string) ([]string, error) {
+	applyUserRules(ctx, gs, userID)
+	applyRawEmailStore(ctx, gs, userID)
+	var warnings []string
+	for _, period := range refreshPeriods() {
+		_, warning, err := runRefreshPeriod(ctx, gs, userID, period)
+		if err != nil {
+			logger.Ctx(ctx).Debugf("Refresh of %s failed for %s: %v", period.Filter, userID, err)
+			return warnings, err
+		}
+		if warning != "" {
+			warnings = append(warnings, period.Filter+": "+warning)
+		}
+	}
+	return warnings, nil
+}
+
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	gmailService, userID, ok := gmailServiceFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLen {
+		respondError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+		return
+	}
+	claimedKey := false
+	if idempotencyKey != "" {
+		claimed, previous, err := beginIdempotent(r.Context(), userID, idempotencyKey)
+		if err != nil {
+			// Without Redis we can't dedupe; fall through and refresh anyway.
+			logger.Ctx(r.Context()).Warnf("Error checking idempotency key: %v", err)
+		} else if !claimed {
+			if previous == nil {
+				respondError(w, http.StatusConflict, "A refresh with this Idempotency-Key is already in progress")
+				return
+			}
+			writeRefreshResult(w, *previous)
+			return
+		}
+		claimedKey = claimed
+	}
+
+	// Only raw access tokens are scheduled; a session's token stays in the
+	// session.
+	if accessToken := r.URL.Query().Get("access_token"); accessToken != "" && r.Header.Get(sessionHeader) == "" {
+		registerScheduledUser(r.Context(), userID, accessToken)
+	}
+
+	stopHold := func() {}
+	if claimedKey {
+		stopHold = holdIdempotent(r.Context(), userID, idempotencyKey)
+	}
+	result := idempotentResult{Status: http.StatusOK}
+	warnings, err := refreshUser(r.Context(), gmailService, userID)
+	if err != nil {
+		result = idempotentResultFromError(err)
+	} else {
+		result.Warnings = warnings
+	}
+	stopHold()
+
+	if idempotencyKey != "" {
+		finishIdempotent(r.Context(), userID, idempotencyKey, result)
+	}
+	writeRefreshResult(w, result)
+}
+
+// refreshStreamHandler performs the same work as refreshHandler but reports
+// progress as server-sent events: a "progress" event per completed period,
+// then "done", or "error" if a period fails.
+func refreshStreamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+
+	gmailService, userID, ok := gmailServiceFromRequest(w, r)
+	if !ok {
+		return
+	}
+	applyUserRules(r.Context(), gmailService, userID)
+	applyRawEmailStore(r.Context(), gmailService, userID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for i, period := range refreshPeriods() {
+		response, warning, err := runRefreshPeriod(r.Context(), gmailService, userID, period)
+		if err != nil {
+			result := idempotentResultFromError(err)
+			body := errorBody(result.Status, result.ErrorCode, result.Error)
+			body["period"] = period.Filter
+			writeSSE(w, "error", body)
+			flusher.Flush()
+			return
+		}
+		progress := map[string]interface{}{
+			"period":    period.Filter,
+			"completed": i + 1,
+			"total":     len(refreshFilters),
+			"count":     len(response.Details),
+		}
+		if warning != "" {
+			progress["warning"] = warning
+		}
+		writeSSE(w, "progress", progress)
+		flusher.Flush()
+	}
+
+	writeSSE(w, "done", map[string]interface{}{"success": true})
+	flusher.Flush()
+}
+
+func writeSSE(w http.ResponseWriter, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		payload = []byte(`{}`)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
+
+// warmupCache runs the /refresh logic once for a configured demo token so the
+// first request after boot is served from cache. Failures are logged and
+// otherwise ignored; startup never depends on the demo account.
+func warmupCache(warmCtx context.Context, accessToken string) {
+	gs, userID, err := gmailServiceForToken(warmCtx, accessToken)
+	if err != nil {
+		logger.Warnf("Cache warmup skipped: %v", err)
+		return
+	}
+	warnings, err := refreshUser(warmCtx, gs, userID)
+	if err != nil {
+		logger.Warnf("Cache warmup failed: %v", err)
+		return
+	}
+	for _, warning := range warnings {
+		logger.Warnf("Cache warmup: %s", warning)
+	}
+	logger.Infof("Cache warmup complete for %s", userID)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+)
+
+type sseEvent struct {
+	name string
+	data map[string]interface{}
+}
+
+// readSSE splits a text/event-stream body into its events.
+func readSSE(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &current.data); err != nil {
+				t.Fatalf("event %s: bad data %q", current.name, line)
+			}
+		case line == "" && current.name != "":
+			events = append(events, current)
+			current = sseEvent{}
+		}
+	}
+	return events
+}
+
+func TestRefreshStreamEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		failList int
+		want     []string
+	}{
+		{"all periods", 0, []string{"progress:daily", "progress:weekly", "progress:monthly", "done:"}},
+		{"Gmail failing", http.StatusInternalServerError, []string{"error:daily"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-14", 100, "AMAZON")
+			env.gmail.Fail(gmailtest.List, tt.failList)
+
+			rec := env.do("GET", "/refresh/stream?access_token="+testToken)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			events := readSSE(t, rec.Body.String())
+			var got []string
+			for _, e := range events {
+				period, _ := e.data["period"].(string)
+				got = append(got, e.name+":"+period)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+			for i, e := range events {
+				if e.name != "progress" {
+					continue
+				}
+				if e.data["completed"] != float64(i+1) || e.data["total"] != float64(3) {
+					t.Errorf("progress event %d = %v", i, e.data)
+				}
+			}
+			last := events[len(events)-1]
+			if code, _ := last.data["errorCode"].(string); last.name == "error" && code == "" {
+				t.Errorf("error event lacks an errorCode: %v", last.data)
+			}
+		})
+	}
+}
+
+func TestWarmupCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		gmailFail string
+		cached    bool
+	}{
+		{name: "valid token", token: testToken, cached: true},
+		{name: "unknown token", token: "ya29.not-granted-token"},
+		{name: "malformed token", token: "not a token"},
+		{name: "Gmail down", token: testToken, gmailFail: gmailtest.List},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			if tt.gmailFail != "" {
+				env.gmail.Fail(tt.gmailFail, http.StatusInternalServerError)
+			}
+
+			warmupCache(ctx, tt.token)
+
+			for _, filter := range refreshFilters {
+				if _, ok := env.redis.Get(getCacheKey(testEmail, filter)); ok != tt.cached {
+					t.Errorf("%s cached = %v, want %v", filter, ok, tt.cached)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncatedResponsesNotCached(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		budget time.Duration
+		cached bool
+	}{
+		{"transactions within budget", "GET", "/transactions?filter=weekly", 5 * time.Second, true},
+		{"transactions over budget", "GET", "/transactions?filter=weekly", 20 * time.Millisecond, false},
+		{"refresh within budget", "POST", "/refresh", 5 * time.Second, true},
+		{"refresh over budget", "POST", "/refresh", 20 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) { c.FetchBudget = tt.budget })
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			env.gmail.SetDelay(gmailtest.Batch, 100*time.Millisecond)
+
+			sep := "?"
+			if strings.Contains(tt.target, "?") {
+				sep = "&"
+			}
+			rec := env.do(tt.method, tt.target+sep+"access_token="+testToken)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if tt.method == "GET" {
+				resp := decodeTransactions(t, rec)
+				if resp.Truncated == tt.cached {
+					t.Errorf("truncated = %v, want %v", resp.Truncated, !tt.cached)
+				}
+			}
+			if _, ok := env.redis.Get(getCacheKey(testEmail, "weekly")); ok != tt.cached {
+				t.Errorf("weekly cached = %v, want %v", ok, tt.cached)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+	"google.golang.org/api/gmail/v1"
+)
+
+// getRawEmailsKey holds a user's stored email copies, a hash of message ID
+// to the (encrypted) compact message. It lives outside the response cache
+// prefix so cache invalidation leaves it alone.
+func getRawEmailsKey(userID string) string {
+	return cfg.CachePrefix + ":rawemails:" + userID
+}
+
+// applyRawEmailStore has gs save a compact copy of every email it parses
+// for the user, when STORE_RAW_EMAILS is on, so /reparse can parse them again.
+func applyRawEmailStore(ctx context.Context, gs *services.GmailService, userID string) {
+	if !cfg.StoreRawEmails {
+		return
+	}
+	gs.SetOnMessage(func(msg *gmail.Message) {
+		storeRawEmail(ctx, userID, msg)
+	})
+}
+
+// storeRawEmail saves one compact message. With CACHE_ENCRYPTION it is
+// stored encrypted; if it can't be, it isn't stored.
+func storeRawEmail(parent context.Context, userID string, msg *gmail.Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if cfg.CacheEncryption {
+		if data, err = encrypt(data); err != nil {
+			logger.Ctx(parent).Errorf("Not storing email %s: %v", msg.Id, err)
+			return
+		}
+	}
+	opCtx, cancel := redisContext(parent)
+	defer cancel()
+	key := getRawEmailsKey(userID)
+	pipe := redisClient.TxPipeline()
+	pipe.HSet(opCtx, key, msg.Id, data)
+	pipe.Expire(opCtx, key, cfg.RawEmailTTL)
+	if _, err := pipe.Exec(opCtx); err != nil {
+		logger.Ctx(parent).Warnf("Error storing email %s: %v", msg.Id, err)
+	}
+}
+
+// loadRawEmails returns the user's stored emails, newest first as Gmail
+// lists them. Entries that don't decrypt or decode are skipped.
+func loadRawEmails(parent context.Context, userID string) ([]*gmail.Message, error) {
+	opCtx, cancel := redisContext(parent)
+	defer cancel()
+	stored, err := redisClient.HGetAll(opCtx, getRawEmailsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*gmail.Message, 0, len(stored))
+	for id, raw := range stored {
+		data := []byte(raw)
+		if cfg.CacheEncryption {
+			if data, err = decrypt(data); err != nil {
+				logger.Ctx(parent).Warnf("Error decrypting stored email %s: %v", id, err)
+				continue
+			}
+		}
+		var msg gmail.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		messages = append(messages, &msg)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].InternalDate > messages[j].InternalDate })
+	return messages, nil
+}
+
+// reparseHandler serves POST /reparse: it runs the current parser patterns
+// and category rules over the user's stored emails, without calling Gmail,
+// drops their cached views and re-caches the daily, weekly and monthly ones.
+func reparseHandler(w http.ResponseWriter, r *http.Request) {
+	if !cfg.StoreRawEmails {
+		respondError(w, http.StatusServiceUnavailable, "Re-parsing is disabled: STORE_RAW_EMAILS is off")
+		return
+	}
+	gmailService, userID, ok := gmailServiceFromRequest(w, r)
+	if !ok {
+		return
+	}
+	applyUserRules(r.Context(), gmailService, userID)
+
+	messages, err := loadRawEmails(r.Context(), userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load stored emails")
+		return
+	}
+	if len(messages) == 0 {
+		respondError(w, http.StatusNotFound, "No stored emails to re-parse; fetch transactions first")
+		return
+	}
+	transactions, warnings := gmailService.ParseStoredMessages(r.Context(), messages)
+
+	invalidateUserCache(r, userID)
+	now := time.Now().In(cfg.Location)
+	for _, period := range refreshPeriods() {
+		start, _ := services.QueryBounds(period.Days, now)
+		var inWindow []types.Transaction
+		for _, txn := range transactions {
+			if txn.Date >= start.Format("2006-01-02") {
+				inWindow = append(inWindow, txn)
+			}
+		}
+		if _, err := cachePeriod(r.Context(), userID, period, inWindow, warnings, nil, false, now); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types.ReparseResponse{
+		Messages:     len(messages),
+		Transactions: len(transactions),
+		Warnings:     warnings,
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"github.com/abhayyadav/funnyMoney/be/types"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestReparseAppliesUpdatedRules(t *testing.T) {
+	const adminToken = "admin-secret"
+	tests := []struct {
+		name               string
+		method, path, body string
+		headers            []string
+		merchant, category string
+	}{
+		{name: "category rule", method: "POST", path: "/categories/rules?access_token=" + testToken,
+			body: `{"match":"swiggy","category":"groceries"}`, headers: []string{"Content-Type", "application/json"},
+			merchant: "SWIGGY", category: "groceries"},
+		{name: "merchant pattern", method: "PUT", path: "/admin/patterns",
+			body: `{"merchant":"(?i)\\bfrom your (account)\\b"}`, headers: []string{"Authorization", "Bearer " + adminToken},
+			merchant: "account", category: "uncategorized"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) {
+				c.StoreRawEmails = true
+				c.AdminToken = adminToken
+			})
+			// Re-parsed views are cached for the current windows, so the
+			// email has to be recent.
+			env.addDebit("m1", time.Now().AddDate(0, 0, -1).Format("2006-01-02"), 300, "SWIGGY")
+			target := "/transactions?filter=weekly&access_token=" + testToken
+
+			if got := decodeTransactions(t, env.do("GET", target)).Details; len(got) != 1 || got[0].Category != "food" {
+				t.Fatalf("before: got %+v, want one food transaction", got)
+			}
+			if rec := env.doBody(tt.method, tt.path, tt.body, tt.headers...); rec.Code >= 300 {
+				t.Fatalf("updating the rule: status %d: %s", rec.Code, rec.Body.String())
+			}
+			listed := env.gmail.Calls(gmailtest.List)
+
+			rec := env.do("POST", "/reparse?access_token="+testToken)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("reparse: status %d: %s", rec.Code, rec.Body.String())
+			}
+			var result types.ReparseResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Messages != 1 || result.Transactions != 1 {
+				t.Errorf("reparse = %+v, want 1 message and 1 transaction", result)
+			}
+
+			got := decodeTransactions(t, env.do("GET", target)).Details
+			if len(got) != 1 || got[0].Merchant != tt.merchant || got[0].Category != tt.category {
+				t.Errorf("after: got %+v, want %s in %s", got, tt.merchant, tt.category)
+			}
+			if calls := env.gmail.Calls(gmailtest.List); calls != listed {
+				t.Errorf("Gmail listed %d more times, want the re-parsed cache to serve", calls-listed)
+			}
+		})
+	}
+}
+
+func TestReparseStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		inline bool
+	}{
+		{"downloaded by attachment ID", false},
+		{"sent inline", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) {
+				c.StoreRawEmails = true
+				c.PDFStatements = true
+			})
+			day := time.Now().AddDate(0, 0, -1)
+			stream := fmt.Sprintf("BT (%s SWIGGY 300.00) Tj 0 -14 Td (%s AMAZON RETAIL 1,250.00) Tj ET",
+				day.Format("02/01/2006"), day.Format("02/01/2006"))
+			pdf := []byte(fmt.Sprintf("%%PDF-1.4\n1 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(stream), stream))
+			msg := gmailtest.Email("s1", "statements@hdfcbank.net", "Your statement", "", day)
+			msg.Payload.MimeType = "multipart/mixed"
+			msg.Payload.Body = nil
+			body := &gmail.MessagePartBody{AttachmentId: "s1-pdf", Size: int64(len(pdf))}
+			if tt.inline {
+				body = &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString(pdf), Size: int64(len(pdf))}
+			}
+			msg.Payload.Parts = []*gmail.MessagePart{
+				{MimeType: "text/plain", Body: &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte("Your statement is attached."))}},
+				{MimeType: "application/pdf", Filename: "statement.pdf", Body: body},
+			}
+			env.gmail.Add(msg)
+			env.gmail.AddAttachment("s1-pdf", pdf)
+			target := "/transactions?filter=weekly&access_token=" + testToken
+
+			if got := decodeTransactions(t, env.do("GET", target)).Details; len(got) != 2 {
+				t.Fatalf("before: got %+v, want the statement's two transactions", got)
+			}
+			downloads := env.gmail.Calls(gmailtest.Attachment)
+
+			rec := env.do("POST", "/reparse?access_token="+testToken)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("reparse: status %d: %s", rec.Code, rec.Body.String())
+			}
+			var result types.ReparseResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Messages != 1 || result.Transactions != 2 {
+				t.Errorf("reparse = %+v, want 1 message and 2 transactions", result)
+			}
+			if got := decodeTransactions(t, env.do("GET", target)).Details; len(got) != 2 {
+				t.Errorf("after: got %+v, want the statement's two transactions", got)
+			}
+			if calls := env.gmail.Calls(gmailtest.Attachment); calls != downloads {
+				t.Errorf("Gmail served %d more attachments, want the stored PDF used", calls-downloads)
+			}
+		})
+	}
+}
+
+func TestReparseUnavailable(t *testing.T) {
+	tests := []struct {
+		name   string
+		store  bool
+		status int
+	}{
+		{"storage off", false, http.StatusServiceUnavailable},
+		{"nothing stored yet", true, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) { c.StoreRawEmails = tt.store })
+			if rec := env.do("POST", "/reparse?access_token="+testToken); rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+)
+
+func TestRouteAuth(t *testing.T) {
+	const adminToken = "admin-secret"
+	const frontend = "https://app.example.com"
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		headers []string
+		status  int
+	}{
+		{name: "health is open", method: "GET", target: "/health", status: http.StatusOK},
+		{name: "transactions without a token", method: "GET", target: "/transactions", status: http.StatusUnauthorized},
+		{name: "transactions with a token", method: "GET", target: "/transactions?access_token=" + testToken, status: http.StatusOK},
+		{name: "transactions preflight", method: "OPTIONS", target: "/transactions", status: http.StatusOK},
+		{name: "admin without a token", method: "GET", target: "/admin/quota", status: http.StatusUnauthorized},
+		{name: "admin with a user token", method: "GET", target: "/admin/quota?access_token=" + testToken,
+			headers: []string{"Authorization", "Bearer " + testToken}, status: http.StatusUnauthorized},
+		{name: "admin with the admin token", method: "GET", target: "/admin/quota",
+			headers: []string{"Authorization", "Bearer " + adminToken}, status: http.StatusOK},
+	}
+	t.Setenv("FRONTEND_URL", frontend)
+	env := newTestEnv(t, func(c *config.Config) { c.AdminToken = adminToken })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.do(tt.method, tt.target, tt.headers...)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			// Rejections must carry CORS headers too, or the browser hides
+			// the 401 from the page.
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != frontend {
+				t.Errorf("Allow-Origin = %q, want %q", got, frontend)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	scheduledUsersKey = "scheduler:users"
+	// scheduledTokenTTL matches the lifetime of a Google access token; a user
+	// drops out of the schedule once their last token would have expired.
+	scheduledTokenTTL = time.Hour
+)
+
+func scheduledTokenKey(userID string) string {
+	return "scheduler:token:" + userID
+}
+
+// registerScheduledUser records a user's access token so the background
+// scheduler keeps their caches warm. It is a no-op when the scheduler is off.
+// With CACHE_ENCRYPTION the token is stored encrypted; if it can't be, the
+// user isn't scheduled rather than storing the token in plaintext.
+func registerScheduledUser(parent context.Context, userID, accessToken string) {
+	if cfg.SchedulerInterval <= 0 {
+		return
+	}
+	stored := []byte(accessToken)
+	if cfg.CacheEncryption {
+		sealed, err := encrypt(stored)
+		if err != nil {
+			logger.Ctx(parent).Errorf("Not scheduling %s: %v", userID, err)
+			return
+		}
+		stored = sealed
+	}
+	opCtx, cancel := redisContext(parent)
+	defer cancel()
+	pipe := redisClient.TxPipeline()
+	pipe.Set(opCtx, scheduledTokenKey(userID), stored, scheduledTokenTTL)
+	pipe.SAdd(opCtx, scheduledUsersKey, userID)
+	if _, err := pipe.Exec(opCtx); err != nil {
+		logger.Ctx(parent).Warnf("Error registering %s for scheduled refresh: %v", userID, err)
+	}
+}
+
+func unregisterScheduledUser(parent context.Context, userID string) {
+	opCtx, cancel := redisContext(parent)
+	defer cancel()
+	pipe := redisClient.TxPipeline()
+	pipe.Del(opCtx, scheduledTokenKey(userID))
+	pipe.SRem(opCtx, scheduledUsersKey, userID)
+	if _, err := pipe.Exec(opCtx); err != nil {
+		logger.Ctx(parent).Warnf("Error unregistering %s from scheduled refresh: %v", userID, err)
+	}
+}
+
+// runScheduler re-runs the refresh logic for every registered user each
+// interval until stopCtx is cancelled. Each tick runs as a background task,
+// so a tick in progress at shutdown is drained rather than cut off.
+func runScheduler(stopCtx context.Context) {
+	logger.Infof("Scheduled refresh every %s with concurrency %d", cfg.SchedulerInterval, cfg.SchedulerConcurrency)
+	ticker := time.NewTicker(cfg.SchedulerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCtx.Done():
+			return
+		case <-ticker.C:
+			done := make(chan struct{})
+			goBackground(func(tickCtx context.Context) {
+				defer close(done)
+				schedulerTick(tickCtx)
+			})
+			<-done
+		}
+	}
+}
+
+// schedulerTick refreshes each registered user once, at most
+// SchedulerConcurrency at a time. Users whose token has expired or is
+// rejected are dropped from the schedule.
+func schedulerTick(tickCtx context.Context) {
+	opCtx, cancel := redisContext(tickCtx)
+	userIDs, err := redisClient.SMembers(opCtx, scheduledUsersKey).Result()
+	cancel()
+	if err != nil {
+		logger.Warnf("Error listing scheduled users: %v", err)
+		return
+	}
+
+	sem := make(chan struct{}, cfg.SchedulerConcurrency)
+	var wg sync.WaitGroup
+	for _, userID := range userIDs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			refreshScheduledUser(tickCtx, userID)
+		}(userID)
+	}
+	wg.Wait()
+}
+
+func refreshScheduledUser(tickCtx context.Context, userID string) {
+	opCtx, cancel := redisContext(tickCtx)
+	stored, err := redisClient.Get(opCtx, scheduledTokenKey(userID)).Bytes()
+	cancel()
+	if err == redis.Nil {
+		logger.Debugf("Dropping %s from scheduled refresh: no live token", userID)
+		unregisterScheduledUser(tickCtx, userID)
+		return
+	}
+	if err != nil {
+		logger.Warnf("Error reading scheduled token for %s: %v", userID, err)
+		return
+	}
+	if cfg.CacheEncryption {
+		if stored, err = decrypt(stored); err != nil {
+			logger.Warnf("Dropping %s from scheduled refresh: stored token doesn't decrypt: %v", userID, err)
+			unregisterScheduledUser(tickCtx, userID)
+			return
+		}
+	}
+	token := string(stored)
+
+	gs, tokenUser, err := gmailServiceForToken(tickCtx, token)
+	if err != nil {
+		if appErr, ok := err.(*services.AppError); ok && appErr.Code == http.StatusUnauthorized {
+			logger.Infof("Dropping %s from scheduled refresh: %v", userID, err)
+			unregisterScheduledUser(tickCtx, userID)
+			return
+		}
+		logger.Warnf("Scheduled refresh skipped for %s: %v", userID, err)
+		return
+	}
+	if _, err := refreshUser(tickCtx, gs, tokenUser); err != nil {
+		logger.Warnf("Scheduled refresh failed for %s: %v", userID, err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+)
+
+func TestSchedulerTick(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		encrypted bool
+		cached    bool
+	}{
+		{"plaintext token", testToken, false, true},
+		{"encrypted token", testToken, true, true},
+		{"rejected token is dropped", "revoked-token", false, false},
+		{"rejected encrypted token is dropped", "revoked-token", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(c *config.Config) {
+				c.SchedulerInterval = time.Minute
+				c.CacheEncryption = tt.encrypted
+				if tt.encrypted {
+					c.EncryptionKey = []byte(strings.Repeat("k", 32))
+				}
+			})
+			env.addDebit("m1", "2024-03-14", 100, "AMAZON")
+
+			registerScheduledUser(context.Background(), testEmail, tt.token)
+			stored, ok := env.redis.Get(scheduledTokenKey(testEmail))
+			if !ok {
+				t.Fatal("token not stored")
+			}
+			if plain := stored == tt.token; plain == tt.encrypted {
+				t.Errorf("stored token %q, want encrypted %v", stored, tt.encrypted)
+			}
+
+			schedulerTick(context.Background())
+
+			for _, filter := range refreshFilters {
+				if _, ok := env.redis.Get(getCacheKey(testEmail, filter)); ok != tt.cached {
+					t.Errorf("%s cached = %v, want %v", filter, ok, tt.cached)
+				}
+			}
+			scheduled := len(env.redis.Members(scheduledUsersKey)) == 1
+			if scheduled != tt.cached {
+				t.Errorf("still scheduled = %v, want %v", scheduled, tt.cached)
+			}
+		})
+	}
+}
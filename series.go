@@ -0,0 +1,26 @@
+package main
+
+import (
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+// buildSeries totals spend per day for the `days` days ending on end, oldest
+// first, with a zero entry for days without transactions so charts can plot
+// it directly.
+func buildSeries(transactions []types.Transaction, end time.Time, days int) []types.DayTotal {
+	layout := "2006-01-02"
+	totals := make(map[string]float64)
+	for _, txn := range transactions {
+		totals[txn.Date] += spendAmount(txn)
+	}
+
+	series := make([]types.DayTotal, 0, days)
+	for i := days - 1; i >= 0; i-- {
+		date := end.AddDate(0, 0, -i).Format(layout)
+		series = append(series, types.DayTotal{Date: date, Total: services.RoundAmount(totals[date], cfg.AmountDecimals)})
+	}
+	return series
+}
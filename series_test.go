@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+func TestBuildSeries(t *testing.T) {
+	newTestEnv(t, nil)
+	end := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	debit := func(date string, amount float64) types.Transaction {
+		return types.Transaction{Date: date, Amount: amount, Type: types.TransactionTypeDebit}
+	}
+	refund := debit("2024-03-14", 20)
+	refund.IsRefund = true
+
+	tests := []struct {
+		name         string
+		transactions []types.Transaction
+		days         int
+		want         string
+	}{
+		{"empty window is all zeros", nil, 3,
+			"[{2024-03-13 0} {2024-03-14 0} {2024-03-15 0}]"},
+		{"gaps zero-filled", []types.Transaction{debit("2024-03-11", 10), debit("2024-03-14", 5.5)}, 5,
+			"[{2024-03-11 10} {2024-03-12 0} {2024-03-13 0} {2024-03-14 5.5} {2024-03-15 0}]"},
+		{"same-day totals and refunds", []types.Transaction{debit("2024-03-14", 100), debit("2024-03-14", 0.255), refund, debit("2024-03-15", 1)}, 2,
+			"[{2024-03-14 80.26} {2024-03-15 1}]"},
+		{"outside the window ignored", []types.Transaction{debit("2024-03-01", 999), debit("2024-03-16", 999), debit("2024-03-15", 7)}, 2,
+			"[{2024-03-14 0} {2024-03-15 7}]"},
+		{"across a month end", []types.Transaction{debit("2024-02-29", 3)}, 17,
+			"[{2024-02-28 0} {2024-02-29 3} {2024-03-01 0} {2024-03-02 0} {2024-03-03 0} {2024-03-04 0} {2024-03-05 0} {2024-03-06 0} " +
+				"{2024-03-07 0} {2024-03-08 0} {2024-03-09 0} {2024-03-10 0} {2024-03-11 0} {2024-03-12 0} {2024-03-13 0} {2024-03-14 0} {2024-03-15 0}]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series := buildSeries(tt.transactions, end, tt.days)
+			if len(series) != tt.days {
+				t.Fatalf("got %d days, want %d", len(series), tt.days)
+			}
+			if got := fmt.Sprint(series); got != tt.want {
+				t.Errorf("series = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactionsSeries(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.addDebit("m1", "2024-03-10", 40, "SWIGGY")
+	env.addDebit("m2", "2024-03-10", 60, "ZOMATO")
+	env.addDebit("m3", "2024-03-13", 25, "UBER")
+
+	resp := decodeTransactions(t, env.do("GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token="+testToken))
+	days, _ := filterDays("weekly")
+	if len(resp.Series) != days {
+		t.Fatalf("series has %d days, want the %d-day window", len(resp.Series), days)
+	}
+	totals := map[string]float64{}
+	for _, d := range resp.Series {
+		totals[d.Date] = d.Total
+	}
+	want := map[string]float64{"2024-03-09": 0, "2024-03-10": 100, "2024-03-11": 0, "2024-03-13": 25, "2024-03-15": 0}
+	for date, total := range want {
+		if got, ok := totals[date]; !ok || got != total {
+			t.Errorf("series[%s] = %v (present %v), want %v", date, got, ok, total)
+		}
+	}
+	if last := resp.Series[len(resp.Series)-1].Date; last != "2024-03-15" {
+		t.Errorf("series ends on %s, want the endDate", last)
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"google.golang.org/api/googleapi"
+)
+
+const ErrCodeServiceUnavailable = "SERVICE_UNAVAILABLE"
+
+// CircuitBreaker stops calling Gmail during an outage. After threshold
+// consecutive failures it opens and refuses calls for the cooldown; then it
+// half-opens, letting a single probe through, and closes again if the probe
+// succeeds. It is process-wide and shared by every GmailService.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+// NewCircuitBreaker returns a breaker, or nil (which never opens) when
+// threshold is 0.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a Gmail call may proceed, returning a 503
+// SERVICE_UNAVAILABLE AppError while the breaker is open.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if time.Since(b.openedAt) >= b.cooldown && !b.probing {
+		b.probing = true
+		return nil
+	}
+	return &AppError{
+		Code:      http.StatusServiceUnavailable,
+		ErrorCode: ErrCodeServiceUnavailable,
+		Msg:       fmt.Sprintf("Gmail is failing; retrying after %s", b.cooldown),
+	}
+}
+
+// Record reports the outcome of a call Allow let through. Only outage-like
+// failures (5xx, rate limiting, transport errors) count; a rejected token
+// or bad request says nothing about Gmail's health, and a call its caller
+// canceled says nothing either way, so it neither counts nor resets.
+func (b *CircuitBreaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasProbing := b.probing
+	b.probing = false
+	if isCanceled(err) {
+		return
+	}
+	if err == nil || !isOutageError(err) {
+		if b.failures >= b.threshold {
+			logger.Infof("Gmail circuit breaker closed")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures == b.threshold || wasProbing {
+		logger.Warnf("Gmail circuit breaker open for %s after %d failures: %v", b.cooldown, b.failures, err)
+		b.openedAt = time.Now()
+	}
+}
+
+func isOutageError(err error) bool {
+	if isCanceled(err) {
+		return false
+	}
+	gErr, ok := err.(*googleapi.Error)
+	if !ok {
+		return true
+	}
+	return gErr.Code >= 500 || gErr.Code == http.StatusTooManyRequests
+}
+
+// isCanceled reports whether err comes from the caller's context ending
+// rather than from Gmail.
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsOutageError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &googleapi.Error{Code: http.StatusInternalServerError}, true},
+		{"rate limited", &googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{"unauthorized", &googleapi.Error{Code: http.StatusUnauthorized}, false},
+		{"not found", &googleapi.Error{Code: http.StatusNotFound}, false},
+		{"transport error", errors.New("connection reset by peer"), true},
+		{"canceled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"canceled request", &url.Error{Op: "Get", URL: "https://gmail.googleapis.com", Err: context.Canceled}, false},
+		{"wrapped deadline", fmt.Errorf("unable to get message: %w", context.DeadlineExceeded), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOutageError(tt.err); got != tt.want {
+				t.Errorf("isOutageError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	outage := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	// A step either makes a call that fails with err or, when wait is set,
+	// lets the cooldown pass; open is whether the next call is refused.
+	type step struct {
+		err  error
+		wait bool
+		open bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"opens at the threshold", []step{{err: outage}, {err: outage}, {err: outage, open: true}}},
+		{"success resets the count", []step{{err: outage}, {err: outage}, {}, {err: outage}, {err: outage}}},
+		{"client errors reset the count", []step{{err: outage}, {err: outage}, {err: &googleapi.Error{Code: 404}}, {err: outage}}},
+		{"cancellations neither count nor reset", []step{
+			{err: outage}, {err: outage}, {err: context.Canceled}, {err: context.DeadlineExceeded}, {err: outage, open: true},
+		}},
+		{"half-open probe succeeds", []step{{err: outage}, {err: outage}, {err: outage, open: true}, {wait: true}, {}}},
+		{"half-open probe fails", []step{{err: outage}, {err: outage}, {err: outage, open: true}, {wait: true}, {err: outage, open: true}}},
+		{"canceled probe allows another", []step{{err: outage}, {err: outage}, {err: outage, open: true}, {wait: true}, {err: context.Canceled}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(3, 20*time.Millisecond)
+			for i, s := range tt.steps {
+				if s.wait {
+					time.Sleep(30 * time.Millisecond)
+					continue
+				}
+				if err := b.Allow(); err != nil {
+					t.Fatalf("step %d: call refused: %v", i, err)
+				}
+				b.Record(s.err)
+				if err := b.Allow(); (err != nil) != s.open {
+					t.Fatalf("step %d: Allow = %v, want open %v", i, err, s.open)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerGuardsMessageFetches(t *testing.T) {
+	tests := []struct {
+		name      string
+		fail      []string
+		want      int
+		gets      int
+		truncated bool
+	}{
+		{name: "healthy", want: 5},
+		{name: "batch failing, gets recover", fail: []string{gmailtest.Batch}, want: 5, gets: 5},
+		{name: "batch and gets failing", fail: []string{gmailtest.Batch, gmailtest.Get}, gets: 1, truncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, nil)
+			gs.SetCircuitBreaker(NewCircuitBreaker(2, time.Minute))
+			for i := 0; i < 5; i++ {
+				fake.Add(debitEmail(fmt.Sprintf("m%d", i), testNow.AddDate(0, 0, -1), float64(100+i), "AMAZON"))
+			}
+			for _, kind := range tt.fail {
+				fake.Fail(kind, http.StatusServiceUnavailable)
+			}
+			result, err := gs.FetchTransactions(context.Background(), 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Transactions) != tt.want || result.Truncated != tt.truncated {
+				t.Errorf("got %d transactions, truncated %v; want %d, %v", len(result.Transactions), result.Truncated, tt.want, tt.truncated)
+			}
+			// Gets inside a successful batch count too; only compare the
+			// individual ones made after a failed batch.
+			if len(tt.fail) > 0 {
+				if got := fake.Calls(gmailtest.Get); got != tt.gets {
+					t.Errorf("%d individual gets, want %d", got, tt.gets)
+				}
+			}
+		})
+	}
+}
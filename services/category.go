@@ -0,0 +1,59 @@
+package services
+
+import (
+	"sort"
+	"strings"
+)
+
+const UncategorizedCategory = "uncategorized"
+
+// defaultCategoryKeywords maps each category to merchant substrings that
+// identify it. Matching is case-insensitive.
+var defaultCategoryKeywords = map[string][]string{
+	"food":          {"zomato", "swiggy", "restaurant", "cafe", "dominos", "mcdonald", "starbucks", "kfc"},
+	"groceries":     {"bigbasket", "blinkit", "zepto", "dmart", "grofers", "instamart", "supermarket"},
+	"shopping":      {"amazon", "flipkart", "myntra", "ajio", "nykaa", "meesho"},
+	"travel":        {"uber", "ola", "rapido", "irctc", "makemytrip", "indigo", "redbus", "goibibo"},
+	"fuel":          {"petrol", "hpcl", "bpcl", "indian oil", "iocl", "shell"},
+	"utilities":     {"electricity", "airtel", "jio", "vodafone", "bescom", "broadband", "gas"},
+	"entertainment": {"netflix", "spotify", "bookmyshow", "hotstar", "prime video", "youtube"},
+	"health":        {"pharmacy", "apollo", "1mg", "pharmeasy", "hospital", "clinic"},
+}
+
+// Categorize returns the category for a merchant using the default keyword
+// table, or UncategorizedCategory when nothing matches.
+func Categorize(merchant string) string {
+	m := strings.ToLower(merchant)
+	if m == "" {
+		return UncategorizedCategory
+	}
+	// Iterate in a stable order so overlapping keywords classify consistently.
+	for _, category := range Categories() {
+		for _, keyword := range defaultCategoryKeywords[category] {
+			if strings.Contains(m, keyword) {
+				return category
+			}
+		}
+	}
+	return UncategorizedCategory
+}
+
+// Categories lists the known category names, sorted.
+func Categories() []string {
+	categories := make([]string, 0, len(defaultCategoryKeywords))
+	for c := range defaultCategoryKeywords {
+		categories = append(categories, c)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// IsKnownCategory reports whether c is a known category or the
+// uncategorized bucket.
+func IsKnownCategory(c string) bool {
+	if c == UncategorizedCategory {
+		return true
+	}
+	_, ok := defaultCategoryKeywords[c]
+	return ok
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// CategoryRule assigns Category to any transaction whose merchant contains
+// Match (case-insensitive).
+type CategoryRule struct {
+	Match    string `json:"match"`
+	Category string `json:"category"`
+}
+
+// getCategoryRulesKey holds a user's rules under prefix, the deployment's
+// CACHE_PREFIX.
+func getCategoryRulesKey(prefix, userID string) string {
+	return prefix + ":category_rules:" + userID
+}
+
+// LoadCategoryRules returns a user's category rules, keyed by lowercase
+// merchant substring.
+func LoadCategoryRules(ctx context.Context, client *redis.Client, prefix, userID string) (map[string]string, error) {
+	return client.HGetAll(ctx, getCategoryRulesKey(prefix, userID)).Result()
+}
+
+func SaveCategoryRule(ctx context.Context, client *redis.Client, prefix, userID string, rule CategoryRule) error {
+	return client.HSet(ctx, getCategoryRulesKey(prefix, userID), strings.ToLower(rule.Match), rule.Category).Err()
+}
+
+func DeleteCategoryRule(ctx context.Context, client *redis.Client, prefix, userID, match string) (bool, error) {
+	n, err := client.HDel(ctx, getCategoryRulesKey(prefix, userID), strings.ToLower(match)).Result()
+	return n > 0, err
+}
+
+// categorizeWithRules consults the user's rules before the default keyword
+// table. When several rules match, the longest (most specific) one wins.
+func categorizeWithRules(merchant string, rules map[string]string) string {
+	m := strings.ToLower(merchant)
+	best := ""
+	for match := range rules {
+		if match != "" && strings.Contains(m, match) && len(match) > len(best) {
+			best = match
+		}
+	}
+	if best != "" {
+		return rules[best]
+	}
+	return Categorize(merchant)
+}
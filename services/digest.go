@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/types"
+	"google.golang.org/api/gmail/v1"
+)
+
+const digestProfile = "digest"
+
+// bodySpan is the byte range of a match in a normalized body.
+type bodySpan struct {
+	start, end int
+}
+
+type digestAmount struct {
+	bodySpan
+	number string
+	symbol string
+}
+
+type digestDate struct {
+	bodySpan
+	date, time string
+}
+
+// spanDistance is how far apart two spans of body are: the bytes between
+// them, plus the length of the body when a sentence break (". ", "; ", " | ")
+// separates them, so an amount pairs with a date in its own sentence before
+// a closer one across a break. Overlapping spans are 0 apart.
+func spanDistance(body string, a, b bodySpan) int {
+	if b.end <= a.start {
+		a, b = b, a
+	}
+	if a.end > b.start {
+		return 0
+	}
+	gap := body[a.end:b.start]
+	distance := len(gap)
+	if strings.Contains(gap, ". ") || strings.Contains(gap, "; ") || strings.Contains(gap, " | ") {
+		distance += len(body)
+	}
+	return distance
+}
+
+// findAllAmounts returns every well-formed transaction amount in the body, in
+// order, skipping the same limits, balances and fees findAmount does. As there, a
+// currency written first wins: an amountAfter match that overlaps one, or a
+// date (the "24" of "12-03-24. Rs 200"), is not an amount.
+func findAllAmounts(patterns *compiledPatterns, rules *parseRules, body string, dates []digestDate) []digestAmount {
+	var amounts []digestAmount
+	overlaps := func(span bodySpan) bool {
+		for _, a := range amounts {
+			if span.start < a.end && a.start < span.end {
+				return true
+			}
+		}
+		for _, d := range dates {
+			if span.start < d.end && d.start < span.end {
+				return true
+			}
+		}
+		return false
+	}
+	collect := func(re *regexp.Regexp, amountGroup, currencyGroup int) {
+		for _, loc := range re.FindAllStringSubmatchIndex(body, -1) {
+			span := bodySpan{loc[0], loc[1]}
+			number, ok := normalizeAmount(submatch(body, loc, amountGroup))
+			if !ok || overlaps(span) || isContextAmount(body[:loc[0]]) || !rules.nearAmountKeyword(body, loc[0], loc[1]) ||
+				rules.isFeeAmount(body, loc[0], loc[1]) {
+				continue
+			}
+			amounts = append(amounts, digestAmount{
+				bodySpan: span,
+				number:   number,
+				symbol:   normalizeCurrencyToken(submatch(body, loc, currencyGroup)),
+			})
+		}
+	}
+	collect(patterns.amount, 2, 1)
+	collect(patterns.amountAfter, 1, 2)
+	sort.Slice(amounts, func(i, j int) bool { return amounts[i].start < amounts[j].start })
+	return amounts
+}
+
+// findAllDates returns every date in the body, in order, with the time of
+// day written after it if any. Two-digit years resolve relative to now.
+func findAllDates(patterns *compiledPatterns, body string, now time.Time) []digestDate {
+	var dates []digestDate
+	for _, loc := range patterns.date.FindAllStringSubmatchIndex(body, -1) {
+		parsed, err := time.Parse("02-01-06", submatch(body, loc, 1))
+		if err != nil {
+			continue
+		}
+		dates = append(dates, digestDate{
+			bodySpan: bodySpan{loc[0], loc[1]},
+			date:     resolveCentury(parsed, now).Format("2006-01-02"),
+			time:     parseTimeOfDay(body[loc[1]:]),
+		})
+	}
+	return dates
+}
+
+// pairByProximity pairs each date with the closest amount not yet paired,
+// closest pairs first. It returns the date index for each amount, -1 for
+// amounts left without one.
+func pairByProximity(body string, amounts []digestAmount, dates []digestDate) []int {
+	type candidate struct{ amount, date, distance int }
+	var candidates []candidate
+	for i, a := range amounts {
+		for j, d := range dates {
+			candidates = append(candidates, candidate{i, j, spanDistance(body, a.bodySpan, d.bodySpan)})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+
+	pairs := make([]int, len(amounts))
+	for i := range pairs {
+		pairs[i] = -1
+	}
+	dateUsed := make([]bool, len(dates))
+	for _, c := range candidates {
+		if pairs[c.amount] < 0 && !dateUsed[c.date] {
+			pairs[c.amount] = c.date
+			dateUsed[c.date] = true
+		}
+	}
+	return pairs
+}
+
+// nearestDates gives every amount the date closest to it; dates may be
+// shared.
+func nearestDates(body string, amounts []digestAmount, dates []digestDate) []int {
+	pairs := make([]int, len(amounts))
+	for i, a := range amounts {
+		best := -1
+		for j, d := range dates {
+			if best < 0 || spanDistance(body, a.bodySpan, d.bodySpan) < spanDistance(body, a.bodySpan, dates[best].bodySpan) {
+				best = j
+			}
+		}
+		pairs[i] = best
+	}
+	return pairs
+}
+
+// parseDigest splits a body that lists several transactions into one set of
+// details per amount. ok is false when the body has fewer than two amounts
+// or two dates, so it is parsed as a single alert instead. When the amount
+// and date counts differ, strategy (a config.Digest* value) decides how to
+// pair them, and the warning describes what was done.
+func parseDigest(body string, rules *parseRules, strategy string, now time.Time) (items []*ParseDetails, warning string, ok bool) {
+	body = normalizeBody(body)
+	patterns := currentPatterns()
+	dates := findAllDates(patterns, body, now)
+	amounts := findAllAmounts(patterns, rules, body, dates)
+	if len(amounts) < 2 || len(dates) < 2 {
+		return nil, "", false
+	}
+
+	var pairs []int
+	if len(amounts) == len(dates) {
+		pairs = make([]int, len(amounts))
+		for i := range pairs {
+			pairs[i] = i
+		}
+	} else {
+		counts := fmt.Sprintf("%d amounts but %d dates", len(amounts), len(dates))
+		switch strategy {
+		case config.DigestProximity:
+			pairs = pairByProximity(body, amounts, dates)
+			dropped := 0
+			for _, p := range pairs {
+				if p < 0 {
+					dropped++
+				}
+			}
+			warning = fmt.Sprintf("digest has %s; paired by proximity, %d of %d amounts dropped", counts, dropped, len(amounts))
+		case config.DigestNearestDate:
+			pairs = nearestDates(body, amounts, dates)
+			warning = fmt.Sprintf("digest has %s; each amount given its nearest date", counts)
+		default:
+			return nil, "digest skipped: " + counts, true
+		}
+	}
+
+	account := ""
+	if m := accountPattern.FindStringSubmatch(body); len(m) >= 2 {
+		account = maskAccount(m[1])
+	}
+	for i, a := range amounts {
+		if pairs[i] < 0 {
+			continue
+		}
+		d := dates[pairs[i]]
+		// An item runs from its amount (or its date, if that comes first) to
+		// the next amount; the wording since the previous amount decides its
+		// direction.
+		prevEnd, start, end := 0, a.start, len(body)
+		if i > 0 {
+			prevEnd = amounts[i-1].end
+		}
+		if d.start < start && d.start >= prevEnd {
+			start = d.start
+		}
+		if i+1 < len(amounts) {
+			end = amounts[i+1].start
+		}
+		item, lead := body[start:end], body[prevEnd:end]
+
+		amount, err := strconv.ParseFloat(strings.ReplaceAll(a.number, ",", ""), 64)
+		if err != nil {
+			continue
+		}
+		details := &ParseDetails{
+			Amount:         amount,
+			Fee:            rules.findFee(patterns, item),
+			Date:           d.date,
+			Time:           d.time,
+			CurrencySymbol: a.symbol,
+			Currency:       currencyCode(a.symbol, nil),
+			Account:        account,
+			Profile:        digestProfile,
+			AmountPattern:  patterns.amount.String(),
+			DatePattern:    patterns.date.String(),
+		}
+		if m := patterns.merchant.FindStringSubmatch(item); len(m) >= 2 {
+			details.Merchant = strings.TrimSpace(m[1])
+			details.MerchantPattern = patterns.merchant.String()
+		}
+		details.Type = detectTransactionType(lead)
+		if refundPattern.MatchString(item) {
+			details.IsRefund = true
+			details.Type = types.TransactionTypeCredit
+		} else if isAdjustment(lead) {
+			details.IsAdjustment = true
+			details.Type = types.TransactionTypeCredit
+		}
+		details.Confidence = parseConfidence(details, lead)
+		items = append(items, details)
+	}
+	return items, warning, true
+}
+
+// digestTransactions parses a message as a digest of several transactions.
+// ok is false when it isn't one, including balance notifications when those
+// are skipped, so the message is parsed as a single alert; a skipped digest
+// returns no transactions and a warning. Like parseWithTimeout, it returns
+// errParseTimeout when parsing takes longer than ParseTimeout.
+func (gs *GmailService) digestTransactions(ctx context.Context, msg *gmail.Message) ([]types.Transaction, string, bool, error) {
+	var body, warning string
+	var items []*ParseDetails
+	var ok bool
+	parsed := runWithTimeout(gs.config.ParseTimeout, func() {
+		text := extractMessageContent(msg.Payload, gs.partPreference(senderDomain(msg)))
+		if text == "" {
+			return
+		}
+		text = stripHTMLTags(text)
+		if gs.config.SkipBalanceEmails && isBalanceOnly(text) {
+			return
+		}
+		body = text
+		items, warning, ok = parseDigest(text, gs.rules, gs.config.DigestMismatch, gs.now())
+	})
+	if !parsed {
+		return nil, "", false, errParseTimeout
+	}
+	if !ok {
+		return nil, "", false, nil
+	}
+	if warning != "" {
+		logger.Ctx(ctx).Debugf("Digest message %s: %s", msg.Id, warning)
+	}
+	subject := strings.TrimSpace(partHeader(msg.Payload, "Subject"))
+	var transactions []types.Transaction
+	for _, details := range items {
+		transactions = append(transactions, *gs.newTransaction(details, msg, body, subject))
+	}
+	return transactions, warning, true, nil
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"google.golang.org/api/gmail/v1"
+)
+
+// mismatchedDigest lists three amounts but only two dates: SWIGGY's line has
+// none.
+const mismatchedDigest = "Your recent transactions: Rs.250.00 spent at AMAZON on 12-03-24. " +
+	"Rs.120.00 spent at SWIGGY. Rs.80.00 spent at ZOMATO on 14-03-24."
+
+func TestParseDigestMismatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		strategy string
+		now      time.Time
+		want     []string
+		warning  string
+	}{
+		{name: "skip", body: mismatchedDigest, strategy: config.DigestSkip, now: testNow,
+			warning: "digest skipped: 3 amounts but 2 dates"},
+		{name: "proximity", body: mismatchedDigest, strategy: config.DigestProximity, now: testNow,
+			want:    []string{"2024-03-12 250 AMAZON", "2024-03-14 80 ZOMATO"},
+			warning: "paired by proximity, 1 of 3 amounts dropped"},
+		{name: "nearest date", body: mismatchedDigest, strategy: config.DigestNearestDate, now: testNow,
+			want:    []string{"2024-03-12 250 AMAZON", "2024-03-12 120 SWIGGY", "2024-03-14 80 ZOMATO"},
+			warning: "each amount given its nearest date"},
+		{name: "matched counts pair in order", strategy: config.DigestSkip, now: testNow,
+			body: "Rs.250.00 spent at AMAZON on 12-03-24. Rs.80.00 spent at ZOMATO on 14-03-24.",
+			want: []string{"2024-03-12 250 AMAZON", "2024-03-14 80 ZOMATO"}},
+		{name: "two-digit years follow the clock", strategy: config.DigestSkip, now: time.Date(1999, 6, 1, 0, 0, 0, 0, time.UTC),
+			body: "Rs.250.00 spent at AMAZON on 12-03-24. Rs.80.00 spent at ZOMATO on 14-03-24.",
+			want: []string{"1924-03-12 250 AMAZON", "1924-03-14 80 ZOMATO"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, warning, ok := parseDigest(tt.body, nil, tt.strategy, tt.now)
+			if !ok {
+				t.Fatal("not parsed as a digest")
+			}
+			var got []string
+			for _, d := range items {
+				got = append(got, fmt.Sprintf("%s %g %s", d.Date, d.Amount, d.Merchant))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("items %q, want %q", got, tt.want)
+			}
+			if tt.warning == "" && warning != "" || !strings.Contains(warning, tt.warning) {
+				t.Errorf("warning %q, want %q", warning, tt.warning)
+			}
+		})
+	}
+}
+
+func TestParseDigestFees(t *testing.T) {
+	tests := []struct {
+		name     string
+		keywords []string
+		want     []string
+	}{
+		{"fee kept with its item", nil, []string{"250 fee 10", "80 fee 0"}},
+		{"detection off", []string{}, []string{"250 fee 0", "10 fee 0", "80 fee 0"}},
+	}
+	const body = "Rs.250.00 spent at AMAZON on 12-03-24 (incl. fee Rs.10.00). Rs.80.00 spent at ZOMATO on 14-03-24."
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.LoadConfig()
+			if tt.keywords != nil {
+				cfg.FeeKeywords = tt.keywords
+			}
+			items, _, ok := parseDigest(body, newParseRules(cfg), config.DigestNearestDate, testNow)
+			if !ok {
+				t.Fatal("not parsed as a digest")
+			}
+			var got []string
+			for _, d := range items {
+				got = append(got, fmt.Sprintf("%g fee %g", d.Amount, d.Fee))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("items %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestEmailGuards(t *testing.T) {
+	// Big enough to take far longer than the short timeout below, small
+	// enough that the abandoned parse doesn't hog a CPU for the rest of the
+	// package's tests.
+	huge := "<html><body>" + strings.Repeat("<div><span>Rs.1.00 debited at NOWHERE on 14-03-24 ", 3000) + "</body></html>"
+	hugeEmail := gmailtest.Email("huge", "alerts@hdfcbank.net", "Transaction alert", huge, testNow.Add(-time.Hour))
+	hugeEmail.Payload.MimeType = "text/html"
+	balance := gmailtest.Email("b1", "alerts@hdfcbank.net", "Balance update",
+		"Your available balance in A/c XX1234 is Rs.25,000.00 as on 14-03-24. Available balance on 13-03-24 was Rs.24,000.00.",
+		testNow.Add(-time.Hour))
+	digest := gmailtest.Email("d1", "alerts@hdfcbank.net", "Your transactions", mismatchedDigest, testNow.Add(-time.Hour))
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		messages []*gmail.Message
+		want     int
+		warning  string
+	}{
+		{"digest within the timeout", time.Second, []*gmail.Message{digest}, 3, "each amount given its nearest date"},
+		{"huge email skipped, batch continues", 20 * time.Millisecond, []*gmail.Message{digest, hugeEmail}, 3, "huge skipped: parsing timed out"},
+		{"balance notification skipped", time.Second, []*gmail.Message{balance}, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, func(cfg *config.Config) {
+				cfg.DigestEmails = true
+				cfg.DigestMismatch = config.DigestNearestDate
+				cfg.SkipBalanceEmails = true
+				cfg.ParseTimeout = tt.timeout
+			})
+			fake.Add(tt.messages...)
+			start := time.Now()
+			result, err := gs.FetchTransactions(context.Background(), 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("fetch took %s; the huge email stalled the batch", elapsed)
+			}
+			if len(result.Transactions) != tt.want {
+				t.Errorf("got %d transactions, want %d: %+v", len(result.Transactions), tt.want, result.Transactions)
+			}
+			if !strings.Contains(strings.Join(result.Warnings, "\n"), tt.warning) {
+				t.Errorf("warnings %q lack %q", result.Warnings, tt.warning)
+			}
+		})
+	}
+}
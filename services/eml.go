@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/types"
+	"google.golang.org/api/gmail/v1"
+)
+
+// ParseEML parses a raw RFC 822 (.eml) message into a transaction using the
+// same extraction as Gmail messages, without any Gmail access.
+func ParseEML(ctx context.Context, cfg *config.Config, raw io.Reader) (*types.Transaction, error) {
+	msg, err := EMLToMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	gs := &GmailService{config: cfg, minAmount: cfg.MinAmount, rules: newParseRules(cfg), now: time.Now, location: cfg.Location}
+	return gs.parseTransactionEmail(ctx, msg)
+}
+
+// EMLToMessage converts a raw RFC 822 message into the Gmail API's message
+// shape, so offline emails can go through extractMessageContent and
+// parseTransactionEmail unchanged.
+func EMLToMessage(raw io.Reader) (*gmail.Message, error) {
+	m, err := mail.ReadMessage(raw)
+	if err != nil {
+		return nil, &AppError{Code: http.StatusBadRequest, Msg: fmt.Sprintf("invalid EML message: %v", err)}
+	}
+	payload, err := emlPart(m.Header, m.Body)
+	if err != nil {
+		return nil, &AppError{Code: http.StatusBadRequest, Msg: fmt.Sprintf("invalid EML message: %v", err)}
+	}
+
+	msg := &gmail.Message{
+		Id:      strings.Trim(m.Header.Get("Message-Id"), "<>"),
+		Payload: payload,
+	}
+	if sent, err := m.Header.Date(); err == nil {
+		msg.InternalDate = sent.UnixMilli()
+	}
+	return msg, nil
+}
+
+// emlPart builds a MessagePart from a MIME entity, recursing into multipart
+// bodies. Base64 bodies are decoded here; quoted-printable is left for
+// decodePartBody, as with Gmail.
+func emlPart(header map[string][]string, body io.Reader) (*gmail.MessagePart, error) {
+	part := &gmail.MessagePart{}
+	for name, values := range header {
+		for _, v := range values {
+			part.Headers = append(part.Headers, &gmail.MessagePartHeader{Name: name, Value: v})
+		}
+	}
+
+	contentType := firstHeader(header, "Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	part.MimeType = mediaType
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			child, err := emlPart(p.Header, p)
+			if err != nil {
+				return nil, err
+			}
+			part.Parts = append(part.Parts, child)
+		}
+		return part, nil
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(firstHeader(header, "Content-Transfer-Encoding"), "base64") {
+		decoded, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(stripWhitespace(data))))
+		if err != nil {
+			return nil, err
+		}
+		data = decoded
+	}
+	part.Body = &gmail.MessagePartBody{
+		Data: base64.URLEncoding.EncodeToString(data),
+		Size: int64(len(data)),
+	}
+	return part, nil
+}
+
+func firstHeader(header map[string][]string, name string) string {
+	for k, v := range header {
+		if strings.EqualFold(k, name) && len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+	}
+	return ""
+}
+
+func stripWhitespace(data []byte) []byte {
+	return bytes.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, data)
+}
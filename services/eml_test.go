@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+)
+
+func TestParseEML(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		messageID string
+		amount    float64
+		txnType   string
+		merchant  string
+		date      string
+		timestamp string
+	}{
+		{"hdfc_debit.eml", "20240312143512.4f2a@hdfcbank.net", 1250, "debit", "AMAZON", "2024-03-12", "2024-03-12T14:35:00+05:30"},
+		{"icici_credit.eml", "CAJ9x2@icicibank.com", 5000, "credit", "", "2024-03-14", "2024-03-14T09:02:44+05:30"},
+	}
+	cfg := config.LoadConfig()
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			txn, err := ParseEML(context.Background(), cfg, f)
+			if err != nil {
+				t.Fatalf("ParseEML: %v", err)
+			}
+			if txn.MessageID != tt.messageID || txn.Amount != tt.amount || string(txn.Type) != tt.txnType ||
+				txn.Date != tt.date || txn.Timestamp != tt.timestamp {
+				t.Errorf("got id %q amount %v type %s date %s at %s, want %q %v %s %s at %s",
+					txn.MessageID, txn.Amount, txn.Type, txn.Date, txn.Timestamp,
+					tt.messageID, tt.amount, tt.txnType, tt.date, tt.timestamp)
+			}
+			if tt.merchant != "" && txn.Merchant != tt.merchant {
+				t.Errorf("merchant = %q, want %q", txn.Merchant, tt.merchant)
+			}
+		})
+	}
+
+	for _, raw := range []string{"", "not an email at all", "Content-Type: multipart/mixed\r\n\r\nno boundary"} {
+		if _, err := ParseEML(context.Background(), cfg, strings.NewReader(raw)); err == nil {
+			t.Errorf("ParseEML(%q) succeeded", raw)
+		}
+	}
+}
+
+func TestParseEMLAmountKeywords(t *testing.T) {
+	const raw = "From: alerts@hdfcbank.net\r\nDate: Tue, 12 Mar 2024 14:35:12 +0530\r\nSubject: Alert\r\n" +
+		"Content-Type: text/plain\r\n\r\nGet Rs. 100 cashback on your next order. Rs. 450.00 spent at ZOMATO on 12-03-24.\r\n"
+	tests := []struct {
+		name   string
+		window int
+		amount float64
+	}{
+		{"keywords off", 0, 100},
+		{"stray number skipped", 15, 450},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.LoadConfig()
+			cfg.AmountKeywordWindow = tt.window
+			txn, err := ParseEML(context.Background(), cfg, strings.NewReader(raw))
+			if err != nil {
+				t.Fatalf("ParseEML: %v", err)
+			}
+			if txn.Amount != tt.amount {
+				t.Errorf("amount = %v, want %v", txn.Amount, tt.amount)
+			}
+		})
+	}
+}
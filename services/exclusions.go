@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/types"
+	"github.com/go-redis/redis/v8"
+)
+
+// Exclusion hides any transaction whose merchant contains Match
+// (case-insensitive).
+type Exclusion struct {
+	Match string `json:"match"`
+}
+
+// getExclusionsKey holds a user's exclusions under prefix, the deployment's
+// CACHE_PREFIX.
+func getExclusionsKey(prefix, userID string) string {
+	return prefix + ":exclusions:" + userID
+}
+
+// LoadExclusions returns a user's excluded merchant substrings, lowercase.
+func LoadExclusions(ctx context.Context, client *redis.Client, prefix, userID string) ([]string, error) {
+	return client.SMembers(ctx, getExclusionsKey(prefix, userID)).Result()
+}
+
+func SaveExclusion(ctx context.Context, client *redis.Client, prefix, userID, match string) error {
+	return client.SAdd(ctx, getExclusionsKey(prefix, userID), strings.ToLower(match)).Err()
+}
+
+func DeleteExclusion(ctx context.Context, client *redis.Client, prefix, userID, match string) (bool, error) {
+	n, err := client.SRem(ctx, getExclusionsKey(prefix, userID), strings.ToLower(match)).Result()
+	return n > 0, err
+}
+
+// isExcluded reports whether a transaction's merchant contains any of the
+// exclusions.
+func isExcluded(txn types.Transaction, exclusions []string) bool {
+	merchant := strings.ToLower(txn.Merchant)
+	for _, match := range exclusions {
+		if match != "" && strings.Contains(merchant, match) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type localeFormat struct {
+	groupSep      string
+	decimalSep    string
+	indianGroups  bool
+	symbolAfter   bool
+	symbolSpacing string
+}
+
+var localeFormats = map[string]localeFormat{
+	"en-IN": {groupSep: ",", decimalSep: ".", indianGroups: true},
+	"en-US": {groupSep: ",", decimalSep: "."},
+	"en-GB": {groupSep: ",", decimalSep: "."},
+	"de-DE": {groupSep: ".", decimalSep: ",", symbolAfter: true, symbolSpacing: " "},
+}
+
+var currencySymbols = map[string]string{
+	"INR": "₹",
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+}
+
+// currencyPrecision is the number of minor-unit decimals per ISO 4217 code,
+// for currencies that don't use two.
+var currencyPrecision = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"VND": 0,
+	"CLP": 0,
+	"ISK": 0,
+	"BHD": 3,
+	"KWD": 3,
+	"OMR": 3,
+	"JOD": 3,
+	"TND": 3,
+}
+
+// CurrencyDecimals returns how many decimals amounts in currency carry: the
+// currency's own precision when it is known not to be two, otherwise
+// fallback (AMOUNT_DECIMALS) — which also covers an unknown or empty code.
+func CurrencyDecimals(currency string, fallback int) int {
+	if decimals, ok := currencyPrecision[strings.ToUpper(currency)]; ok {
+		return decimals
+	}
+	return fallback
+}
+
+// RoundAmount rounds a monetary value to the given number of decimals,
+// removing float drift such as 1234.560000001.
+func RoundAmount(v float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
+}
+
+// IsSupportedLocale reports whether FormatAmount knows the locale.
+func IsSupportedLocale(locale string) bool {
+	_, ok := localeFormats[locale]
+	return ok
+}
+
+// FormatAmount renders an amount with its currency symbol using the locale's
+// digit grouping, e.g. ₹1,23,456.78 for en-IN or $123,456.78 for en-US, and
+// the currency's precision (¥1,235 for JPY). Unknown currencies are shown by
+// ISO code with two decimals.
+func FormatAmount(amount float64, currency, locale string) (string, error) {
+	lf, ok := localeFormats[locale]
+	if !ok {
+		return "", fmt.Errorf("unsupported locale %q", locale)
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = math.Abs(amount)
+	}
+	// FormatFloat rounds half to even (¥1,234 for 1234.5); round half away
+	// from zero first so displayed amounts match RoundAmount.
+	decimals := CurrencyDecimals(currency, 2)
+	fixed := strconv.FormatFloat(RoundAmount(amount, decimals), 'f', decimals, 64)
+	intPart, fracPart, hasFrac := strings.Cut(fixed, ".")
+	number := groupDigits(intPart, lf.groupSep, lf.indianGroups)
+	if hasFrac {
+		number += lf.decimalSep + fracPart
+	}
+
+	symbol, ok := currencySymbols[currency]
+	if !ok {
+		symbol = currency
+		if !lf.symbolAfter && symbol != "" {
+			symbol += " "
+		}
+	}
+	if lf.symbolAfter {
+		return sign + number + lf.symbolSpacing + symbol, nil
+	}
+	return sign + symbol + number, nil
+}
+
+// groupDigits inserts separators into a run of digits, either in threes or in
+// the Indian lakh/crore style (last three, then pairs).
+func groupDigits(digits, sep string, indian bool) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+	head, tail := digits[:len(digits)-3], digits[len(digits)-3:]
+	size := 3
+	if indian {
+		size = 2
+	}
+	var groups []string
+	for len(head) > size {
+		groups = append([]string{head[len(head)-size:]}, groups...)
+		head = head[:len(head)-size]
+	}
+	groups = append([]string{head}, groups...)
+	return strings.Join(groups, sep) + sep + tail
+}
@@ -0,0 +1,39 @@
+package services
+
+import "testing"
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		locale   string
+		want     string
+	}{
+		{1234.56, "INR", "en-IN", "₹1,234.56"},
+		{123456.78, "INR", "en-IN", "₹1,23,456.78"},
+		{12345678.9, "INR", "en-IN", "₹1,23,45,678.90"},
+		{999, "INR", "en-IN", "₹999.00"},
+		{123456.78, "INR", "en-US", "₹123,456.78"},
+		{1234567.5, "USD", "en-US", "$1,234,567.50"},
+		{12345678.9, "USD", "en-IN", "$1,23,45,678.90"},
+		{-1500, "USD", "en-US", "-$1,500.00"},
+		{1234.5, "EUR", "de-DE", "1.234,50 €"},
+		{1234.5, "JPY", "en-US", "¥1,235"},
+		{12.3456, "BHD", "en-US", "BHD 12.346"},
+		{10, "", "en-US", "10.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale+" "+tt.want, func(t *testing.T) {
+			got, err := FormatAmount(tt.amount, tt.currency, tt.locale)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("FormatAmount(%v, %q, %q) = %q, want %q", tt.amount, tt.currency, tt.locale, got, tt.want)
+			}
+		})
+	}
+	if _, err := FormatAmount(1, "INR", "xx-XX"); err == nil {
+		t.Error("unsupported locale accepted")
+	}
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// maxBatchSize is the most requests Gmail accepts in one batch call.
+const maxBatchSize = 100
+
+// getMessages fetches full message bodies for ids using Gmail's batch
+// endpoint, falling back to individual gets for any batch or message that
+// fails. The result is in the same order as ids; messages that could not be
+// fetched at all are nil. Once ctx is done or the circuit breaker opens no
+// further requests are made, and only the messages fetched so far are
+// returned, with truncated set.
+func (gs *GmailService) getMessages(ctx context.Context, ids []string) (messages []*gmail.Message, truncated bool) {
+	messages = make([]*gmail.Message, len(ids))
+	for start := 0; start < len(ids); start += maxBatchSize {
+		if ctx.Err() != nil {
+			return messages[:start], true
+		}
+		if err := gs.breaker.Allow(); err != nil {
+			logger.Ctx(ctx).Warnf("Stopping after %d of %d messages: %v", start, len(ids), err)
+			return messages[:start], true
+		}
+		end := start + maxBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunk := ids[start:end]
+
+		fetched, err := gs.batchGetMessages(ctx, chunk)
+		if err != nil {
+			logger.Ctx(ctx).Warnf("Gmail batch get failed, falling back to individual gets: %v", err)
+			fetched = map[string]*gmail.Message{}
+		}
+		for i, id := range chunk {
+			msg, ok := fetched[id]
+			if !ok {
+				if ctx.Err() != nil {
+					return messages[:start+i], true
+				}
+				if err := gs.breaker.Allow(); err != nil {
+					logger.Ctx(ctx).Warnf("Stopping after %d of %d messages: %v", start+i, len(ids), err)
+					return messages[:start+i], true
+				}
+				msg, err = gs.service.Users.Messages.Get("me", id).Format("full").Context(ctx).Do()
+				gs.quota.Record(ctx, CallGet, 1)
+				gs.breaker.Record(err)
+				if err != nil {
+					logger.Ctx(ctx).Warnf("Error getting message %s: %v", id, err)
+					continue
+				}
+			}
+			messages[start+i] = msg
+		}
+	}
+	return messages, false
+}
+
+// batchGetMessages issues one multipart batch request for the given ids and
+// returns the messages that came back successfully, keyed by id. The
+// request's outcome is recorded with the circuit breaker, which the caller
+// has already consulted.
+func (gs *GmailService) batchGetMessages(ctx context.Context, ids []string) (map[string]*gmail.Message, error) {
+	if gs.httpClient == nil {
+		return nil, fmt.Errorf("no HTTP client available for batch requests")
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, id := range ids {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", "<"+strconv.Itoa(i)+">")
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(part, "GET /gmail/v1/users/me/messages/%s?format=full\r\n\r\n", id)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	batchURL := strings.TrimSuffix(gs.service.BasePath, "/") + "/batch/gmail/v1"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, batchURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+
+	resp, err := gs.httpClient.Do(req)
+	gs.quota.Record(ctx, CallBatch, 1)
+	if err == nil {
+		defer resp.Body.Close()
+		err = googleapi.CheckResponse(resp)
+	}
+	gs.breaker.Record(err)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("unexpected batch response content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	results := make(map[string]*gmail.Message, len(ids))
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return results, err
+		}
+		msg, err := readBatchPart(part)
+		if err != nil {
+			logger.Warnf("Skipping batch response part: %v", err)
+			continue
+		}
+		results[msg.Id] = msg
+	}
+	return results, nil
+}
+
+// readBatchPart decodes one application/http part of a batch response.
+func readBatchPart(part *multipart.Part) (*gmail.Message, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(part), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("message get returned %d", resp.StatusCode)
+	}
+	var msg gmail.Message
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("unable to decode message: %v", err)
+	}
+	if msg.Id == "" {
+		return nil, fmt.Errorf("message has no id")
+	}
+	return &msg, nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+)
+
+func TestGetMessagesBatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		messages  int
+		failBatch int
+		batches   int
+	}{
+		{name: "one batch", messages: 3, batches: 1},
+		{name: "exactly one full batch", messages: maxBatchSize, batches: 1},
+		{name: "split across batches", messages: maxBatchSize + 50, batches: 2},
+		{name: "batch failing falls back to gets", messages: 5, failBatch: http.StatusServiceUnavailable, batches: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, nil)
+			fake.Fail(gmailtest.Batch, tt.failBatch)
+			ids := make([]string, tt.messages)
+			for i := range ids {
+				ids[i] = fmt.Sprintf("m%03d", i)
+				fake.Add(debitEmail(ids[i], testNow.AddDate(0, 0, -1), float64(i+1), "AMAZON"))
+			}
+
+			messages, truncated := gs.getMessages(context.Background(), ids)
+			if truncated {
+				t.Error("unexpectedly truncated")
+			}
+			if len(messages) != len(ids) {
+				t.Fatalf("got %d messages, want %d", len(messages), len(ids))
+			}
+			for i, msg := range messages {
+				if msg == nil || msg.Id != ids[i] {
+					t.Fatalf("message %d = %v, want %s in order", i, msg, ids[i])
+				}
+			}
+			transactions, _ := gs.parseFetched(context.Background(), messages)
+			if len(transactions) != tt.messages {
+				t.Errorf("parsed %d transactions, want %d", len(transactions), tt.messages)
+			}
+			if got := fake.Calls(gmailtest.Batch); got != tt.batches {
+				t.Errorf("%d batch calls, want %d", got, tt.batches)
+			}
+			if got := fake.Calls(gmailtest.Get); got != tt.messages {
+				t.Errorf("%d message gets, want %d", got, tt.messages)
+			}
+		})
+	}
+}
+
+func TestGetMessagesMissingFromBatch(t *testing.T) {
+	gs, fake := newTestService(t, nil)
+	fake.Add(debitEmail("present", testNow, 10, "AMAZON"))
+
+	messages, _ := gs.getMessages(context.Background(), []string{"present", "deleted"})
+	if messages[0] == nil || messages[0].Id != "present" {
+		t.Errorf("present message = %v", messages[0])
+	}
+	if messages[1] != nil {
+		t.Errorf("deleted message = %v, want nil", messages[1])
+	}
+}
@@ -1,19 +1,23 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"mime/quotedprintable"
 	"net/http"
+	"net/mail"
 	"os"
-	"regexp"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/logger"
 	"github.com/abhayyadav/funnyMoney/be/types"
 	"golang.org/x/net/html"
 	"golang.org/x/oauth2"
@@ -23,8 +27,9 @@ import (
 )
 
 type AppError struct {
-	Code int
-	Msg  string
+	Code      int
+	ErrorCode string
+	Msg       string
 }
 
 func (e *AppError) Error() string {
@@ -32,8 +37,94 @@ func (e *AppError) Error() string {
 }
 
 type GmailService struct {
-	service *gmail.Service
-	config  *config.Config
+	service       *gmail.Service
+	config        *config.Config
+	categoryRules map[string]string
+	exclusions    []string
+	httpClient    *http.Client
+	minAmount     float64
+	rules         *parseRules
+	quota         *QuotaTracker
+	breaker       *CircuitBreaker
+	rawBodies     bool
+	spamTrash     bool
+	onTransaction func(types.Transaction)
+	onMessage     func(*gmail.Message)
+	now           func() time.Time
+	senderDomains []string
+	location      *time.Location
+
+	profileMu sync.Mutex
+	profile   *gmail.Profile
+}
+
+// SetLocation sets the timezone whose calendar days the fetch window covers.
+func (gs *GmailService) SetLocation(loc *time.Location) {
+	gs.location = loc
+}
+
+// SetSenderDomains limits subsequent fetches to emails from the given
+// domains (and their subdomains). An empty list processes every sender.
+func (gs *GmailService) SetSenderDomains(domains []string) {
+	gs.senderDomains = domains
+}
+
+// SetClock replaces the time source the fetch window is anchored on, so a
+// window can end on a fixed date (historical queries, tests).
+func (gs *GmailService) SetClock(now func() time.Time) {
+	gs.now = now
+}
+
+// SetEndDate anchors subsequent fetch windows on the given day instead of today.
+func (gs *GmailService) SetEndDate(end time.Time) {
+	gs.SetClock(func() time.Time { return end })
+}
+
+// SetIncludeRawBody makes subsequent fetches attach each email's stripped,
+// PII-masked body to its transaction, for diagnosing parse issues.
+func (gs *GmailService) SetIncludeRawBody(include bool) {
+	gs.rawBodies = include
+}
+
+// SetIncludeSpamTrash makes subsequent fetches search Spam and Trash too,
+// where bank alerts occasionally land.
+func (gs *GmailService) SetIncludeSpamTrash(include bool) {
+	gs.spamTrash = include
+}
+
+// SetOnTransaction registers fn to be called with each transaction as soon
+// as it is parsed, before the fetch completes, for streaming responses.
+func (gs *GmailService) SetOnTransaction(fn func(types.Transaction)) {
+	gs.onTransaction = fn
+}
+
+// SetCircuitBreaker makes the service stop calling Gmail while b is open.
+func (gs *GmailService) SetCircuitBreaker(b *CircuitBreaker) {
+	gs.breaker = b
+}
+
+// SetQuotaTracker makes the service count its Gmail calls and refuse to fetch
+// once the tracker's soft limit is reached.
+func (gs *GmailService) SetQuotaTracker(q *QuotaTracker) {
+	gs.quota = q
+}
+
+// SetMinAmount overrides the configured minimum transaction amount for
+// subsequent fetches.
+func (gs *GmailService) SetMinAmount(min float64) {
+	gs.minAmount = min
+}
+
+// SetCategoryRules installs user-defined category rules that take precedence
+// over the default classifier for subsequent fetches.
+func (gs *GmailService) SetCategoryRules(rules map[string]string) {
+	gs.categoryRules = rules
+}
+
+// SetExclusions hides, from subsequent fetches, every transaction whose
+// merchant contains one of the given lowercase substrings.
+func (gs *GmailService) SetExclusions(exclusions []string) {
+	gs.exclusions = exclusions
 }
 
 func NewGmailServiceWithClient(cfg *config.Config, client *http.Client) (*GmailService, error) {
@@ -45,28 +136,147 @@ func NewGmailServiceWithClient(cfg *config.Config, client *http.Client) (*GmailS
 	}
 
 	return &GmailService{
-		service: srv,
-		config:  cfg,
+		service:       srv,
+		config:        cfg,
+		httpClient:    client,
+		minAmount:     cfg.MinAmount,
+		rules:         newParseRules(cfg),
+		now:           time.Now,
+		senderDomains: cfg.SenderDomains,
+		location:      cfg.Location,
+		spamTrash:     cfg.IncludeSpamTrash,
 	}, nil
 }
+
 func (gs *GmailService) GetUserId() (string, error) {
-	userInfo, err := gs.service.Users.GetProfile("me").Do()
+	profile, err := gs.GetProfile()
 	if err != nil {
-		return "", fmt.Errorf("unable to get user profile: %v", err)
+		return "", err
 	}
-	return userInfo.EmailAddress, nil
+	return profile.EmailAddress, nil
 }
-func (gs *GmailService) FetchTransactions(days int) ([]types.Transaction, error) {
 
-	endDate := time.Now()
-	startDate := endDate.AddDate(0, 0, -days)
+// GetProfile returns the Gmail profile (address, message totals) the token
+// belongs to, fetched once per service. A rejected token is reported as a
+// 401 INVALID_TOKEN AppError.
+func (gs *GmailService) GetProfile() (*gmail.Profile, error) {
+	gs.profileMu.Lock()
+	defer gs.profileMu.Unlock()
+	if gs.profile != nil {
+		return gs.profile, nil
+	}
+	profile, err := gs.service.Users.GetProfile("me").Do()
+	if err != nil {
+		if appErr := gmailDisabledError(err); appErr != nil {
+			return nil, appErr
+		}
+		if gErr, ok := err.(*googleapi.Error); ok && (gErr.Code == 401 || gErr.Code == 403) {
+			return nil, &AppError{
+				Code:      http.StatusUnauthorized,
+				ErrorCode: ErrCodeInvalidToken,
+				Msg:       fmt.Sprintf("access token rejected by Gmail: %v", err),
+			}
+		}
+		return nil, fmt.Errorf("unable to get user profile: %v", err)
+	}
+	gs.profile = profile
+	return profile, nil
+}
+
+// FetchResult is the outcome of a FetchTransactions call. Warnings carries
+// non-fatal conditions the client should know about, such as truncation;
+// NextCursor is only set by FetchTransactionsPage.
+type FetchResult struct {
+	Transactions []types.Transaction
+	Warnings     []string
+	NextCursor   string
+	// MatchedMessages is how many emails matched the query, counted from the
+	// listed IDs before any bodies are fetched. It is only an estimate
+	// (Gmail's resultSizeEstimate) when MatchedExact is false.
+	MatchedMessages int64
+	MatchedExact    bool
+	// Truncated is set when the fetch budget ran out (or ctx ended, or the
+	// circuit breaker opened) before every matched email was fetched, so the
+	// result is partial.
+	Truncated bool
+}
+
+// FetchTransactions lists and parses the transaction emails of the last days
+// days. Email bodies stop being fetched once ctx is done or the configured
+// fetch budget has passed, and whatever was fetched is returned as a
+// truncated result.
+func (gs *GmailService) FetchTransactions(ctx context.Context, days int) (*FetchResult, error) {
+
+	if err := gs.quota.CheckSoftLimit(ctx); err != nil {
+		return nil, err
+	}
 
-	query := fmt.Sprintf("after:%s before:%s subject:(transaction OR payment OR purchase OR UPI txn)",
-		startDate.Format("2006/01/02"),
-		endDate.Format("2006/01/02"))
+	query := buildTransactionQuery(days, gs.now().In(gs.location), gs.senderDomains, gs.config.PDFStatements, gs.spamTrash)
+
+	result := &FetchResult{}
+	var messages []*gmail.Message
+	pageToken := ""
+	for {
+		page, err := gs.listPage(ctx, query, pageToken, 0)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, page.Messages...)
+		pageToken = page.NextPageToken
+		if pageToken == "" || len(messages) > gs.config.MaxMessages {
+			result.MatchedMessages = int64(len(messages))
+			result.MatchedExact = pageToken == ""
+			if !result.MatchedExact && page.ResultSizeEstimate > result.MatchedMessages {
+				result.MatchedMessages = page.ResultSizeEstimate
+			}
+			break
+		}
+	}
+
+	// Gmail lists newest first, so truncating keeps the most recent messages.
+	if len(messages) > gs.config.MaxMessages {
+		logger.Ctx(ctx).Warnf("Truncating %d+ matching messages to the most recent %d", len(messages), gs.config.MaxMessages)
+		messages = messages[:gs.config.MaxMessages]
+		result.Warnings = append(result.Warnings,
+			fmt.Sprintf("results truncated to the most recent %d messages", gs.config.MaxMessages))
+	}
+
+	if gs.config.FetchBudget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, gs.config.FetchBudget)
+		defer cancel()
+	}
+	transactions, warnings, truncated := gs.parseMessages(ctx, messages)
+	result.Transactions = transactions
+	result.Warnings = append(result.Warnings, warnings...)
+	if truncated {
+		result.Truncated = true
+		result.Warnings = append(result.Warnings,
+			"truncated: stopped fetching emails early; showing the most recent transactions only")
+	}
+	return result, nil
+}
 
-	messages, err := gs.service.Users.Messages.List("me").Q(query).Do()
+// listPage runs one messages.list call, mapping Gmail errors to AppErrors. A
+// maxResults of 0 uses Gmail's default page size.
+func (gs *GmailService) listPage(ctx context.Context, query, pageToken string, maxResults int64) (*gmail.ListMessagesResponse, error) {
+	call := gs.service.Users.Messages.List("me").Q(query)
+	if pageToken != "" {
+		call = call.PageToken(pageToken)
+	}
+	if maxResults > 0 {
+		call = call.MaxResults(maxResults)
+	}
+	if err := gs.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	page, err := call.Context(ctx).Do()
+	gs.quota.Record(ctx, CallList, 1)
+	gs.breaker.Record(err)
 	if err != nil {
+		if appErr := gmailDisabledError(err); appErr != nil {
+			return nil, appErr
+		}
 		if gErr, ok := err.(*googleapi.Error); ok && (gErr.Code == 403 || gErr.Code == 401) {
 			return nil, &AppError{
 				Code: http.StatusUnauthorized,
@@ -79,65 +289,449 @@ func (gs *GmailService) FetchTransactions(days int) ([]types.Transaction, error)
 			Msg:  fmt.Sprintf("unable to retrieve messages: %v", err),
 		}
 	}
+	return page, nil
+}
+
+// gmailDisabledError maps Gmail's "Mail service not enabled" failure, which
+// Workspace accounts without Gmail return for every call, to a 403
+// GMAIL_DISABLED AppError. Any other error yields nil.
+func gmailDisabledError(err error) *AppError {
+	gErr, ok := err.(*googleapi.Error)
+	if !ok {
+		return nil
+	}
+	disabled := strings.Contains(strings.ToLower(gErr.Message), "mail service not enabled")
+	for _, item := range gErr.Errors {
+		if strings.Contains(strings.ToLower(item.Message), "mail service not enabled") {
+			disabled = true
+		}
+	}
+	if !disabled {
+		return nil
+	}
+	return &AppError{
+		Code:      http.StatusForbidden,
+		ErrorCode: ErrCodeGmailDisabled,
+		Msg:       "Gmail is not enabled for this account; ask your Workspace administrator to turn it on",
+	}
+}
+
+// parseMessages fetches the listed messages and returns the transactions
+// parsed from them, skipping anything that isn't one. Messages that take
+// longer than the parse timeout are skipped too, and reported as warnings.
+// The bool reports that ctx ended before every message was fetched.
+func (gs *GmailService) parseMessages(ctx context.Context, messages []*gmail.Message) ([]types.Transaction, []string, bool) {
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.Id
+	}
+	fetched, truncated := gs.getMessages(ctx, ids)
+	transactions, warnings := gs.parseFetched(ctx, fetched)
+	return transactions, warnings, truncated
+}
+
+// parseFetched parses full messages into transactions, applying the sender
+// allowlist, date checks and minimum amount, and collapsing repeats within a
+// thread.
+func (gs *GmailService) parseFetched(ctx context.Context, fetched []*gmail.Message) ([]types.Transaction, []string) {
 	var transactions []types.Transaction
-	for _, msg := range messages.Messages {
+	var warnings []string
+	threads := make(map[threadKey][]int)
+	for _, message := range fetched {
+		if message == nil {
+			continue
+		}
+		// Gmail's from: operator matches loosely (display names, substrings), so
+		// check the sender's domain exactly as well.
+		if !senderAllowed(message, gs.senderDomains) {
+			logger.Ctx(ctx).Debugf("Skipping message %s: sender not in allowlist", message.Id)
+			continue
+		}
 
-		message, err := gs.service.Users.Messages.Get("me", msg.Id).Format("full").Do()
-		if err != nil {
-			log.Printf("Error getting message %s: %v", msg.Id, err)
+		// Statements are read before the message is stored so the stored
+		// copy carries their downloaded PDFs.
+		var statement []types.Transaction
+		var statementErr error
+		if gs.config.PDFStatements {
+			statement, statementErr = gs.statementTransactions(ctx, message)
+		}
+		if gs.onMessage != nil {
+			gs.onMessage(gs.storedCopy(message))
+		}
+
+		if statementErr == errParseTimeout {
+			logger.Ctx(ctx).Warnf("Skipping message %s: parsing its statement took longer than %s", message.Id, gs.config.ParseTimeout)
+			warnings = append(warnings, fmt.Sprintf("message %s skipped: parsing timed out", message.Id))
+			continue
+		}
+		if len(statement) > 0 {
+			for i := range statement {
+				keep, warning := gs.admit(ctx, message, &statement[i])
+				if warning != "" {
+					warnings = append(warnings, warning)
+				}
+				if keep {
+					transactions = gs.addTransaction(ctx, transactions, threads, statement[i])
+				}
+			}
 			continue
 		}
 
-		transaction, err := gs.parseTransactionEmail(message)
+		if gs.config.DigestEmails {
+			items, warning, ok, err := gs.digestTransactions(ctx, message)
+			if err == errParseTimeout {
+				logger.Ctx(ctx).Warnf("Skipping message %s: parsing took longer than %s", message.Id, gs.config.ParseTimeout)
+				warnings = append(warnings, fmt.Sprintf("message %s skipped: parsing timed out", message.Id))
+				continue
+			}
+			if ok {
+				if warning != "" {
+					warnings = append(warnings, fmt.Sprintf("message %s: %s", message.Id, warning))
+				}
+				for i := range items {
+					keep, warning := gs.admit(ctx, message, &items[i])
+					if warning != "" {
+						warnings = append(warnings, warning)
+					}
+					if keep {
+						transactions = gs.addTransaction(ctx, transactions, threads, items[i])
+					}
+				}
+				continue
+			}
+		}
+
+		transaction, err := gs.parseWithTimeout(ctx, message)
+		if err == errParseTimeout {
+			logger.Ctx(ctx).Warnf("Skipping message %s: parsing took longer than %s", message.Id, gs.config.ParseTimeout)
+			warnings = append(warnings, fmt.Sprintf("message %s skipped: parsing timed out", message.Id))
+			continue
+		}
+		if err == errBalanceOnly {
+			logger.Ctx(ctx).Debugf("Skipping message %s: balance notification, not a transaction", message.Id)
+			continue
+		}
 		if err != nil {
-			log.Printf("Error parsing message %s: %v", msg.Id, err)
+			logger.Ctx(ctx).Debugf("Error parsing message %s: %v", message.Id, err)
 			continue
 		}
 
-		if transaction != nil {
-			transactions = append(transactions, *transaction)
+		if transaction == nil {
+			continue
+		}
+		keep, warning := gs.admit(ctx, message, transaction)
+		if warning != "" {
+			warnings = append(warnings, warning)
 		}
+		if !keep {
+			continue
+		}
+		transactions = gs.addTransaction(ctx, transactions, threads, *transaction)
 	}
 
-	return transactions, nil
+	return transactions, warnings
 }
 
-func (gs *GmailService) parseTransactionEmail(msg *gmail.Message) (*types.Transaction, error) {
+// admit applies the date checks, exclusions and minimum amount to a parsed
+// transaction, re-dating or flagging it as configured. It reports whether to
+// keep it, and a warning when the user should hear why it was dropped.
+func (gs *GmailService) admit(ctx context.Context, message *gmail.Message, transaction *types.Transaction) (bool, string) {
+	if gs.isImplausiblyOld(*transaction) {
+		if gs.config.OldDatePolicy == config.OldDateDrop {
+			logger.Ctx(ctx).Debugf("Dropping message %s: dated %s, implausibly old", message.Id, transaction.Date)
+			return false, fmt.Sprintf("message %s skipped: implausible date %s", message.Id, transaction.Date)
+		}
+		if !gs.redateFromHeader(transaction, message) {
+			logger.Ctx(ctx).Debugf("Dropping message %s: dated %s, implausibly old, and no usable Date header", message.Id, transaction.Date)
+			return false, ""
+		}
+	}
+	if gs.isFutureDated(*transaction) {
+		if gs.config.FutureDatePolicy != config.FutureDateFlag {
+			logger.Ctx(ctx).Debugf("Dropping message %s: dated %s, in the future", message.Id, transaction.Date)
+			return false, ""
+		}
+		transaction.FutureDated = true
+	}
+	if isExcluded(*transaction, gs.exclusions) {
+		logger.Ctx(ctx).Debugf("Dropping message %s: merchant %q is excluded", message.Id, transaction.Merchant)
+		return false, ""
+	}
+	if transaction.Amount < gs.minAmount {
+		logger.Ctx(ctx).Debugf("Dropping message %s: amount %.2f below minimum %.2f", message.Id, transaction.Amount, gs.minAmount)
+		return false, ""
+	}
+	return true, ""
+}
 
-	body := extractMessageContent(msg.Payload)
+// isFutureDated reports whether a transaction is dated more than the
+// configured tolerance after now, as scheduled-payment notices and misread
+// dates are.
+func (gs *GmailService) isFutureDated(txn types.Transaction) bool {
+	date, err := time.ParseInLocation("2006-01-02", txn.Date, gs.location)
+	if err != nil {
+		return false
+	}
+	return date.After(gs.now().Add(gs.config.FutureDateTolerance))
+}
+
+// isImplausiblyOld reports whether a transaction is dated more than
+// DatePlausibilityYears ago, as a misread two-digit year can be. A limit of
+// 0 turns the check off.
+func (gs *GmailService) isImplausiblyOld(txn types.Transaction) bool {
+	if gs.config.DatePlausibilityYears <= 0 {
+		return false
+	}
+	date, err := time.ParseInLocation("2006-01-02", txn.Date, gs.location)
+	if err != nil {
+		return false
+	}
+	return date.Before(gs.now().AddDate(-gs.config.DatePlausibilityYears, 0, 0))
+}
+
+// redateFromHeader replaces a transaction's date and timestamp with the
+// email's own send time. It reports false when the message has none.
+func (gs *GmailService) redateFromHeader(txn *types.Transaction, msg *gmail.Message) bool {
+	timestamp := transactionTimestamp(&ParseDetails{}, msg)
+	sent, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return false
+	}
+	txn.Date = sent.In(gs.location).Format("2006-01-02")
+	txn.Timestamp = timestamp
+	return true
+}
+
+func (gs *GmailService) emit(txn types.Transaction) {
+	if gs.onTransaction != nil {
+		gs.onTransaction(txn)
+	}
+}
+
+var errParseTimeout = errors.New("parse timed out")
+
+// errBalanceOnly marks an email that only reports an account balance.
+var errBalanceOnly = errors.New("balance notification, not a transaction")
+
+// parseWithTimeout parses a message on its own goroutine so one pathological
+// body can't stall the batch. Go can't interrupt html.Parse or a regex, so a
+// timed-out parse runs on in the background and its result is discarded.
+func (gs *GmailService) parseWithTimeout(ctx context.Context, msg *gmail.Message) (*types.Transaction, error) {
+	var txn *types.Transaction
+	var err error
+	if !runWithTimeout(gs.config.ParseTimeout, func() { txn, err = gs.parseTransactionEmail(ctx, msg) }) {
+		return nil, errParseTimeout
+	}
+	return txn, err
+}
+
+// runWithTimeout runs fn on its own goroutine and reports whether it
+// finished within timeout. When it didn't, fn runs on and the caller must
+// not read anything it writes.
+func runWithTimeout(timeout time.Duration, fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// buildTransactionQuery returns the Gmail search query for the last `days`
+// days ending on now's day, restricted to the given sender domains if any.
+// The bounds are midnights in now's location, given as epoch seconds: Gmail
+// reads date-only bounds as midnight Pacific time, which puts users far from
+// it in the wrong day near midnight. before: is exclusive, so the upper bound
+// is the start of tomorrow to keep today's messages in the window. With
+// statements, emails with a PDF statement attached match too.
+func buildTransactionQuery(days int, now time.Time, senderDomains []string, statements, spamTrash bool) string {
+	start, end := QueryBounds(days, now)
+
+	subject := "subject:(transaction OR payment OR purchase OR UPI txn)"
+	if statements {
+		subject = "{" + subject + " (subject:statement filename:pdf)}"
+	}
+	query := fmt.Sprintf("after:%d before:%d %s", start.Unix(), end.Unix(), subject)
+	if len(senderDomains) > 0 {
+		query += " from:(" + strings.Join(senderDomains, " OR ") + ")"
+	}
+	if spamTrash {
+		query += " in:anywhere"
+	}
+	return query
+}
+
+// SearchedFolders names the mail a fetch searches: Gmail leaves Spam and
+// Trash out unless the query says in:anywhere.
+func SearchedFolders(spamTrash bool) []string {
+	if spamTrash {
+		return []string{"all mail", "spam", "trash"}
+	}
+	return []string{"all mail"}
+}
+
+// QueryBounds returns local midnight `days` days before now and local
+// midnight at the start of the day after now, in now's location. These are
+// the bounds of the Gmail search for a fetch of that many days.
+func QueryBounds(days int, now time.Time) (start, end time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return today.AddDate(0, 0, -days), today.AddDate(0, 0, 1)
+}
+
+// senderAllowed reports whether the message's From address is on one of the
+// allowed domains or their subdomains. An empty allowlist allows everyone.
+func senderAllowed(msg *gmail.Message, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+	sender := senderDomain(msg)
+	for _, domain := range domains {
+		if domainMatches(sender, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// senderDomain returns the lowercased domain of the message's From address,
+// or "" if it can't be read.
+func senderDomain(msg *gmail.Message) string {
+	if msg.Payload == nil {
+		return ""
+	}
+	addr, err := mail.ParseAddress(partHeader(msg.Payload, "From"))
+	if err != nil {
+		return ""
+	}
+	at := strings.LastIndex(addr.Address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(addr.Address[at+1:])
+}
+
+// domainMatches reports whether sender is domain or one of its subdomains.
+func domainMatches(sender, domain string) bool {
+	return sender != "" && (sender == domain || strings.HasSuffix(sender, "."+domain))
+}
+
+// resolveCurrency maps a currency token to its ISO code, preferring the
+// sending issuer's overrides (so an Australian bank's "$" is AUD), then the
+// deployment's, then the built-in defaults.
+func (gs *GmailService) resolveCurrency(symbol, sender string) string {
+	var issuer map[string]string
+	for domain, symbols := range gs.config.IssuerCurrencySymbols {
+		if domainMatches(sender, domain) {
+			issuer = symbols
+			break
+		}
+	}
+	return currencyCode(symbol, issuer, gs.config.CurrencySymbols)
+}
+
+func (gs *GmailService) parseTransactionEmail(ctx context.Context, msg *gmail.Message) (*types.Transaction, error) {
+	body := extractMessageContent(msg.Payload, gs.partPreference(senderDomain(msg)))
 	if body == "" {
 		return nil, fmt.Errorf("no suitable content found in email")
 	}
 
 	body = stripHTMLTags(body)
 
-	amountPattern := regexp.MustCompile(`(?i)Rs.\s*\$?([0-9,.]+)`)
-	datePattern := regexp.MustCompile(`on\s+(\d{2}-\d{2}-\d{2})`)
+	if gs.config.SkipBalanceEmails && isBalanceOnly(body) {
+		return nil, errBalanceOnly
+	}
+	// The subject is a fallback source: some alerts only give the amount or
+	// merchant there ("Txn of Rs.500 at Amazon").
+	subject := strings.TrimSpace(partHeader(msg.Payload, "Subject"))
+	details, err := parseBody(body, gs.rules, gs.now())
+	if subject != "" && (err != nil || details.Merchant == "") {
+		if withSubject, subjectErr := parseBody(body+"\n"+subject, gs.rules, gs.now()); subjectErr == nil {
+			details, err = withSubject, nil
+		}
+	}
+	if err != nil {
+		if gs.config.ParseDebug {
+			step := "unknown"
+			if pErr, ok := err.(*ParseError); ok {
+				step = pErr.Step
+			}
+			logger.Ctx(ctx).Infof("Parse failure for message %s at step %s: %s", msg.Id, step, redactBody(body))
+		}
+		return nil, err
+	}
 
-	amountMatch := amountPattern.FindStringSubmatch(body)
-	dateMatch := datePattern.FindStringSubmatch(body)
+	return gs.newTransaction(details, msg, body, subject), nil
+}
 
-	if len(amountMatch) < 2 || len(dateMatch) < 2 {
-		return nil, fmt.Errorf("could not parse transaction details")
+// newTransaction builds a transaction from parsed details and the message
+// and stripped body they came from.
+func (gs *GmailService) newTransaction(details *ParseDetails, msg *gmail.Message, body, subject string) *types.Transaction {
+	sender := senderDomain(msg)
+	currency := gs.resolveCurrency(details.CurrencySymbol, sender)
+	txn := &types.Transaction{
+		Date:               details.Date,
+		Timestamp:          transactionTimestamp(details, msg),
+		Amount:             RoundAmount(details.Amount, CurrencyDecimals(currency, gs.config.AmountDecimals)),
+		Fee:                RoundAmount(details.Fee, CurrencyDecimals(currency, gs.config.AmountDecimals)),
+		Description:        "Transaction from HTML email",
+		Type:               details.Type,
+		Merchant:           details.Merchant,
+		MerchantNormalized: NormalizeMerchant(details.Merchant, gs.config.MerchantAliases),
+		Currency:           currency,
+		Account:            details.Account,
+		Category:           categorizeWithRules(details.Merchant, gs.categoryRules),
+		MessageID:          msg.Id,
+		ThreadID:           msg.ThreadId,
+		Subject:            subject,
+		IsTransfer:         isSelfTransfer(body, gs.config.TransferKeywords),
+		IsRefund:           details.IsRefund,
+		IsAdjustment:       details.IsAdjustment,
+	}
+	if gs.rawBodies {
+		txn.RawBody = maskPII(body)
 	}
+	gs.applyPolarity(txn, sender)
+	return txn
+}
 
-	amountStr := strings.ReplaceAll(amountMatch[1], ",", "")
-	amount, err := strconv.ParseFloat(amountStr, 64)
-	if err != nil {
-		return nil, fmt.Errorf("could not parse amount: %v", err)
+// applyPolarity adjusts a transaction for a card issuer. A card account runs
+// the other way round: purchases raise what is owed and a credit is the user
+// paying the bill from a bank account, whose debit is already counted. Treat
+// it as a transfer so it isn't income.
+func (gs *GmailService) applyPolarity(txn *types.Transaction, sender string) {
+	if gs.polarity(sender) == config.PolarityCard && txn.Type == types.TransactionTypeCredit && !txn.IsRefund && !txn.IsAdjustment {
+		txn.IsTransfer = true
 	}
+}
 
-	parsedDate, err := time.Parse("02-01-06", dateMatch[1])
+// transactionTimestamp returns the RFC3339 time of a transaction: the parsed
+// date and time of day in the email's timezone when the body gives a time,
+// otherwise the email's own Date header.
+func transactionTimestamp(details *ParseDetails, msg *gmail.Message) string {
+	sent, err := mail.ParseDate(partHeader(msg.Payload, "Date"))
 	if err != nil {
-		return nil, fmt.Errorf("could not parse date: %v", err)
+		if msg.InternalDate == 0 {
+			return ""
+		}
+		sent = time.UnixMilli(msg.InternalDate).UTC()
 	}
-
-	return &types.Transaction{
-		Date:        parsedDate.Format("2006-01-02"),
-		Amount:      amount,
-		Description: "Transaction from HTML email",
-	}, nil
+	if details.Time != "" {
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", details.Date+" "+details.Time, sent.Location())
+		if err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return sent.Format(time.RFC3339)
 }
+
 func stripHTMLTags(htmlContent string) string {
 	doc, err := html.Parse(strings.NewReader(htmlContent))
 	if err != nil {
@@ -159,30 +753,105 @@ func stripHTMLTags(htmlContent string) string {
 	return sb.String()
 }
 
-func extractMessageContent(part *gmail.MessagePart) string {
+// defaultPartPreference is the order MIME types are tried in when neither
+// the deployment nor the sender's issuer configures one.
+var defaultPartPreference = []string{"text/plain", "text/html"}
 
-	if part.MimeType == "text/plain" && part.Body != nil && part.Body.Data != "" {
-		data, err := base64.URLEncoding.DecodeString(part.Body.Data)
-		if err == nil {
-			return string(data)
+// extractMessageContent returns the decoded body of the first part, anywhere
+// in the MIME tree, of the most preferred type that has content.
+func extractMessageContent(part *gmail.MessagePart, preference []string) string {
+	if len(preference) == 0 {
+		preference = defaultPartPreference
+	}
+	for _, mimeType := range preference {
+		if content := findPartContent(part, mimeType); content != "" {
+			return content
 		}
 	}
+	return ""
+}
 
-	if part.MimeType == "text/html" && part.Body != nil && part.Body.Data != "" {
-		data, err := base64.URLEncoding.DecodeString(part.Body.Data)
+func findPartContent(part *gmail.MessagePart, mimeType string) string {
+	if strings.EqualFold(part.MimeType, mimeType) && isTextBody(part) && part.Body != nil && part.Body.Data != "" {
+		data, err := decodePartBody(part)
 		if err == nil {
 			return string(data)
 		}
 	}
 
 	for _, nestedPart := range part.Parts {
-		if content := extractMessageContent(nestedPart); content != "" {
+		if content := findPartContent(nestedPart, mimeType); content != "" {
 			return content
 		}
 	}
 	return ""
 }
 
+// isTextBody reports whether a part can be message text: a text/* part that
+// isn't an attachment. Inline images and other binary parts of a
+// multipart/related message never are, whatever the part preference says.
+func isTextBody(part *gmail.MessagePart) bool {
+	if !strings.HasPrefix(strings.ToLower(part.MimeType), "text/") {
+		return false
+	}
+	if part.Filename != "" {
+		return false
+	}
+	disposition := strings.ToLower(partHeader(part, "Content-Disposition"))
+	return !strings.HasPrefix(disposition, "attachment")
+}
+
+// polarity returns the configured account polarity for a sender, treating
+// unconfigured senders as bank accounts.
+func (gs *GmailService) polarity(sender string) string {
+	for domain, polarity := range gs.config.IssuerPolarity {
+		if domainMatches(sender, domain) {
+			return polarity
+		}
+	}
+	return config.PolarityBank
+}
+
+// partPreference returns the MIME part order for a message: the sending
+// issuer's if configured (some banks only put the details in the HTML part),
+// otherwise the deployment's.
+func (gs *GmailService) partPreference(sender string) []string {
+	for domain, preference := range gs.config.IssuerPartPreference {
+		if domainMatches(sender, domain) {
+			return preference
+		}
+	}
+	return gs.config.PartPreference
+}
+
+// decodePartBody base64-decodes the part body and then undoes any
+// quoted-printable transfer encoding declared in the part headers.
+func decodePartBody(part *gmail.MessagePart) ([]byte, error) {
+	data, err := base64.URLEncoding.DecodeString(part.Body.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(partHeader(part, "Content-Transfer-Encoding"), "quoted-printable") {
+		return data, nil
+	}
+
+	decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode quoted-printable body: %v", err)
+	}
+	return decoded, nil
+}
+
+func partHeader(part *gmail.MessagePart, name string) string {
+	for _, h := range part.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return strings.TrimSpace(h.Value)
+		}
+	}
+	return ""
+}
+
 func TokenFromFile(file string) (*oauth2.Token, error) {
 	f, err := os.Open(file)
 	if err != nil {
@@ -197,7 +866,7 @@ func TokenFromFile(file string) (*oauth2.Token, error) {
 func SaveToken(path string, token *oauth2.Token) {
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		logger.Fatalf("Unable to cache oauth token: %v", err)
 	}
 	defer f.Close()
 	json.NewEncoder(f).Encode(token)
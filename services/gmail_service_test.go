@@ -0,0 +1,835 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"google.golang.org/api/gmail/v1"
+)
+
+// testNow is the clock every test service runs on.
+var testNow = time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+// newTestService returns a service reading from a fresh fake mailbox, with
+// the default configuration in UTC as adjusted by configure.
+func newTestService(t *testing.T, configure func(*config.Config)) (*GmailService, *gmailtest.Server) {
+	t.Helper()
+	cfg := config.LoadConfig()
+	cfg.Location = time.UTC
+	if configure != nil {
+		configure(cfg)
+	}
+	fake := gmailtest.Run(t, "user@example.com")
+	gs, err := NewGmailServiceWithClient(cfg, fake.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs.SetClock(func() time.Time { return testNow })
+	return gs, fake
+}
+
+// textPart builds a message part with the given type, transfer encoding and
+// undecoded content.
+func textPart(mimeType, encoding, content string) *gmail.MessagePart {
+	part := &gmail.MessagePart{
+		MimeType: mimeType,
+		Body:     &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(content))},
+	}
+	if encoding != "" {
+		part.Headers = []*gmail.MessagePartHeader{{Name: "Content-Transfer-Encoding", Value: encoding}}
+	}
+	return part
+}
+
+func TestParseTransactionEmailTransferEncodings(t *testing.T) {
+	tests := []struct {
+		name     string
+		part     *gmail.MessagePart
+		amount   float64
+		merchant string
+	}{
+		{
+			name:     "plain",
+			part:     textPart("text/plain", "", "Rs.1,250.00 debited from your account at AMAZON on 12-03-24."),
+			amount:   1250,
+			merchant: "AMAZON",
+		},
+		{
+			name: "quoted-printable html",
+			part: textPart("text/html", "quoted-printable",
+				"<p style=3D\"color:red\">Rs.1,2=\r\n50.00 debited from your account at AMAZON on 12-03-24.</p>"),
+			amount:   1250,
+			merchant: "AMAZON",
+		},
+		{
+			name:     "quoted-printable with encoded rupee sign",
+			part:     textPart("text/plain", "Quoted-Printable", "=E2=82=B9499.50 spent at SWIGGY on 11-03-24."),
+			amount:   499.5,
+			merchant: "SWIGGY",
+		},
+		{
+			name: "quoted-printable part nested in multipart",
+			part: &gmail.MessagePart{
+				MimeType: "multipart/alternative",
+				Parts: []*gmail.MessagePart{
+					textPart("text/plain", "quoted-printable", "Rs.75.00 paid to UBER on=\n 10-03-24."),
+				},
+			},
+			amount:   75,
+			merchant: "UBER",
+		},
+	}
+
+	gs, _ := newTestService(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txn, err := gs.parseTransactionEmail(context.Background(), &gmail.Message{Id: "m1", Payload: tt.part})
+			if err != nil {
+				t.Fatalf("parseTransactionEmail: %v", err)
+			}
+			if txn.Amount != tt.amount || txn.Merchant != tt.merchant {
+				t.Errorf("got amount %v merchant %q, want %v %q", txn.Amount, txn.Merchant, tt.amount, tt.merchant)
+			}
+		})
+	}
+}
+
+func TestBuildTransactionQueryBounds(t *testing.T) {
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	cfg := config.LoadConfig()
+	tests := []struct {
+		filter string
+		days   int
+		now    time.Time
+		after  time.Time
+		before time.Time
+	}{
+		{"daily", cfg.DailyWindowDays, testNow,
+			time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"weekly", cfg.WeeklyWindowDays, testNow,
+			time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"monthly", cfg.MonthlyWindowDays, testNow,
+			time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"all", cfg.AllWindowDays, testNow,
+			time.Date(2023, 12, 16, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+		// Late evening in India is still today there, though UTC has moved on.
+		{"daily in IST", cfg.DailyWindowDays, time.Date(2024, 3, 15, 23, 30, 0, 0, kolkata),
+			time.Date(2024, 3, 13, 0, 0, 0, 0, kolkata), time.Date(2024, 3, 16, 0, 0, 0, 0, kolkata)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			query := buildTransactionQuery(tt.days, tt.now, nil, false, false)
+			want := fmt.Sprintf("after:%d before:%d ", tt.after.Unix(), tt.before.Unix())
+			if !strings.HasPrefix(query, want) {
+				t.Errorf("query %q does not start with %q", query, want)
+			}
+			if !tt.now.Before(tt.before) {
+				t.Errorf("before bound %s excludes now %s", tt.before, tt.now)
+			}
+		})
+	}
+}
+
+// debitEmail builds a debit alert for amount at merchant, sent on day.
+func debitEmail(id string, day time.Time, amount float64, merchant string) *gmail.Message {
+	body := fmt.Sprintf("Rs.%.2f debited from your account at %s on %s.", amount, merchant, day.Format("02-01-06"))
+	return gmailtest.Email(id, "alerts@hdfcbank.net", "Transaction alert", body, day.Add(10*time.Hour))
+}
+
+func TestFetchTransactionsMaxMessages(t *testing.T) {
+	tests := []struct {
+		name        string
+		mailbox     int
+		maxMessages int
+		pageSize    int
+		want        int
+		truncated   bool
+	}{
+		{name: "under the cap", mailbox: 3, maxMessages: 5, pageSize: 100, want: 3},
+		{name: "at the cap", mailbox: 5, maxMessages: 5, pageSize: 100, want: 5},
+		{name: "over the cap", mailbox: 8, maxMessages: 5, pageSize: 100, want: 5, truncated: true},
+		{name: "over the cap across pages", mailbox: 8, maxMessages: 5, pageSize: 2, want: 5, truncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, func(cfg *config.Config) { cfg.MaxMessages = tt.maxMessages })
+			fake.SetPageSize(tt.pageSize)
+			for i := 0; i < tt.mailbox; i++ {
+				fake.Add(debitEmail(fmt.Sprintf("m%d", i), testNow.AddDate(0, 0, -tt.mailbox+i), float64(100+i), "AMAZON"))
+			}
+
+			result, err := gs.FetchTransactions(context.Background(), 30)
+			if err != nil {
+				t.Fatalf("FetchTransactions: %v", err)
+			}
+			if len(result.Transactions) != tt.want {
+				t.Fatalf("got %d transactions, want %d", len(result.Transactions), tt.want)
+			}
+			// The most recent messages are the ones kept.
+			if newest := float64(100 + tt.mailbox - 1); result.Transactions[0].Amount != newest {
+				t.Errorf("first transaction amount = %v, want the newest, %v", result.Transactions[0].Amount, newest)
+			}
+			warned := false
+			for _, w := range result.Warnings {
+				if strings.Contains(w, fmt.Sprintf("truncated to the most recent %d messages", tt.maxMessages)) {
+					warned = true
+				}
+			}
+			if warned != tt.truncated {
+				t.Errorf("truncation warning = %v, want %v (warnings %q)", warned, tt.truncated, result.Warnings)
+			}
+			if got := fake.Calls(gmailtest.Get); got != tt.want {
+				t.Errorf("fetched %d message bodies, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchTransactionsUsesClock(t *testing.T) {
+	tests := []struct {
+		name          string
+		now           time.Time
+		days          int
+		after, before string
+	}{
+		{"mid-month", testNow, 7, "2024-03-08", "2024-03-16"},
+		{"across a month end", time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC), 7, "2024-02-24", "2024-03-03"},
+		{"last second of the day", time.Date(2024, 3, 15, 23, 59, 59, 0, time.UTC), 1, "2024-03-14", "2024-03-16"},
+		{"historical", time.Date(2022, 12, 31, 12, 0, 0, 0, time.UTC), 30, "2022-12-01", "2023-01-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, nil)
+			gs.SetClock(func() time.Time { return tt.now })
+			if _, err := gs.FetchTransactions(context.Background(), tt.days); err != nil {
+				t.Fatal(err)
+			}
+			after, _ := time.Parse("2006-01-02", tt.after)
+			before, _ := time.Parse("2006-01-02", tt.before)
+			want := fmt.Sprintf("after:%d before:%d ", after.Unix(), before.Unix())
+			queries := fake.Queries()
+			if len(queries) != 1 || !strings.HasPrefix(queries[0], want) {
+				t.Errorf("queries = %q, want one starting %q", queries, want)
+			}
+		})
+	}
+}
+
+func TestTransactionTimestamp(t *testing.T) {
+	const sent = "Tue, 12 Mar 2024 21:05:00 +0530"
+	tests := []struct {
+		name string
+		body string
+		date string
+		want string
+	}{
+		{"at hh:mm", "Rs.100.00 debited from your account at AMAZON on 12-03-24 at 14:35.", sent, "2024-03-12T14:35:00+05:30"},
+		{"comma and PM", "Rs.100.00 debited from your account at AMAZON on 12-03-24, 02:35 PM.", sent, "2024-03-12T14:35:00+05:30"},
+		{"seconds", "Rs.100.00 debited from your account at AMAZON on 12-03-24 09:05:07.", sent, "2024-03-12T09:05:07+05:30"},
+		{"dotted", "Rs.100.00 debited from your account at AMAZON on 12-03-24 at 9.05 am.", sent, "2024-03-12T09:05:00+05:30"},
+		{"midnight AM", "Rs.100.00 debited from your account at AMAZON on 12-03-24 12:10 AM.", sent, "2024-03-12T00:10:00+05:30"},
+		{"no time falls back to the header", "Rs.100.00 debited from your account at AMAZON on 12-03-24.", sent, "2024-03-12T21:05:00+05:30"},
+		{"impossible time falls back", "Rs.100.00 debited from your account at AMAZON on 12-03-24 at 13:00 PM.", sent, "2024-03-12T21:05:00+05:30"},
+		{"no header uses internalDate", "Rs.100.00 debited from your account at AMAZON on 12-03-24.", "", "2024-03-12T15:35:00Z"},
+	}
+	gs, _ := newTestService(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			part := textPart("text/plain", "", tt.body)
+			if tt.date != "" {
+				part.Headers = append(part.Headers, &gmail.MessagePartHeader{Name: "Date", Value: tt.date})
+			}
+			msg := &gmail.Message{Id: "m1", Payload: part, InternalDate: time.Date(2024, 3, 12, 15, 35, 0, 0, time.UTC).UnixMilli()}
+			txn, err := gs.parseTransactionEmail(context.Background(), msg)
+			if err != nil {
+				t.Fatalf("parseTransactionEmail: %v", err)
+			}
+			if txn.Timestamp != tt.want {
+				t.Errorf("timestamp = %q, want %q", txn.Timestamp, tt.want)
+			}
+			if txn.Date != "2024-03-12" {
+				t.Errorf("date = %q, want it kept as 2024-03-12", txn.Date)
+			}
+		})
+	}
+}
+
+func TestSenderAllowlist(t *testing.T) {
+	day := testNow.AddDate(0, 0, -1)
+	mail := []struct{ id, from string }{
+		{"m1", "HDFC Bank <alerts@hdfcbank.net>"},
+		{"m2", "alerts@mail.icicibank.com"},
+		{"m3", "\"hdfcbank.net\" <promo@shop.example>"},
+		{"m4", "alerts@hdfcbank.net.evil.example"},
+		{"m5", "alerts@nothdfcbank.net"},
+	}
+	tests := []struct {
+		name    string
+		domains []string
+		clause  string
+		want    []string
+	}{
+		{"empty allowlist processes all", nil, "", []string{"m5", "m4", "m3", "m2", "m1"}},
+		{"one domain", []string{"hdfcbank.net"}, " from:(hdfcbank.net)", []string{"m1"}},
+		{"subdomains match", []string{"hdfcbank.net", "icicibank.com"}, " from:(hdfcbank.net OR icicibank.com)", []string{"m2", "m1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, nil)
+			for _, m := range mail {
+				body := fmt.Sprintf("Rs.100.00 debited from your account at AMAZON on %s.", day.Format("02-01-06"))
+				fake.Add(gmailtest.Email(m.id, m.from, "Transaction alert", body, day))
+			}
+			gs.SetSenderDomains(tt.domains)
+			result, err := gs.FetchTransactions(context.Background(), 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := fake.Queries()[0]
+			if tt.clause == "" && strings.Contains(query, "from:") {
+				t.Errorf("query %q has a from: clause", query)
+			}
+			if tt.clause != "" && !strings.Contains(query, tt.clause) {
+				t.Errorf("query %q lacks %q", query, tt.clause)
+			}
+			var got []string
+			for _, txn := range result.Transactions {
+				got = append(got, txn.MessageID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("parsed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveAmbiguousCurrency(t *testing.T) {
+	tests := []struct {
+		name       string
+		deployment map[string]string
+		issuers    map[string]map[string]string
+		from       string
+		body       string
+		want       string
+	}{
+		{"$ defaults to USD", nil, nil, "alerts@bank.example", "$12.50 spent at STEAM on 12-03-24.", "USD"},
+		{"₹ is INR", nil, nil, "alerts@bank.example", "₹12.50 spent at STEAM on 12-03-24.", "INR"},
+		{"Rs is INR", nil, nil, "alerts@bank.example", "Rs.12.50 spent at STEAM on 12-03-24.", "INR"},
+		{"deployment override", map[string]string{"$": "CAD"}, nil,
+			"alerts@bank.example", "$12.50 spent at STEAM on 12-03-24.", "CAD"},
+		{"issuer override", map[string]string{"$": "CAD"}, map[string]map[string]string{"commbank.com.au": {"$": "AUD"}},
+			"CommBank <alerts@commbank.com.au>", "$12.50 spent at STEAM on 12-03-24.", "AUD"},
+		{"issuer override covers subdomains", nil, map[string]map[string]string{"commbank.com.au": {"$": "AUD"}},
+			"notify@mail.commbank.com.au", "$12.50 spent at STEAM on 12-03-24.", "AUD"},
+		{"other issuers keep the deployment mapping", map[string]string{"$": "CAD"}, map[string]map[string]string{"commbank.com.au": {"$": "AUD"}},
+			"alerts@td.example", "$12.50 spent at STEAM on 12-03-24.", "CAD"},
+		{"ISO codes are left alone", map[string]string{"$": "CAD"}, nil,
+			"alerts@bank.example", "USD 12.50 spent at STEAM on 12-03-24.", "USD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, _ := newTestService(t, func(c *config.Config) {
+				c.CurrencySymbols = tt.deployment
+				c.IssuerCurrencySymbols = tt.issuers
+			})
+			msg := gmailtest.Email("m1", tt.from, "Transaction alert", tt.body, testNow)
+			txn, err := gs.parseTransactionEmail(context.Background(), msg)
+			if err != nil {
+				t.Fatalf("parseTransactionEmail: %v", err)
+			}
+			if txn.Currency != tt.want {
+				t.Errorf("currency = %q, want %q", txn.Currency, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssuerPartPreference(t *testing.T) {
+	// The bank's plain part is a teaser; only the html part has the details.
+	msg := &gmail.Message{Id: "m1", Payload: &gmail.MessagePart{
+		MimeType: "multipart/alternative",
+		Headers:  []*gmail.MessagePartHeader{{Name: "From", Value: "alerts@hdfcbank.net"}},
+		Parts: []*gmail.MessagePart{
+			textPart("text/plain", "", "You have a new account alert. View it in your inbox."),
+			textPart("text/html", "", "<p>Rs.640.00 debited from your account at ZOMATO on 12-03-24.</p>"),
+		},
+	}}
+	html := []string{"text/html", "text/plain"}
+	plain := []string{"text/plain", "text/html"}
+
+	tests := []struct {
+		name       string
+		preference []string
+		issuers    map[string][]string
+		parsed     bool
+	}{
+		{name: "default prefers plain", parsed: false},
+		{name: "deployment prefers html", preference: html, parsed: true},
+		{name: "issuer flips the default", issuers: map[string][]string{"hdfcbank.net": html}, parsed: true},
+		{name: "partial domain is another issuer", issuers: map[string][]string{"bank.net": html}, parsed: false},
+		{name: "other issuer's override ignored", issuers: map[string][]string{"icicibank.com": html}, parsed: false},
+		{name: "issuer overrides deployment", preference: html, issuers: map[string][]string{"hdfcbank.net": plain}, parsed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, _ := newTestService(t, func(cfg *config.Config) {
+				if tt.preference != nil {
+					cfg.PartPreference = tt.preference
+				}
+				cfg.IssuerPartPreference = tt.issuers
+			})
+			txn, err := gs.parseTransactionEmail(context.Background(), msg)
+			if got := err == nil && txn.Amount == 640; got != tt.parsed {
+				t.Errorf("parsed the html amount = %v (txn %+v, err %v), want %v", got, txn, err, tt.parsed)
+			}
+		})
+	}
+}
+
+func TestMatchedMessageCount(t *testing.T) {
+	tests := []struct {
+		name        string
+		mailbox     int
+		maxMessages int
+		pageSize    int
+		paged       bool
+		want        int64
+		exact       bool
+		gets        int
+	}{
+		{name: "whole listing", mailbox: 4, maxMessages: 10, pageSize: 100, want: 4, exact: true, gets: 4},
+		{name: "bodies capped, count is not", mailbox: 6, maxMessages: 2, pageSize: 100, want: 6, exact: true, gets: 2},
+		{name: "listing cut short uses the estimate", mailbox: 9, maxMessages: 2, pageSize: 2, want: 9, exact: false, gets: 2},
+		{name: "single page", mailbox: 3, pageSize: 5, paged: true, want: 3, exact: true, gets: 3},
+		{name: "first of several pages", mailbox: 7, pageSize: 3, paged: true, want: 7, exact: false, gets: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, func(cfg *config.Config) {
+				if tt.maxMessages > 0 {
+					cfg.MaxMessages = tt.maxMessages
+				}
+			})
+			fake.SetPageSize(tt.pageSize)
+			for i := 0; i < tt.mailbox; i++ {
+				fake.Add(debitEmail(fmt.Sprintf("m%d", i), testNow.AddDate(0, 0, -tt.mailbox+i), float64(100+i), "AMAZON"))
+			}
+
+			var result *FetchResult
+			var err error
+			if tt.paged {
+				result, err = gs.FetchTransactionsPage(context.Background(), 30, "", int64(tt.pageSize))
+			} else {
+				result, err = gs.FetchTransactions(context.Background(), 30)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.MatchedMessages != tt.want || result.MatchedExact != tt.exact {
+				t.Errorf("matched %d (exact %v), want %d (exact %v)", result.MatchedMessages, result.MatchedExact, tt.want, tt.exact)
+			}
+			if got := fake.Calls(gmailtest.Get); got != tt.gets {
+				t.Errorf("fetched %d bodies, want %d", got, tt.gets)
+			}
+		})
+	}
+}
+
+func TestFetchTransactionsQueryZone(t *testing.T) {
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	newYork := time.FixedZone("EST", -5*3600)
+	tests := []struct {
+		name     string
+		location *time.Location
+		now      time.Time
+		after    time.Time
+		before   time.Time
+	}{
+		// 00:15 IST on the 16th is still the 15th in UTC.
+		{"just after midnight in IST", kolkata, time.Date(2024, 3, 15, 18, 45, 0, 0, time.UTC),
+			time.Date(2024, 3, 15, 0, 0, 0, 0, kolkata), time.Date(2024, 3, 17, 0, 0, 0, 0, kolkata)},
+		// 23:45 IST on the 15th.
+		{"just before midnight in IST", kolkata, time.Date(2024, 3, 15, 18, 15, 0, 0, time.UTC),
+			time.Date(2024, 3, 14, 0, 0, 0, 0, kolkata), time.Date(2024, 3, 16, 0, 0, 0, 0, kolkata)},
+		// 23:30 EST on the 14th is already the 15th in UTC.
+		{"before midnight west of UTC", newYork, time.Date(2024, 3, 15, 4, 30, 0, 0, time.UTC),
+			time.Date(2024, 3, 13, 0, 0, 0, 0, newYork), time.Date(2024, 3, 15, 0, 0, 0, 0, newYork)},
+		{"UTC", time.UTC, time.Date(2024, 3, 15, 23, 59, 0, 0, time.UTC),
+			time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, func(cfg *config.Config) { cfg.Location = tt.location })
+			gs.SetClock(func() time.Time { return tt.now })
+			if _, err := gs.FetchTransactions(context.Background(), 1); err != nil {
+				t.Fatal(err)
+			}
+			queries := fake.Queries()
+			want := fmt.Sprintf("after:%d before:%d ", tt.after.Unix(), tt.before.Unix())
+			if len(queries) != 1 || !strings.HasPrefix(queries[0], want) {
+				t.Errorf("queries %q, want one starting %q", queries, want)
+			}
+		})
+	}
+}
+
+func TestParsedAmountRounding(t *testing.T) {
+	tests := []struct {
+		name     string
+		decimals int
+		body     string
+		amount   float64
+	}{
+		{"two decimals", 2, "INR 1234.56 spent at AMAZON on 12-03-24", 1234.56},
+		{"one decimal", 1, "INR 1234.56 spent at AMAZON on 12-03-24", 1234.6},
+		{"whole units", 0, "INR 1234.49 spent at AMAZON on 12-03-24", 1234},
+		{"half rounds away from zero", 0, "INR 10.50 spent at AMAZON on 12-03-24", 11},
+		{"currency precision wins", 2, "JPY 1234.6 spent at AMAZON on 12-03-24", 1235},
+		{"three-decimal currency keeps its mils", 2, "KWD 12.345 spent at ALSHAYA on 12-03-24", 12.345},
+		{"three-decimal currency ignores whole units", 0, "BHD 4.755 spent at LULU on 12-03-24", 4.755},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, _ := newTestService(t, func(cfg *config.Config) { cfg.AmountDecimals = tt.decimals })
+			txn, err := gs.parseTransactionEmail(context.Background(), &gmail.Message{Id: "m1", Payload: textPart("text/plain", "", tt.body)})
+			if err != nil {
+				t.Fatalf("parseTransactionEmail: %v", err)
+			}
+			if txn.Amount != tt.amount {
+				t.Errorf("amount %v, want %v", txn.Amount, tt.amount)
+			}
+		})
+	}
+}
+
+func TestParseTimeoutSkipsMessage(t *testing.T) {
+	// Deeply nested markup is slow to parse: far slower than the timeout.
+	huge := "<html><body>" + strings.Repeat("<div><span>Rs.1.00 debited at NOWHERE on 14-03-24 ", 200000) + "</body></html>"
+	hugeEmail := gmailtest.Email("huge", "alerts@hdfcbank.net", "Transaction alert", huge, testNow.Add(-time.Hour))
+	hugeEmail.Payload.MimeType = "text/html"
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		messages []*gmail.Message
+		want     int
+		skipped  bool
+	}{
+		{"normal email within the timeout", 200 * time.Millisecond,
+			[]*gmail.Message{debitEmail("m1", testNow.AddDate(0, 0, -1), 250, "AMAZON")}, 1, false},
+		{"huge email skipped, batch continues", 20 * time.Millisecond,
+			[]*gmail.Message{debitEmail("m1", testNow.AddDate(0, 0, -1), 250, "AMAZON"), hugeEmail}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, func(cfg *config.Config) { cfg.ParseTimeout = tt.timeout })
+			fake.Add(tt.messages...)
+			start := time.Now()
+			result, err := gs.FetchTransactions(context.Background(), 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("fetch took %s; the huge email stalled the batch", elapsed)
+			}
+			if len(result.Transactions) != tt.want {
+				t.Errorf("got %d transactions, want %d", len(result.Transactions), tt.want)
+			}
+			skipped := strings.Contains(strings.Join(result.Warnings, "\n"), "huge skipped: parsing timed out")
+			if skipped != tt.skipped {
+				t.Errorf("warnings %q, want skip warning %v", result.Warnings, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestIssuerPolarity(t *testing.T) {
+	const (
+		payment  = "Payment of Rs.5,000.00 received towards your account on 12-03-24."
+		purchase = "Rs.1,200.00 spent at AMAZON on 12-03-24."
+		refund   = "Refund of Rs.300.00 from AMAZON credited on 12-03-24."
+	)
+	tests := []struct {
+		name     string
+		from     string
+		body     string
+		txnType  string
+		transfer bool
+	}{
+		{"card payment is a transfer", "alerts@sbicard.com", payment, "credit", true},
+		{"card subdomain payment", "no-reply@mail.sbicard.com", payment, "credit", true},
+		{"card purchase", "alerts@sbicard.com", purchase, "debit", false},
+		{"card refund is still a refund", "alerts@sbicard.com", refund, "credit", false},
+		{"bank credit is income", "alerts@hdfcbank.net", payment, "credit", false},
+		{"bank debit", "alerts@hdfcbank.net", purchase, "debit", false},
+		{"unconfigured issuer", "alerts@examplebank.com", payment, "credit", false},
+	}
+	gs, _ := newTestService(t, func(cfg *config.Config) {
+		cfg.IssuerPolarity = map[string]string{"sbicard.com": config.PolarityCard, "hdfcbank.net": config.PolarityBank}
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txn, err := gs.parseTransactionEmail(context.Background(), gmailtest.Email("m1", tt.from, "Alert", tt.body, testNow))
+			if err != nil {
+				t.Fatalf("parseTransactionEmail: %v", err)
+			}
+			if txn.Type != tt.txnType || txn.IsTransfer != tt.transfer {
+				t.Errorf("type %s transfer %v, want %s %v", txn.Type, txn.IsTransfer, tt.txnType, tt.transfer)
+			}
+		})
+	}
+}
+
+func TestBalanceEmailsSkipped(t *testing.T) {
+	balance := gmailtest.Email("b1", "alerts@hdfcbank.net", "Balance update",
+		"Your available balance in A/c XX1234 is Rs.25,000.00 as on 14-03-24.", testNow.Add(-time.Hour))
+	tests := []struct {
+		name     string
+		skip     bool
+		messages []*gmail.Message
+		want     []float64
+	}{
+		{"balance email alone", true, []*gmail.Message{balance}, nil},
+		{"balance email among debits", true, []*gmail.Message{debitEmail("m1", testNow.AddDate(0, 0, -1), 250, "AMAZON"), balance}, []float64{250}},
+		{"debit mentioning balance kept", true, []*gmail.Message{gmailtest.Email("m2", "alerts@hdfcbank.net", "Transaction alert",
+			"Rs.250.00 debited from A/c XX1234 at AMAZON on 14-03-24. Avl Bal Rs.24,750.00", testNow.Add(-time.Hour))}, []float64{250}},
+		{"skipping disabled", false, []*gmail.Message{balance}, []float64{25000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, func(cfg *config.Config) { cfg.SkipBalanceEmails = tt.skip })
+			fake.Add(tt.messages...)
+			result, err := gs.FetchTransactions(context.Background(), 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []float64
+			for _, txn := range result.Transactions {
+				got = append(got, txn.Amount)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("amounts %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchBudget(t *testing.T) {
+	fetchAll := func(ctx context.Context, gs *GmailService) (*FetchResult, error) {
+		return gs.FetchTransactions(ctx, 30)
+	}
+	fetchPage := func(ctx context.Context, gs *GmailService) (*FetchResult, error) {
+		return gs.FetchTransactionsPage(ctx, 30, "", 200)
+	}
+	tests := []struct {
+		name      string
+		budget    time.Duration
+		cancelAt  int
+		fetch     func(context.Context, *GmailService) (*FetchResult, error)
+		want      int
+		truncated bool
+	}{
+		{name: "no budget waits for every batch", fetch: fetchAll, want: 150},
+		{name: "generous budget", budget: 5 * time.Second, fetch: fetchAll, want: 150},
+		{name: "budget runs out after the first batch", budget: 450 * time.Millisecond, fetch: fetchAll, want: 100, truncated: true},
+		{name: "request canceled before the budget", budget: 5 * time.Second, cancelAt: 2, fetch: fetchAll, want: 100, truncated: true},
+		{name: "page respects the request context", cancelAt: 2, fetch: fetchPage, want: 100, truncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, func(cfg *config.Config) {
+				cfg.FetchBudget = tt.budget
+				cfg.MaxMessages = 500
+			})
+			for i := 0; i < 150; i++ {
+				fake.Add(debitEmail(fmt.Sprintf("m%d", i), testNow.AddDate(0, 0, -1), float64(100+i), "AMAZON"))
+			}
+			fake.SetDelay(gmailtest.Batch, 300*time.Millisecond)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAt > 0 {
+				// The client goes away while the cancelAt'th batch is in flight.
+				go func() {
+					for fake.Calls(gmailtest.Batch) < tt.cancelAt {
+						time.Sleep(time.Millisecond)
+					}
+					cancel()
+				}()
+			}
+
+			result, err := tt.fetch(ctx, gs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Transactions) != tt.want || result.Truncated != tt.truncated {
+				t.Errorf("got %d transactions, truncated %v; want %d, %v", len(result.Transactions), result.Truncated, tt.want, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestSubjectAsParseSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		subject  string
+		body     string
+		amount   float64
+		merchant string
+		wantErr  bool
+	}{
+		{"amount and merchant only in subject", "Txn of Rs.500.00 at Amazon",
+			"A transaction was made on your card ending 1234 on 14-03-24.", 500, "Amazon", false},
+		{"merchant only in subject", "Purchase at SWIGGY",
+			"Rs.349.00 debited from your account on 14-03-24.", 349, "SWIGGY", false},
+		{"body wins when complete", "Txn of Rs.999.00 at Flipkart",
+			"Rs.250.00 debited from your account at AMAZON on 14-03-24.", 250, "AMAZON", false},
+		{"neither has an amount", "Your statement is ready",
+			"Your account statement for March is attached.", 0, "", true},
+	}
+	gs, _ := newTestService(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txn, err := gs.parseTransactionEmail(context.Background(), gmailtest.Email("m1", "alerts@hdfcbank.net", tt.subject, tt.body, testNow))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if txn.Amount != tt.amount || txn.Merchant != tt.merchant {
+				t.Errorf("got %v at %q, want %v at %q", txn.Amount, txn.Merchant, tt.amount, tt.merchant)
+			}
+			if txn.Subject != tt.subject {
+				t.Errorf("subject %q, want %q", txn.Subject, tt.subject)
+			}
+		})
+	}
+}
+
+func TestFutureDatedTransactions(t *testing.T) {
+	tests := []struct {
+		name      string
+		date      string
+		policy    string
+		tolerance time.Duration
+		keep      bool
+		flagged   bool
+	}{
+		{"today", "15-03-24", config.FutureDateReject, 24 * time.Hour, true, false},
+		{"tomorrow within tolerance", "16-03-24", config.FutureDateReject, 24 * time.Hour, true, false},
+		{"beyond tolerance rejected", "18-03-24", config.FutureDateReject, 24 * time.Hour, false, false},
+		{"beyond tolerance flagged", "18-03-24", config.FutureDateFlag, 24 * time.Hour, true, true},
+		{"wider tolerance", "18-03-24", config.FutureDateReject, 96 * time.Hour, true, false},
+		{"ambiguous year in the past", "18-03-70", config.FutureDateReject, 24 * time.Hour, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, func(cfg *config.Config) {
+				cfg.FutureDatePolicy = tt.policy
+				cfg.FutureDateTolerance = tt.tolerance
+				cfg.OldDatePolicy = config.OldDateDrop
+			})
+			fake.Add(gmailtest.Email("m1", "alerts@hdfcbank.net", "Transaction alert",
+				"Rs.250.00 debited from your account at AMAZON on "+tt.date+".", testNow.Add(-time.Hour)))
+			result, err := gs.FetchTransactions(context.Background(), 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(result.Transactions) == 1; got != tt.keep {
+				t.Fatalf("kept = %v, want %v", got, tt.keep)
+			}
+			if tt.keep && result.Transactions[0].FutureDated != tt.flagged {
+				t.Errorf("futureDated = %v, want %v", result.Transactions[0].FutureDated, tt.flagged)
+			}
+		})
+	}
+}
+
+func TestImplausiblyOldDates(t *testing.T) {
+	tests := []struct {
+		name     string
+		date     string
+		years    int
+		policy   string
+		wantDate string
+		warning  bool
+	}{
+		{"recent date kept", "12-03-22", 5, config.OldDateHeader, "2022-03-12", false},
+		{"old date re-dated from header", "12-03-06", 5, config.OldDateHeader, "2024-03-15", false},
+		{"old date dropped", "12-03-06", 5, config.OldDateDrop, "", true},
+		{"wider window keeps it", "12-03-06", 20, config.OldDateDrop, "2006-03-12", false},
+		{"check off", "12-03-06", 0, config.OldDateDrop, "2006-03-12", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, func(cfg *config.Config) {
+				cfg.DatePlausibilityYears = tt.years
+				cfg.OldDatePolicy = tt.policy
+			})
+			fake.Add(gmailtest.Email("m1", "alerts@hdfcbank.net", "Transaction alert",
+				"Rs.250.00 debited from your account at AMAZON on "+tt.date+".", testNow.Add(-time.Hour)))
+			result, err := gs.FetchTransactions(context.Background(), 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotDate string
+			if len(result.Transactions) == 1 {
+				gotDate = result.Transactions[0].Date
+			}
+			if gotDate != tt.wantDate {
+				t.Errorf("date = %q, want %q", gotDate, tt.wantDate)
+			}
+			warned := false
+			for _, w := range result.Warnings {
+				warned = warned || strings.Contains(w, "implausible date")
+			}
+			if warned != tt.warning {
+				t.Errorf("warnings %q, want implausible-date warning %v", result.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestExtractMessageContentTextOnly(t *testing.T) {
+	html := textPart("text/html", "", "<p>Rs.250.00 debited at AMAZON on 12-03-24.</p>")
+	image := textPart("image/png", "base64", "\x89PNG\r\n\x1a\n binary")
+	image.Headers = append(image.Headers, &gmail.MessagePartHeader{Name: "Content-Disposition", Value: "inline"},
+		&gmail.MessagePartHeader{Name: "Content-ID", Value: "<logo@bank>"})
+	pdf := textPart("application/pdf", "base64", "%PDF-1.4 binary")
+	pdf.Filename = "statement.pdf"
+	namedText := textPart("text/plain", "", "Rs.9,999.00 in an attached note")
+	namedText.Filename = "note.txt"
+	attachedText := textPart("text/plain", "", "Rs.8,888.00 in an attachment")
+	attachedText.Headers = []*gmail.MessagePartHeader{{Name: "Content-Disposition", Value: `attachment; filename="x.txt"`}}
+
+	related := func(parts ...*gmail.MessagePart) *gmail.MessagePart {
+		return &gmail.MessagePart{MimeType: "multipart/related", Parts: parts}
+	}
+	tests := []struct {
+		name       string
+		part       *gmail.MessagePart
+		preference []string
+		want       string
+	}{
+		{"inline image skipped", related(image, html), []string{"text/plain", "text/html"}, "<p>Rs.250.00 debited at AMAZON on 12-03-24.</p>"},
+		{"image preference ignored", related(image, html), []string{"image/png", "text/html"}, "<p>Rs.250.00 debited at AMAZON on 12-03-24.</p>"},
+		{"pdf attachment skipped", &gmail.MessagePart{MimeType: "multipart/mixed", Parts: []*gmail.MessagePart{pdf, html}},
+			[]string{"application/pdf", "text/html"}, "<p>Rs.250.00 debited at AMAZON on 12-03-24.</p>"},
+		{"named text file skipped", related(namedText, html), []string{"text/plain", "text/html"}, "<p>Rs.250.00 debited at AMAZON on 12-03-24.</p>"},
+		{"attached text skipped", related(attachedText, html), []string{"text/plain", "text/html"}, "<p>Rs.250.00 debited at AMAZON on 12-03-24.</p>"},
+		{"no text at all", related(image), []string{"text/plain", "text/html"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractMessageContent(tt.part, tt.preference); got != tt.want {
+				t.Errorf("extractMessageContent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"sort"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+)
+
+// IssuerProfile describes a sender domain the deployment has configured
+// explicitly, and what is customized for it.
+type IssuerProfile struct {
+	Domain          string            `json:"domain"`
+	Profile         string            `json:"profile"`
+	Allowlisted     bool              `json:"allowlisted,omitempty"`
+	CurrencySymbols map[string]string `json:"currencySymbols,omitempty"`
+	PartPreference  []string          `json:"partPreference,omitempty"`
+	Polarity        string            `json:"polarity,omitempty"`
+}
+
+// SupportedIssuers lists every sender domain named in the configuration
+// (sender allowlist and per-issuer overrides), sorted by domain. Emails from
+// any other sender go through the generic profile.
+func SupportedIssuers(cfg *config.Config) []IssuerProfile {
+	byDomain := make(map[string]*IssuerProfile)
+	get := func(domain string) *IssuerProfile {
+		if p, ok := byDomain[domain]; ok {
+			return p
+		}
+		p := &IssuerProfile{Domain: domain, Profile: genericProfile}
+		byDomain[domain] = p
+		return p
+	}
+	for _, domain := range cfg.SenderDomains {
+		get(domain).Allowlisted = true
+	}
+	for domain, symbols := range cfg.IssuerCurrencySymbols {
+		get(domain).CurrencySymbols = symbols
+	}
+	for domain, preference := range cfg.IssuerPartPreference {
+		get(domain).PartPreference = preference
+	}
+	for domain, polarity := range cfg.IssuerPolarity {
+		get(domain).Polarity = polarity
+	}
+
+	issuers := make([]IssuerProfile, 0, len(byDomain))
+	for _, p := range byDomain {
+		issuers = append(issuers, *p)
+	}
+	sort.Slice(issuers, func(i, j int) bool { return issuers[i].Domain < issuers[j].Domain })
+	return issuers
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	merchantDomainPattern = regexp.MustCompile(`\.(?:COM|IN|CO\.IN|NET)$`)
+	merchantNoisePattern  = regexp.MustCompile(`[^A-Z0-9& ]+`)
+	// merchantSuffixPattern matches trailing legal-entity words, which vary
+	// between alerts for the same merchant.
+	merchantSuffixPattern = regexp.MustCompile(`(?:\s+(?:PVT|PRIVATE|LTD|LIMITED|LLP|INC|CORP|CO|INDIA|IN|SERVICES?))+$`)
+)
+
+// defaultMerchantAliases collapses common variants that suffix trimming
+// alone doesn't. Keys and values are normalized names.
+var defaultMerchantAliases = map[string]string{
+	"AMAZON PAY":        "AMAZON",
+	"AMZN":              "AMAZON",
+	"AMAZON RETAIL":     "AMAZON",
+	"FLIPKART INTERNET": "FLIPKART",
+	"SWIGGY INSTAMART":  "SWIGGY",
+	"ZOMATO MEDIA":      "ZOMATO",
+}
+
+// NormalizeMerchant returns the grouping key for a raw merchant string:
+// upper-cased, with legal/web suffixes and punctuation removed, and known
+// aliases collapsed. aliases (from configuration) win over the defaults; an
+// alias applies when it is the whole name or its leading words.
+func NormalizeMerchant(raw string, aliases map[string]string) string {
+	name := strings.ToUpper(strings.TrimSpace(raw))
+	name = merchantDomainPattern.ReplaceAllString(name, "")
+	name = strings.Join(strings.Fields(merchantNoisePattern.ReplaceAllString(name, " ")), " ")
+	if trimmed := merchantSuffixPattern.ReplaceAllString(name, ""); trimmed != "" {
+		name = trimmed
+	}
+	for _, table := range []map[string]string{aliases, defaultMerchantAliases} {
+		if alias, ok := lookupMerchantAlias(name, table); ok {
+			return alias
+		}
+	}
+	return name
+}
+
+// lookupMerchantAlias returns the alias for the longest key that is name or
+// a word-aligned prefix of it.
+func lookupMerchantAlias(name string, table map[string]string) (string, bool) {
+	best, alias := "", ""
+	for key, value := range table {
+		if (name == key || strings.HasPrefix(name, key+" ")) && len(key) > len(best) {
+			best, alias = key, value
+		}
+	}
+	return alias, best != ""
+}
@@ -0,0 +1,36 @@
+package services
+
+import "testing"
+
+func TestNormalizeMerchant(t *testing.T) {
+	configured := map[string]string{"AMZN MKTP": "AMAZON", "BUNDL": "SWIGGY", "AMAZON PAY": "AMAZON PAY"}
+	tests := []struct {
+		raw     string
+		aliases map[string]string
+		want    string
+	}{
+		{"AMAZON", nil, "AMAZON"},
+		{"Amazon", nil, "AMAZON"},
+		{"Amazon.in", nil, "AMAZON"},
+		{"amazon.com", nil, "AMAZON"},
+		{"AMAZON PAY INDIA", nil, "AMAZON"},
+		{"Amazon Pay India Pvt. Ltd.", nil, "AMAZON"},
+		{"AMAZON RETAIL INDIA PRIVATE LIMITED", nil, "AMAZON"},
+		{"AMZN Mktp IN", nil, "AMAZON"},
+		{"  amazon   seller services  ", nil, "AMAZON SELLER"},
+		{"AMZN MKTP US*2K4", configured, "AMAZON"},
+		{"BUNDL TECHNOLOGIES", configured, "SWIGGY"},
+		{"Amazon Pay India", configured, "AMAZON PAY"},
+		{"Swiggy Instamart", nil, "SWIGGY"},
+		{"Flipkart Internet Pvt Ltd", nil, "FLIPKART"},
+		{"AMAZONIA CAFE", nil, "AMAZONIA CAFE"},
+		{"India", nil, "INDIA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := NormalizeMerchant(tt.raw, tt.aliases); got != tt.want {
+				t.Errorf("NormalizeMerchant(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const ErrCodeInvalidCursor = "INVALID_CURSOR"
+
+// pageCursor is what an opaque pagination cursor carries: Gmail's next page
+// token and the window end the first page was queried with, so every page
+// runs the identical query even as the clock moves on.
+type pageCursor struct {
+	PageToken string    `json:"t"`
+	End       time.Time `json:"e"`
+}
+
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (pageCursor, error) {
+	var c pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c.PageToken == "" || c.End.IsZero() {
+		return pageCursor{}, &AppError{Code: http.StatusBadRequest, ErrorCode: ErrCodeInvalidCursor, Msg: "invalid cursor"}
+	}
+	return c, nil
+}
+
+// FetchTransactionsPage fetches a single page of up to pageSize messages.
+// An empty cursor starts from the newest message; the result's NextCursor
+// continues from where this page stopped and is empty on the last page.
+// Bodies not fetched before ctx is done are left out and the result marked
+// truncated.
+func (gs *GmailService) FetchTransactionsPage(ctx context.Context, days int, cursor string, pageSize int64) (*FetchResult, error) {
+	if err := gs.quota.CheckSoftLimit(ctx); err != nil {
+		return nil, err
+	}
+
+	c := pageCursor{End: gs.now()}
+	if cursor != "" {
+		var err error
+		if c, err = decodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	page, err := gs.listPage(ctx, buildTransactionQuery(days, c.End.In(gs.location), gs.senderDomains, gs.config.PDFStatements, gs.spamTrash), c.PageToken, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	transactions, warnings, truncated := gs.parseMessages(ctx, page.Messages)
+	result := &FetchResult{
+		Transactions:    transactions,
+		Warnings:        warnings,
+		MatchedMessages: page.ResultSizeEstimate,
+		Truncated:       truncated,
+	}
+	if c.PageToken == "" && page.NextPageToken == "" {
+		result.MatchedMessages, result.MatchedExact = int64(len(page.Messages)), true
+	}
+	if page.NextPageToken != "" {
+		result.NextCursor = encodeCursor(pageCursor{PageToken: page.NextPageToken, End: c.End})
+	}
+	return result, nil
+}
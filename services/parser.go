@@ -0,0 +1,517 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+// ParseDetails describes everything the parser extracted from an email body,
+// along with which patterns matched. It backs both transaction creation and
+// the parse preview endpoint.
+type ParseDetails struct {
+	Amount         float64 `json:"amount"`
+	Date           string  `json:"date"`
+	Time           string  `json:"time,omitempty"`
+	Merchant       string  `json:"merchant"`
+	Currency       string  `json:"currency"`
+	CurrencySymbol string  `json:"currencySymbol,omitempty"`
+	Account        string  `json:"account,omitempty"`
+	IsRefund       bool    `json:"isRefund,omitempty"`
+	IsAdjustment   bool    `json:"isAdjustment,omitempty"`
+	// Fee totals the amounts labelled as a fee, charge or tax, which are
+	// never taken for Amount.
+	Fee             float64 `json:"fee,omitempty"`
+	Type            string  `json:"type"`
+	Confidence      float64 `json:"confidence"`
+	Profile         string  `json:"profile"`
+	AmountPattern   string  `json:"amountPattern,omitempty"`
+	DatePattern     string  `json:"datePattern,omitempty"`
+	MerchantPattern string  `json:"merchantPattern,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+const genericProfile = "generic"
+
+// ParseError reports which extraction step (amount, date, ...) failed.
+type ParseError struct {
+	Step string
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return e.Msg
+}
+
+var (
+	accountPattern = regexp.MustCompile(`(?i)\b(?:a/c|acct|account|card)\s*(?:no\.?|number|ending(?:\s+in)?)?\s*[:\-]?\s*([X*]*\d{3,})`)
+	refundPattern  = regexp.MustCompile(`(?i)\b(refund(?:ed)?|reversed|reversal)\b`)
+	// adjustmentPattern marks cashback, rewards and fee waivers credited back.
+	// Alerts often advertise cashback, so it only counts in a credit alert.
+	// A bare "adjusted" is too common ("adjusted against your limit") to
+	// count; an adjustment has to be called a credit.
+	adjustmentPattern = regexp.MustCompile(`(?i)\b(cash\s?back|credit\s+adjustment|adjustment\s+credit|reward\s+(?:points\s+)?redemption|waiver|waived)\b`)
+	creditPattern     = regexp.MustCompile(`(?i)\b(credited|received|deposited)\b`)
+	debitPattern      = regexp.MustCompile(`(?i)\b(debited|spent|paid|withdrawn|purchase)\b`)
+	timePattern       = regexp.MustCompile(`(?i)^[\s,]*(?:at\s+)?([01]?\d|2[0-3])[:.]([0-5]\d)(?:[:.]([0-5]\d))?(?:\s*(AM|PM)\b)?`)
+)
+
+// parseBody extracts transaction details from a stripped email body under
+// rules, resolving two-digit years relative to now. The returned details are
+// populated as far as parsing got even when an error is returned, so callers
+// can report what matched.
+func parseBody(body string, rules *parseRules, now time.Time) (*ParseDetails, error) {
+	body = normalizeBody(body)
+	details := &ParseDetails{Profile: genericProfile}
+	patterns := currentPatterns()
+
+	amountStr, symbol, matchedPattern := findAmount(patterns, rules, body)
+	fee := rules.findFee(patterns, body)
+	// An email about the fee alone ("Annual fee of Rs 500 charged") has no
+	// other amount; the fee is then the transaction.
+	if amountStr == "" && fee > 0 {
+		amountStr, symbol, matchedPattern = findAmountWith(patterns, rules, body, false)
+		fee = 0
+	}
+	dateLoc := patterns.date.FindStringSubmatchIndex(body)
+	dateMatch := patterns.date.FindStringSubmatch(body)
+
+	details.AmountPattern = matchedPattern
+	if len(dateMatch) >= 2 {
+		details.DatePattern = patterns.date.String()
+	}
+	if amountStr == "" {
+		return details, &ParseError{Step: "amount", Msg: "could not parse transaction details: no amount found"}
+	}
+	if len(dateMatch) < 2 {
+		return details, &ParseError{Step: "date", Msg: "could not parse transaction details: no date found"}
+	}
+
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(amountStr, ",", ""), 64)
+	if err != nil {
+		return details, &ParseError{Step: "amount", Msg: fmt.Sprintf("could not parse amount: %v", err)}
+	}
+	details.Amount = amount
+	details.Fee = fee
+	details.CurrencySymbol = symbol
+	details.Currency = currencyCode(symbol, nil)
+
+	parsedDate, err := time.Parse("02-01-06", dateMatch[1])
+	if err != nil {
+		return details, &ParseError{Step: "date", Msg: fmt.Sprintf("could not parse date: %v", err)}
+	}
+	details.Date = resolveCentury(parsedDate, now).Format("2006-01-02")
+	details.Time = parseTimeOfDay(body[dateLoc[1]:])
+
+	if m := patterns.merchant.FindStringSubmatch(body); len(m) >= 2 {
+		details.Merchant = strings.TrimSpace(m[1])
+		details.MerchantPattern = patterns.merchant.String()
+	}
+	if m := accountPattern.FindStringSubmatch(body); len(m) >= 2 {
+		details.Account = maskAccount(m[1])
+	}
+	details.Type = detectTransactionType(body)
+	// A refund or reversal puts money back, whatever verb the alert uses.
+	if refundPattern.MatchString(body) {
+		details.IsRefund = true
+		details.Type = types.TransactionTypeCredit
+	} else if isAdjustment(body) {
+		details.IsAdjustment = true
+		details.Type = types.TransactionTypeCredit
+	}
+	details.Confidence = parseConfidence(details, body)
+
+	return details, nil
+}
+
+// isAdjustment reports whether a body credits back cashback, a reward
+// redemption or a waived fee, which nets against spend like a refund. Only
+// credit alerts with no debit wording qualify, so a purchase alert that
+// mentions cashback ("Rs.500 spent ... cashback will be credited") stays a
+// debit.
+func isAdjustment(body string) bool {
+	return adjustmentPattern.MatchString(body) &&
+		detectTransactionType(body) == types.TransactionTypeCredit && !debitPattern.MatchString(body)
+}
+
+// resolveCentury places a date parsed from a two-digit year in the latest
+// century that doesn't put it more than a year after now. Go's own rule
+// (69-99 is 19xx) would read "70" as 1970 for decades to come, while alerts
+// are about the recent past.
+func resolveCentury(date, now time.Time) time.Time {
+	yy := date.Year() % 100
+	year := now.Year() - now.Year()%100 + yy
+	if year+100 <= now.Year()+1 {
+		year += 100
+	} else if year > now.Year()+1 {
+		year -= 100
+	}
+	return date.AddDate(year-date.Year(), 0, 0)
+}
+
+// parseTimeOfDay reads a time written straight after the date, as in
+// "on 12-03-24 at 14:35" or "on 12-03-24, 02:35 PM", and returns it as
+// "15:04:05". It returns "" when there is none.
+func parseTimeOfDay(afterDate string) string {
+	m := timePattern.FindStringSubmatch(afterDate)
+	if m == nil {
+		return ""
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute, _ := strconv.Atoi(m[2])
+	second, _ := strconv.Atoi(m[3])
+	switch strings.ToUpper(m[4]) {
+	case "AM":
+		if hour > 12 {
+			return ""
+		}
+		if hour == 12 {
+			hour = 0
+		}
+	case "PM":
+		if hour > 12 {
+			return ""
+		}
+		if hour < 12 {
+			hour += 12
+		}
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", hour, minute, second)
+}
+
+// normalizeBody decodes any HTML entities left in the text (e.g. a literal
+// "&nbsp;" in a plain-text part) and collapses every run of whitespace,
+// including non-breaking and zero-width spaces, into a single space, so
+// amounts split across elements or lines ("Rs.\n1,234") still match.
+func normalizeBody(body string) string {
+	body = html.UnescapeString(body)
+	body = strings.Map(func(r rune) rune {
+		switch r {
+		case '\u00a0', '\u2007', '\u202f':
+			return ' '
+		case '\u200b', '\u200c', '\u200d', '\ufeff':
+			return -1
+		}
+		return r
+	}, body)
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(body, " "))
+}
+
+// findAmount returns the first well-formed amount in the body along with its
+// currency token as written and the pattern that matched, whichever side of
+// the number the currency was written on. Matches whose number isn't a proper
+// decimal (e.g. "1.2.3"), that are labelled as a limit, balance or fee, or
+// that aren't near a transaction keyword (when that check is on) are skipped
+// in favour of the next candidate.
+func findAmount(patterns *compiledPatterns, rules *parseRules, body string) (amount, currency, pattern string) {
+	return findAmountWith(patterns, rules, body, true)
+}
+
+// findAmountWith is findAmount, skipping fee-labelled amounts only when
+// skipFees is set.
+func findAmountWith(patterns *compiledPatterns, rules *parseRules, body string, skipFees bool) (amount, currency, pattern string) {
+	start := -1
+	try := func(re *regexp.Regexp, amountGroup, currencyGroup int) {
+		for _, loc := range re.FindAllStringSubmatchIndex(body, -1) {
+			if start >= 0 && loc[0] >= start {
+				return
+			}
+			number, ok := normalizeAmount(submatch(body, loc, amountGroup))
+			if !ok || isContextAmount(body[:loc[0]]) || !rules.nearAmountKeyword(body, loc[0], loc[1]) ||
+				(skipFees && rules.isFeeAmount(body, loc[0], loc[1])) {
+				continue
+			}
+			start = loc[0]
+			amount, currency, pattern = number, normalizeCurrencyToken(submatch(body, loc, currencyGroup)), re.String()
+			return
+		}
+	}
+	try(patterns.amount, 2, 1)
+	try(patterns.amountAfter, 1, 2)
+	return amount, currency, pattern
+}
+
+// findFee totals the fee-labelled amounts in the body, as in "Rs.500 (incl.
+// fee Rs.10)" or "GST: Rs 1.80". Where both patterns match the same text,
+// it is counted once.
+func (r *parseRules) findFee(patterns *compiledPatterns, body string) float64 {
+	if r == nil || r.feeBefore == nil {
+		return 0
+	}
+	var fee float64
+	var seen [][]int
+	collect := func(re *regexp.Regexp, amountGroup int) {
+	candidates:
+		for _, loc := range re.FindAllStringSubmatchIndex(body, -1) {
+			for _, s := range seen {
+				if loc[0] < s[1] && s[0] < loc[1] {
+					continue candidates
+				}
+			}
+			number, ok := normalizeAmount(submatch(body, loc, amountGroup))
+			if !ok || !r.isFeeAmount(body, loc[0], loc[1]) {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.ReplaceAll(number, ",", ""), 64)
+			if err != nil {
+				continue
+			}
+			fee += value
+			seen = append(seen, loc[:2])
+		}
+	}
+	collect(patterns.amount, 2)
+	collect(patterns.amountAfter, 1)
+	return fee
+}
+
+// contextAmountPattern matches the end of the text before an amount that
+// labels it as a limit, balance or due amount rather than the transaction
+// itself, as in "Available limit Rs. 45,000" or "Outstanding: INR 1,200".
+var contextAmountPattern = regexp.MustCompile(`(?i)(?:(?:avail(?:able)?|avl\.?)\s*(?:credit\s*)?(?:limit|lmt|bal(?:ance)?)|credit\s+limit|outstanding(?:\s+amount)?|total\s+(?:amount\s+)?due|min(?:imum)?\s+(?:amount\s+)?due)[\s:.\-]*(?:(?:is|of|now)\s*)?[\s:.\-]*(?:(?:rs\.?|inr|usd|eur|gbp|jpy|₹|\$|€|£|¥)\s*)?$`)
+
+// isContextAmount reports whether the amount following before is a limit,
+// balance or due amount. Only the last few words are considered.
+func isContextAmount(before string) bool {
+	const window = 40
+	if len(before) > window {
+		before = before[len(before)-window:]
+	}
+	return contextAmountPattern.MatchString(before)
+}
+
+// parseRules holds the parts of parsing that come from configuration,
+// compiled once per service. A nil *parseRules turns every such check off.
+type parseRules struct {
+	// amountKeywords is the pattern a candidate amount must have within
+	// amountKeywordWindow characters on either side; nil turns the check
+	// off.
+	amountKeywords      *regexp.Regexp
+	amountKeywordWindow int
+	// feeBefore and feeAfter match a fee keyword just before or just after
+	// an amount; nil turns fee detection off.
+	feeBefore, feeAfter *regexp.Regexp
+}
+
+// newParseRules compiles the parse rules cfg sets. Amounts must appear
+// within AmountKeywordWindow characters of one of AmountKeywords (e.g.
+// "debited", "spent") to count as the transaction amount, so stray numbers
+// are ignored; a window of 0 turns the check off. FeeKeywords (e.g. "fee",
+// "gst") label an amount as a fee, charge or tax rather than the transaction
+// amount; no keywords turns fee detection off.
+func newParseRules(cfg *config.Config) *parseRules {
+	rules := &parseRules{}
+	quoted := make([]string, len(cfg.AmountKeywords))
+	for i, k := range cfg.AmountKeywords {
+		quoted[i] = regexp.QuoteMeta(k)
+	}
+	if cfg.AmountKeywordWindow > 0 && len(quoted) > 0 {
+		rules.amountKeywords = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+		rules.amountKeywordWindow = cfg.AmountKeywordWindow
+	}
+	var fees []string
+	for _, k := range cfg.FeeKeywords {
+		if k != "" {
+			fees = append(fees, regexp.QuoteMeta(k))
+		}
+	}
+	if len(fees) > 0 {
+		words := `(?:` + strings.Join(fees, "|") + `)`
+		rules.feeBefore = regexp.MustCompile(`(?i)\b` + words + `\b[\s:.\-]*(?:(?:of|is|amount)\b[\s:.\-]*)?$`)
+		rules.feeAfter = regexp.MustCompile(`(?i)^\s*` + words + `\b`)
+	}
+	return rules
+}
+
+// nearAmountKeyword reports whether body[start:end] has a transaction keyword
+// close by, or the check is off.
+func (r *parseRules) nearAmountKeyword(body string, start, end int) bool {
+	if r == nil || r.amountKeywords == nil {
+		return true
+	}
+	from, to := start-r.amountKeywordWindow, end+r.amountKeywordWindow
+	if from < 0 {
+		from = 0
+	}
+	if to > len(body) {
+		to = len(body)
+	}
+	return r.amountKeywords.MatchString(body[from:to])
+}
+
+// isFeeAmount reports whether the amount at body[start:end] is labelled as a
+// fee: a fee keyword ends the few words before it or starts the text after.
+func (r *parseRules) isFeeAmount(body string, start, end int) bool {
+	if r == nil || r.feeBefore == nil {
+		return false
+	}
+	const window = 30
+	before := body[:start]
+	if len(before) > window {
+		before = before[len(before)-window:]
+	}
+	return r.feeBefore.MatchString(before) || r.feeAfter.MatchString(body[end:])
+}
+
+// amountGrammar is a decimal with optional thousands separators, in either
+// Western (1,234,567) or Indian (12,34,567) grouping, and at most three
+// decimal places (BHD, KWD and OMR have three minor-unit digits).
+var amountGrammar = regexp.MustCompile(`^(?:\d{1,3}(?:,\d{2,3})+|\d+)(?:\.\d{1,3})?$`)
+
+// normalizeAmount trims sentence punctuation off a captured number and
+// reports whether what's left is a well-formed amount.
+func normalizeAmount(raw string) (string, bool) {
+	raw = strings.TrimRight(raw, ".,")
+	return raw, amountGrammar.MatchString(raw)
+}
+
+// submatch returns capture group n from a FindStringSubmatchIndex result, or
+// "" if the group did not participate in the match.
+func submatch(s string, loc []int, n int) string {
+	if 2*n+1 >= len(loc) || loc[2*n] < 0 {
+		return ""
+	}
+	return s[loc[2*n]:loc[2*n+1]]
+}
+
+// defaultCurrencySymbols resolves the currency tokens that aren't already ISO
+// codes. "$" is ambiguous; deployments and issuers override it as needed.
+var defaultCurrencySymbols = map[string]string{
+	"RS": "INR",
+	"₹":  "INR",
+	"$":  "USD",
+	"€":  "EUR",
+	"£":  "GBP",
+	"¥":  "JPY",
+}
+
+func normalizeCurrencyToken(token string) string {
+	return strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(token), "."))
+}
+
+// currencyCode maps a normalized currency token to its ISO code, consulting
+// each override map in order before the built-in defaults. ISO codes map to
+// themselves.
+func currencyCode(token string, overrides ...map[string]string) string {
+	for _, symbols := range overrides {
+		if code, ok := symbols[token]; ok {
+			return code
+		}
+	}
+	if code, ok := defaultCurrencySymbols[token]; ok {
+		return code
+	}
+	return token
+}
+
+// maskAccount reduces an account or card reference to its last four digits,
+// e.g. "XX1234" or "****1234", so it can be shown and grouped without
+// exposing the full number.
+func maskAccount(raw string) string {
+	digits := strings.TrimLeft(raw, "Xx*")
+	if len(digits) > 4 {
+		digits = digits[len(digits)-4:]
+	}
+	return "XX" + digits
+}
+
+// parseConfidence is a rough score of how complete a parse was: amount and
+// date are required, merchant and an explicit direction keyword add to it.
+func parseConfidence(details *ParseDetails, body string) float64 {
+	confidence := 0.6
+	if details.Merchant != "" {
+		confidence += 0.2
+	}
+	if creditPattern.MatchString(body) || debitPattern.MatchString(body) {
+		confidence += 0.2
+	}
+	return confidence
+}
+
+// detectTransactionType classifies a body as a credit or debit, defaulting to
+// debit since most alerts are for spend.
+func detectTransactionType(body string) string {
+	creditLoc := creditPattern.FindStringIndex(body)
+	if creditLoc == nil {
+		return types.TransactionTypeDebit
+	}
+	debitLoc := debitPattern.FindStringIndex(body)
+	if debitLoc != nil && debitLoc[0] < creditLoc[0] {
+		return types.TransactionTypeDebit
+	}
+	return types.TransactionTypeCredit
+}
+
+// balancePattern matches wording that reports an account balance, as in
+// "Available balance in A/c XX1234" or "Balance alert".
+var balancePattern = regexp.MustCompile(`(?i)\b(?:(?:avail(?:able)?|avl\.?|closing|current|ledger|account|a/c)\s*bal(?:ance)?|balance\s+(?:alert|update|enquiry|inquiry))\b`)
+
+// isBalanceOnly reports whether a body is a balance notification with no
+// transaction in it: it talks about a balance and never says money was
+// debited, credited, spent or refunded. Its amount is the balance, so it
+// must not be parsed as a transaction.
+func isBalanceOnly(body string) bool {
+	body = normalizeBody(body)
+	return balancePattern.MatchString(body) &&
+		!creditPattern.MatchString(body) && !debitPattern.MatchString(body) && !refundPattern.MatchString(body) && !isAdjustment(body)
+}
+
+// isSelfTransfer reports whether the body mentions any of the configured
+// self-transfer keywords (e.g. "own account"), marking money moved between
+// the user's own accounts rather than spent.
+func isSelfTransfer(body string, keywords []string) bool {
+	lower := strings.ToLower(body)
+	for _, keyword := range keywords {
+		if keyword != "" && strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// PreviewParse runs the parser, configured by cfg, over a raw (possibly
+// HTML) email body as of now and reports what it extracted, including the
+// failure reason if any.
+func PreviewParse(cfg *config.Config, rawBody string, now time.Time) *ParseDetails {
+	details, err := parseBody(stripHTMLTags(rawBody), newParseRules(cfg), now)
+	if err != nil {
+		details.Error = err.Error()
+	}
+	return details
+}
+
+const redactedSnippetLen = 300
+
+var (
+	accountRefPattern   = regexp.MustCompile(`(?i)\b(a/c|acct|account|card)(\s*(?:no\.?|number|ending(?:\s+in)?)?\s*[:\-]?\s*)[X*\d\-]{3,}`)
+	maskedDigitsPattern = regexp.MustCompile(`(?i)(?:\bX|\*)[X*]+\d+\b`)
+	longDigitsPattern   = regexp.MustCompile(`\d{9,}`)
+	emailPattern        = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+`)
+	whitespacePattern   = regexp.MustCompile(`\s+`)
+)
+
+// maskPII returns an email body on a single line with account numbers, long
+// digit runs and email/VPA addresses masked.
+func maskPII(body string) string {
+	masked := whitespacePattern.ReplaceAllString(strings.TrimSpace(body), " ")
+	masked = accountRefPattern.ReplaceAllString(masked, "${1}${2}[ACCOUNT]")
+	masked = maskedDigitsPattern.ReplaceAllString(masked, "[ACCOUNT]")
+	masked = longDigitsPattern.ReplaceAllString(masked, "[NUMBER]")
+	return emailPattern.ReplaceAllString(masked, "[EMAIL]")
+}
+
+// redactBody returns a short, masked snippet of an email body, suitable for
+// logging parse failures.
+func redactBody(body string) string {
+	snippet := maskPII(body)
+	if len(snippet) > redactedSnippetLen {
+		snippet = snippet[:redactedSnippetLen] + "..."
+	}
+	return snippet
+}
@@ -0,0 +1,474 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestParseBodyCurrencyPosition(t *testing.T) {
+	tests := []struct {
+		body     string
+		amount   float64
+		currency string
+	}{
+		{"Rs. 1,234.00 debited at AMAZON on 01-03-24", 1234, "INR"},
+		{"1,234.00 INR debited at AMAZON on 01-03-24", 1234, "INR"},
+		{"1234.00 Rs debited at AMAZON on 01-03-24", 1234, "INR"},
+		{"₹ 2,10,500.50 debited at AMAZON on 01-03-24", 210500.5, "INR"},
+		{"USD 12.50 spent at STEAM on 01-03-24", 12.5, "USD"},
+		{"12.50 USD spent at STEAM on 01-03-24", 12.5, "USD"},
+		{"£45.00 spent at TESCO on 01-03-24", 45, "GBP"},
+		{"45.00 GBP spent at TESCO on 01-03-24", 45, "GBP"},
+		{"EUR 1,000 spent at IKEA on 01-03-24", 1000, "EUR"},
+		{"1,000 EUR spent at IKEA on 01-03-24", 1000, "EUR"},
+		{"KWD 12.345 spent at ALSHAYA on 01-03-24", 12.345, "KWD"},
+		{"4.750 BHD spent at LULU on 01-03-24", 4.75, "BHD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			details, err := parseBody(tt.body, nil, testNow)
+			if err != nil {
+				t.Fatalf("parseBody: %v", err)
+			}
+			if details.Amount != tt.amount || details.Currency != tt.currency {
+				t.Errorf("got %v %s, want %v %s", details.Amount, details.Currency, tt.amount, tt.currency)
+			}
+		})
+	}
+}
+
+func TestParseFailureDiagnostics(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		debug   bool
+		step    string
+		hidden  []string
+		visible []string
+	}{
+		{
+			name:    "no amount",
+			body:    "Your A/c no. 123456789012 was updated on 01-03-24. Contact care@bank.example",
+			debug:   true,
+			step:    "amount",
+			hidden:  []string{"123456789012", "care@bank.example"},
+			visible: []string{"[ACCOUNT]", "[EMAIL]"},
+		},
+		{
+			name:    "no date",
+			body:    "Rs.500 debited from card XX9876 to merchant@okicici, ref 998877665544",
+			debug:   true,
+			step:    "date",
+			hidden:  []string{"XX9876", "merchant@okicici", "998877665544"},
+			visible: []string{"[ACCOUNT]", "[EMAIL]", "[NUMBER]"},
+		},
+		{
+			name:  "debug off",
+			body:  "Your A/c no. 123456789012 was updated on 01-03-24.",
+			debug: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger.InitWithWriter(&logs, "debug", "text")
+			t.Cleanup(func() { logger.Init("info", "text") })
+			gs, _ := newTestService(t, func(cfg *config.Config) { cfg.ParseDebug = tt.debug })
+
+			msg := &gmail.Message{Id: "m1", Payload: textPart("text/plain", "", tt.body)}
+			if _, err := gs.parseTransactionEmail(context.Background(), msg); err == nil {
+				t.Fatal("expected a parse failure")
+			}
+			out := logs.String()
+			if !tt.debug {
+				if strings.Contains(out, "Parse failure") {
+					t.Errorf("diagnostic logged with PARSE_DEBUG off: %s", out)
+				}
+				return
+			}
+			if !strings.Contains(out, "Parse failure for message m1 at step "+tt.step) {
+				t.Errorf("log does not name step %q: %s", tt.step, out)
+			}
+			for _, s := range tt.hidden {
+				if strings.Contains(out, s) {
+					t.Errorf("log leaks %q: %s", s, out)
+				}
+			}
+			for _, s := range tt.visible {
+				if !strings.Contains(out, s) {
+					t.Errorf("log is missing %q: %s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestIsSelfTransfer(t *testing.T) {
+	keywords := config.LoadConfig().TransferKeywords
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{"Rs.5000 debited from a/c XX1234 for Self Transfer to a/c XX9876.", true},
+		{"INR 2,000 moved to your own account ending 4321.", true},
+		{"Rs.750 transferred between your accounts.", true},
+		{"Rs.300 debited from your account at SWIGGY.", false},
+		{"Rs.300 transferred to RAHUL via UPI.", false},
+	}
+	for _, tt := range tests {
+		if got := isSelfTransfer(tt.body, keywords); got != tt.want {
+			t.Errorf("isSelfTransfer(%q) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+	if isSelfTransfer("Rs.500 to my savings pot.", nil) {
+		t.Error("matched with no keywords configured")
+	}
+	if !isSelfTransfer("Rs.500 to my savings pot.", []string{"savings pot"}) {
+		t.Error("configured keyword not matched")
+	}
+}
+
+func TestParseBodyRefund(t *testing.T) {
+	tests := []struct {
+		body   string
+		refund bool
+	}{
+		{"Refund of Rs.499.00 credited to your a/c XX1234 from AMAZON on 12-03-24.", true},
+		{"Your transaction of INR 1,250.00 at FLIPKART on 12-03-24 has been reversed.", true},
+		{"Reversal: Rs.75.00 at UBER on 12-03-24 has been credited back.", true},
+		{"Rs.200.00 refunded to your card at MYNTRA on 12-03-24.", true},
+		{"Rs.499.00 debited from your account at AMAZON on 12-03-24.", false},
+		{"Rs.5,000.00 credited to your account from ACME PAYROLL on 12-03-24.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			details, err := parseBody(tt.body, nil, testNow)
+			if err != nil {
+				t.Fatalf("parseBody: %v", err)
+			}
+			if details.IsRefund != tt.refund {
+				t.Errorf("IsRefund = %v, want %v", details.IsRefund, tt.refund)
+			}
+			if tt.refund && details.Type != "credit" {
+				t.Errorf("refund Type = %q, want credit", details.Type)
+			}
+		})
+	}
+}
+
+func TestNormalizeAmount(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		ok   bool
+	}{
+		{"1234", "1234", true},
+		{"1,234.50", "1,234.50", true},
+		{"12,34,567.5", "12,34,567.5", true},
+		{"499.", "499", true},
+		{"499.00.", "499.00", true},
+		{"1,250,", "1,250", true},
+		{"1.2.3", "1.2.3", false},
+		{"12.345", "12.345", true},
+		{"1,234.500", "1,234.500", true},
+		{"12.3456", "12.3456", false},
+		{"1,2,3", "1,2,3", false},
+		{",100", ",100", false},
+		{"1,,000", "1,,000", false},
+		{"...", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeAmount(tt.raw)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("normalizeAmount(%q) = %q, %v; want %q, %v", tt.raw, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseBodySkipsMalformedAmounts(t *testing.T) {
+	tests := []struct {
+		body   string
+		amount float64
+	}{
+		{"Ref Rs.1.2.3 noted. Rs.450.00 debited at AMAZON on 01-03-24", 450},
+		{"Rs. 1,,000 invalid; Rs. 1,000.00 debited at AMAZON on 01-03-24", 1000},
+		{"Rs.99. debited at AMAZON on 01-03-24", 99},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			details, err := parseBody(tt.body, nil, testNow)
+			if err != nil {
+				t.Fatalf("parseBody: %v", err)
+			}
+			if details.Amount != tt.amount {
+				t.Errorf("amount = %v, want %v", details.Amount, tt.amount)
+			}
+		})
+	}
+	if _, err := parseBody("Rs.1.2.3 debited at AMAZON on 01-03-24", nil, testNow); err == nil {
+		t.Error("a body whose only amount is malformed parsed")
+	}
+}
+
+func TestParseBodyEntitiesAndLineBreaks(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		amount   float64
+		merchant string
+	}{
+		{"nbsp entity", "Rs.&nbsp;1,234.00 debited at AMAZON on 01-03-24", 1234, "AMAZON"},
+		{"decimal entity", "Rs.&#160;1,234.00 debited at AMAZON on 01-03-24", 1234, "AMAZON"},
+		{"ampersand in merchant", "Rs.499 spent at M&amp;S on 01-03-24", 499, "M&S"},
+		{"rupee entity", "&#8377;250 spent at SWIGGY on 01-03-24", 250, "SWIGGY"},
+		{"newline after symbol", "Rs.\n1,234.00 debited at AMAZON on 01-03-24", 1234, "AMAZON"},
+		{"crlf and tabs", "INR\r\n\t 75.50 spent\r\nat UBER\r\non 01-03-24", 75.5, "UBER"},
+		{"non-breaking space rune", "Rs.\u00a0640 spent at ZOMATO on 01-03-24", 640, "ZOMATO"},
+		{"zero-width space", "Rs.\u200b99 spent at CAFE on 01-03-24", 99, "CAFE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := parseBody(tt.body, nil, testNow)
+			if err != nil {
+				t.Fatalf("parseBody: %v", err)
+			}
+			if details.Amount != tt.amount || details.Merchant != tt.merchant {
+				t.Errorf("got %v %q, want %v %q", details.Amount, details.Merchant, tt.amount, tt.merchant)
+			}
+		})
+	}
+}
+
+func TestParseBodyIgnoresLimitContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		amount float64
+	}{
+		{"limit after spend", "Rs.1,250.00 spent on your card at AMAZON on 01-03-24. Available limit Rs. 45,000.00", 1250},
+		{"limit before spend", "Avl Lmt: INR 45,000.00. INR 640.00 spent at ZOMATO on 01-03-24", 640},
+		{"credit limit", "Your credit limit of Rs.2,00,000 remains. Rs.99 debited at CAFE on 01-03-24", 99},
+		{"outstanding", "Outstanding: INR 12,300.00. Rs.450 spent at UBER on 01-03-24", 450},
+		{"total due", "Total amount due Rs.8,000. Rs.300 spent at SWIGGY on 01-03-24", 300},
+		{"minimum due", "Rs.75 spent at TEA on 01-03-24. Minimum due: Rs. 500", 75},
+		{"available balance", "Rs.2,000 debited at RENT on 01-03-24. Avl bal Rs 10,500.25", 2000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := parseBody(tt.body, nil, testNow)
+			if err != nil {
+				t.Fatalf("parseBody: %v", err)
+			}
+			if details.Amount != tt.amount {
+				t.Errorf("amount %v, want %v", details.Amount, tt.amount)
+			}
+		})
+	}
+}
+
+func TestIsBalanceOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"available balance", "Your available balance in A/c XX1234 is Rs.25,000.00 as on 14-03-24.", true},
+		{"abbreviated", "Avl Bal for A/c XX1234: INR 1,02,345.67", true},
+		{"closing balance", "Closing balance for your account as of 14-Mar-2024 is Rs. 8,500.00.", true},
+		{"balance alert", "Balance alert: your account has Rs.500.00.", true},
+		{"debit with balance", "Rs.250.00 debited from A/c XX1234 at AMAZON on 14-03-24. Avl Bal Rs.24,750.00", false},
+		{"credit with balance", "Rs.5,000.00 credited to A/c XX1234 on 14-03-24. Available balance Rs.30,000.00", false},
+		{"refund with balance", "Refund of Rs.300.00 from FLIPKART processed. Avl Bal: Rs.9,300.00", false},
+		{"plain debit", "Rs.250.00 spent at AMAZON on 14-03-24.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBalanceOnly(tt.body); got != tt.want {
+				t.Errorf("isBalanceOnly = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskPII(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"account reference", "Rs.500.00 debited from A/c XX1234 on 14-03-24.", "Rs.500.00 debited from A/c [ACCOUNT] on 14-03-24."},
+		{"card ending", "Spent on card ending in 4321 at AMAZON", "Spent on card ending in [ACCOUNT] at AMAZON"},
+		{"bare masked digits", "Ref **5678 for your payment", "Ref [ACCOUNT] for your payment"},
+		{"long number", "UPI ref 412345678901 completed", "UPI ref [NUMBER] completed"},
+		{"email and VPA", "Paid to merchant@okicici, receipt sent to user@example.com", "Paid to [EMAIL], receipt sent to [EMAIL]"},
+		{"line breaks collapsed", "Rs.100.00\n\n  debited\ton 14-03-24", "Rs.100.00 debited on 14-03-24"},
+		{"amounts and dates kept", "INR 1,250.00 on 14-03-2024", "INR 1,250.00 on 14-03-2024"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maskPII(tt.body); got != tt.want {
+				t.Errorf("maskPII = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveCentury(t *testing.T) {
+	tests := []struct {
+		yy   string
+		now  time.Time
+		want int
+	}{
+		{"24", testNow, 2024},
+		{"06", testNow, 2006},
+		{"00", testNow, 2000},
+		{"25", testNow, 2025},
+		{"26", testNow, 1926},
+		{"70", testNow, 1970},
+		{"99", testNow, 1999},
+		{"01", time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC), 2001},
+		{"00", time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC), 2100},
+		{"99", time.Date(2100, 6, 1, 0, 0, 0, 0, time.UTC), 2099},
+	}
+	for _, tt := range tests {
+		t.Run(tt.yy+" as of "+tt.now.Format("2006"), func(t *testing.T) {
+			parsed, err := time.Parse("02-01-06", "12-03-"+tt.yy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resolveCentury(parsed, tt.now).Year(); got != tt.want {
+				t.Errorf("year = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBodyAmbiguousYear(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		now  time.Time
+		want string
+	}{
+		{"this year", "Rs.100.00 debited at AMAZON on 12-03-24.", testNow, "2024-03-12"},
+		{"seventies read as the past", "Rs.100.00 debited at AMAZON on 12-03-70.", testNow, "1970-03-12"},
+		{"next year allowed", "Rs.100.00 debited at AMAZON on 12-01-25.", testNow, "2025-01-12"},
+		{"follows the clock it is given", "Rs.100.00 debited at AMAZON on 12-03-24.", time.Date(2130, 1, 1, 0, 0, 0, 0, time.UTC), "2124-03-12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := parseBody(tt.body, nil, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if details.Date != tt.want {
+				t.Errorf("date = %s, want %s", details.Date, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBodyAmountKeywords(t *testing.T) {
+	const promo = "Get Rs. 100 cashback on your next order. "
+	tests := []struct {
+		name    string
+		window  int
+		body    string
+		amount  float64
+		wantErr bool
+	}{
+		{"near verb accepted", 15, "Rs. 450.00 spent at ZOMATO on 01-03-24", 450, false},
+		{"verb before amount", 15, "You have paid Rs. 450.00 at ZOMATO on 01-03-24", 450, false},
+		{"stray number skipped", 15, promo + "Rs. 450.00 spent at ZOMATO on 01-03-24", 450, false},
+		{"only stray number", 15, promo + "Offer valid at ZOMATO on 01-03-24", 0, true},
+		{"window 0 turns check off", 0, promo + "Rs. 450.00 spent at ZOMATO on 01-03-24", 100, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.LoadConfig()
+			cfg.AmountKeywordWindow = tt.window
+			details, err := parseBody(tt.body, newParseRules(cfg), testNow)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseBody(%q) = %+v, want error", tt.body, details)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBody(%q): %v", tt.body, err)
+			}
+			if details.Amount != tt.amount {
+				t.Errorf("amount = %v, want %v", details.Amount, tt.amount)
+			}
+		})
+	}
+}
+
+func TestParseBodyFees(t *testing.T) {
+	tests := []struct {
+		name     string
+		keywords []string
+		body     string
+		amount   float64
+		fee      float64
+	}{
+		{"fee in brackets after the amount", nil, "Rs.500.00 (incl. fee Rs.10.00) debited at AMAZON on 12-03-24", 500, 10},
+		{"fee written first", nil, "Convenience fee Rs.20.00 and Rs.1,200.00 debited at IRCTC on 12-03-24", 1200, 20},
+		{"fee and GST totalled", nil, "Rs.500.00 spent at AIRTEL on 12-03-24. Charges Rs.10.00, GST: Rs 1.80", 500, 11.8},
+		{"keyword after the amount", nil, "Rs.500.00 spent at AIRTEL on 12-03-24 plus Rs.15.00 surcharge", 500, 15},
+		{"fee alone is the transaction", nil, "Annual fee of Rs.499.00 debited from your card on 12-03-24", 499, 0},
+		{"no fee", nil, "Rs.500.00 spent at AMAZON on 12-03-24", 500, 0},
+		{"detection off", []string{}, "Convenience fee Rs.20.00 and Rs.1,200.00 debited at IRCTC on 12-03-24", 20, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.LoadConfig()
+			if tt.keywords != nil {
+				cfg.FeeKeywords = tt.keywords
+			}
+			details, err := parseBody(tt.body, newParseRules(cfg), testNow)
+			if err != nil {
+				t.Fatalf("parseBody(%q): %v", tt.body, err)
+			}
+			if details.Amount != tt.amount || details.Fee != tt.fee {
+				t.Errorf("amount %v, fee %v; want %v, %v", details.Amount, details.Fee, tt.amount, tt.fee)
+			}
+		})
+	}
+}
+
+func TestParseBodyAdjustment(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		txnType    string
+		adjustment bool
+	}{
+		{"cashback credited", "Cashback of Rs.50.00 has been credited to your account on 12-03-24.", "credit", true},
+		{"reward redemption", "Rs.200.00 credited to your card towards reward points redemption on 12-03-24.", "credit", true},
+		{"fee waived", "Annual fee of Rs.499.00 waived and credited to your card on 12-03-24.", "credit", true},
+		{"credit adjustment", "Rs.75.00 credited to your account as a credit adjustment on 12-03-24.", "credit", true},
+		{"purchase mentioning cashback", "Rs.500.00 spent at AMAZON on 12-03-24. Cashback will be credited in 7 days.", "debit", false},
+		{"debit before credit wording", "Rs.500.00 debited at SWIGGY on 12-03-24; cashback of Rs.50 credited later.", "debit", false},
+		{"bare adjusted", "Rs.300.00 credited to your account on 12-03-24, adjusted for rounding.", "credit", false},
+		{"plain credit", "Rs.300.00 received from RAHUL on 12-03-24.", "credit", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := parseBody(tt.body, nil, testNow)
+			if err != nil {
+				t.Fatalf("parseBody(%q): %v", tt.body, err)
+			}
+			if details.Type != tt.txnType || details.IsAdjustment != tt.adjustment {
+				t.Errorf("type %s, adjustment %v; want %s, %v", details.Type, details.IsAdjustment, tt.txnType, tt.adjustment)
+			}
+		})
+	}
+}
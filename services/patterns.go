@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const patternsKey = "parser:patterns"
+
+// PatternConfig is the operator-editable set of extraction regexes.
+//
+// Amount must capture (currency, number), AmountAfter (number, currency),
+// Date a single dd-mm-yy date and Merchant the merchant name.
+type PatternConfig struct {
+	Amount      string `json:"amount"`
+	AmountAfter string `json:"amountAfter"`
+	Date        string `json:"date"`
+	Merchant    string `json:"merchant"`
+}
+
+type compiledPatterns struct {
+	config      PatternConfig
+	amount      *regexp.Regexp
+	amountAfter *regexp.Regexp
+	date        *regexp.Regexp
+	merchant    *regexp.Regexp
+}
+
+// DefaultPatternConfig returns the built-in patterns. Currency may be written
+// before ("Rs. 1,234") or after ("1,234.00 INR") the number.
+func DefaultPatternConfig() PatternConfig {
+	return PatternConfig{
+		Amount:      `(?i)(\bRs\.?|\bINR|\bUSD|\bEUR|\bGBP|\bJPY|\bBHD|\bKWD|\bOMR|₹|\$|€|£|¥)\s*([0-9][0-9,.]*)`,
+		AmountAfter: `(?i)\b([0-9][0-9,.]*)\s*(Rs\b\.?|INR\b|USD\b|EUR\b|GBP\b|BHD\b|KWD\b|OMR\b|₹)`,
+		Date:        `on\s+(\d{2}-\d{2}-\d{2})`,
+		Merchant:    `(?i)\b(?:at|to|towards)\s+(?:VPA\s+)?([A-Za-z0-9@&._*\-]+(?:\s+[A-Za-z0-9&._*\-]+){0,3}?)(?:\s+on\b|\s+via\b|\s+ref\b|[.,;]\s|[.,;]?$)`,
+	}
+}
+
+var (
+	patternsMu     sync.RWMutex
+	activePatterns = mustCompilePatterns(DefaultPatternConfig())
+)
+
+func mustCompilePatterns(pc PatternConfig) *compiledPatterns {
+	p, err := compilePatterns(pc)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// compilePatterns compiles every pattern and checks it has the capture
+// groups the parser relies on.
+func compilePatterns(pc PatternConfig) (*compiledPatterns, error) {
+	p := &compiledPatterns{config: pc}
+	var err error
+	if p.amount, err = compilePattern("amount", pc.Amount, 2); err != nil {
+		return nil, err
+	}
+	if p.amountAfter, err = compilePattern("amountAfter", pc.AmountAfter, 2); err != nil {
+		return nil, err
+	}
+	if p.date, err = compilePattern("date", pc.Date, 1); err != nil {
+		return nil, err
+	}
+	if p.merchant, err = compilePattern("merchant", pc.Merchant, 1); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func compilePattern(name, expr string, groups int) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s pattern: %v", name, err)
+	}
+	if re.NumSubexp() < groups {
+		return nil, fmt.Errorf("invalid %s pattern: expected at least %d capture group(s), got %d", name, groups, re.NumSubexp())
+	}
+	return re, nil
+}
+
+func currentPatterns() *compiledPatterns {
+	patternsMu.RLock()
+	defer patternsMu.RUnlock()
+	return activePatterns
+}
+
+// CurrentPatternConfig returns the patterns the parser is using.
+func CurrentPatternConfig() PatternConfig {
+	return currentPatterns().config
+}
+
+// SetPatterns validates and activates a pattern set. Empty fields keep their
+// current value, so callers can update a single pattern.
+func SetPatterns(update PatternConfig) (PatternConfig, error) {
+	merged := CurrentPatternConfig()
+	if update.Amount != "" {
+		merged.Amount = update.Amount
+	}
+	if update.AmountAfter != "" {
+		merged.AmountAfter = update.AmountAfter
+	}
+	if update.Date != "" {
+		merged.Date = update.Date
+	}
+	if update.Merchant != "" {
+		merged.Merchant = update.Merchant
+	}
+
+	compiled, err := compilePatterns(merged)
+	if err != nil {
+		return PatternConfig{}, &AppError{Code: http.StatusBadRequest, ErrorCode: "INVALID_PATTERN", Msg: err.Error()}
+	}
+	patternsMu.Lock()
+	activePatterns = compiled
+	patternsMu.Unlock()
+	return merged, nil
+}
+
+// SavePatterns persists a pattern set so it survives restarts.
+func SavePatterns(ctx context.Context, client *redis.Client, pc PatternConfig) error {
+	data, err := json.Marshal(pc)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, patternsKey, data, 0).Err()
+}
+
+// LoadPatterns activates the persisted pattern set, if any.
+func LoadPatterns(ctx context.Context, client *redis.Client) error {
+	data, err := client.Get(ctx, patternsKey).Bytes()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var pc PatternConfig
+	if err := json.Unmarshal(data, &pc); err != nil {
+		return fmt.Errorf("invalid stored patterns: %v", err)
+	}
+	_, err = SetPatterns(pc)
+	return err
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/types"
+	"google.golang.org/api/gmail/v1"
+)
+
+// statementLinePattern matches one line item of a statement: a date, a
+// description and an amount, optionally marked Cr/Dr.
+var statementLinePattern = regexp.MustCompile(`(?i)^\s*(\d{1,2}[/-]\d{1,2}[/-]\d{2,4}|\d{1,2} [a-z]{3} \d{4})\s+(.+?)\s+(?:(?:rs\.?|inr|₹)\s*)?(\d[\d,]*\.\d{2})\s*(cr|dr)?\s*$`)
+
+var statementDateLayouts = []string{"2/1/2006", "2-1-2006", "2/1/06", "2-1-06", "2 Jan 2006"}
+
+// findPDFParts returns the message's PDF attachments.
+func findPDFParts(part *gmail.MessagePart) []*gmail.MessagePart {
+	var parts []*gmail.MessagePart
+	if part.Body != nil && (strings.EqualFold(part.MimeType, "application/pdf") ||
+		strings.HasSuffix(strings.ToLower(part.Filename), ".pdf")) {
+		parts = append(parts, part)
+	}
+	for _, nested := range part.Parts {
+		parts = append(parts, findPDFParts(nested)...)
+	}
+	return parts
+}
+
+// attachmentData returns a part's decoded body, downloading it when Gmail
+// only sent an attachment ID and keeping the download on the part so a
+// stored copy carries it. Parts over PDFMaxBytes are refused.
+func (gs *GmailService) attachmentData(ctx context.Context, messageID string, part *gmail.MessagePart) ([]byte, error) {
+	if part.Body.Size > int64(gs.config.PDFMaxBytes) {
+		return nil, fmt.Errorf("attachment %q is %d bytes, over the %d byte limit", part.Filename, part.Body.Size, gs.config.PDFMaxBytes)
+	}
+	data := part.Body.Data
+	if data == "" && part.Body.AttachmentId != "" {
+		if err := gs.breaker.Allow(); err != nil {
+			return nil, err
+		}
+		attachment, err := gs.service.Users.Messages.Attachments.Get("me", messageID, part.Body.AttachmentId).Context(ctx).Do()
+		gs.quota.Record(ctx, CallGet, 1)
+		gs.breaker.Record(err)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get attachment %q: %v", part.Filename, err)
+		}
+		data = attachment.Data
+		part.Body.Data = data
+	}
+	return base64.URLEncoding.DecodeString(data)
+}
+
+// statementTransactions parses the line items of every PDF statement
+// attached to a message. It returns nil when there are none, and
+// errParseTimeout when reading a statement's text takes longer than
+// ParseTimeout.
+func (gs *GmailService) statementTransactions(ctx context.Context, msg *gmail.Message) ([]types.Transaction, error) {
+	sender := senderDomain(msg)
+	var transactions []types.Transaction
+	for _, part := range findPDFParts(msg.Payload) {
+		data, err := gs.attachmentData(ctx, msg.Id, part)
+		if err != nil {
+			logger.Ctx(ctx).Debugf("Skipping PDF in message %s: %v", msg.Id, err)
+			continue
+		}
+		var items []types.Transaction
+		if !runWithTimeout(gs.config.ParseTimeout, func() { items = parseStatement(data, gs.now()) }) {
+			return nil, errParseTimeout
+		}
+		for _, txn := range items {
+			txn.Amount = RoundAmount(txn.Amount, gs.config.AmountDecimals)
+			txn.MerchantNormalized = NormalizeMerchant(txn.Merchant, gs.config.MerchantAliases)
+			txn.Category = categorizeWithRules(txn.Merchant, gs.categoryRules)
+			txn.MessageID = msg.Id
+			txn.ThreadID = msg.ThreadId
+			gs.applyPolarity(&txn, sender)
+			transactions = append(transactions, txn)
+		}
+	}
+	return transactions, nil
+}
+
+// parseStatement reads the line items of a PDF statement, resolving
+// two-digit years relative to now.
+func parseStatement(data []byte, now time.Time) []types.Transaction {
+	var items []types.Transaction
+	for _, line := range strings.Split(extractPDFText(data), "\n") {
+		if txn, ok := parseStatementLine(line, now); ok {
+			items = append(items, txn)
+		}
+	}
+	return items
+}
+
+// parseStatementLine reads one statement line item. Lines marked Cr are
+// credits; everything else is a debit. Two-digit years are resolved
+// relative to now.
+func parseStatementLine(line string, now time.Time) (types.Transaction, bool) {
+	m := statementLinePattern.FindStringSubmatch(line)
+	if m == nil {
+		return types.Transaction{}, false
+	}
+	var date time.Time
+	var err error
+	for _, layout := range statementDateLayouts {
+		if date, err = time.Parse(layout, m[1]); err == nil {
+			if strings.HasSuffix(layout, "/06") || strings.HasSuffix(layout, "-06") {
+				date = resolveCentury(date, now)
+			}
+			break
+		}
+	}
+	if err != nil {
+		return types.Transaction{}, false
+	}
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(m[3], ",", ""), 64)
+	if err != nil || amount == 0 {
+		return types.Transaction{}, false
+	}
+	txn := types.Transaction{
+		Date:        date.Format("2006-01-02"),
+		Amount:      amount,
+		Description: "Transaction from PDF statement",
+		Type:        types.TransactionTypeDebit,
+		Merchant:    strings.TrimSpace(m[2]),
+	}
+	if strings.EqualFold(m[4], "cr") {
+		txn.Type = types.TransactionTypeCredit
+	}
+	if refundPattern.MatchString(txn.Merchant) {
+		txn.IsRefund = true
+		txn.Type = types.TransactionTypeCredit
+	} else if adjustmentPattern.MatchString(txn.Merchant) && txn.Type == types.TransactionTypeCredit {
+		txn.IsAdjustment = true
+	}
+	return txn, true
+}
+
+// extractPDFText returns the text drawn by a PDF's content streams, one line
+// per text positioning step. It handles uncompressed and Flate streams with
+// simple (non-CID) font encodings, which is what statement generators emit;
+// anything else yields no text rather than an error.
+func extractPDFText(data []byte) string {
+	var text strings.Builder
+	for rest := data; ; {
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			break
+		}
+		dict := rest[:start]
+		if i := bytes.LastIndex(dict, []byte("obj")); i >= 0 {
+			dict = dict[i:]
+		}
+		body := rest[start+len("stream"):]
+		body = bytes.TrimPrefix(bytes.TrimPrefix(body, []byte("\r")), []byte("\n"))
+		end := bytes.Index(body, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		stream := body[:end]
+		rest = body[end+len("endstream"):]
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			r, err := zlib.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				continue
+			}
+			stream, err = io.ReadAll(r)
+			if err != nil && len(stream) == 0 {
+				continue
+			}
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue
+		}
+		text.WriteString(pdfContentText(stream))
+	}
+	return text.String()
+}
+
+// pdfContentText interprets the text operators of one content stream.
+func pdfContentText(content []byte) string {
+	var out, line, pending strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(line.String()); s != "" {
+			out.WriteString(s)
+			out.WriteByte('\n')
+		}
+		line.Reset()
+	}
+	inArray := false
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := pdfLiteralString(content[i:])
+			pending.WriteString(s)
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			i += 2
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return out.String()
+			}
+			raw := strings.Map(func(r rune) rune {
+				if strings.ContainsRune(" \t\r\n", r) {
+					return -1
+				}
+				return r
+			}, string(content[i+1:i+end]))
+			if len(raw)%2 == 1 {
+				raw += "0"
+			}
+			if decoded, err := hex.DecodeString(raw); err == nil {
+				pending.Write(decoded)
+			}
+			i += end + 1
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			flush()
+			line.WriteString(pending.String())
+			pending.Reset()
+			i++
+		case bytes.IndexByte([]byte(" \t\r\n\f>/{}"), c) >= 0:
+			i++
+		default:
+			j := i
+			for j < len(content) && bytes.IndexByte([]byte(" \t\r\n\f()<>[]{}/%'\""), content[j]) < 0 {
+				j++
+			}
+			token := string(content[i:j])
+			i = j
+			// A wide negative kern inside a TJ array is a word gap.
+			if n, err := strconv.ParseFloat(token, 64); err == nil {
+				if inArray && n <= -200 {
+					pending.WriteByte(' ')
+				}
+				continue
+			}
+			switch token {
+			case "Tj", "TJ":
+				line.WriteString(pending.String())
+				pending.Reset()
+			case "Td", "TD", "T*", "Tm", "ET":
+				flush()
+			}
+		}
+	}
+	flush()
+	return out.String()
+}
+
+// pdfLiteralString decodes the (…) string at the start of b, returning it
+// and the number of bytes consumed.
+func pdfLiteralString(b []byte) (string, int) {
+	var s strings.Builder
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '(':
+			if depth > 0 {
+				s.WriteByte(c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s.String(), i + 1
+			}
+			s.WriteByte(c)
+		case '\\':
+			i++
+			if i >= len(b) {
+				return s.String(), i
+			}
+			switch e := b[i]; e {
+			case 'n':
+				s.WriteByte('\n')
+			case 'r':
+				s.WriteByte('\r')
+			case 't':
+				s.WriteByte('\t')
+			case 'b':
+				s.WriteByte('\b')
+			case 'f':
+				s.WriteByte('\f')
+			case '\r', '\n':
+				// Line continuation.
+			default:
+				if e >= '0' && e <= '7' {
+					j := i
+					for j < len(b) && j < i+3 && b[j] >= '0' && b[j] <= '7' {
+						j++
+					}
+					v, _ := strconv.ParseUint(string(b[i:j]), 8, 8)
+					s.WriteByte(byte(v))
+					i = j - 1
+				} else {
+					s.WriteByte(e)
+				}
+			}
+		default:
+			s.WriteByte(c)
+		}
+	}
+	return s.String(), len(b)
+}
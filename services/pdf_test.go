@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"google.golang.org/api/gmail/v1"
+)
+
+// statementEmail builds a statement email whose PDF, of size bytes, Gmail
+// only sends by attachment ID.
+func statementEmail(id string, size int) *gmail.Message {
+	msg := gmailtest.Email(id, "statements@hdfcbank.net", "Your March statement", "", testNow.Add(-time.Hour))
+	msg.Payload.MimeType = "multipart/mixed"
+	msg.Payload.Body = nil
+	msg.Payload.Parts = []*gmail.MessagePart{
+		textPart("text/plain", "", "Your account statement for March is attached."),
+		{
+			MimeType: "application/pdf",
+			Filename: "statement.pdf",
+			Body:     &gmail.MessagePartBody{AttachmentId: id + "-pdf", Size: int64(size)},
+		},
+	}
+	return msg
+}
+
+// slowStatement is a PDF with enough line items to outlast a short parse
+// timeout.
+func slowStatement() []byte {
+	var content strings.Builder
+	content.WriteString("BT")
+	for i := 0; i < 200000; i++ {
+		content.WriteString(" 0 -14 Td (05/03/2024 AMAZON RETAIL 1,250.00) Tj")
+	}
+	content.WriteString(" ET")
+	return []byte(fmt.Sprintf("%%PDF-1.4\n1 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", content.Len(), content.String()))
+}
+
+func TestStatementTransactions(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/statement.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type item struct {
+		merchant string
+		amount   float64
+		txnType  string
+	}
+	all := []item{{"AMAZON RETAIL", 1250, "debit"}, {"SALARY ACME CORP", 50000, "credit"}, {"SWIGGY", 45.5, "debit"}}
+
+	tests := []struct {
+		name      string
+		pdf       []byte
+		configure func(*config.Config)
+		setup     func(gs *GmailService)
+		want      []item
+		warning   string
+	}{
+		{name: "disabled", pdf: fixture, configure: func(c *config.Config) { c.PDFStatements = false }},
+		{name: "line items, future-dated one dropped", pdf: fixture, want: all},
+		{name: "minimum amount applies", pdf: fixture, setup: func(gs *GmailService) { gs.SetMinAmount(100) }, want: all[:2]},
+		{name: "exclusions apply", pdf: fixture, setup: func(gs *GmailService) { gs.SetExclusions([]string{"swiggy"}) }, want: all[:2]},
+		{name: "over the size cap", pdf: fixture, configure: func(c *config.Config) { c.PDFMaxBytes = 100 }},
+		{name: "parse timeout", pdf: slowStatement(), configure: func(c *config.Config) {
+			c.PDFMaxBytes = 64 << 20
+			c.ParseTimeout = 5 * time.Millisecond
+		}, warning: "message s1 skipped: parsing timed out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, func(c *config.Config) {
+				c.PDFStatements = true
+				if tt.configure != nil {
+					tt.configure(c)
+				}
+			})
+			if tt.setup != nil {
+				tt.setup(gs)
+			}
+			fake.Add(statementEmail("s1", len(tt.pdf)))
+			fake.AddAttachment("s1-pdf", tt.pdf)
+
+			result, err := gs.FetchTransactions(context.Background(), 30)
+			if err != nil {
+				t.Fatalf("FetchTransactions: %v", err)
+			}
+			var got []item
+			for _, txn := range result.Transactions {
+				got = append(got, item{txn.Merchant, txn.Amount, txn.Type})
+				if txn.MessageID != "s1" {
+					t.Errorf("%s: message ID %q, want s1", txn.Merchant, txn.MessageID)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if warnings := strings.Join(result.Warnings, "\n"); !strings.Contains(warnings, tt.warning) {
+				t.Errorf("warnings %q, want %q", result.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestParseStatementLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		ok      bool
+		date    string
+		amount  float64
+		txnType string
+		refund  bool
+	}{
+		{"05/03/2024 AMAZON RETAIL 1,250.00", true, "2024-03-05", 1250, "debit", false},
+		{"08-03-24 SALARY ACME CORP Rs. 50,000.00 Cr", true, "2024-03-08", 50000, "credit", false},
+		{"31/12/99 OLD ENTRY 10.00", true, "1999-12-31", 10, "debit", false},
+		{"12 Mar 2024 REFUND FLIPKART INR 300.00", true, "2024-03-12", 300, "credit", true},
+		{"Opening balance 12,000.00", false, "", 0, "", false},
+		{"05/03/2024 ZERO CHARGE 0.00", false, "", 0, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			txn, ok := parseStatementLine(tt.line, testNow)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if txn.Date != tt.date || txn.Amount != tt.amount || txn.Type != tt.txnType || txn.IsRefund != tt.refund {
+				t.Errorf("got %s %v %s refund=%v, want %s %v %s refund=%v",
+					txn.Date, txn.Amount, txn.Type, txn.IsRefund, tt.date, tt.amount, tt.txnType, tt.refund)
+			}
+		})
+	}
+}
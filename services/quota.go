@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/go-redis/redis/v8"
+)
+
+const ErrCodeQuotaExceeded = "QUOTA_EXCEEDED"
+
+// Gmail API call kinds counted by QuotaTracker.
+const (
+	CallList  = "list"
+	CallGet   = "get"
+	CallBatch = "batch"
+)
+
+// QuotaTracker counts Gmail API calls in fixed time windows in Redis, shared
+// across server instances, and reports when a soft limit is reached.
+type QuotaTracker struct {
+	client    *redis.Client
+	window    time.Duration
+	softLimit int64
+	timeout   time.Duration
+}
+
+// NewQuotaTracker returns a tracker; a softLimit of 0 disables the limit but
+// calls are still counted. Each Redis operation is bounded by timeout (none
+// when 0) so a slow Redis can't stall the fetch being counted.
+func NewQuotaTracker(client *redis.Client, window time.Duration, softLimit int64, timeout time.Duration) *QuotaTracker {
+	return &QuotaTracker{client: client, window: window, softLimit: softLimit, timeout: timeout}
+}
+
+// opContext bounds one Redis operation by the tracker's timeout.
+func (q *QuotaTracker) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if q.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, q.timeout)
+}
+
+type QuotaUsage struct {
+	WindowStart   time.Time        `json:"windowStart"`
+	WindowSeconds int64            `json:"windowSeconds"`
+	SoftLimit     int64            `json:"softLimit"`
+	Total         int64            `json:"total"`
+	Calls         map[string]int64 `json:"calls"`
+}
+
+func (q *QuotaTracker) windowStart(now time.Time) time.Time {
+	return now.Truncate(q.window)
+}
+
+func (q *QuotaTracker) windowKey(now time.Time) string {
+	return "gmail_calls:" + strconv.FormatInt(q.windowStart(now).Unix(), 10)
+}
+
+// Record adds n calls of the given kind to the current window. Errors are
+// logged; accounting must never fail a fetch.
+func (q *QuotaTracker) Record(ctx context.Context, kind string, n int64) {
+	if q == nil || n <= 0 {
+		return
+	}
+	opCtx, cancel := q.opContext(ctx)
+	defer cancel()
+	key := q.windowKey(time.Now())
+	pipe := q.client.TxPipeline()
+	pipe.HIncrBy(opCtx, key, kind, n)
+	pipe.HIncrBy(opCtx, key, "total", n)
+	pipe.Expire(opCtx, key, 2*q.window)
+	if _, err := pipe.Exec(opCtx); err != nil {
+		logger.Ctx(ctx).Warnf("Error recording Gmail quota usage: %v", err)
+	}
+}
+
+// Usage returns the call counts for the current window.
+func (q *QuotaTracker) Usage(ctx context.Context) (*QuotaUsage, error) {
+	now := time.Now()
+	raw, err := q.client.HGetAll(ctx, q.windowKey(now)).Result()
+	if err != nil {
+		return nil, err
+	}
+	usage := &QuotaUsage{
+		WindowStart:   q.windowStart(now),
+		WindowSeconds: int64(q.window / time.Second),
+		SoftLimit:     q.softLimit,
+		Calls:         map[string]int64{CallList: 0, CallGet: 0, CallBatch: 0},
+	}
+	for kind, v := range raw {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		if kind == "total" {
+			usage.Total = n
+			continue
+		}
+		usage.Calls[kind] = n
+	}
+	return usage, nil
+}
+
+// CheckSoftLimit returns a QUOTA_EXCEEDED AppError once the current window's
+// calls reach the soft limit.
+func (q *QuotaTracker) CheckSoftLimit(ctx context.Context) error {
+	if q == nil || q.softLimit <= 0 {
+		return nil
+	}
+	opCtx, cancel := q.opContext(ctx)
+	defer cancel()
+	total, err := q.client.HGet(opCtx, q.windowKey(time.Now()), "total").Int64()
+	if err != nil && err != redis.Nil {
+		logger.Ctx(ctx).Warnf("Error reading Gmail quota usage: %v", err)
+		return nil
+	}
+	if total >= q.softLimit {
+		return &AppError{
+			Code:      http.StatusServiceUnavailable,
+			ErrorCode: ErrCodeQuotaExceeded,
+			Msg:       fmt.Sprintf("Gmail API soft limit of %d calls per %s reached; try again later", q.softLimit, q.window),
+		}
+	}
+	return nil
+}
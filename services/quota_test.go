@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"github.com/abhayyadav/funnyMoney/be/internal/redistest"
+)
+
+func TestQuotaTrackerCountsCalls(t *testing.T) {
+	tests := []struct {
+		name      string
+		messages  int
+		pageSize  int
+		failBatch bool
+		want      map[string]int64
+		total     int64
+	}{
+		{"one page, one batch", 3, 100, false, map[string]int64{CallList: 1, CallBatch: 1, CallGet: 0}, 2},
+		{"two pages", 3, 2, false, map[string]int64{CallList: 2, CallBatch: 1, CallGet: 0}, 3},
+		{"batch fails over to gets", 3, 100, true, map[string]int64{CallList: 1, CallBatch: 1, CallGet: 3}, 5},
+		{"nothing matched", 0, 100, false, map[string]int64{CallList: 1, CallBatch: 0, CallGet: 0}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, nil)
+			tracker := NewQuotaTracker(redistest.Run(t).Client(t), time.Hour, 0, time.Second)
+			gs.SetQuotaTracker(tracker)
+			fake.SetPageSize(tt.pageSize)
+			for i := 0; i < tt.messages; i++ {
+				fake.Add(debitEmail(fmt.Sprintf("m%d", i), testNow.AddDate(0, 0, -1), 100, "AMAZON"))
+			}
+			if tt.failBatch {
+				fake.Fail(gmailtest.Batch, http.StatusInternalServerError)
+			}
+
+			if _, err := gs.FetchTransactions(context.Background(), 7); err != nil {
+				t.Fatal(err)
+			}
+			usage, err := tracker.Usage(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for kind, want := range tt.want {
+				if usage.Calls[kind] != want {
+					t.Errorf("%s calls = %d, want %d", kind, usage.Calls[kind], want)
+				}
+			}
+			if usage.Total != tt.total {
+				t.Errorf("total = %d, want %d", usage.Total, tt.total)
+			}
+		})
+	}
+}
+
+func TestQuotaTrackerSoftLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		softLimit int64
+		recorded  int64
+		delay     time.Duration
+		exceeded  bool
+	}{
+		{"disabled", 0, 100, 0, false},
+		{"under the limit", 10, 9, 0, false},
+		{"at the limit", 10, 10, 0, true},
+		{"over the limit", 10, 25, 0, true},
+		{"slow Redis lets the fetch through", 10, 25, 200 * time.Millisecond, false},
+	}
+	const timeout = 50 * time.Millisecond
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redis := redistest.Run(t)
+			tracker := NewQuotaTracker(redis.Client(t), time.Hour, tt.softLimit, timeout)
+			tracker.Record(context.Background(), CallGet, tt.recorded)
+			redis.SetDelay(tt.delay)
+
+			start := time.Now()
+			err := tracker.CheckSoftLimit(context.Background())
+			if elapsed := time.Since(start); tt.delay > 0 && elapsed >= tt.delay {
+				t.Errorf("CheckSoftLimit took %s, want it cut off at %s", elapsed, timeout)
+			}
+			if exceeded := err != nil; exceeded != tt.exceeded {
+				t.Fatalf("exceeded = %v (%v), want %v", exceeded, err, tt.exceeded)
+			}
+			if err != nil {
+				if appErr, ok := err.(*AppError); !ok || appErr.ErrorCode != ErrCodeQuotaExceeded || appErr.Code != http.StatusServiceUnavailable {
+					t.Errorf("err = %#v, want a 503 QUOTA_EXCEEDED AppError", err)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchTransactionsStopsAtSoftLimit(t *testing.T) {
+	gs, fake := newTestService(t, nil)
+	gs.SetQuotaTracker(NewQuotaTracker(redistest.Run(t).Client(t), time.Hour, 2, time.Second))
+	fake.Add(debitEmail("m1", testNow.AddDate(0, 0, -1), 100, "AMAZON"))
+
+	for i, wantErr := range []bool{false, true} {
+		_, err := gs.FetchTransactions(context.Background(), 7)
+		if (err != nil) != wantErr {
+			t.Fatalf("fetch %d: err = %v, want error %v", i+1, err, wantErr)
+		}
+	}
+	if got := fake.Calls(gmailtest.List); got != 1 {
+		t.Errorf("Gmail listed %d times, want 1 (second fetch refused before calling Gmail)", got)
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/abhayyadav/funnyMoney/be/types"
+	"google.golang.org/api/gmail/v1"
+)
+
+// storedHeaders are the headers parsing reads, kept on stored copies.
+var storedHeaders = []string{"From", "Date", "Subject"}
+
+// SetOnMessage registers a callback for every fetched message that reaches
+// the parser, with a compact copy suitable for storing: the headers parsing
+// needs and the stripped body, plus, with PDF_STATEMENTS on, the statement
+// PDFs it downloaded. ParseStoredMessages accepts these copies.
+func (gs *GmailService) SetOnMessage(fn func(*gmail.Message)) {
+	gs.onMessage = fn
+}
+
+// storedCopy reduces a message to what parseTransactionEmail and
+// statementTransactions read. The body is stored under the sender's most
+// preferred MIME type so the same part preference finds it again; PDFs whose
+// data was read are kept inline alongside it.
+func (gs *GmailService) storedCopy(msg *gmail.Message) *gmail.Message {
+	sender := senderDomain(msg)
+	preference := gs.partPreference(sender)
+	if len(preference) == 0 {
+		preference = defaultPartPreference
+	}
+	body := stripHTMLTags(extractMessageContent(msg.Payload, preference))
+
+	payload := &gmail.MessagePart{
+		MimeType: preference[0],
+		Body:     &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(body))},
+	}
+	if gs.config.PDFStatements {
+		var pdfs []*gmail.MessagePart
+		for _, part := range findPDFParts(msg.Payload) {
+			if part.Body.Data != "" {
+				pdfs = append(pdfs, &gmail.MessagePart{
+					MimeType: part.MimeType,
+					Filename: part.Filename,
+					Body:     &gmail.MessagePartBody{Data: part.Body.Data, Size: part.Body.Size},
+				})
+			}
+		}
+		if len(pdfs) > 0 {
+			payload = &gmail.MessagePart{MimeType: "multipart/mixed", Parts: append([]*gmail.MessagePart{payload}, pdfs...)}
+		}
+	}
+	for _, name := range storedHeaders {
+		if value := partHeader(msg.Payload, name); value != "" {
+			payload.Headers = append(payload.Headers, &gmail.MessagePartHeader{Name: name, Value: value})
+		}
+	}
+	return &gmail.Message{Id: msg.Id, ThreadId: msg.ThreadId, InternalDate: msg.InternalDate, Payload: payload}
+}
+
+// ParseStoredMessages re-runs the parser, with the current patterns and
+// rules, over copies saved from SetOnMessage. No Gmail calls are made.
+func (gs *GmailService) ParseStoredMessages(ctx context.Context, messages []*gmail.Message) ([]types.Transaction, []string) {
+	return gs.parseFetched(ctx, messages)
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+// threadKey identifies one transaction within a Gmail thread. Banks often
+// thread an alert with its follow-up ("your payment was successful"), and
+// both parse into the same transaction.
+type threadKey struct {
+	thread   string
+	date     string
+	amount   float64
+	txnType  string
+	currency string
+}
+
+// completeness scores how much of a transaction a parse recovered, to pick
+// the better of two parses of the same transaction.
+func completeness(txn types.Transaction) int {
+	score := 0
+	for _, field := range []string{txn.Merchant, txn.Account, txn.Currency, txn.Timestamp} {
+		if field != "" {
+			score++
+		}
+	}
+	if txn.Category != "" && txn.Category != UncategorizedCategory {
+		score++
+	}
+	return score
+}
+
+// SameMoment reports whether two transactions happened within window of each
+// other, comparing dates when either lacks a timestamp.
+func SameMoment(a, b types.Transaction, window time.Duration) bool {
+	ta, errA := time.Parse(time.RFC3339, a.Timestamp)
+	tb, errB := time.Parse(time.RFC3339, b.Timestamp)
+	if errA != nil || errB != nil {
+		return a.Date == b.Date
+	}
+	diff := ta.Sub(tb)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= window
+}
+
+// addTransaction appends txn to transactions, unless another message in
+// its thread already gave the same transaction within DedupWindow; then the
+// more complete parse is kept in the earlier one's place. Two purchases of
+// the same amount further apart are both kept. Only new transactions are
+// emitted, so a stream carries the first parse of a repeated one.
+func (gs *GmailService) addTransaction(ctx context.Context, transactions []types.Transaction, threads map[threadKey][]int, txn types.Transaction) []types.Transaction {
+	if gs.config.ThreadDedup && txn.ThreadID != "" {
+		key := threadKey{txn.ThreadID, txn.Date, txn.Amount, txn.Type, txn.Currency}
+		for _, i := range threads[key] {
+			if transactions[i].MessageID == txn.MessageID || !SameMoment(transactions[i], txn, gs.config.DedupWindow) {
+				continue
+			}
+			logger.Ctx(ctx).Debugf("Message %s repeats message %s in thread %s", txn.MessageID, transactions[i].MessageID, txn.ThreadID)
+			if completeness(txn) > completeness(transactions[i]) {
+				transactions[i] = txn
+			}
+			return transactions
+		}
+		threads[key] = append(threads[key], len(transactions))
+	}
+	gs.emit(txn)
+	return append(transactions, txn)
+}
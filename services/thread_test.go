@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"google.golang.org/api/gmail/v1"
+)
+
+// threadEmail is an alert sent at sent in Gmail thread thread.
+func threadEmail(id, thread, body string, sent time.Time) *gmail.Message {
+	msg := gmailtest.Email(id, "alerts@hdfcbank.net", "Transaction alert", body, sent)
+	msg.ThreadId = thread
+	return msg
+}
+
+func TestThreadDedup(t *testing.T) {
+	const (
+		alert    = "Rs.250.00 debited from your account on 14-03-24."
+		followUp = "Your payment of Rs.250.00 at AMAZON on 14-03-24 was successful."
+	)
+	sent := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		dedup    bool
+		messages []*gmail.Message
+		want     []string
+	}{
+		{name: "alert and follow-up collapse to the fuller parse", dedup: true,
+			messages: []*gmail.Message{threadEmail("m1", "t1", alert, sent), threadEmail("m2", "t1", followUp, sent.Add(2*time.Minute))},
+			want:     []string{"m2 AMAZON"}},
+		{name: "fuller parse kept whichever is sent first", dedup: true,
+			messages: []*gmail.Message{threadEmail("m1", "t1", followUp, sent), threadEmail("m2", "t1", alert, sent.Add(2*time.Minute))},
+			want:     []string{"m1 AMAZON"}},
+		{name: "same amount hours apart kept", dedup: true,
+			messages: []*gmail.Message{threadEmail("m1", "t1", followUp, sent), threadEmail("m2", "t1", followUp, sent.Add(3*time.Hour))},
+			want:     []string{"m2 AMAZON", "m1 AMAZON"}},
+		{name: "different threads kept", dedup: true,
+			messages: []*gmail.Message{threadEmail("m1", "t1", alert, sent), threadEmail("m2", "t2", followUp, sent.Add(2*time.Minute))},
+			want:     []string{"m2 AMAZON", "m1 "}},
+		{name: "dedup off", dedup: false,
+			messages: []*gmail.Message{threadEmail("m1", "t1", alert, sent), threadEmail("m2", "t1", followUp, sent.Add(2*time.Minute))},
+			want:     []string{"m2 AMAZON", "m1 "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, fake := newTestService(t, func(cfg *config.Config) { cfg.ThreadDedup = tt.dedup })
+			fake.Add(tt.messages...)
+			result, err := gs.FetchTransactions(context.Background(), 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Messages are listed newest first.
+			var got []string
+			for _, txn := range result.Transactions {
+				got = append(got, txn.MessageID+" "+txn.Merchant)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("transactions %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
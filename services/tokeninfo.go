@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	ErrCodeInsufficientScope = "INSUFFICIENT_SCOPE"
+	ErrCodeInvalidToken      = "INVALID_TOKEN"
+	ErrCodeGmailDisabled     = "GMAIL_DISABLED"
+)
+
+// TokenInfoURL is Google's token introspection endpoint.
+var TokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+
+// gmailReadScopes are the granted scopes that allow reading messages.
+var gmailReadScopes = []string{
+	"https://www.googleapis.com/auth/gmail.readonly",
+	"https://www.googleapis.com/auth/gmail.modify",
+	"https://mail.google.com/",
+}
+
+// Bounds for a plausible OAuth access token. Google's are well under the max;
+// anything outside these, or with characters no token uses, is rejected
+// before it reaches the network.
+const (
+	minAccessTokenLen = 20
+	maxAccessTokenLen = 4096
+)
+
+var accessTokenPattern = regexp.MustCompile(`^[A-Za-z0-9._~+/\-]+=*$`)
+
+// ValidateTokenFormat is a cheap sanity check of an access token's shape. It
+// returns a 401 AppError with ErrCodeInvalidToken for obviously bad tokens;
+// passing it says nothing about whether Google will accept the token.
+func ValidateTokenFormat(accessToken string) error {
+	if len(accessToken) < minAccessTokenLen || len(accessToken) > maxAccessTokenLen ||
+		!accessTokenPattern.MatchString(accessToken) {
+		return &AppError{
+			Code:      http.StatusUnauthorized,
+			ErrorCode: ErrCodeInvalidToken,
+			Msg:       "access token is malformed",
+		}
+	}
+	return nil
+}
+
+type TokenInfo struct {
+	Scope     string `json:"scope"`
+	ExpiresIn string `json:"expires_in"`
+	Email     string `json:"email"`
+}
+
+// Scopes returns the granted scopes as a slice.
+func (ti *TokenInfo) Scopes() []string {
+	return strings.Fields(ti.Scope)
+}
+
+// FetchTokenInfo introspects an access token. An invalid or expired token is
+// reported as a 401 AppError with ErrCodeInvalidToken.
+func FetchTokenInfo(ctx context.Context, accessToken string) (*TokenInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		TokenInfoURL+"?access_token="+url.QueryEscape(accessToken), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, &AppError{
+			Code: http.StatusBadGateway,
+			Msg:  fmt.Sprintf("unable to verify access token: %v", err),
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		return nil, &AppError{
+			Code:      http.StatusUnauthorized,
+			ErrorCode: ErrCodeInvalidToken,
+			Msg:       "access token is invalid or expired",
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &AppError{
+			Code: http.StatusBadGateway,
+			Msg:  fmt.Sprintf("unable to verify access token: tokeninfo returned %d", resp.StatusCode),
+		}
+	}
+
+	var info TokenInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("unable to decode tokeninfo response: %v", err)
+	}
+	return &info, nil
+}
+
+// TokenInfoCache keeps tokeninfo results in Redis under a hash of the access
+// token, so repeated requests with one token are introspected once per TTL.
+// A nil cache is valid and caches nothing.
+type TokenInfoCache struct {
+	client  *redis.Client
+	ttl     time.Duration
+	timeout time.Duration
+}
+
+// NewTokenInfoCache returns a cache keeping results for up to ttl. Each Redis
+// operation is bounded by timeout (none when 0) so a slow Redis falls back to
+// introspecting instead of stalling every authenticated request.
+func NewTokenInfoCache(client *redis.Client, ttl, timeout time.Duration) *TokenInfoCache {
+	return &TokenInfoCache{client: client, ttl: ttl, timeout: timeout}
+}
+
+// opContext bounds one Redis operation by the cache's timeout.
+func (c *TokenInfoCache) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+func tokenInfoKey(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return "tokeninfo:" + hex.EncodeToString(sum[:])
+}
+
+// Lookup returns the token's introspection result, from the cache when it
+// holds one and otherwise from FetchTokenInfo. Cache errors are logged and
+// treated as misses.
+func (c *TokenInfoCache) Lookup(ctx context.Context, accessToken string) (*TokenInfo, error) {
+	if c == nil {
+		return FetchTokenInfo(ctx, accessToken)
+	}
+	key := tokenInfoKey(accessToken)
+	opCtx, cancel := c.opContext(ctx)
+	data, err := c.client.Get(opCtx, key).Bytes()
+	cancel()
+	if err == nil {
+		var info TokenInfo
+		if err := json.Unmarshal(data, &info); err == nil {
+			return &info, nil
+		}
+	} else if err != redis.Nil {
+		logger.Ctx(ctx).Warnf("Error reading tokeninfo cache: %v", err)
+	}
+
+	info, err := FetchTokenInfo(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	// Never keep a result past the token's own expiry.
+	ttl := c.ttl
+	if secs, err := strconv.Atoi(info.ExpiresIn); err == nil && time.Duration(secs)*time.Second < ttl {
+		ttl = time.Duration(secs) * time.Second
+	}
+	if ttl > 0 {
+		data, _ := json.Marshal(info)
+		opCtx, cancel := c.opContext(ctx)
+		defer cancel()
+		if err := c.client.Set(opCtx, key, data, ttl).Err(); err != nil {
+			logger.Ctx(ctx).Warnf("Error writing tokeninfo cache: %v", err)
+		}
+	}
+	return info, nil
+}
+
+// Invalidate drops a token's cached result, e.g. after Gmail rejects it.
+func (c *TokenInfoCache) Invalidate(ctx context.Context, accessToken string) {
+	if c == nil {
+		return
+	}
+	opCtx, cancel := c.opContext(ctx)
+	defer cancel()
+	if err := c.client.Del(opCtx, tokenInfoKey(accessToken)).Err(); err != nil {
+		logger.Ctx(ctx).Warnf("Error invalidating tokeninfo cache: %v", err)
+	}
+}
+
+// CheckGmailScope verifies the token was granted a scope that can read Gmail,
+// so users who skipped the permission at consent time get an actionable error
+// instead of a failure deep inside the fetch.
+func (c *TokenInfoCache) CheckGmailScope(ctx context.Context, accessToken string) error {
+	info, err := c.Lookup(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	return checkScopes(info.Scopes())
+}
+
+func checkScopes(granted []string) error {
+	for _, scope := range granted {
+		for _, allowed := range gmailReadScopes {
+			if scope == allowed {
+				return nil
+			}
+		}
+	}
+	return &AppError{
+		Code:      http.StatusForbidden,
+		ErrorCode: ErrCodeInsufficientScope,
+		Msg:       "access token is missing the gmail.readonly scope; sign in again and grant read access to Gmail",
+	}
+}
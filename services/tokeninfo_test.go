@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+	"github.com/abhayyadav/funnyMoney/be/internal/redistest"
+)
+
+func TestTokenInfoCache(t *testing.T) {
+	const (
+		token   = "ya29.token-info-cache-test-01"
+		timeout = 50 * time.Millisecond
+	)
+	tests := []struct {
+		name       string
+		nilCache   bool
+		invalidate bool
+		delay      time.Duration
+		tokenInfo  int
+	}{
+		{name: "second lookup is cached", tokenInfo: 1},
+		{name: "invalidate forces introspection", invalidate: true, tokenInfo: 2},
+		{name: "nil cache always introspects", nilCache: true, tokenInfo: 2},
+		{name: "slow Redis times out to introspection", delay: 4 * timeout, tokenInfo: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := gmailtest.Run(t, "user@example.com")
+			fake.Grant(token, gmailtest.ReadScope)
+			saved := TokenInfoURL
+			TokenInfoURL = fake.TokenInfoURL()
+			t.Cleanup(func() { TokenInfoURL = saved })
+
+			srv := redistest.Run(t)
+			var cache *TokenInfoCache
+			if !tt.nilCache {
+				cache = NewTokenInfoCache(srv.Client(t), time.Minute, timeout)
+			}
+			ctx := context.Background()
+
+			if _, err := cache.Lookup(ctx, token); err != nil {
+				t.Fatal(err)
+			}
+			if tt.invalidate {
+				cache.Invalidate(ctx, token)
+			}
+			srv.SetDelay(tt.delay)
+			start := time.Now()
+			info, err := cache.Lookup(ctx, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.delay > 0 && time.Since(start) >= tt.delay {
+				t.Errorf("lookup took %s, want Redis cut off at %s", time.Since(start), timeout)
+			}
+			if info.Email != "user@example.com" {
+				t.Errorf("email = %q, want user@example.com", info.Email)
+			}
+			if got := fake.Calls(gmailtest.TokenInfo); got != tt.tokenInfo {
+				t.Errorf("tokeninfo called %d times, want %d", got, tt.tokenInfo)
+			}
+		})
+	}
+}
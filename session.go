@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/logger"
+	"github.com/abhayyadav/funnyMoney/be/services"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// sessionHeader carries the session token returned by /connect.
+	sessionHeader = "X-Session-Token"
+	// errCodeInvalidSession is the errorCode of unknown or expired sessions.
+	errCodeInvalidSession = "INVALID_SESSION"
+)
+
+type ConnectRequest struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+}
+
+type ConnectResponse struct {
+	SessionToken string `json:"sessionToken"`
+	EmailAddress string `json:"emailAddress"`
+}
+
+// storedSession is what Redis holds, encrypted, for a session.
+type storedSession struct {
+	UserID string        `json:"userId"`
+	Token  *oauth2.Token `json:"token"`
+}
+
+// getSessionKey stores sessions under a hash of the session token, so the
+// token itself never sits in Redis.
+func getSessionKey(sessionToken string) string {
+	sum := sha256.Sum256([]byte(sessionToken))
+	return cfg.CachePrefix + ":session:" + hex.EncodeToString(sum[:])
+}
+
+// connectHandler checks the user's OAuth tokens, stores them encrypted
+// server-side and returns a session token to send as X-Session-Token instead
+// of access_token.
+func connectHandler(w http.ResponseWriter, r *http.Request) {
+	if len(cfg.EncryptionKey) == 0 {
+		respondError(w, http.StatusServiceUnavailable, "Sessions are disabled: ENCRYPTION_KEY is not configured")
+		return
+	}
+	var req ConnectRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	_, userID, err := gmailServiceForToken(r.Context(), req.AccessToken)
+	if err != nil {
+		respondAppError(w, err)
+		return
+	}
+
+	token := &oauth2.Token{AccessToken: strings.TrimSpace(req.AccessToken), RefreshToken: req.RefreshToken}
+	if req.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+	}
+	sessionToken, err := newSessionToken()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+	if err := saveSession(r.Context(), sessionToken, storedSession{UserID: userID, Token: token}); err != nil {
+		logger.Ctx(r.Context()).Errorf("Error saving session for %s: %v", userID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConnectResponse{SessionToken: sessionToken, EmailAddress: userID})
+}
+
+// disconnectHandler deletes the session and the tokens stored with it, and
+// stops refreshing the user's caches in the background.
+func disconnectHandler(w http.ResponseWriter, r *http.Request) {
+	sessionToken := r.Header.Get(sessionHeader)
+	if sessionToken == "" {
+		respondError(w, http.StatusUnauthorized, "Missing "+sessionHeader+" header")
+		return
+	}
+	if session, err := loadSession(r.Context(), sessionToken); err == nil {
+		unregisterScheduledUser(r.Context(), session.UserID)
+	}
+	opCtx, cancel := redisContext(r.Context())
+	defer cancel()
+	if err := redisClient.Del(opCtx, getSessionKey(sessionToken)).Err(); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete session")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func saveSession(parent context.Context, sessionToken string, session storedSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	sealed, err := encrypt(data)
+	if err != nil {
+		return err
+	}
+	opCtx, cancel := redisContext(parent)
+	defer cancel()
+	return redisClient.Set(opCtx, getSessionKey(sessionToken), sealed, cfg.SessionTTL).Err()
+}
+
+func invalidSessionError() error {
+	return &services.AppError{Code: http.StatusUnauthorized, ErrorCode: errCodeInvalidSession, Msg: "Session is invalid or has expired"}
+}
+
+// loadSession returns the stored session for a session token. Unknown or
+// expired sessions, and ones that no longer decrypt, are a 401.
+func loadSession(parent context.Context, sessionToken string) (*storedSession, error) {
+	opCtx, cancel := redisContext(parent)
+	defer cancel()
+	sealed, err := redisClient.Get(opCtx, getSessionKey(sessionToken)).Bytes()
+	if err == redis.Nil {
+		return nil, invalidSessionError()
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := decrypt(sealed)
+	if err != nil {
+		logger.Ctx(parent).Warnf("Error decrypting session: %v", err)
+		return nil, invalidSessionError()
+	}
+	var session storedSession
+	if err := json.Unmarshal(data, &session); err != nil || session.Token == nil {
+		return nil, invalidSessionError()
+	}
+	return &session, nil
+}
+
+// gmailServiceForSession builds a Gmail service from a stored session. The
+// OAuth client refreshes the access token itself when a refresh token was
+// provided at /connect.
+func gmailServiceForSession(reqCtx context.Context, sessionToken string) (*services.GmailService, string, error) {
+	session, err := loadSession(reqCtx, sessionToken)
+	if err != nil {
+		return nil, "", err
+	}
+	gs, err := gmailServiceForOAuthToken(session.Token)
+	if err != nil {
+		return nil, "", err
+	}
+	return gs, session.UserID, nil
+}
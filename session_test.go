@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+)
+
+// connect opens a session for testToken and returns the session token.
+func (env *testEnv) connect(t *testing.T) string {
+	t.Helper()
+	rec := env.doBody("POST", "/connect", `{"access_token":"`+testToken+`","expires_in":3599}`, "Content-Type", "application/json")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("connect: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp ConnectResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.SessionToken == "" || resp.EmailAddress != testEmail {
+		t.Fatalf("connect = %+v, want a session for %s", resp, testEmail)
+	}
+	return resp.SessionToken
+}
+
+func sessionTestConfig(c *config.Config) {
+	c.EncryptionKey = []byte(strings.Repeat("k", 32))
+	c.SchedulerInterval = time.Minute
+}
+
+func TestConnect(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*config.Config)
+		body      string
+		status    int
+	}{
+		{"valid token", sessionTestConfig, `{"access_token":"` + testToken + `"}`, http.StatusOK},
+		{"with refresh token", sessionTestConfig, `{"access_token":"` + testToken + `","refresh_token":"1//r","expires_in":3599}`, http.StatusOK},
+		{"ungranted token", sessionTestConfig, `{"access_token":"ya29.not-granted-token"}`, http.StatusUnauthorized},
+		{"missing token", sessionTestConfig, `{}`, http.StatusUnauthorized},
+		{"no encryption key", nil, `{"access_token":"` + testToken + `"}`, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, tt.configure)
+			rec := env.doBody("POST", "/connect", tt.body, "Content-Type", "application/json")
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			for _, key := range env.redis.Keys() {
+				value, _ := env.redis.Get(key)
+				if strings.Contains(value, testToken) {
+					t.Errorf("%s holds the access token in plaintext", key)
+				}
+				if strings.HasPrefix(key, "session:") {
+					t.Errorf("session stored under %s, outside the %s: namespace", key, cfg.CachePrefix)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"transactions", "GET", "/transactions?filter=weekly&endDate=2024-03-15", http.StatusOK},
+		{"whoami", "GET", "/whoami", http.StatusOK},
+		{"refresh", "POST", "/refresh", http.StatusOK},
+		{"aggregate", "GET", "/transactions/aggregate?groupBy=merchant", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, sessionTestConfig)
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			session := env.connect(t)
+
+			if rec := env.do(tt.method, tt.path, sessionHeader, session); rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if rec := env.do(tt.method, tt.path, sessionHeader, "unknown-session"); rec.Code != http.StatusUnauthorized || errorCodeOf(rec) != errCodeInvalidSession {
+				t.Errorf("unknown session: status %d, errorCode %q", rec.Code, errorCodeOf(rec))
+			}
+			// A session's token is never copied into the scheduler.
+			if members := env.redis.Members(scheduledUsersKey); len(members) != 0 {
+				t.Errorf("scheduled users = %v, want none", members)
+			}
+		})
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	tests := []struct {
+		name        string
+		session     func(env *testEnv, t *testing.T) string
+		status      int
+		unscheduled bool
+	}{
+		{"connected session", func(env *testEnv, t *testing.T) string { return env.connect(t) }, http.StatusNoContent, true},
+		{"unknown session", func(*testEnv, *testing.T) string { return "unknown-session" }, http.StatusNoContent, false},
+		{"no session header", func(*testEnv, *testing.T) string { return "" }, http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, sessionTestConfig)
+			session := tt.session(env, t)
+			// The user also refreshed with a raw token, so is scheduled.
+			if rec := env.do("POST", "/refresh?access_token="+testToken); rec.Code != http.StatusOK {
+				t.Fatalf("refresh: status %d: %s", rec.Code, rec.Body.String())
+			}
+
+			var headers []string
+			if session != "" {
+				headers = []string{sessionHeader, session}
+			}
+			if rec := env.do("POST", "/disconnect", headers...); rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if scheduled := len(env.redis.Members(scheduledUsersKey)) > 0; scheduled == tt.unscheduled {
+				t.Errorf("still scheduled = %v, want %v", scheduled, !tt.unscheduled)
+			}
+			if session == "" {
+				return
+			}
+			if rec := env.do("GET", "/whoami", sessionHeader, session); rec.Code != http.StatusUnauthorized {
+				t.Errorf("whoami after disconnect: status %d, want 401", rec.Code)
+			}
+		})
+	}
+}
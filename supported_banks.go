@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/abhayyadav/funnyMoney/be/services"
+)
+
+type SupportedBanksResponse struct {
+	Issuers []services.IssuerProfile `json:"issuers"`
+	// SendersRestricted is true when only the allowlisted issuers are parsed.
+	SendersRestricted bool   `json:"sendersRestricted"`
+	Generic           string `json:"generic"`
+}
+
+// supportedBanksHandler lists the issuers the deployment is configured for,
+// so users can check their bank before connecting.
+func supportedBanksHandler(w http.ResponseWriter, r *http.Request) {
+	resp := SupportedBanksResponse{
+		Issuers:           services.SupportedIssuers(cfg),
+		SendersRestricted: len(cfg.SenderDomains) > 0,
+		Generic:           "Alerts from other banks are parsed with the generic profile, which expects an amount with a currency and a dd-mm-yy date.",
+	}
+	if resp.SendersRestricted {
+		resp.Generic = "Only alerts from the allowlisted issuers are parsed."
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
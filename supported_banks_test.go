@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/abhayyadav/funnyMoney/be/config"
+)
+
+func TestSupportedBanks(t *testing.T) {
+	tests := []struct {
+		name       string
+		configure  func(*config.Config)
+		issuers    string
+		restricted bool
+	}{
+		{"nothing configured", nil, "", false},
+		{"allowlist", func(c *config.Config) {
+			c.SenderDomains = []string{"hdfcbank.net", "icicibank.com"}
+		}, "hdfcbank.net[allowlisted] icicibank.com[allowlisted]", true},
+		{"per-issuer overrides", func(c *config.Config) {
+			c.IssuerCurrencySymbols = map[string]map[string]string{"commbank.com.au": {"$": "AUD"}}
+			c.IssuerPartPreference = map[string][]string{"axisbank.com": {"text/html"}}
+			c.IssuerPolarity = map[string]string{"amex.com": "credit"}
+		}, "amex.com[polarity] axisbank.com[parts] commbank.com.au[currency]", false},
+		{"allowlisted issuer with an override", func(c *config.Config) {
+			c.SenderDomains = []string{"hdfcbank.net"}
+			c.IssuerPartPreference = map[string][]string{"hdfcbank.net": {"text/html"}}
+		}, "hdfcbank.net[allowlisted parts]", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, tt.configure)
+			rec := env.do("GET", "/supported-banks")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp SupportedBanksResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			for i, p := range resp.Issuers {
+				var traits []string
+				if p.Allowlisted {
+					traits = append(traits, "allowlisted")
+				}
+				if len(p.CurrencySymbols) > 0 {
+					traits = append(traits, "currency")
+				}
+				if len(p.PartPreference) > 0 {
+					traits = append(traits, "parts")
+				}
+				if p.Polarity != "" {
+					traits = append(traits, "polarity")
+				}
+				if i > 0 {
+					got += " "
+				}
+				got += fmt.Sprintf("%s%v", p.Domain, traits)
+			}
+			if got != tt.issuers {
+				t.Errorf("issuers = %q, want %q", got, tt.issuers)
+			}
+			if resp.SendersRestricted != tt.restricted || resp.Generic == "" {
+				t.Errorf("restricted = %v, generic %q; want %v and a note", resp.SendersRestricted, resp.Generic, tt.restricted)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package types
+
+type Summary struct {
+	Total      float64 `json:"total"`
+	Previously float64 `json:"previously"`
+	// ChangePercentage is nil when Previously is zero: new spending for the
+	// daily, weekly and monthly filters, but for filter=all simply that
+	// there is nothing to compare with.
+	ChangePercentage *float64 `json:"changePercentage"`
+	Income           float64  `json:"income"`
+	Expense          float64  `json:"expense"`
+	Net              float64  `json:"net"`
+	// Baseline names what Previously measures when it is not simply the
+	// period before: "lastYear" or "rolling3".
+	Baseline string `json:"baseline,omitempty"`
+	// Set for filter=all only, where there is no previous period to compare.
+	Count        int     `json:"count,omitempty"`
+	DailyAverage float64 `json:"dailyAverage,omitempty"`
+	FirstDate    string  `json:"firstDate,omitempty"`
+	LastDate     string  `json:"lastDate,omitempty"`
+}
+
+// TransactionsResponse is the body of /transactions and the cached views.
+type TransactionsResponse struct {
+	Summary    Summary       `json:"summary"`
+	Details    []Transaction `json:"details"`
+	Series     []DayTotal    `json:"series"`
+	Warnings   []string      `json:"warnings,omitempty"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+	Matched    *MatchCount   `json:"matched,omitempty"`
+	// WindowStart and WindowEnd (exclusive) are the RFC3339 bounds of the
+	// Gmail search behind the response, in the request's timezone.
+	WindowStart string `json:"windowStart,omitempty"`
+	WindowEnd   string `json:"windowEnd,omitempty"`
+	// Truncated is set when FETCH_BUDGET_MS ran out, or Gmail started
+	// failing, before every matching email was fetched.
+	Truncated bool `json:"truncated,omitempty"`
+	// SearchedFolders names the mail searched, e.g. ["all mail", "spam",
+	// "trash"] when Spam and Trash were included.
+	SearchedFolders []string `json:"searchedFolders,omitempty"`
+}
+
+// MatchCount is how many emails matched the search, counted from message
+// IDs alone. Some of them may not parse into transactions. Exact is false
+// when the count is Gmail's estimate (the listing was truncated or paged).
+type MatchCount struct {
+	Messages int64 `json:"messages"`
+	Exact    bool  `json:"exact"`
+}
+
+// DayTotal is one point of the per-day spend series.
+type DayTotal struct {
+	Date  string  `json:"date"`
+	Total float64 `json:"total"`
+}
+
+// DayGroup is one day of a group=day /transactions response: the day's
+// transactions and their spend subtotal.
+type DayGroup struct {
+	Date         string        `json:"date"`
+	Total        float64       `json:"total"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// AggregateBucket is one group in a /transactions/aggregate response.
+type AggregateBucket struct {
+	Key   string  `json:"key"`
+	Total float64 `json:"total"`
+	Count int     `json:"count"`
+}
+
+// CompareRange is one side of a /transactions/compare response.
+type CompareRange struct {
+	From    string  `json:"from"`
+	To      string  `json:"to"`
+	Summary Summary `json:"summary"`
+}
+
+// CompareResponse summarizes two date ranges side by side. ChangePercentage
+// is the change in total from Base to Compare, null when Base is zero.
+type CompareResponse struct {
+	Base             CompareRange `json:"base"`
+	Compare          CompareRange `json:"compare"`
+	ChangePercentage *float64     `json:"changePercentage"`
+	Warnings         []string     `json:"warnings,omitempty"`
+}
+
+// NDJSONTrailer is the last line of an NDJSON /transactions stream, after
+// one line per transaction. Trailer is always true, to tell it apart.
+type NDJSONTrailer struct {
+	Trailer         bool        `json:"trailer"`
+	Summary         Summary     `json:"summary"`
+	Series          []DayTotal  `json:"series"`
+	Warnings        []string    `json:"warnings,omitempty"`
+	Matched         *MatchCount `json:"matched,omitempty"`
+	WindowStart     string      `json:"windowStart,omitempty"`
+	WindowEnd       string      `json:"windowEnd,omitempty"`
+	Truncated       bool        `json:"truncated,omitempty"`
+	SearchedFolders []string    `json:"searchedFolders,omitempty"`
+}
+
+// ReparseResponse reports a /reparse run: how many stored emails were
+// parsed again and how many transactions they now yield.
+type ReparseResponse struct {
+	Messages     int      `json:"messages"`
+	Transactions int      `json:"transactions"`
+	Warnings     []string `json:"warnings,omitempty"`
+}
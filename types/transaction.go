@@ -1,7 +1,43 @@
 package types
 
+const (
+	TransactionTypeDebit  = "debit"
+	TransactionTypeCredit = "credit"
+)
+
 type Transaction struct {
-	Date        string  `json:"date"`
-	Amount      float64 `json:"amount"`
+	Date          string  `json:"date"`
+	Timestamp     string  `json:"timestamp,omitempty"`
+	Amount        float64 `json:"amount"`
+	AmountDisplay string  `json:"amountDisplay,omitempty"`
+	// Fee is what the email labels a fee, charge or tax, apart from Amount.
+	// It is informational: Amount already is what was spent.
+	Fee         float64 `json:"fee,omitempty"`
 	Description string  `json:"description"`
+	Type        string  `json:"type"`
+	Merchant    string  `json:"merchant,omitempty"`
+	// MerchantNormalized is the merchant's grouping key, collapsing variants
+	// such as "Amazon.in" and "AMAZON PAY INDIA" to "AMAZON".
+	MerchantNormalized string `json:"merchantNormalized,omitempty"`
+	Currency           string `json:"currency,omitempty"`
+	Category           string `json:"category,omitempty"`
+	Account            string `json:"account,omitempty"`
+	MessageID          string `json:"messageId,omitempty"`
+	ThreadID           string `json:"threadId,omitempty"`
+	Subject            string `json:"subject,omitempty"`
+	// RawBody is the stripped, PII-masked email body, only set for admin
+	// debug=raw requests.
+	RawBody    string `json:"rawBody,omitempty"`
+	IsTransfer bool   `json:"isTransfer,omitempty"`
+	IsRefund   bool   `json:"isRefund,omitempty"`
+	// IsAdjustment marks cashback, reward redemptions and fee waivers
+	// credited back. Like refunds, they reduce spend rather than count as
+	// income.
+	IsAdjustment bool `json:"isAdjustment,omitempty"`
+	// FutureDated marks a transaction dated after now (FUTURE_DATE_POLICY
+	// =flag); it is left out of summaries.
+	FutureDated bool `json:"futureDated,omitempty"`
+	// IsNew marks a transaction after the user's last-seen marker. It is
+	// worked out per request and never cached.
+	IsNew bool `json:"isNew,omitempty"`
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/abhayyadav/funnyMoney/be/types"
+)
+
+const (
+	acceptVersionHeader = "Accept-Version"
+	apiVersionHeader    = "X-API-Version"
+)
+
+// Response schema versions. Version 1 is the original /transactions shape:
+// a summary of total, previously and changePercentage, and details of date,
+// amount and description only. Version 2 is the current shape.
+const (
+	apiVersionLegacy = 1
+	apiVersionLatest = 2
+)
+
+type apiVersionKey struct{}
+
+// apiVersionMiddleware negotiates the response schema version from
+// Accept-Version (default: latest), rejecting unknown versions with a 406,
+// and reports the version served as X-API-Version.
+func apiVersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := apiVersionLatest
+		if raw := strings.TrimPrefix(strings.TrimSpace(r.Header.Get(acceptVersionHeader)), "v"); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil || v < apiVersionLegacy || v > apiVersionLatest {
+				respondErrorCode(w, http.StatusNotAcceptable, "UNSUPPORTED_VERSION",
+					"Unsupported "+acceptVersionHeader+"; expected 1 or 2")
+				return
+			}
+			version = v
+		}
+		w.Header().Set(apiVersionHeader, strconv.Itoa(version))
+		w.Header().Add("Vary", acceptVersionHeader)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+	})
+}
+
+// apiVersion returns the negotiated schema version for a request.
+func apiVersion(r *http.Request) int {
+	if v, ok := r.Context().Value(apiVersionKey{}).(int); ok {
+		return v
+	}
+	return apiVersionLatest
+}
+
+// legacySummary always carries a changePercentage: version 1 clients read
+// 0, never null, when there is nothing to compare against.
+type legacySummary struct {
+	Total            float64 `json:"total"`
+	Previously       float64 `json:"previously"`
+	ChangePercentage float64 `json:"changePercentage"`
+}
+
+type legacyTransaction struct {
+	Date        string  `json:"date"`
+	Amount      float64 `json:"amount"`
+	Description string  `json:"description"`
+}
+
+type legacyResponse struct {
+	Summary legacySummary       `json:"summary"`
+	Details []legacyTransaction `json:"details"`
+}
+
+// legacyBody marshals a response in the version 1 shape.
+func legacyBody(response types.TransactionsResponse) ([]byte, error) {
+	legacy := legacyResponse{
+		Summary: legacySummary{
+			Total:      response.Summary.Total,
+			Previously: response.Summary.Previously,
+		},
+		Details: make([]legacyTransaction, 0, len(response.Details)),
+	}
+	if response.Summary.ChangePercentage != nil {
+		legacy.Summary.ChangePercentage = *response.Summary.ChangePercentage
+	}
+	for _, txn := range response.Details {
+		legacy.Details = append(legacy.Details, legacyTransaction{Date: txn.Date, Amount: txn.Amount, Description: txn.Description})
+	}
+	return json.Marshal(legacy)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestAPIVersionNegotiation(t *testing.T) {
+	env := newTestEnv(t, nil)
+	// Only the current week has spend, so there is no change to report.
+	env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+	target := "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken
+
+	keys := func(raw json.RawMessage) string {
+		var m map[string]json.RawMessage
+		json.Unmarshal(raw, &m)
+		var names []string
+		for k := range m {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	tests := []struct {
+		name      string
+		accept    string
+		status    int
+		version   string
+		summary   string
+		detail    string
+		hasSeries bool
+	}{
+		{name: "default is latest", status: http.StatusOK, version: "2", hasSeries: true},
+		{name: "explicit 2", accept: "2", status: http.StatusOK, version: "2", hasSeries: true},
+		{name: "legacy 1", accept: "1", status: http.StatusOK, version: "1",
+			summary: "changePercentage,previously,total", detail: "amount,date,description"},
+		{name: "v prefix", accept: "v1", status: http.StatusOK, version: "1",
+			summary: "changePercentage,previously,total", detail: "amount,date,description"},
+		{name: "unknown version", accept: "3", status: http.StatusNotAcceptable},
+		{name: "not a number", accept: "latest", status: http.StatusNotAcceptable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.accept != "" {
+				headers = []string{"Accept-Version", tt.accept}
+			}
+			rec := env.do("GET", target, headers...)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				if got := errorCodeOf(rec); got != "UNSUPPORTED_VERSION" {
+					t.Errorf("errorCode %q, want UNSUPPORTED_VERSION", got)
+				}
+				return
+			}
+			if got := rec.Header().Get("X-API-Version"); got != tt.version {
+				t.Errorf("X-API-Version = %q, want %q", got, tt.version)
+			}
+			var body struct {
+				Summary json.RawMessage   `json:"summary"`
+				Details []json.RawMessage `json:"details"`
+				Series  json.RawMessage   `json:"series"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if (body.Series != nil) != tt.hasSeries {
+				t.Errorf("series present = %v, want %v", body.Series != nil, tt.hasSeries)
+			}
+			if tt.summary == "" {
+				return
+			}
+			if got := keys(body.Summary); got != tt.summary {
+				t.Errorf("summary fields %s, want %s", got, tt.summary)
+			}
+			if len(body.Details) != 1 || keys(body.Details[0]) != tt.detail {
+				t.Errorf("details %s, want one with fields %s", body.Details, tt.detail)
+			}
+			var summary struct {
+				ChangePercentage *float64 `json:"changePercentage"`
+			}
+			json.Unmarshal(body.Summary, &summary)
+			if summary.ChangePercentage == nil || *summary.ChangePercentage != 0 {
+				t.Errorf("legacy changePercentage = %v, want 0", summary.ChangePercentage)
+			}
+		})
+	}
+}
+
+func TestAPIVersionCORS(t *testing.T) {
+	env := newTestEnv(t, nil)
+	preflight := env.do("OPTIONS", "/transactions?access_token="+testToken)
+	if allowed := preflight.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(allowed, "Accept-Version") {
+		t.Errorf("Allow-Headers %q lacks Accept-Version", allowed)
+	}
+	tests := []struct {
+		method, target string
+	}{
+		{"GET", "/transactions?filter=weekly&endDate=2024-03-15&access_token=" + testToken},
+		{"POST", "/refresh?access_token=" + testToken},
+	}
+	for _, tt := range tests {
+		rec := env.do(tt.method, tt.target)
+		if exposed := rec.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(exposed, "X-API-Version") {
+			t.Errorf("%s %s: Expose-Headers %q lacks X-API-Version", tt.method, tt.target, exposed)
+		}
+	}
+}
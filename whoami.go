@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type WhoAmIResponse struct {
+	EmailAddress  string `json:"emailAddress"`
+	MessagesTotal int64  `json:"messagesTotal"`
+}
+
+// whoamiHandler reports which Gmail account the credential belongs to. It
+// makes a single profile call, so it doubles as a cheap token check.
+func whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	gs, _, ok := gmailServiceFromRequest(w, r)
+	if !ok {
+		return
+	}
+	profile, err := gs.GetProfile()
+	if err != nil {
+		respondAppError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WhoAmIResponse{
+		EmailAddress:  profile.EmailAddress,
+		MessagesTotal: profile.MessagesTotal,
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/abhayyadav/funnyMoney/be/internal/gmailtest"
+)
+
+func TestWhoAmI(t *testing.T) {
+	tests := []struct {
+		name        string
+		token       string
+		failProfile int
+		status      int
+	}{
+		{name: "valid token", token: testToken, status: http.StatusOK},
+		{name: "missing token", token: "", status: http.StatusUnauthorized},
+		{name: "ungranted token", token: "ya29.not-granted-token", status: http.StatusUnauthorized},
+		{name: "profile rejects the token", token: testToken, failProfile: http.StatusUnauthorized, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.addDebit("m1", "2024-03-14", 250, "AMAZON")
+			env.gmail.Add(gmailtest.Email("m2", "friend@example.com", "Hello", "Hi", time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)))
+			if tt.failProfile != 0 {
+				env.gmail.Fail(gmailtest.Profile, tt.failProfile)
+			}
+
+			rec := env.do("GET", "/whoami?access_token="+tt.token)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				if errorCodeOf(rec) == "" {
+					t.Errorf("error body lacks errorCode: %s", rec.Body.String())
+				}
+				return
+			}
+			var body WhoAmIResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.EmailAddress != testEmail || body.MessagesTotal != 2 {
+				t.Errorf("whoami = %+v, want %s with 2 messages", body, testEmail)
+			}
+			if got := env.gmail.Calls(gmailtest.Profile); got != 1 {
+				t.Errorf("profile called %d times, want 1", got)
+			}
+		})
+	}
+}
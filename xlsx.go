@@ -0,0 +1,176 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Cell styles defined in xlsxStyles.
+const (
+	xlsxStyleDefault = iota
+	xlsxStyleHeader
+	xlsxStylePercent // a fraction shown as a percentage: 0.25 is 25.00%
+	xlsxStyleMoney   // money with no decimals; see xlsxMoneyStyle
+)
+
+// xlsxMaxDecimals is the most decimals a money style shows, as many as
+// AMOUNT_DECIMALS allows.
+const xlsxMaxDecimals = 6
+
+// xlsxMoneyStyle returns the money style showing decimals places, so JPY
+// amounts read 1,250 and INR ones 1,250.00.
+func xlsxMoneyStyle(decimals int) int {
+	if decimals < 0 {
+		decimals = 0
+	}
+	if decimals > xlsxMaxDecimals {
+		decimals = xlsxMaxDecimals
+	}
+	return xlsxStyleMoney + decimals
+}
+
+// xlsxCell is one spreadsheet cell: a string or a float64 value.
+type xlsxCell struct {
+	Value interface{}
+	Style int
+}
+
+type xlsxSheet struct {
+	Name string
+	Rows [][]xlsxCell
+}
+
+// xlsxStyles defines the cell styles, in style constant order, with one
+// money number format per number of decimals.
+var xlsxStyles = func() string {
+	var formats, moneyXfs strings.Builder
+	for d := 0; d <= xlsxMaxDecimals; d++ {
+		code := "#,##0"
+		if d > 0 {
+			code += "." + strings.Repeat("0", d)
+		}
+		fmt.Fprintf(&formats, `<numFmt numFmtId="%d" formatCode="%s"/>`, 164+d, code)
+		fmt.Fprintf(&moneyXfs, `<xf numFmtId="%d" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>`, 164+d)
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="%d">%s</numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="%d"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/><xf numFmtId="10" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>%s</cellXfs>
+</styleSheet>`, xlsxMaxDecimals+1, formats.String(), xlsxStyleMoney+xlsxMaxDecimals+1, moneyXfs.String())
+}()
+
+// xlsxPart is one file in the workbook archive.
+type xlsxPart struct {
+	Name    string
+	Content string
+}
+
+// writeXLSX writes a minimal Office Open XML workbook with the given sheets.
+// Strings are stored inline, so no shared string table is needed.
+func writeXLSX(w io.Writer, sheets []xlsxSheet) error {
+	zw := zip.NewWriter(w)
+	// Some readers expect [Content_Types].xml first, so parts are written
+	// in a fixed order.
+	parts := []xlsxPart{
+		{"[Content_Types].xml", xlsxContentTypes(len(sheets))},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook(sheets)},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels(len(sheets))},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for i, sheet := range sheets {
+		parts = append(parts, xlsxPart{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), xlsxWorksheet(sheet)})
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.Content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+func xlsxContentTypes(sheets int) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	b.WriteString(`</Types>`)
+	return b.String()
+}
+
+func xlsxWorkbook(sheets []xlsxSheet) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for i, sheet := range sheets {
+		fmt.Fprintf(&b, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sheet.Name), i+1, i+1)
+	}
+	b.WriteString(`</sheets></workbook>`)
+	return b.String()
+}
+
+func xlsxWorkbookRels(sheets int) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+	fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, sheets+1)
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+func xlsxWorksheet(sheet xlsxSheet) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for r, row := range sheet.Rows {
+		fmt.Fprintf(&b, `<row r="%d">`, r+1)
+		for c, cell := range row {
+			ref := xlsxColumn(c) + strconv.Itoa(r+1)
+			switch v := cell.Value.(type) {
+			case float64:
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, cell.Style, strconv.FormatFloat(v, 'f', -1, 64))
+			case string:
+				fmt.Fprintf(&b, `<c r="%s" s="%d" t="inlineStr"><is><t>%s</t></is></c>`, ref, cell.Style, xmlEscape(v))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// xlsxColumn returns the column letters for a zero-based index: A, B, ... Z,
+// AA, AB, ...
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}